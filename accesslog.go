@@ -0,0 +1,168 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LogRecord describes a request handled by AccessLogFunc.
+type LogRecord struct {
+	Time       time.Time     // When the request was received.
+	Method     string        // The request method.
+	URI        string        // The request URI, as sent by the client.
+	Proto      string        // The request protocol, such as "HTTP/1.1".
+	Status     int           // The response status code.
+	Size       int64         // The number of bytes in the response body.
+	Duration   time.Duration // The time taken to handle the request.
+	RemoteAddr string        // The client's IP address.
+	User       string        // The user name used with basic authentication, if any.
+	Referer    string        // The Referer HTTP header, if any.
+	UserAgent  string        // The User-Agent HTTP header, if any.
+	RequestID  string        // The request ID set by RequestID, if any.
+}
+
+// AccessLog creates an http.Handler which calls next, then writes
+// a line to out describing the request, in the Combined Log Format
+// used by Apache and many other web servers. Writes to out are
+// serialised, so out need not be safe for concurrent use.
+//
+// The Combined Log Format does not include the time taken to handle
+// the request. AccessLogFunc can be used to log this. If the request
+// has an ID, as set by RequestID, it is added in quotes at the end of
+// the line.
+//
+//	site.Always(web.AccessLog(os.Stdout, handler))
+func AccessLog(out io.Writer, next http.Handler) http.Handler {
+	var mu sync.Mutex
+	return AccessLogFunc(func(record LogRecord) {
+		buf := logBuffers.Get().(*[]byte)
+		line := record.appendCombined((*buf)[:0])
+		mu.Lock()
+		out.Write(line)
+		mu.Unlock()
+		*buf = line
+		logBuffers.Put(buf)
+	}, next)
+}
+
+// AccessLogFunc creates an http.Handler which calls next, then
+// calls record with a description of the request.
+//
+//	logRequests := func(record web.LogRecord) {
+//		log.Printf("%s %s: %d in %v", record.Method, record.URI, record.Status, record.Duration)
+//	}
+//	site.Always(web.AccessLogFunc(logRequests, handler))
+func AccessLogFunc(record func(LogRecord), next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		status := rec.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		uri := r.RequestURI
+		if uri == "" {
+			uri = r.URL.RequestURI()
+		}
+		user := ""
+		if r.Header.Get("Authorization") != "" {
+			user, _, _ = r.BasicAuth()
+		}
+
+		record(LogRecord{
+			Time:       start,
+			Method:     r.Method,
+			URI:        uri,
+			Proto:      r.Proto,
+			Status:     status,
+			Size:       rec.Written(),
+			Duration:   time.Since(start),
+			RemoteAddr: remoteIP(r),
+			User:       user,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			RequestID:  loggedRequestID(rec, r),
+		})
+	})
+}
+
+// logBuffers holds buffers used to format
+// log lines, to avoid allocating for each.
+var logBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
+// appendCombined appends the record to buf
+// in the Combined Log Format.
+func (l *LogRecord) appendCombined(buf []byte) []byte {
+	buf = appendLogField(buf, l.RemoteAddr)
+	buf = append(buf, " - "...)
+	buf = appendLogField(buf, l.User)
+	buf = append(buf, " ["...)
+	buf = l.Time.AppendFormat(buf, "02/Jan/2006:15:04:05 -0700")
+	buf = append(buf, `] "`...)
+	buf = appendLogString(buf, l.Method)
+	buf = append(buf, ' ')
+	buf = appendLogString(buf, l.URI)
+	buf = append(buf, ' ')
+	buf = appendLogString(buf, l.Proto)
+	buf = append(buf, `" `...)
+	buf = strconv.AppendInt(buf, int64(l.Status), 10)
+	buf = append(buf, ' ')
+	if l.Size > 0 {
+		buf = strconv.AppendInt(buf, l.Size, 10)
+	} else {
+		buf = append(buf, '-')
+	}
+	buf = append(buf, ` "`...)
+	buf = appendLogField(buf, l.Referer)
+	buf = append(buf, `" "`...)
+	buf = appendLogField(buf, l.UserAgent)
+	buf = append(buf, '"')
+	if l.RequestID != "" {
+		buf = append(buf, ` "`...)
+		buf = appendLogString(buf, l.RequestID)
+		buf = append(buf, '"')
+	}
+	buf = append(buf, '\n')
+	return buf
+}
+
+// appendLogField appends a field,
+// using "-" if the field is empty.
+func appendLogField(buf []byte, s string) []byte {
+	if s == "" {
+		return append(buf, '-')
+	}
+	return appendLogString(buf, s)
+}
+
+// appendLogString appends s, escaping quotes,
+// backslashes, and control characters, as
+// Apache does.
+func appendLogString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			buf = append(buf, '\\', c)
+		case c < 0x20 || c == 0x7f:
+			buf = append(buf, '\\', 'x', hex[c>>4], hex[c&0xf])
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
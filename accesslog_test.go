@@ -0,0 +1,112 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	h := AccessLog(&out, Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "hello")
+	}))
+
+	r := httptest.NewRequest("POST", "/users?name=a%20b", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.SetBasicAuth("alice", "secret")
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("User-Agent", `Test "Agent"`)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	pattern := `^203\.0\.113\.7 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "POST /users\?name=a%20b HTTP/1\.1" 201 5 "https://example\.com/" "Test \\"Agent\\""` + "\n$"
+	if !regexp.MustCompile(pattern).MatchString(out.String()) {
+		t.Errorf("got log line %q", out.String())
+	}
+
+	// Empty fields are logged as "-", and IDs are appended.
+	out.Reset()
+	h = RequestID(AccessLog(&out, Handler(func(w http.ResponseWriter, r *http.Request) {})))
+	r = httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set("X-Request-ID", "abc-123")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	pattern = `^203\.0\.113\.7 - - \[.+\] "GET / HTTP/1\.1" 200 - "-" "-" "abc-123"` + "\n$"
+	if !regexp.MustCompile(pattern).MatchString(out.String()) {
+		t.Errorf("got log line %q", out.String())
+	}
+}
+
+func TestAccessLogFunc(t *testing.T) {
+	var got LogRecord
+	h := AccessLogFunc(func(record LogRecord) { got = record }, Handler(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}))
+
+	r := httptest.NewRequest("GET", "/missing", nil)
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got.Method != "GET" || got.URI != "/missing" || got.Status != http.StatusNotFound || got.Size != 10 || got.RemoteAddr != "2001:db8::1" {
+		t.Errorf("got record %+v", got)
+	}
+	if got.Time.IsZero() || got.Duration < 0 {
+		t.Errorf("got time %v and duration %v", got.Time, got.Duration)
+	}
+}
+
+func TestAppendCombinedAllocs(t *testing.T) {
+	record := benchmarkLogRecord()
+	buf := make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = record.appendCombined(buf[:0])
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations formatting a log line, want 0", allocs)
+	}
+}
+
+func benchmarkLogRecord() *LogRecord {
+	return &LogRecord{
+		Time:       time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC),
+		Method:     "GET",
+		URI:        "/search?q=web",
+		Proto:      "HTTP/1.1",
+		Status:     http.StatusOK,
+		Size:       1234,
+		RemoteAddr: "203.0.113.7",
+		Referer:    "https://example.com/",
+		UserAgent:  "Mozilla/5.0",
+		RequestID:  "abc-123",
+	}
+}
+
+func BenchmarkAccessLog(b *testing.B) {
+	h := AccessLog(io.Discard, Handler(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/search?q=web", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkAppendCombined(b *testing.B) {
+	record := benchmarkLogRecord()
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = record.appendCombined(buf[:0])
+	}
+}
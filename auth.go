@@ -0,0 +1,130 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// BasicAuth creates Middleware which requires HTTP basic
+// authentication, as defined in RFC 7617. The user name and password
+// given by the client are passed to validate, and requests are only
+// passed to the next handler if it returns true. Otherwise, a 401
+// Unauthorized response is sent, with a WWW-Authenticate HTTP header
+// using the given realm. The credentials are split at the first
+// colon, so passwords may contain colons, but user names may not.
+//
+// The authenticated user name is stored in the request's context,
+// where it can be retrieved with User.
+//
+// Validate is called for each request, so it should be safe for
+// concurrent use, and should compare credentials in constant time,
+// such as with SecureCompare or a password hashing function.
+//
+//	requireAdmin := web.BasicAuth("Admin", func(user, pass string) bool {
+//		return web.SecureCompare(user, "admin") && web.SecureCompare(pass, adminPassword)
+//	})
+//	site.HasPrefix(requireAdmin(adminHandler), "/admin/")
+//
+// Validate may block, such as to check a password against a stored
+// bcrypt hash:
+//
+//	requireUser := web.BasicAuth("Members", func(user, pass string) bool {
+//		hash, ok := lookupPasswordHash(user)
+//		return ok && bcrypt.CompareHashAndPassword(hash, []byte(pass)) == nil
+//	})
+func BasicAuth(realm string, validate func(user, pass string) bool) Middleware {
+	challenge := `Basic realm="` + quoteEscape(realm) + `", charset="UTF-8"`
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := parseBasicAuth(r.Header.Get("Authorization"))
+			if !ok || !validate(user, pass) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+		})
+	}
+}
+
+// BearerAuth creates Middleware which requires a bearer token, as
+// defined in RFC 6750. The token given by the client is passed to
+// validate, and requests are only passed to the next handler if it
+// returns true. Otherwise, a 401 Unauthorized response is sent.
+//
+//	requireToken := web.BearerAuth(func(token string) bool {
+//		return web.SecureCompare(token, apiToken)
+//	})
+//	site.HasPrefix(requireToken(apiHandler), "/api/")
+func BearerAuth(validate func(token string) bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := authCredentials(r.Header.Get("Authorization"), "Bearer")
+			if !ok || token == "" || !validate(token) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// User returns the user name authenticated by BasicAuth, or
+// the empty string if the request was not authenticated.
+//
+//	fmt.Fprintf(w, "Hello, %s!", web.User(r))
+func User(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// userKey is the context key for the authenticated user name.
+type userKey struct{}
+
+// SecureCompare reports whether the given strings are equal, taking
+// the same time regardless of their contents or lengths, so it can be
+// used to check secrets without revealing them to timing attacks.
+func SecureCompare(given, expected string) bool {
+	a := sha256.Sum256([]byte(given))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// parseBasicAuth returns the user name and password
+// in an Authorization header using the basic scheme.
+func parseBasicAuth(header string) (user, pass string, ok bool) {
+	credentials, ok := authCredentials(header, "Basic")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// authCredentials returns the credentials in an Authorization
+// header, if it uses the given scheme.
+func authCredentials(header, scheme string) (string, bool) {
+	if len(header) <= len(scheme) || header[len(scheme)] != ' ' || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(scheme)+1:]), true
+}
+
+// quoteEscape escapes quotes and backslashes, so s
+// can be used in a quoted string.
+func quoteEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,135 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// userHandler writes the authenticated user name.
+var userHandler = Handler(func(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, User(r))
+})
+
+func serveAuth(h http.Handler, authorization string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func basic(credentials string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
+
+func TestBasicAuth(t *testing.T) {
+	var validated []string
+	requireAuth := BasicAuth(`Admin "area"`, func(user, pass string) bool {
+		validated = append(validated, user+":"+pass)
+		return SecureCompare(user, "jamie") && SecureCompare(pass, "se:cret")
+	})
+	h := requireAuth(userHandler)
+
+	tests := []struct {
+		name, authorization string
+		validated           bool
+		ok                  bool
+	}{
+		{"valid", basic("jamie:se:cret"), true, true},
+		{"lower case scheme", "basic " + base64.StdEncoding.EncodeToString([]byte("jamie:se:cret")), true, true},
+		{"wrong password", basic("jamie:secret"), true, false},
+		{"missing", "", false, false},
+		{"missing colon", basic("jamie"), false, false},
+		{"malformed base64", "Basic !!!", false, false},
+		{"truncated base64", "Basic amFtaWU6c2U6Y3JldA", false, false},
+		{"bearer scheme", "Bearer " + base64.StdEncoding.EncodeToString([]byte("jamie:se:cret")), false, false},
+		{"no space", "Basic" + base64.StdEncoding.EncodeToString([]byte("jamie:se:cret")), false, false},
+	}
+	for _, test := range tests {
+		validated = nil
+		w := serveAuth(h, test.authorization)
+		if (len(validated) > 0) != test.validated {
+			t.Errorf("%s: validate called with %q", test.name, validated)
+		}
+		if !test.ok {
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s: got status %d, want 401", test.name, w.Code)
+			}
+			if got, want := w.Header().Get("WWW-Authenticate"), `Basic realm="Admin \"area\"", charset="UTF-8"`; got != want {
+				t.Errorf("%s: got WWW-Authenticate %q, want %q", test.name, got, want)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK || w.Body.String() != "jamie" {
+			t.Errorf("%s: got %d %q, want 200 jamie", test.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUserUnauthenticated(t *testing.T) {
+	if w := serveAuth(userHandler, basic("jamie:secret")); w.Body.String() != "" {
+		t.Errorf("got user %q without BasicAuth", w.Body.String())
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	h := BearerAuth(func(token string) bool {
+		return SecureCompare(token, "abc123")
+	})(userHandler)
+
+	tests := []struct {
+		authorization string
+		ok            bool
+	}{
+		{"Bearer abc123", true},
+		{"bearer abc123", true},
+		{"Bearer  abc123 ", true},
+		{"Bearer abc1234", false},
+		{"Bearer ", false},
+		{"Bearer", false},
+		{"Basic abc123", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		w := serveAuth(h, test.authorization)
+		if test.ok {
+			if w.Code != http.StatusOK {
+				t.Errorf("%q: got status %d, want 200", test.authorization, w.Code)
+			}
+			if w.Body.String() != "" {
+				t.Errorf("%q: BearerAuth set user %q", test.authorization, w.Body.String())
+			}
+			continue
+		}
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%q: got %d with WWW-Authenticate %q, want 401 Bearer", test.authorization, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestSecureCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", true},
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"secret", "secret2", false},
+		{"", "secret", false},
+	}
+	for _, test := range tests {
+		if got := SecureCompare(test.a, test.b); got != test.want {
+			t.Errorf("SecureCompare(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"fmt"
+	"golang.org/x/crypto/acme/autocert"
+	"net/http"
+)
+
+// ServeTLS listens on the Site's port and serves requests over
+// TLS, using the given certificate files. The Site's ConfigureTLS
+// hook is applied to the TLS configuration. ServeTLS always returns
+// a non-nil error.
+//
+//	site := web.NewSite("example.com", 443, nil)
+//	log.Fatal(site.ServeTLS("cert.pem", "key.pem"))
+func (s *Site) ServeTLS(certFile, keyFile string) error {
+	server := &http.Server{Addr: fmt.Sprintf(":%d", s.Port), Handler: s}
+	s.secure(server, nil)
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+// ServeAutocert listens on the Site's port and serves requests over
+// TLS, using certificates obtained automatically from Let's Encrypt
+// with golang.org/x/crypto/acme/autocert. By using ServeAutocert, you
+// accept Let's Encrypt's terms of service.
+//
+// Certificates are only requested for the given hosts, or the Site's
+// name if none are given, and are stored in cacheDir, so they persist
+// across restarts. The Site's ConfigureTLS hook is applied to the TLS
+// configuration.
+//
+// If the Site's HTTPRedirectPort field is set, usually to 80, a second
+// listener is started on that port, which answers the ACME HTTP-01
+// challenges, and redirects all other requests to HTTPS, as with
+// RedirectToHTTPSPort. ServeAutocert always returns a non-nil error.
+//
+//	site := web.NewSite("example.com", 443, nil)
+//	site.HTTPRedirectPort = 80
+//	site.ConfigureTLS = func(c *tls.Config) {
+//		c.MinVersion = tls.VersionTLS12
+//	}
+//	log.Fatal(site.ServeAutocert("/var/cache/autocert", "example.com", "www.example.com"))
+func (s *Site) ServeAutocert(cacheDir string, hosts ...string) error {
+	m := s.autocertManager(cacheDir, hosts)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", s.Port), Handler: s}
+	s.secure(server, m.TLSConfig())
+
+	errChan := make(chan error, 2)
+	if s.HTTPRedirectPort != 0 {
+		redirect := &http.Server{
+			Addr:    fmt.Sprintf(":%d", s.HTTPRedirectPort),
+			Handler: s.autocertRedirect(m),
+		}
+		go serveHTTP(redirect, errChan)
+	}
+	go serveHTTPS(server, "", "", errChan)
+
+	return <-errChan
+}
+
+// autocertManager creates an autocert.Manager which only
+// obtains certificates for the given hosts, or the Site's
+// name if none are given.
+func (s *Site) autocertManager(cacheDir string, hosts []string) *autocert.Manager {
+	if len(hosts) == 0 {
+		hosts = []string{s.Name}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(hosts...),
+	}
+}
+
+// autocertRedirect returns the handler used for the Site's
+// HTTPRedirectPort, which answers ACME challenges and
+// redirects other requests to the Site's HTTPS port.
+func (s *Site) autocertRedirect(m *autocert.Manager) http.Handler {
+	return m.HTTPHandler(RedirectToHTTPSPort(s.Port))
+}
@@ -0,0 +1,168 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAutocertHostPolicy(t *testing.T) {
+	site := NewSite("example.com", 443, nil)
+	m := site.autocertManager(t.TempDir(), nil)
+	if err := m.HostPolicy(context.Background(), "example.com"); err != nil {
+		t.Errorf("host policy rejected the site's name: %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "evil.example.net"); err == nil {
+		t.Error("host policy accepted an unknown host")
+	}
+
+	m = site.autocertManager(t.TempDir(), []string{"www.example.com"})
+	if err := m.HostPolicy(context.Background(), "www.example.com"); err != nil {
+		t.Errorf("host policy rejected a given host: %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "example.com"); err == nil {
+		t.Error("host policy accepted the site's name when hosts were given")
+	}
+}
+
+func TestAutocertRedirect(t *testing.T) {
+	site := NewSite("example.com", 8443, nil)
+	h := site.autocertRedirect(site.autocertManager(t.TempDir(), nil))
+
+	r := httptest.NewRequest("GET", "http://example.com/path?q=1", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMovedPermanently {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusMovedPermanently)
+	}
+	if got, want := w.Header().Get("Location"), "https://example.com:8443/path?q=1"; got != want {
+		t.Errorf("got Location %q, want %q", got, want)
+	}
+}
+
+func TestConfigureTLS(t *testing.T) {
+	site := NewSecureSite("example.com", 443, "cert.pem", "key.pem", nil)
+	site.ConfigureTLS = func(c *tls.Config) {
+		c.MinVersion = tls.VersionTLS13
+	}
+	server := site.newServer()
+	if server.TLSConfig == nil || server.TLSConfig.MinVersion != tls.VersionTLS13 {
+		t.Error("ConfigureTLS was not applied to the server's TLS configuration")
+	}
+
+	m := site.autocertManager(t.TempDir(), nil)
+	server = &http.Server{}
+	site.secure(server, m.TLSConfig())
+	if server.TLSConfig.MinVersion != tls.VersionTLS13 {
+		t.Error("ConfigureTLS was not applied to the autocert TLS configuration")
+	}
+	if server.TLSConfig.GetCertificate == nil {
+		t.Error("autocert GetCertificate was lost")
+	}
+}
+
+// writeTestCert writes the certificate used by httptest's TLS
+// servers to PEM files in a temporary directory, returning their
+// paths and a client which trusts the certificate.
+func writeTestCert(t *testing.T) (certFile, keyFile string, client *http.Client) {
+	t.Helper()
+	ts := httptest.NewTLSServer(emptyHandler)
+	cert := ts.TLS.Certificates[0]
+	client = ts.Client()
+	ts.Close()
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, client
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, client := writeTestCert(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	site := NewSite("example.com", port, nil)
+	site.Equals(namedHandler("secure"), "/")
+	site.ConfigureTLS = func(c *tls.Config) {
+		c.MinVersion = tls.VersionTLS13
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- site.ServeTLS(certFile, keyFile)
+	}()
+
+	url := "https://127.0.0.1:" + strconv.Itoa(port) + "/"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "secure" {
+				t.Errorf("got body %q, want secure", body)
+			}
+			break
+		}
+		select {
+		case err := <-errc:
+			t.Fatalf("ServeTLS returned %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("could not connect to ServeTLS: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// ConfigureTLS requires TLS 1.3.
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.MaxVersion = tls.VersionTLS12
+	if resp, err := (&http.Client{Transport: transport}).Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("ConfigureTLS was not applied")
+	}
+}
+
+func TestServeTLSMissingCertificate(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	site := NewSite("example.com", port, nil)
+	dir := t.TempDir()
+	if err := site.ServeTLS(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Error("ServeTLS did not return an error")
+	}
+}
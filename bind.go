@@ -0,0 +1,325 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors reported for form fields by Bind.
+var (
+	ErrMissingField = errors.New("web: missing required form field")
+	ErrInvalidField = errors.New("web: invalid form field")
+)
+
+// maxBindMemory is the number of bytes of a multipart
+// form which Bind stores in memory, rather than in
+// temporary files.
+const maxBindMemory = 32 << 20
+
+// FieldError describes a problem with a form field found by Bind.
+// Err is ErrMissingField or ErrInvalidField. Value is the invalid
+// value given by the client, if any.
+type FieldError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Value != "" {
+		return fmt.Sprintf("%v %q: %q", e.Err, e.Field, e.Value)
+	}
+	return fmt.Sprintf("%v %q", e.Err, e.Field)
+}
+
+// Unwrap returns the wrapped error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// BindErrors is the error returned by Bind when form fields are
+// missing or invalid. It lists every problem, in the order of the
+// fields in the struct, so they can all be shown to the user.
+type BindErrors []*FieldError
+
+func (e BindErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the errors for each field.
+func (e BindErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Bind populates the struct pointed to by dst with the request's
+// query parameters and form values. Requests with the Content-Type
+// application/x-www-form-urlencoded or multipart/form-data have their
+// bodies parsed, and form values take precedence over query parameters.
+// Other requests, such as GET requests, only use query parameters.
+//
+// Only fields with a form tag are populated, so clients cannot set
+// other fields. The tag gives the name of the parameter, and may add
+// the "required" option, in which case a missing or empty parameter
+// is reported with ErrMissingField:
+//
+//	type Signup struct {
+//		Email  string                `form:"email,required"`
+//		Age    int                   `form:"age"`
+//		Terms  bool                  `form:"terms,required"`
+//		Born   time.Time             `form:"born" layout:"2006-01-02"`
+//		Tags   []string              `form:"tag"`
+//		Avatar *multipart.FileHeader `form:"avatar"`
+//	}
+//
+// Fields may be strings, bools, integers, floats, time.Time values,
+// slices of these, which take each repeated parameter, or file headers
+// of type *multipart.FileHeader or []*multipart.FileHeader. Bools accept
+// "on", as sent for checkboxes, as well as the values accepted by
+// strconv.ParseBool. Times are parsed with time.RFC3339, or the layout
+// given in a layout tag. Values which cannot be parsed are reported with
+// ErrInvalidField, and leave the field unchanged.
+//
+// If any fields are missing or invalid, Bind populates the other fields
+// and returns BindErrors listing every problem. Other errors, such as
+// when dst is not a pointer to a struct or the body cannot be parsed,
+// are returned as they are. Errors reading a body which is too large,
+// as with LimitBody, match ErrBodyTooLarge.
+//
+//	var signup Signup
+//	if err := web.Bind(r, &signup); err != nil {
+//		var fieldErrs web.BindErrors
+//		if errors.As(err, &fieldErrs) {
+//			renderForm(w, signup, fieldErrs)
+//			return
+//		}
+//		web.Fail(w, r, err)
+//		return
+//	}
+func Bind(r *http.Request, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("web: Bind requires a non-nil pointer to a struct")
+	}
+
+	values, files, err := bindValues(r)
+	if err != nil {
+		return err
+	}
+
+	var errs BindErrors
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("form")
+		if !ok || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		required := options == "required"
+
+		fieldErr, err := bindField(v.Field(i), field, name, values[name], files[name])
+		if err != nil {
+			return err
+		}
+		if fieldErr == nil && required && !present(values[name], files[name]) {
+			fieldErr = &FieldError{Field: name, Err: ErrMissingField}
+		}
+		if fieldErr != nil {
+			errs = append(errs, fieldErr)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// bindValues parses the request's query parameters
+// and form, returning the values and files.
+func bindValues(r *http.Request) (url.Values, map[string][]*multipart.FileHeader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxBindMemory); err != nil {
+			return nil, nil, bindParseError(err)
+		}
+		return r.Form, r.MultipartForm.File, nil
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, nil, bindParseError(err)
+		}
+		return r.Form, nil, nil
+	default:
+		return r.URL.Query(), nil, nil
+	}
+}
+
+// bindParseError returns the error to report
+// for a failure to parse a form.
+func bindParseError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, ErrBodyTooLarge) || errors.As(err, &maxBytesErr) {
+		return ErrBodyTooLarge
+	}
+	return fmt.Errorf("web: malformed form: %w", err)
+}
+
+// present reports whether a non-empty value
+// or file was given for a field.
+func present(values []string, files []*multipart.FileHeader) bool {
+	for _, value := range values {
+		if value != "" {
+			return true
+		}
+	}
+	return len(files) > 0
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	fileHeaderType = reflect.TypeOf((*multipart.FileHeader)(nil))
+)
+
+// bindField sets the field to the given values or files. Invalid
+// values are reported with a FieldError, and fields of unsupported
+// types with an error.
+func bindField(v reflect.Value, field reflect.StructField, name string, values []string, files []*multipart.FileHeader) (*FieldError, error) {
+	switch {
+	case field.Type == fileHeaderType:
+		if len(files) > 0 {
+			v.Set(reflect.ValueOf(files[0]))
+		}
+		return nil, nil
+	case field.Type.Kind() == reflect.Slice && field.Type.Elem() == fileHeaderType:
+		if len(files) > 0 {
+			v.Set(reflect.ValueOf(files))
+		}
+		return nil, nil
+	case field.Type.Kind() == reflect.Slice:
+		if !supportedBindType(field.Type.Elem()) {
+			break
+		}
+		var nonEmpty []string
+		for _, value := range values {
+			if value != "" {
+				nonEmpty = append(nonEmpty, value)
+			}
+		}
+		if len(nonEmpty) == 0 {
+			return nil, nil
+		}
+		slice := reflect.MakeSlice(field.Type, len(nonEmpty), len(nonEmpty))
+		for i, value := range nonEmpty {
+			if !setBindValue(slice.Index(i), field, value) {
+				return &FieldError{Field: name, Value: value, Err: ErrInvalidField}, nil
+			}
+		}
+		v.Set(slice)
+		return nil, nil
+	case supportedBindType(field.Type):
+		if len(values) == 0 || values[0] == "" {
+			return nil, nil
+		}
+		elem := reflect.New(field.Type).Elem()
+		if !setBindValue(elem, field, values[0]) {
+			return &FieldError{Field: name, Value: values[0], Err: ErrInvalidField}, nil
+		}
+		v.Set(elem)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("web: cannot bind form field %q to %s", name, field.Type)
+}
+
+// supportedBindType reports whether Bind can
+// parse a single value of the given type.
+func supportedBindType(t reflect.Type) bool {
+	if t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// setBindValue parses the value into v, reporting
+// whether it is valid.
+func setBindValue(v reflect.Value, field reflect.StructField, value string) bool {
+	if v.Type() == timeType {
+		layout := field.Tag.Get("layout")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return false
+		}
+		v.Set(reflect.ValueOf(t))
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		if value == "on" {
+			v.SetBool(true)
+			return true
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return false
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return false
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return false
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return false
+		}
+		v.SetFloat(f)
+	default:
+		return false
+	}
+	return true
+}
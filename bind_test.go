@@ -0,0 +1,279 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindTypes struct {
+	String   string    `form:"string"`
+	Bool     bool      `form:"bool"`
+	Int      int       `form:"int"`
+	Int8     int8      `form:"int8"`
+	Uint     uint      `form:"uint"`
+	Float32  float32   `form:"float32"`
+	Float64  float64   `form:"float64"`
+	Time     time.Time `form:"time"`
+	Date     time.Time `form:"date" layout:"2006-01-02"`
+	Strings  []string  `form:"strings"`
+	Ints     []int     `form:"ints"`
+	Named    string    `form:""`
+	Ignored  string    `form:"-"`
+	Untagged string
+}
+
+func TestBindConversions(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bindTypes
+	}{
+		{"", bindTypes{}},
+		{"string=hello+world", bindTypes{String: "hello world"}},
+		{"bool=true", bindTypes{Bool: true}},
+		{"bool=on", bindTypes{Bool: true}},
+		{"bool=0", bindTypes{}},
+		{"int=-42", bindTypes{Int: -42}},
+		{"int8=127", bindTypes{Int8: 127}},
+		{"uint=7", bindTypes{Uint: 7}},
+		{"float32=1.5", bindTypes{Float32: 1.5}},
+		{"float64=-2.25e3", bindTypes{Float64: -2250}},
+		{"time=2013-01-02T03:04:05Z", bindTypes{Time: time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		{"date=2013-01-02", bindTypes{Date: time.Date(2013, 1, 2, 0, 0, 0, 0, time.UTC)}},
+		{"strings=a&strings=b&strings=", bindTypes{Strings: []string{"a", "b"}}},
+		{"ints=1&ints=2&ints=3", bindTypes{Ints: []int{1, 2, 3}}},
+		{"Named=x&Ignored=y&Untagged=z&-=w", bindTypes{Named: "x"}},
+		{"int=&float64=", bindTypes{}},
+		{"string=first&string=second", bindTypes{String: "first"}},
+	}
+	for _, test := range tests {
+		var got bindTypes
+		r := httptest.NewRequest("GET", "/?"+test.query, nil)
+		if err := Bind(r, &got); err != nil {
+			t.Errorf("%q: got error %v", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestBindInvalidValues(t *testing.T) {
+	tests := []struct {
+		query, field, value string
+	}{
+		{"bool=maybe", "bool", "maybe"},
+		{"int=1.5", "int", "1.5"},
+		{"int8=128", "int8", "128"},
+		{"uint=-1", "uint", "-1"},
+		{"float64=abc", "float64", "abc"},
+		{"time=2013-01-02", "time", "2013-01-02"},
+		{"date=2013-01-02T03:04:05Z", "date", "2013-01-02T03:04:05Z"},
+		{"ints=1&ints=x", "ints", "x"},
+	}
+	for _, test := range tests {
+		var got bindTypes
+		err := Bind(httptest.NewRequest("GET", "/?"+test.query, nil), &got)
+		var errs BindErrors
+		if !errors.As(err, &errs) || len(errs) != 1 {
+			t.Errorf("%q: got error %v, want one field error", test.query, err)
+			continue
+		}
+		if errs[0].Field != test.field || errs[0].Value != test.value || !errors.Is(err, ErrInvalidField) {
+			t.Errorf("%q: got %+v, want invalid %s %q", test.query, errs[0], test.field, test.value)
+		}
+		if !reflect.DeepEqual(got, bindTypes{}) {
+			t.Errorf("%q: invalid value changed fields to %+v", test.query, got)
+		}
+	}
+}
+
+type bindSignup struct {
+	Email string `form:"email,required"`
+	Name  string `form:"name,required"`
+	Age   int    `form:"age,required"`
+	Terms bool   `form:"terms,required"`
+	Bio   string `form:"bio"`
+}
+
+func TestBindErrorsAggregated(t *testing.T) {
+	form := url.Values{"name": {""}, "age": {"old"}, "terms": {"false"}, "bio": {"hi"}}
+	r := httptest.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var got bindSignup
+	err := Bind(r, &got)
+	var errs BindErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want BindErrors", err)
+	}
+
+	want := BindErrors{
+		{Field: "email", Err: ErrMissingField},
+		{Field: "name", Err: ErrMissingField},
+		{Field: "age", Value: "old", Err: ErrInvalidField},
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("got errors %v, want %v", errs, want)
+	}
+	if want := `web: missing required form field "email"; web: missing required form field "name"; web: invalid form field "age": "old"`; err.Error() != want {
+		t.Errorf("got message %q, want %q", err.Error(), want)
+	}
+	if !errors.Is(err, ErrMissingField) || !errors.Is(err, ErrInvalidField) {
+		t.Errorf("%v does not match both field errors", err)
+	}
+	if got.Bio != "hi" || got.Terms {
+		t.Errorf("valid fields not populated: %+v", got)
+	}
+}
+
+func TestBindFormPrecedence(t *testing.T) {
+	form := url.Values{"email": {"form@example.com"}}
+	r := httptest.NewRequest("POST", "/?email=query@example.com&bio=query", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	var got struct {
+		Email string `form:"email"`
+		Bio   string `form:"bio"`
+	}
+	if err := Bind(r, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "form@example.com" || got.Bio != "query" {
+		t.Errorf("got %+v, want form email and query bio", got)
+	}
+}
+
+func TestBindIgnoresOtherBodies(t *testing.T) {
+	r := httptest.NewRequest("POST", "/?name=query", strings.NewReader(`{"name":"json"}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	var got struct {
+		Name string `form:"name"`
+	}
+	if err := Bind(r, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "query" {
+		t.Errorf("got name %q, want %q", got.Name, "query")
+	}
+}
+
+func TestBindMultipart(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "Holiday")
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		part, err := mw.CreateFormFile("photos", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("data for " + name))
+	}
+	part, err := mw.CreateFormFile("cover", "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("cover"))
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var got struct {
+		Title  string                  `form:"title,required"`
+		Cover  *multipart.FileHeader   `form:"cover,required"`
+		Photos []*multipart.FileHeader `form:"photos"`
+		Avatar *multipart.FileHeader   `form:"avatar"`
+	}
+	if err := Bind(r, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Holiday" {
+		t.Errorf("got title %q, want %q", got.Title, "Holiday")
+	}
+	if got.Cover == nil || got.Cover.Filename != "cover.png" || got.Cover.Size != 5 {
+		t.Errorf("got cover %+v, want cover.png", got.Cover)
+	}
+	if len(got.Photos) != 2 || got.Photos[0].Filename != "a.jpg" || got.Photos[1].Filename != "b.jpg" {
+		t.Errorf("got photos %v, want a.jpg and b.jpg", got.Photos)
+	}
+	if got.Avatar != nil {
+		t.Errorf("got avatar %+v, want none", got.Avatar)
+	}
+}
+
+func TestBindMissingFile(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "Holiday")
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var got struct {
+		Cover *multipart.FileHeader `form:"cover,required"`
+	}
+	err := Bind(r, &got)
+	var errs BindErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "cover" || errs[0].Err != ErrMissingField {
+		t.Errorf("got error %v, want missing cover", err)
+	}
+}
+
+func TestBindBodyTooLarge(t *testing.T) {
+	form := url.Values{"bio": {strings.Repeat("x", 100)}}
+	var bindErr error
+	h := LimitBody(10)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		var got struct {
+			Bio string `form:"bio"`
+		}
+		bindErr = Bind(r, &got)
+	}))
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if !errors.Is(bindErr, ErrBodyTooLarge) {
+		t.Errorf("got error %v, want ErrBodyTooLarge", bindErr)
+	}
+}
+
+func TestBindInvalidDestination(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?x=1", nil)
+	var notStruct int
+	var nilStruct *bindTypes
+	var unsupported struct {
+		Map map[string]string `form:"map"`
+	}
+
+	tests := []struct {
+		name string
+		dst  interface{}
+	}{
+		{"non-pointer", bindTypes{}},
+		{"pointer to non-struct", &notStruct},
+		{"nil pointer", nilStruct},
+		{"unsupported field", &unsupported},
+	}
+	for _, test := range tests {
+		err := Bind(r, test.dst)
+		var errs BindErrors
+		if err == nil || errors.As(err, &errs) {
+			t.Errorf("%s: got error %v, want a non-field error", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+// The states of a CircuitBreaker.
+const (
+	CircuitClosed   CircuitState = iota // Requests are passed on.
+	CircuitOpen                         // Requests are rejected.
+	CircuitHalfOpen                     // A trial request is passed on.
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "CircuitState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// CircuitBreaker stops requests from reaching a failing handler, such
+// as a Proxy to a backend which is down, so they fail quickly, rather
+// than waiting for timeouts. Responses with a status code of 500 or
+// above, and panics, are failures.
+//
+// The circuit starts closed, passing requests on. After threshold
+// consecutive failures, it opens, and requests receive a 503 Service
+// Unavailable response, with a Retry-After header, without calling the
+// handler. Once resetAfter has elapsed, the circuit is half-open, and
+// the next request is passed on as a trial, while others are still
+// rejected. If the trial succeeds, the circuit closes, and otherwise
+// it opens again.
+//
+// CircuitBreaker must be created with NewCircuitBreaker. Its fields
+// must be set before it is used.
+type CircuitBreaker struct {
+	// Clock is used to tell the time. If nil,
+	// the system clock is used.
+	Clock Clock
+
+	mu         sync.Mutex
+	threshold  int
+	resetAfter time.Duration
+	state      CircuitState
+	failures   int
+	openedAt   time.Time
+	trial      bool
+}
+
+// NewCircuitBreaker creates a CircuitBreaker which opens after
+// threshold consecutive failures, and allows a trial request
+// once resetAfter has elapsed. NewCircuitBreaker panics if
+// threshold or resetAfter is not positive.
+//
+//	breaker := web.NewCircuitBreaker(5, 30*time.Second)
+//	site.HasPrefix(breaker.Wrap(web.Proxy(backendURL, web.ProxyOptions{})), "/api/")
+func NewCircuitBreaker(threshold int, resetAfter time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		panic("web: circuit breaker threshold must be positive")
+	}
+	if resetAfter <= 0 {
+		panic("web: circuit breaker reset time must be positive")
+	}
+	return &CircuitBreaker{threshold: threshold, resetAfter: resetAfter}
+}
+
+// State returns the current state of the circuit,
+// such as for monitoring.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && !b.now().Before(b.openedAt.Add(b.resetAfter)) {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// Wrap returns an http.Handler which passes requests
+// to next while the circuit is closed.
+func (b *CircuitBreaker) Wrap(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		wait, trial, ok := b.allow()
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		rec := NewResponseRecorder(w)
+		failed := true
+		defer func() {
+			b.record(trial, failed)
+		}()
+		next.ServeHTTP(rec, r)
+		failed = rec.Status() >= 500
+	})
+}
+
+// now returns the current time.
+func (b *CircuitBreaker) now() time.Time {
+	if b.Clock != nil {
+		return b.Clock.Now()
+	}
+	return time.Now()
+}
+
+// allow reports whether a request may be passed on, and
+// whether it is the trial request. If it may not, allow
+// also returns how long until the next trial.
+func (b *CircuitBreaker) allow() (wait time.Duration, trial, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		wait = b.openedAt.Add(b.resetAfter).Sub(b.now())
+		if wait > 0 {
+			return wait, false, false
+		}
+		b.state = CircuitHalfOpen
+		fallthrough
+	case CircuitHalfOpen:
+		if b.trial {
+			return b.resetAfter, false, false
+		}
+		b.trial = true
+		return 0, true, true
+	}
+	return 0, false, true
+}
+
+// record records the outcome of a request.
+func (b *CircuitBreaker) record(trial, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case trial:
+		b.trial = false
+		if failed {
+			b.open()
+		} else {
+			b.state = CircuitClosed
+			b.failures = 0
+		}
+	case b.state != CircuitClosed:
+		// The request started before the circuit opened.
+	case failed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open()
+		}
+	default:
+		b.failures = 0
+	}
+}
+
+// open opens the circuit. The caller must hold b.mu.
+func (b *CircuitBreaker) open() {
+	b.state = CircuitOpen
+	b.openedAt = b.now()
+	b.failures = 0
+}
@@ -0,0 +1,126 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := &fakeClock{time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)}
+	breaker := NewCircuitBreaker(3, 10*time.Second)
+	breaker.Clock = clock
+
+	status := http.StatusInternalServerError
+	calls := 0
+	h := breaker.Wrap(Handler(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+
+	// A success resets the count of consecutive failures.
+	serve()
+	serve()
+	status = http.StatusOK
+	serve()
+	status = http.StatusBadGateway
+	serve()
+	serve()
+	if got := breaker.State(); got != CircuitClosed {
+		t.Fatalf("after 2 consecutive failures: got state %v, want closed", got)
+	}
+	serve()
+	if got := breaker.State(); got != CircuitOpen {
+		t.Fatalf("after 3 consecutive failures: got state %v, want open", got)
+	}
+
+	calls = 0
+	clock.now = clock.now.Add(4 * time.Second)
+	w := serve()
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "6" || calls != 0 {
+		t.Errorf("open: got %d with Retry-After %q after %d calls, want 503 with Retry-After 6", w.Code, w.Header().Get("Retry-After"), calls)
+	}
+
+	// A failed trial opens the circuit again.
+	clock.now = clock.now.Add(6 * time.Second)
+	if got := breaker.State(); got != CircuitHalfOpen {
+		t.Errorf("after reset time: got state %v, want half-open", got)
+	}
+	if w := serve(); w.Code != http.StatusBadGateway || calls != 1 {
+		t.Errorf("trial: got %d after %d calls, want 502 after 1", w.Code, calls)
+	}
+	if got := breaker.State(); got != CircuitOpen {
+		t.Errorf("after failed trial: got state %v, want open", got)
+	}
+
+	// A successful trial closes it.
+	clock.now = clock.now.Add(10 * time.Second)
+	status = http.StatusOK
+	if w := serve(); w.Code != http.StatusOK || calls != 2 {
+		t.Errorf("trial: got %d after %d calls, want 200 after 2", w.Code, calls)
+	}
+	if got := breaker.State(); got != CircuitClosed {
+		t.Errorf("after successful trial: got state %v, want closed", got)
+	}
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+	clock := &fakeClock{time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)}
+	breaker := NewCircuitBreaker(1, time.Second)
+	breaker.Clock = clock
+
+	var inner http.Handler
+	h := breaker.Wrap(Handler(func(w http.ResponseWriter, r *http.Request) {
+		inner.ServeHTTP(w, r)
+	}))
+
+	inner = Handler(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	func() {
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	if got := breaker.State(); got != CircuitOpen {
+		t.Fatalf("after panic: got state %v, want open", got)
+	}
+
+	// Requests made during the trial are rejected.
+	clock.now = clock.now.Add(time.Second)
+	var during int
+	inner = Handler(func(w http.ResponseWriter, r *http.Request) {
+		w2 := httptest.NewRecorder()
+		h.ServeHTTP(w2, httptest.NewRequest("GET", "/", nil))
+		during = w2.Code
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if during != http.StatusServiceUnavailable {
+		t.Errorf("during trial: got %d, want 503", during)
+	}
+	if got := breaker.State(); got != CircuitClosed {
+		t.Errorf("after trial: got state %v, want closed", got)
+	}
+}
+
+func TestCircuitStateString(t *testing.T) {
+	for state, want := range map[CircuitState]string{
+		CircuitClosed:   "closed",
+		CircuitOpen:     "open",
+		CircuitHalfOpen: "half-open",
+		CircuitState(7): "CircuitState(7)",
+	} {
+		if got := state.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// BufferedResponseWriter is an http.ResponseWriter which buffers the
+// response status, headers, and body in memory, until they are sent
+// with Flush. This allows middleware to inspect or change a response
+// after the handler has returned, such as to set its Content-Length.
+//
+// The zero value is an empty response, ready to use.
+//
+//	buf := new(web.BufferedResponseWriter)
+//	next.ServeHTTP(buf, r)
+//	buf.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+//	buf.Flush(w)
+type BufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header returns the response headers, which can be
+// changed until the response is flushed.
+func (b *BufferedResponseWriter) Header() http.Header {
+	if b.header == nil {
+		b.header = make(http.Header)
+	}
+	return b.header
+}
+
+// WriteHeader records the response status code. As
+// with other ResponseWriters, only the first call
+// has any effect.
+func (b *BufferedResponseWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// Write adds data to the buffered response body.
+func (b *BufferedResponseWriter) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+// Status returns the response status code. If none
+// has been written, 200 OK is returned.
+func (b *BufferedResponseWriter) Status() int {
+	if b.status == 0 {
+		return http.StatusOK
+	}
+	return b.status
+}
+
+// Body returns the buffered response body. The slice
+// is only valid until the next change to the response.
+func (b *BufferedResponseWriter) Body() []byte {
+	return b.body.Bytes()
+}
+
+// Len returns the length of the buffered response body.
+func (b *BufferedResponseWriter) Len() int {
+	return b.body.Len()
+}
+
+// Reset discards the buffered response, so a new
+// response can be written.
+func (b *BufferedResponseWriter) Reset() {
+	b.header = nil
+	b.status = 0
+	b.body.Reset()
+}
+
+// Flush sends the buffered response to w. Its headers replace
+// any with the same names which are already set on w.
+func (b *BufferedResponseWriter) Flush(w http.ResponseWriter) error {
+	header := w.Header()
+	for key, values := range b.header {
+		header[key] = append([]string(nil), values...)
+	}
+	w.WriteHeader(b.Status())
+	if b.body.Len() == 0 {
+		return nil
+	}
+	_, err := w.Write(b.body.Bytes())
+	return err
+}
@@ -0,0 +1,102 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestBufferedResponseWriter(t *testing.T) {
+	buf := new(BufferedResponseWriter)
+	if buf.Status() != http.StatusOK || buf.Len() != 0 {
+		t.Errorf("zero value has status %d and length %d", buf.Status(), buf.Len())
+	}
+
+	buf.Header().Set("Content-Type", "text/plain")
+	buf.WriteHeader(http.StatusCreated)
+	buf.WriteHeader(http.StatusTeapot)
+	io.WriteString(buf, "hello, ")
+	io.WriteString(buf, "world")
+	if buf.Status() != http.StatusCreated {
+		t.Errorf("got status %d, want 201", buf.Status())
+	}
+	if string(buf.Body()) != "hello, world" || buf.Len() != 12 {
+		t.Errorf("got body %q with length %d", buf.Body(), buf.Len())
+	}
+
+	// Headers can be changed after the body is written.
+	buf.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Other", "kept")
+	if err := buf.Flush(w); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != "hello, world" {
+		t.Errorf("got %d %q, want 201 hello, world", w.Code, w.Body.String())
+	}
+	want := http.Header{
+		"Content-Type":   {"text/plain"},
+		"Content-Length": {"12"},
+		"X-Other":        {"kept"},
+	}
+	for key, values := range want {
+		if got := w.Header()[key]; len(got) != len(values) || got[0] != values[0] {
+			t.Errorf("got %s header %q, want %q", key, got, values)
+		}
+	}
+
+	// Changing the buffer's headers does not change the flushed response.
+	buf.Header().Add("Content-Type", "text/html")
+	if got := w.Header()["Content-Type"]; len(got) != 1 {
+		t.Errorf("flushed header changed to %q", got)
+	}
+}
+
+func TestBufferedResponseWriterWriteStatus(t *testing.T) {
+	buf := new(BufferedResponseWriter)
+	io.WriteString(buf, "body")
+	buf.WriteHeader(http.StatusNotFound)
+	if buf.Status() != http.StatusOK {
+		t.Errorf("got status %d after writing the body, want 200", buf.Status())
+	}
+}
+
+func TestBufferedResponseWriterReset(t *testing.T) {
+	buf := new(BufferedResponseWriter)
+	buf.Header().Set("X-Error", "1")
+	buf.WriteHeader(http.StatusInternalServerError)
+	io.WriteString(buf, "failed")
+
+	buf.Reset()
+	if len(buf.Header()) != 0 || buf.Status() != http.StatusOK || buf.Len() != 0 {
+		t.Errorf("after Reset, got headers %v, status %d, length %d", buf.Header(), buf.Status(), buf.Len())
+	}
+	buf.WriteHeader(http.StatusAccepted)
+	io.WriteString(buf, "ok")
+
+	w := httptest.NewRecorder()
+	buf.Flush(w)
+	if w.Code != http.StatusAccepted || w.Body.String() != "ok" || w.Header().Get("X-Error") != "" {
+		t.Errorf("got %d %q with headers %v", w.Code, w.Body.String(), w.Header())
+	}
+}
+
+func TestBufferedResponseWriterEmptyFlush(t *testing.T) {
+	buf := new(BufferedResponseWriter)
+	buf.WriteHeader(http.StatusNoContent)
+	w := httptest.NewRecorder()
+	if err := buf.Flush(w); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("got %d %q, want an empty 204", w.Code, w.Body.String())
+	}
+}
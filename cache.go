@@ -0,0 +1,322 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DoNotCache uses the Cache-Control, Pragma, and Expires HTTP headers
+// to advise the client not to cache the response.
+func DoNotCache(w http.ResponseWriter) {
+	header := w.Header()
+	header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	header.Set("Pragma", "no-cache")
+	header.Set("Expires", "0")
+}
+
+// DoNotCacheHandler creates an http.Handler which uses DoNotCache to
+// advise the client not to cache the response from next. The headers
+// are set again when the response status is written, so they apply
+// even if next changes them.
+//
+//	site.HasPrefix(web.DoNotCacheHandler(apiHandler), "/api/")
+func DoNotCacheHandler(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		DoNotCache(w)
+		next.ServeHTTP(&noCacheResponseWriter{ResponseWriter: w}, r)
+	})
+}
+
+// noCacheResponseWriter calls DoNotCache
+// before writing the response status.
+type noCacheResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *noCacheResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		DoNotCache(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *noCacheResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *noCacheResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+func (w *noCacheResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+func (w *noCacheResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Cache uses the Last-Modified, Cache-Control, Expires, and Vary HTTP
+// headers to advise the client to cache the response for the given
+// duration. The response may be cached by shared caches, such as CDNs.
+// Accept-Encoding is added to any existing Vary header, such as one set
+// by CORSPolicy. For finer control over the Cache-Control header, use
+// CacheWith.
+func Cache(w http.ResponseWriter, modTime time.Time, duration time.Duration) {
+	header := w.Header()
+	if !modTime.IsZero() {
+		header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	CacheWith(w, CacheOptions{Public: true, MaxAge: duration})
+	addVary(header, "Accept-Encoding")
+}
+
+// CacheWithSWR works like Cache, but also allows caches to serve
+// a stale response for up to swr while revalidating it, and for up
+// to sie if revalidation fails, as described in RFC 5861.
+//
+//	// Cache-Control: public, max-age=600, stale-while-revalidate=60, stale-if-error=86400
+//	web.CacheWithSWR(w, 10*time.Minute, time.Minute, 24*time.Hour)
+func CacheWithSWR(w http.ResponseWriter, duration, swr, sie time.Duration) {
+	CacheWith(w, CacheOptions{
+		Public:               true,
+		MaxAge:               duration,
+		StaleWhileRevalidate: swr,
+		StaleIfError:         sie,
+	})
+	addVary(w.Header(), "Accept-Encoding")
+}
+
+// CacheOptions describes the directives used in a Cache-Control
+// HTTP header, as defined in RFC 7234. Durations are rounded down
+// to the nearest second and zero durations are omitted. If Private
+// is set, Public and SMaxAge are ignored, as shared caches must not
+// store the response.
+type CacheOptions struct {
+	Public               bool          // Allow shared caches to store the response.
+	Private              bool          // Allow only the client to store the response.
+	MaxAge               time.Duration // How long the response remains fresh.
+	SMaxAge              time.Duration // Overrides MaxAge for shared caches.
+	Immutable            bool          // The response will not change while fresh.
+	MustRevalidate       bool          // Stale responses must be revalidated before use.
+	StaleWhileRevalidate time.Duration // How long a stale response may be used while revalidating.
+	StaleIfError         time.Duration // How long a stale response may be used if revalidation fails.
+}
+
+// String returns the Cache-Control header value for the options.
+//
+//	opts := web.CacheOptions{Public: true, MaxAge: time.Hour, Immutable: true}
+//	opts.String() // "public, max-age=3600, immutable"
+func (o CacheOptions) String() string {
+	directives := make([]string, 0, 7)
+	if o.Private {
+		directives = append(directives, "private")
+	} else if o.Public {
+		directives = append(directives, "public")
+	}
+	if o.MaxAge > 0 {
+		directives = append(directives, seconds("max-age", o.MaxAge))
+	}
+	if o.SMaxAge > 0 && !o.Private {
+		directives = append(directives, seconds("s-maxage", o.SMaxAge))
+	}
+	if o.MustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+	if o.Immutable {
+		directives = append(directives, "immutable")
+	}
+	if o.StaleWhileRevalidate > 0 {
+		directives = append(directives, seconds("stale-while-revalidate", o.StaleWhileRevalidate))
+	}
+	if o.StaleIfError > 0 {
+		directives = append(directives, seconds("stale-if-error", o.StaleIfError))
+	}
+	return strings.Join(directives, ", ")
+}
+
+// CacheWith uses the Cache-Control and Expires HTTP headers to
+// advise the client to cache the response as described by opts.
+// The Expires header is used by HTTP/1.0 caches, which do not
+// support Cache-Control, so is only set if MaxAge is positive.
+//
+//	web.CacheWith(w, web.CacheOptions{Private: true, MaxAge: time.Minute})
+func CacheWith(w http.ResponseWriter, opts CacheOptions) {
+	CacheControl(w, opts)
+	if opts.MaxAge > 0 {
+		w.Header().Set("Expires", time.Now().Add(opts.MaxAge).UTC().Format(http.TimeFormat))
+	}
+}
+
+// CacheControl sets only the Cache-Control HTTP header, as described
+// by opts. If opts has no directives, the header is not set.
+//
+//	// Cache-Control: public, max-age=60, s-maxage=3600
+//	web.CacheControl(w, web.CacheOptions{Public: true, MaxAge: time.Minute, SMaxAge: time.Hour})
+func CacheControl(w http.ResponseWriter, opts CacheOptions) {
+	if value := opts.String(); value != "" {
+		w.Header().Set("Cache-Control", value)
+	}
+}
+
+func seconds(directive string, d time.Duration) string {
+	return directive + "=" + strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+// CacheWithETag uses the ETag HTTP header, in addition to the headers
+// set by Cache, to advise the client to cache the response for the
+// given duration. If the etag is not already quoted, it is quoted.
+//
+// If the request's If-None-Match header matches the entity tag,
+// CacheWithETag writes a 304 Not Modified response and returns true,
+// in which case the caller should not write a response body. Both
+// the "*" form and lists of strong or weak (W/"...") entity tags
+// are supported.
+//
+//	func serveContent(w http.ResponseWriter, r *http.Request) {
+//		if web.CacheWithETag(w, r, "v1.2.3", web.OneYear) {
+//			return
+//		}
+//		// Write the response as usual.
+//	}
+func CacheWithETag(w http.ResponseWriter, r *http.Request, etag string, duration time.Duration) bool {
+	return CacheWithETagModTime(w, r, etag, time.Time{}, duration)
+}
+
+// CacheWithETagModTime works like CacheWithETag, but also sets the
+// Last-Modified header and checks the request's If-Modified-Since
+// header. As per RFC 7232, If-Modified-Since is ignored when the
+// request includes If-None-Match.
+//
+// For requests other than GET and HEAD, a matching If-None-Match
+// header results in a 412 Precondition Failed response instead.
+func CacheWithETagModTime(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time, duration time.Duration) bool {
+	etag = quoteETag(etag)
+	Cache(w, modTime, duration)
+	w.Header().Set("ETag", etag)
+	if !notModified(r, etag, modTime) {
+		return false
+	}
+
+	if r.Method == "GET" || r.Method == "HEAD" {
+		w.WriteHeader(http.StatusNotModified)
+	} else {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}
+	return true
+}
+
+// ServeContentCached works like http.ServeContent, but uses Cache
+// to advise the client to cache the response for the given duration,
+// and sends a weak entity tag based on the content's size and modTime,
+// unless the ETag header has already been set. The name is used to
+// detect the Content-Type, if it has not been set.
+//
+// Conditional requests are handled as by CacheWithETagModTime, so
+// If-None-Match takes precedence over If-Modified-Since, and a 304 Not
+// Modified response is sent if the client's copy is current. Otherwise,
+// http.ServeContent sends the content, handling Range and If-Range
+// requests. As weak entity tags cannot be used with If-Range, a
+// partial response is only sent for the default entity tag if the
+// If-Range header contains the modification time.
+//
+// If content is nil, the Site's 404 response is sent, or
+// NotFoundHandler is used if there is none.
+//
+//	f, err := os.Open(name)
+//	if err != nil {
+//		web.ServeContentCached(w, r, name, time.Time{}, 0, nil)
+//		return
+//	}
+//	defer f.Close()
+//	info, _ := f.Stat()
+//	web.ServeContentCached(w, r, name, info.ModTime(), time.Hour, f)
+func ServeContentCached(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, duration time.Duration, content io.ReadSeeker) {
+	if content == nil {
+		notFound(w, r)
+		return
+	}
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		Fail(w, r, &Error{Code: http.StatusInternalServerError, Err: err})
+		return
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		etag = `W/"` + strconv.FormatInt(modTime.UnixNano(), 36) + "-" + strconv.FormatInt(size, 36) + `"`
+	}
+	if CacheWithETagModTime(w, r, etag, modTime, duration) {
+		return
+	}
+	http.ServeContent(w, r, name, modTime, content)
+}
+
+// notModified determines whether the request's conditional
+// headers are satisfied by the given validators.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header["If-None-Match"]; len(inm) > 0 {
+		return etagMatch(strings.Join(inm, ","), etag)
+	}
+
+	if modTime.IsZero() || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(since)
+}
+
+// etagMatch reports whether the given list of entity tags from
+// an If-None-Match header contains etag, using weak comparison.
+func etagMatch(list, etag string) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(list, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// May be useful in cache durations.
+// Slightly less than one year, to conform to RFC 2616.
+var OneYear time.Duration = time.Hour * 24 * 364
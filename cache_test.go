@@ -0,0 +1,339 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	modTime := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+	w := httptest.NewRecorder()
+	Cache(w, modTime, time.Hour)
+
+	header := w.Header()
+	if got, want := header.Get("Cache-Control"), "public, max-age=3600"; got != want {
+		t.Errorf("got Cache-Control %q, want %q", got, want)
+	}
+	if got, want := header.Get("Last-Modified"), "Wed, 02 Jan 2013 03:04:05 GMT"; got != want {
+		t.Errorf("got Last-Modified %q, want %q", got, want)
+	}
+	if got, want := header.Values("Vary"), []string{"Accept-Encoding"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got Vary %q, want %q", got, want)
+	}
+}
+
+func TestCacheKeepsVary(t *testing.T) {
+	policy, err := NewCORSPolicy(CORSPolicy{AllowedOrigins: []string{"https://example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := policy.Handler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		Cache(w, time.Time{}, time.Hour)
+		Cache(w, time.Time{}, time.Hour)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got, want := w.Header().Values("Vary"), []string{"Origin", "Accept-Encoding"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got Vary %q, want %q", got, want)
+	}
+}
+
+func TestCacheWithSWR(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Vary", "Origin")
+	CacheWithSWR(w, 10*time.Minute, time.Minute, 24*time.Hour)
+
+	want := "public, max-age=600, stale-while-revalidate=60, stale-if-error=86400"
+	if got := w.Header().Get("Cache-Control"); got != want {
+		t.Errorf("got Cache-Control %q, want %q", got, want)
+	}
+	if got, want := w.Header().Values("Vary"), []string{"Origin", "Accept-Encoding"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got Vary %q, want %q", got, want)
+	}
+}
+
+func TestCacheOptions(t *testing.T) {
+	tests := []struct {
+		opts CacheOptions
+		want string
+	}{
+		{CacheOptions{}, ""},
+		{CacheOptions{Public: true, MaxAge: time.Hour, Immutable: true}, "public, max-age=3600, immutable"},
+		{CacheOptions{Public: true, MaxAge: time.Minute, SMaxAge: time.Hour}, "public, max-age=60, s-maxage=3600"},
+		{CacheOptions{Public: true, Private: true, MaxAge: 1500 * time.Millisecond, SMaxAge: time.Hour}, "private, max-age=1"},
+		{CacheOptions{MaxAge: -time.Second, MustRevalidate: true}, "must-revalidate"},
+	}
+	for _, test := range tests {
+		if got := test.opts.String(); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestCacheWith(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         CacheOptions
+		cacheControl string
+		expires      bool
+	}{
+		{"empty", CacheOptions{}, "", false},
+		{"max-age", CacheOptions{Private: true, MaxAge: time.Minute}, "private, max-age=60", true},
+		{"s-maxage only", CacheOptions{Public: true, SMaxAge: time.Hour}, "public, s-maxage=3600", false},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		CacheWith(w, test.opts)
+		got, ok := w.Header()["Cache-Control"]
+		if ok != (test.cacheControl != "") || ok && got[0] != test.cacheControl {
+			t.Errorf("%s: got Cache-Control %q, want %q", test.name, got, test.cacheControl)
+		}
+		expires := w.Header().Get("Expires")
+		if (expires != "") != test.expires {
+			t.Errorf("%s: got Expires %q", test.name, expires)
+		}
+		if !test.expires {
+			continue
+		}
+		at, err := http.ParseTime(expires)
+		if want := time.Now().Add(test.opts.MaxAge); err != nil || at.Before(want.Add(-2*time.Second)) || at.After(want) {
+			t.Errorf("%s: got Expires %q, want about %v", test.name, expires, want)
+		}
+	}
+}
+
+func TestCacheWithETag(t *testing.T) {
+	tests := []struct {
+		name, etag, ifNoneMatch string
+		want                    int
+	}{
+		{"no header", "v1", "", http.StatusOK},
+		{"match", "v1", `"v1"`, http.StatusNotModified},
+		{"mismatch", "v1", `"v2"`, http.StatusOK},
+		{"unquoted mismatch", "v1", "v1", http.StatusOK},
+		{"star", "v1", "*", http.StatusNotModified},
+		{"list", "v1", `"v0", "v1", "v2"`, http.StatusNotModified},
+		{"list without spaces", "v1", `"v0","v1"`, http.StatusNotModified},
+		{"list mismatch", "v1", `"v0", "v2"`, http.StatusOK},
+		{"weak request tag", "v1", `W/"v1"`, http.StatusNotModified},
+		{"weak response tag", `W/"v1"`, `"v1"`, http.StatusNotModified},
+		{"weak both", `W/"v1"`, `"v0", W/"v1"`, http.StatusNotModified},
+		{"weak mismatch", `W/"v1"`, `W/"v2"`, http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		done := CacheWithETag(w, r, test.etag, time.Hour)
+		if done != (test.want == http.StatusNotModified) || w.Code != test.want {
+			t.Errorf("%s: got %d, returned %v, want %d", test.name, w.Code, done, test.want)
+		}
+		if got, want := w.Header().Get("ETag"), quoteETag(test.etag); got != want {
+			t.Errorf("%s: got ETag %q, want %q", test.name, got, want)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("%s: got body %q", test.name, w.Body.String())
+		}
+	}
+}
+
+func TestCacheWithETagMultipleHeaders(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Add("If-None-Match", `"v0"`)
+	r.Header.Add("If-None-Match", `W/"v1"`)
+	w := httptest.NewRecorder()
+	if !CacheWithETag(w, r, "v1", time.Hour) || w.Code != http.StatusNotModified {
+		t.Errorf("got %d, want 304 for a tag in the second header", w.Code)
+	}
+}
+
+func TestCacheWithETagModTime(t *testing.T) {
+	modTime := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+	before := "Tue, 01 Jan 2013 00:00:00 GMT"
+	after := "Thu, 03 Jan 2013 00:00:00 GMT"
+
+	tests := []struct {
+		name, method, ifNoneMatch, ifModifiedSince string
+		want                                       int
+	}{
+		{"not modified since", "GET", "", after, http.StatusNotModified},
+		{"same second", "GET", "", "Wed, 02 Jan 2013 03:04:05 GMT", http.StatusNotModified},
+		{"modified since", "GET", "", before, http.StatusOK},
+		{"invalid date", "GET", "", "yesterday", http.StatusOK},
+		{"head", "HEAD", "", after, http.StatusNotModified},
+		{"post ignores date", "POST", "", after, http.StatusOK},
+
+		// If-None-Match takes precedence over If-Modified-Since.
+		{"etag matches, modified since", "GET", `"v1"`, before, http.StatusNotModified},
+		{"etag differs, not modified since", "GET", `"v2"`, after, http.StatusOK},
+
+		// Other methods fail the precondition instead.
+		{"post with matching etag", "POST", `"v1"`, "", http.StatusPreconditionFailed},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/", nil)
+		if test.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+		if test.ifModifiedSince != "" {
+			r.Header.Set("If-Modified-Since", test.ifModifiedSince)
+		}
+		w := httptest.NewRecorder()
+		done := CacheWithETagModTime(w, r, "v1", modTime, time.Hour)
+		if done != (test.want != http.StatusOK) || w.Code != test.want {
+			t.Errorf("%s: got %d, returned %v, want %d", test.name, w.Code, done, test.want)
+		}
+		if got := w.Header().Get("Last-Modified"); got != "Wed, 02 Jan 2013 03:04:05 GMT" {
+			t.Errorf("%s: got Last-Modified %q", test.name, got)
+		}
+	}
+}
+
+func TestServeContentCached(t *testing.T) {
+	modTime := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+	content := "body { color: red }\n"
+	etag := `W/"` + strconv.FormatInt(modTime.UnixNano(), 36) + "-" + strconv.FormatInt(int64(len(content)), 36) + `"`
+	before := "Tue, 01 Jan 2013 00:00:00 GMT"
+	after := "Thu, 03 Jan 2013 00:00:00 GMT"
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"unconditional", nil, http.StatusOK},
+		{"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"matching strong etag", map[string]string{"If-None-Match": strings.TrimPrefix(etag, "W/")}, http.StatusNotModified},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": after}, http.StatusNotModified},
+		{"modified since", map[string]string{"If-Modified-Since": before}, http.StatusOK},
+
+		// If-None-Match takes precedence over If-Modified-Since.
+		{"etag matches, modified since", map[string]string{"If-None-Match": etag, "If-Modified-Since": before}, http.StatusNotModified},
+		{"etag differs, not modified since", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": after}, http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/style.css", nil)
+		for name, value := range test.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		ServeContentCached(w, r, "style.css", modTime, time.Hour, strings.NewReader(content))
+
+		if w.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.want)
+		}
+		header := w.Header()
+		if header.Get("ETag") != etag || header.Get("Cache-Control") != "public, max-age=3600" || header.Get("Last-Modified") != "Wed, 02 Jan 2013 03:04:05 GMT" {
+			t.Errorf("%s: got ETag %q, Cache-Control %q, and Last-Modified %q", test.name, header.Get("ETag"), header.Get("Cache-Control"), header.Get("Last-Modified"))
+		}
+		wantBody := content
+		if test.want == http.StatusNotModified {
+			wantBody = ""
+		} else if got := header.Get("Content-Type"); got != "text/css; charset=utf-8" {
+			t.Errorf("%s: got Content-Type %q", test.name, got)
+		}
+		if w.Body.String() != wantBody {
+			t.Errorf("%s: got body %q, want %q", test.name, w.Body.String(), wantBody)
+		}
+	}
+}
+
+func TestServeContentCachedRange(t *testing.T) {
+	modTime := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		etag    string
+		ifRange string
+		want    int
+		body    string
+	}{
+		{"no If-Range", "", "", http.StatusPartialContent, "234"},
+		{"current date", "", "Wed, 02 Jan 2013 03:04:05 GMT", http.StatusPartialContent, "234"},
+		{"old date", "", "Tue, 01 Jan 2013 00:00:00 GMT", http.StatusOK, "0123456789"},
+		{"weak etag", "", `W/"x"`, http.StatusOK, "0123456789"},
+		{"given etag", `"v1"`, `"v1"`, http.StatusPartialContent, "234"},
+		{"other etag", `"v1"`, `"v2"`, http.StatusOK, "0123456789"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/digits.txt", nil)
+		r.Header.Set("Range", "bytes=2-4")
+		if test.ifRange != "" {
+			r.Header.Set("If-Range", test.ifRange)
+		}
+		w := httptest.NewRecorder()
+		if test.etag != "" {
+			w.Header().Set("ETag", test.etag)
+		}
+		ServeContentCached(w, r, "digits.txt", modTime, time.Hour, strings.NewReader("0123456789"))
+		if w.Code != test.want || w.Body.String() != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, w.Code, w.Body.String(), test.want, test.body)
+		}
+		if test.etag != "" && w.Header().Get("ETag") != test.etag {
+			t.Errorf("%s: got ETag %q, want %q", test.name, w.Header().Get("ETag"), test.etag)
+		}
+	}
+}
+
+func TestServeContentCachedNotFound(t *testing.T) {
+	w := httptest.NewRecorder()
+	ServeContentCached(w, httptest.NewRequest("GET", "/missing", nil), "missing", time.Time{}, time.Hour, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", w.Code)
+	}
+}
+
+func TestDoNotCacheHandler(t *testing.T) {
+	h := DoNotCacheHandler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusAccepted || w.Header().Get("Cache-Control") != "no-cache, no-store, must-revalidate" || w.Header().Get("Expires") != "0" {
+		t.Errorf("got %d with Cache-Control %q and Expires %q", w.Code, w.Header().Get("Cache-Control"), w.Header().Get("Expires"))
+	}
+}
+
+func TestDoNotCacheHandlerUpgrade(t *testing.T) {
+	srv := httptest.NewServer(DoNotCacheHandler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := UpgradeWebSocket(w, r, WSOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})))
+	defer srv.Close()
+
+	c := dialWS(t, srv)
+	if c.resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want 101", c.resp.StatusCode)
+	}
+	c.expectClose(WSCloseNormal)
+
+	w := httptest.NewRecorder()
+	DoNotCacheHandler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("got Flush error %v", err)
+		}
+	})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !w.Flushed {
+		t.Error("response not flushed")
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressMinSize is the default minimum size of
+// response which is compressed by a Compressor.
+const DefaultCompressMinSize = 1024
+
+// Compress creates an http.Handler which calls next, compressing
+// its responses using gzip or deflate, if the client accepts them.
+// Compress uses a Compressor with the default compression level.
+//
+//	site.Always(web.Compress(handler))
+func Compress(next http.Handler) http.Handler {
+	return defaultCompressor.Handler(next)
+}
+
+var defaultCompressor = NewCompressor(gzip.DefaultCompression)
+
+// CompressLevel creates Middleware which works like Compress, but
+// uses the given compression level, as defined in compress/flate.
+// CompressLevel panics if level is not a valid compression level.
+// Use NewCompressor to change the minimum size of response which
+// is compressed.
+//
+//	site.Use(web.CompressLevel(gzip.BestSpeed))
+func CompressLevel(level int) Middleware {
+	return NewCompressor(level).Handler
+}
+
+// Compressor compresses responses, using the content codings
+// accepted by the client, as given by its Accept-Encoding HTTP
+// header. If the client accepts several codings equally, the one
+// registered first is used.
+//
+// Responses are not compressed if they are smaller than MinSize,
+// already have a Content-Encoding, have no body, are partial
+// content, or have a Content-Type which is usually compressed
+// already, such as images, audio, video, fonts, and archives. If
+// the handler flushes the response, it is compressed regardless
+// of its size, so that streamed responses are still compressed.
+//
+// Compressor adds Accept-Encoding to the Vary HTTP header for all
+// responses, and removes any Content-Length from responses which
+// are compressed.
+//
+// Compressor must be created with NewCompressor.
+type Compressor struct {
+	// MinSize is the minimum size of response body which is
+	// compressed. It defaults to DefaultCompressMinSize. If
+	// MinSize is zero or negative, all responses with a body
+	// are compressed.
+	MinSize int
+
+	encodings []*encoding
+}
+
+// NewCompressor creates a Compressor which supports the gzip
+// and deflate content codings, using the given compression
+// level, as defined in compress/flate. NewCompressor panics
+// if level is not a valid compression level.
+//
+//	compressor := web.NewCompressor(gzip.BestSpeed)
+//	compressor.MinSize = 512
+//	site.Always(compressor.Handler(handler))
+func NewCompressor(level int) *Compressor {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		panic("web: invalid compression level " + strconv.Itoa(level))
+	}
+
+	c := &Compressor{MinSize: DefaultCompressMinSize}
+	c.Register("gzip", func(w io.Writer) io.WriteCloser {
+		gw, _ := gzip.NewWriterLevel(w, level)
+		return gw
+	})
+	c.Register("deflate", func(w io.Writer) io.WriteCloser {
+		zw, _ := zlib.NewWriterLevel(w, level)
+		return zw
+	})
+	return c
+}
+
+// Register adds support for a content coding, such as "br". The
+// newWriter function is called to create a writer which compresses
+// data written to it and writes it to w, flushing any remaining data
+// when it is closed. If the writer has a Reset(io.Writer) method, as
+// the writers in compress/gzip and compress/zlib do, it is reused.
+//
+// Register replaces any existing support for the coding. It must not
+// be called while the Compressor is in use.
+func (c *Compressor) Register(coding string, newWriter func(w io.Writer) io.WriteCloser) {
+	coding = strings.ToLower(coding)
+	e := &encoding{name: coding, newWriter: newWriter}
+	for i, existing := range c.encodings {
+		if existing.name == coding {
+			c.encodings[i] = e
+			return
+		}
+	}
+	c.encodings = append(c.encodings, e)
+}
+
+// Handler returns an http.Handler which calls next, compressing
+// its responses.
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Accept-Encoding")
+		e := c.negotiate(r.Header.Get("Accept-Encoding"))
+		if e == nil || r.Method == "HEAD" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, compressor: c, encoding: e}
+		next.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// negotiate returns the most preferred encoding
+// accepted by the client, or nil if there is none.
+func (c *Compressor) negotiate(accept string) *encoding {
+	if accept == "" {
+		return nil
+	}
+
+	var best *encoding
+	bestQ := 0.0
+	for _, e := range c.encodings {
+		if q := acceptQuality(accept, e.name); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// minSize returns the minimum size of
+// response body to compress.
+func (c *Compressor) minSize() int {
+	if c.MinSize <= 0 {
+		return 1
+	}
+	return c.MinSize
+}
+
+// acceptQuality returns the quality value given for the coding
+// in the Accept-Encoding header, falling back to any wildcard.
+func acceptQuality(accept, coding string) float64 {
+	wildcard := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		q := quality(params)
+		switch {
+		case strings.EqualFold(name, coding):
+			return q
+		case name == "*":
+			wildcard = q
+		}
+	}
+	return wildcard
+}
+
+// quality returns the quality value in the parameters
+// of an element of an Accept header. If there is none,
+// or it is malformed, 1 is returned.
+func quality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q >= 0 && q <= 1 {
+				return q
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// addVary adds the given header name to the
+// Vary header, if it is not already present.
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// incompressible reports whether responses with the given
+// Content-Type are usually compressed already.
+func incompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "font/woff"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip",
+		"application/x-bzip2", "application/x-xz", "application/zstd",
+		"application/x-7z-compressed", "application/x-rar-compressed",
+		"application/font-woff", "application/font-woff2":
+		return true
+	}
+	return false
+}
+
+// encoding is a content coding supported by a Compressor.
+type encoding struct {
+	name      string
+	newWriter func(w io.Writer) io.WriteCloser
+	pool      sync.Pool
+}
+
+// resetter is implemented by compressing
+// writers which can be reused.
+type resetter interface {
+	Reset(w io.Writer)
+}
+
+func (e *encoding) get(w io.Writer) io.WriteCloser {
+	if zw, ok := e.pool.Get().(io.WriteCloser); ok {
+		zw.(resetter).Reset(w)
+		return zw
+	}
+	return e.newWriter(w)
+}
+
+func (e *encoding) put(zw io.WriteCloser) {
+	if _, ok := zw.(resetter); ok {
+		e.pool.Put(zw)
+	}
+}
+
+// compressResponseWriter buffers the start of the response,
+// until it can decide whether to compress the response.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor *Compressor
+	encoding   *encoding
+	status     int
+	buf        []byte
+	decided    bool
+	writer     io.WriteCloser // Nil if the response is not compressed.
+	hijacked   bool
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	switch {
+	case w.decided:
+		w.ResponseWriter.WriteHeader(status)
+	case status >= 100 && status < 200:
+		w.ResponseWriter.WriteHeader(status)
+	case w.status == 0:
+		w.status = status
+		if !bodyAllowed(status) {
+			w.decide(false)
+		}
+	}
+}
+
+func (w *compressResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.compressor.minSize() {
+			return len(data), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+
+	if w.writer != nil {
+		return w.writer.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// decide determines whether to compress the response, then
+// sends the response status and any buffered data.
+func (w *compressResponseWriter) decide(compress bool) error {
+	w.decided = true
+	header := w.Header()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	compress = compress && bodyAllowed(w.status) &&
+		w.status != http.StatusPartialContent &&
+		header.Get("Content-Encoding") == "" &&
+		header.Get("Content-Range") == "" &&
+		!incompressible(header.Get("Content-Type"))
+	if compress {
+		header.Set("Content-Encoding", w.encoding.name)
+		header.Del("Content-Length")
+		w.writer = w.encoding.get(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.writer != nil {
+		_, err = w.writer.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close completes the response, once the handler has returned.
+func (w *compressResponseWriter) close() {
+	if w.hijacked {
+		return
+	}
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// Nothing was written.
+			return
+		}
+		w.decide(len(w.buf) >= w.compressor.minSize())
+	}
+	if w.writer != nil {
+		w.writer.Close()
+		w.encoding.put(w.writer)
+		w.writer = nil
+	}
+}
+
+func (w *compressResponseWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if f, ok := w.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyAllowed reports whether a response with
+// the given status may include a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,214 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveCompressed serves a GET request
+// with the given Accept-Encoding.
+func serveCompressed(h http.Handler, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if accept != "" {
+		r.Header.Set("Accept-Encoding", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// decompress returns the decoded response body.
+func decompress(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var r io.Reader = w.Body
+	var err error
+	switch coding := w.Header().Get("Content-Encoding"); coding {
+	case "gzip":
+		r, err = gzip.NewReader(r)
+	case "deflate":
+		r, err = zlib.NewReader(r)
+	case "":
+	default:
+		t.Fatalf("unexpected Content-Encoding %q", coding)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	body := strings.Repeat("Hello, world! ", 200)
+	h := Compress(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+
+	tests := []struct {
+		accept, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"GZIP", "gzip"},
+		{"*", "gzip"},
+		{"gzip;q=0", ""},
+		{"br", ""},
+	}
+	for _, test := range tests {
+		w := serveCompressed(h, test.accept)
+		if got := w.Header().Get("Content-Encoding"); got != test.want {
+			t.Errorf("%q: got Content-Encoding %q, want %q", test.accept, got, test.want)
+			continue
+		}
+		if test.want != "" && w.Header().Get("Content-Length") != "" {
+			t.Errorf("%q: compressed response has Content-Length %q", test.accept, w.Header().Get("Content-Length"))
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%q: got Vary %q", test.accept, w.Header().Get("Vary"))
+		}
+		if got := decompress(t, w); got != body {
+			t.Errorf("%q: got %d bytes, want %d", test.accept, len(got), len(body))
+		}
+	}
+}
+
+func TestCompressBypass(t *testing.T) {
+	large := strings.Repeat("a", DefaultCompressMinSize)
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		encoding    string
+		body        string
+	}{
+		{"small", http.StatusOK, "text/plain", "", "hello"},
+		{"image", http.StatusOK, "image/png", "", large},
+		{"archive", http.StatusOK, "application/zip; charset=binary", "", large},
+		{"encoded", http.StatusOK, "text/plain", "br", large},
+		{"partial", http.StatusPartialContent, "text/plain", "", large},
+		{"no content", http.StatusNoContent, "", "", ""},
+	}
+	for _, test := range tests {
+		h := Compress(Handler(func(w http.ResponseWriter, r *http.Request) {
+			if test.contentType != "" {
+				w.Header().Set("Content-Type", test.contentType)
+			}
+			if test.encoding != "" {
+				w.Header().Set("Content-Encoding", test.encoding)
+			}
+			w.WriteHeader(test.status)
+			io.WriteString(w, test.body)
+		}))
+		w := serveCompressed(h, "gzip")
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.status)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%s: got Content-Encoding %q, want %q", test.name, got, test.encoding)
+		}
+		if w.Body.String() != test.body {
+			t.Errorf("%s: body was modified", test.name)
+		}
+	}
+}
+
+func TestCompressMinSize(t *testing.T) {
+	c := NewCompressor(gzip.BestSpeed)
+	c.MinSize = 0
+	h := c.Handler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hi")
+	}))
+	w := serveCompressed(h, "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || decompress(t, w) != "hi" {
+		t.Errorf("got Content-Encoding %q", w.Header().Get("Content-Encoding"))
+	}
+
+	// HEAD requests are never compressed.
+	r := httptest.NewRequest("HEAD", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("HEAD: got Content-Encoding %q", w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	h := Compress(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "data: 2\n\n")
+	}))
+	w := serveCompressed(h, "gzip")
+	if !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got Content-Encoding %q, flushed %v", w.Header().Get("Content-Encoding"), w.Flushed)
+	}
+	if got := decompress(t, w); got != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestNewCompressorInvalidLevel(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewCompressor did not panic")
+		}
+	}()
+	NewCompressor(100)
+}
+
+func TestCompressLevel(t *testing.T) {
+	body := strings.Repeat("Hello, world! ", 200)
+	h := Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+	})
+
+	sizes := make(map[int]int)
+	for _, level := range []int{gzip.NoCompression, gzip.BestSpeed, gzip.BestCompression} {
+		for _, accept := range []string{"gzip", "deflate"} {
+			w := serveCompressed(CompressLevel(level)(h), accept)
+			if got := w.Header().Get("Content-Encoding"); got != accept {
+				t.Fatalf("level %d: got Content-Encoding %q, want %q", level, got, accept)
+			}
+			if accept == "gzip" {
+				sizes[level] = w.Body.Len()
+			}
+			if got := decompress(t, w); got != body {
+				t.Errorf("level %d: %s body did not round trip", level, accept)
+			}
+		}
+	}
+	if sizes[gzip.NoCompression] <= len(body) || sizes[gzip.BestSpeed] >= len(body) || sizes[gzip.BestCompression] > sizes[gzip.BestSpeed] {
+		t.Errorf("got compressed sizes %v for a %d byte body", sizes, len(body))
+	}
+}
+
+func TestCompressLevelInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("CompressLevel did not panic")
+		}
+	}()
+	CompressLevel(-3)
+}
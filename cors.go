@@ -0,0 +1,196 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy describes which cross-origin requests are permitted,
+// using the Cross-Origin Resource Sharing (CORS) headers.
+//
+// AllowedOrigins lists the permitted origins, such as
+// "https://example.com", or "*" to permit any origin. An origin
+// can also use a wildcard for the subdomain, such as
+// "https://*.example.com", which permits any subdomain of
+// example.com, but not example.com itself. If AllowedMethods is
+// empty, GET, HEAD, and POST are permitted. ExposedHeaders lists
+// the response headers which scripts may read, in addition to
+// the CORS-safelisted headers.
+//
+// CORSPolicy must be created with NewCORSPolicy.
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+	anyOrigin        bool
+}
+
+// NewCORSPolicy checks the given policy's configuration and returns
+// a copy which is ready to use.
+//
+//	policy, err := web.NewCORSPolicy(web.CORSPolicy{
+//		AllowedOrigins: []string{"https://example.com"},
+//		AllowedMethods: []string{"GET", "PUT"},
+//		MaxAge:         time.Hour,
+//	})
+//	if err != nil {
+//		panic(err)
+//	}
+//	site.HasPrefix(policy.Handler(apiHandler), "/api/")
+func NewCORSPolicy(policy CORSPolicy) (*CORSPolicy, error) {
+	if len(policy.AllowedOrigins) == 0 {
+		return nil, errors.New("web: CORS policy has no allowed origins")
+	}
+	if policy.MaxAge < 0 {
+		return nil, errors.New("web: CORS policy has a negative max age")
+	}
+
+	p := &CORSPolicy{
+		AllowedOrigins:   append([]string(nil), policy.AllowedOrigins...),
+		AllowedMethods:   append([]string(nil), policy.AllowedMethods...),
+		AllowedHeaders:   append([]string(nil), policy.AllowedHeaders...),
+		ExposedHeaders:   append([]string(nil), policy.ExposedHeaders...),
+		AllowCredentials: policy.AllowCredentials,
+		MaxAge:           policy.MaxAge,
+	}
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+		} else if origin == "" || strings.HasSuffix(origin, "/") || !validOriginWildcard(origin) {
+			return nil, fmt.Errorf("web: invalid CORS origin %q", origin)
+		}
+	}
+	if p.anyOrigin && p.AllowCredentials {
+		return nil, errors.New("web: CORS policy cannot allow credentials from any origin")
+	}
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = []string{"GET", "HEAD", "POST"}
+	}
+	for i, method := range p.AllowedMethods {
+		if method == "" || strings.ContainsAny(method, " \t,") {
+			return nil, fmt.Errorf("web: invalid CORS method %q", method)
+		}
+		p.AllowedMethods[i] = strings.ToUpper(method)
+	}
+
+	return p, nil
+}
+
+// Handler returns an http.Handler which adds the CORS headers to
+// responses for permitted origins before calling next. Preflight
+// requests are answered with a 204 No Content response, without
+// calling next. Requests from other origins, and preflight requests
+// for methods which are not permitted, receive no CORS headers, so
+// the browser blocks them.
+func (p *CORSPolicy) Handler(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		addVary(header, "Origin")
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			addVary(header, "Access-Control-Request-Method")
+			addVary(header, "Access-Control-Request-Headers")
+		}
+		if !p.allowOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Handle preflight requests.
+		if preflight {
+			if !p.allowMethod(r.Header.Get("Access-Control-Request-Method")) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			p.setOrigin(header, origin)
+			header.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+			if len(p.AllowedHeaders) > 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+			}
+			if p.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(p.MaxAge/time.Second), 10))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		p.setOrigin(header, origin)
+		if len(p.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setOrigin sets the headers permitting the given origin.
+func (p *CORSPolicy) setOrigin(header http.Header, origin string) {
+	if p.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *CORSPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == origin {
+			return true
+		}
+		if i := strings.Index(allowed, "*"); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				sub := origin[len(prefix) : len(origin)-len(suffix)]
+				if !strings.ContainsAny(sub, "/:@") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowMethod(method string) bool {
+	for _, allowed := range p.AllowedMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// validOriginWildcard reports whether any wildcard in the
+// origin is a single subdomain wildcard, as in
+// "https://*.example.com".
+func validOriginWildcard(origin string) bool {
+	i := strings.Index(origin, "*")
+	if i < 0 {
+		return true
+	}
+	return strings.Count(origin, "*") == 1 &&
+		strings.HasSuffix(origin[:i], "://") &&
+		strings.HasPrefix(origin[i+1:], ".") &&
+		len(origin[i+1:]) > 1
+}
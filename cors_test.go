@@ -0,0 +1,199 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// corsHeaders lists the CORS response headers.
+var corsHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Max-Age",
+	"Access-Control-Expose-Headers",
+}
+
+func newTestCORSPolicy(t *testing.T, policy CORSPolicy) http.Handler {
+	t.Helper()
+	p, err := NewCORSPolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Handler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "next")
+	}))
+}
+
+// serveCORS serves a request from the given origin. If
+// preflight is not empty, the request is a preflight
+// request for that method.
+func serveCORS(h http.Handler, origin, preflight string) *httptest.ResponseRecorder {
+	method := "GET"
+	if preflight != "" {
+		method = "OPTIONS"
+	}
+	r := httptest.NewRequest(method, "/api/", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	if preflight != "" {
+		r.Header.Set("Access-Control-Request-Method", preflight)
+		r.Header.Set("Access-Control-Request-Headers", "content-type")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// checkCORSHeaders checks that exactly the given CORS headers were sent.
+func checkCORSHeaders(t *testing.T, name string, header http.Header, want map[string]string) {
+	t.Helper()
+	for _, key := range corsHeaders {
+		if got := header.Get(key); got != want[key] {
+			t.Errorf("%s: got %s %q, want %q", name, key, got, want[key])
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := newTestCORSPolicy(t, CORSPolicy{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowedMethods:   []string{"get", "PUT"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+
+	w := serveCORS(h, "https://example.com", "PUT")
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("got %d %q, want 204 without calling next", w.Code, w.Body.String())
+	}
+	checkCORSHeaders(t, "PUT", w.Header(), map[string]string{
+		"Access-Control-Allow-Origin":      "https://example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, PUT",
+		"Access-Control-Allow-Headers":     "Content-Type",
+		"Access-Control-Max-Age":           "3600",
+	})
+	if got := w.Header().Values("Vary"); len(got) != 3 {
+		t.Errorf("got Vary %q", got)
+	}
+
+	// Methods which are not permitted receive no CORS headers.
+	w = serveCORS(h, "https://example.com", "DELETE")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE: got status %d, want 204", w.Code)
+	}
+	checkCORSHeaders(t, "DELETE", w.Header(), nil)
+
+	// Nor do other origins, which are passed to next.
+	w = serveCORS(h, "https://evil.example", "PUT")
+	if w.Body.String() != "next" {
+		t.Errorf("other origin: got body %q, want next", w.Body.String())
+	}
+	checkCORSHeaders(t, "other origin", w.Header(), nil)
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	h := newTestCORSPolicy(t, CORSPolicy{
+		AllowedOrigins: []string{"https://example.com", "https://*.example.org"},
+		ExposedHeaders: []string{"X-Request-ID", "ETag"},
+	})
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://example.com", true},
+		{"https://www.example.org", true},
+		{"https://api.example.org", true},
+		{"https://example.org", false},
+		{"http://www.example.org", false},
+		{"https://www.example.org.evil.example", false},
+		{"https://evil.example/.example.org", false},
+		{"https://user@x.example.org", false},
+		{"https://example.com.evil.example", false},
+		{"null", false},
+	}
+	for _, test := range tests {
+		w := serveCORS(h, test.origin, "")
+		if w.Body.String() != "next" {
+			t.Errorf("%s: next was not called", test.origin)
+		}
+		if got := w.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("%s: got Vary %q, want Origin", test.origin, got)
+		}
+		var want map[string]string
+		if test.allowed {
+			want = map[string]string{
+				"Access-Control-Allow-Origin":   test.origin,
+				"Access-Control-Expose-Headers": "X-Request-ID, ETag",
+			}
+		}
+		checkCORSHeaders(t, test.origin, w.Header(), want)
+	}
+
+	// Same-origin requests have no Origin header.
+	w := serveCORS(h, "", "")
+	if w.Body.String() != "next" || w.Header().Get("Vary") != "" {
+		t.Errorf("no origin: got %q with Vary %q", w.Body.String(), w.Header().Get("Vary"))
+	}
+	checkCORSHeaders(t, "no origin", w.Header(), nil)
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := newTestCORSPolicy(t, CORSPolicy{AllowedOrigins: []string{"*"}})
+	w := serveCORS(h, "https://anywhere.example", "")
+	checkCORSHeaders(t, "simple", w.Header(), map[string]string{
+		"Access-Control-Allow-Origin": "*",
+	})
+	w = serveCORS(h, "https://anywhere.example", "POST")
+	checkCORSHeaders(t, "preflight", w.Header(), map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "GET, HEAD, POST",
+	})
+}
+
+func TestNewCORSPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy CORSPolicy
+	}{
+		{"no origins", CORSPolicy{}},
+		{"credentials from any origin", CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}},
+		{"negative max age", CORSPolicy{AllowedOrigins: []string{"*"}, MaxAge: -time.Second}},
+		{"empty origin", CORSPolicy{AllowedOrigins: []string{""}}},
+		{"trailing slash", CORSPolicy{AllowedOrigins: []string{"https://example.com/"}}},
+		{"bare wildcard domain", CORSPolicy{AllowedOrigins: []string{"https://*example.com"}}},
+		{"wildcard in the middle", CORSPolicy{AllowedOrigins: []string{"https://www.*.example.com"}}},
+		{"two wildcards", CORSPolicy{AllowedOrigins: []string{"https://*.*.example.com"}}},
+		{"invalid method", CORSPolicy{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET, PUT"}}},
+	}
+	for _, test := range tests {
+		if _, err := NewCORSPolicy(test.policy); err == nil {
+			t.Errorf("%s: got no error", test.name)
+		}
+	}
+}
+
+func TestNewCORSPolicyCopies(t *testing.T) {
+	origins := []string{"https://example.com"}
+	p, err := NewCORSPolicy(CORSPolicy{AllowedOrigins: origins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins[0] = "https://evil.example"
+	w := serveCORS(p.Handler(emptyHandler), "https://example.com", "")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Error("policy was changed by modifying the original origins")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CSPBuilder is used to build a Content-Security-Policy HTTP header.
+// Its methods add sources to the policy's directives, and can be
+// chained together. The zero value is an empty policy.
+//
+//	csp := new(web.CSPBuilder).
+//		DefaultSrc("'self'").
+//		ScriptSrc("'self'", "https://cdn.example.com").
+//		ReportURI("/csp-report")
+//
+//	// Content-Security-Policy: default-src 'self'; script-src 'self' https://cdn.example.com; report-uri /csp-report
+//	err := csp.Apply(w)
+type CSPBuilder struct {
+	directives []cspDirective
+	reportOnly bool
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// DefaultSrc adds sources to the default-src directive.
+func (c *CSPBuilder) DefaultSrc(sources ...string) *CSPBuilder {
+	return c.Directive("default-src", sources...)
+}
+
+// ScriptSrc adds sources to the script-src directive.
+func (c *CSPBuilder) ScriptSrc(sources ...string) *CSPBuilder {
+	return c.Directive("script-src", sources...)
+}
+
+// StyleSrc adds sources to the style-src directive.
+func (c *CSPBuilder) StyleSrc(sources ...string) *CSPBuilder {
+	return c.Directive("style-src", sources...)
+}
+
+// ReportURI sets the report-uri directive, to which the client
+// reports violations of the policy.
+func (c *CSPBuilder) ReportURI(uri string) *CSPBuilder {
+	for i := range c.directives {
+		if c.directives[i].name == "report-uri" {
+			c.directives[i].sources = []string{uri}
+			return c
+		}
+	}
+	return c.Directive("report-uri", uri)
+}
+
+// ReportOnly determines whether the policy is only reported,
+// rather than enforced, using the Content-Security-Policy-Report-Only
+// HTTP header.
+func (c *CSPBuilder) ReportOnly(reportOnly bool) *CSPBuilder {
+	c.reportOnly = reportOnly
+	return c
+}
+
+// Directive adds sources to the named directive, such as
+// "img-src" or "frame-ancestors".
+func (c *CSPBuilder) Directive(name string, sources ...string) *CSPBuilder {
+	for i := range c.directives {
+		if c.directives[i].name == name {
+			c.directives[i].sources = append(c.directives[i].sources, sources...)
+			return c
+		}
+	}
+	c.directives = append(c.directives, cspDirective{name, append([]string(nil), sources...)})
+	return c
+}
+
+// Build returns the policy as a header value. Build returns an error
+// if a directive combines 'none' with other sources, or combines
+// 'unsafe-inline' with a nonce or hash, which causes the client to
+// ignore 'unsafe-inline'.
+func (c *CSPBuilder) Build() (string, error) {
+	directives := make([]string, 0, len(c.directives))
+	for _, directive := range c.directives {
+		var none, unsafeInline, nonce bool
+		for _, source := range directive.sources {
+			switch {
+			case source == "'none'":
+				none = true
+			case source == "'unsafe-inline'":
+				unsafeInline = true
+			case strings.HasPrefix(source, "'nonce-"), strings.HasPrefix(source, "'sha"):
+				nonce = true
+			}
+		}
+		if none && len(directive.sources) > 1 {
+			return "", fmt.Errorf("web: CSP directive %s combines 'none' with other sources", directive.name)
+		}
+		if unsafeInline && nonce {
+			return "", fmt.Errorf("web: CSP directive %s combines 'unsafe-inline' with a nonce or hash", directive.name)
+		}
+
+		if len(directive.sources) == 0 {
+			directives = append(directives, directive.name)
+		} else {
+			directives = append(directives, directive.name+" "+strings.Join(directive.sources, " "))
+		}
+	}
+	return strings.Join(directives, "; "), nil
+}
+
+// Apply sets the Content-Security-Policy header, or the
+// Content-Security-Policy-Report-Only header if the policy
+// is report-only. Apply returns any error from Build, in
+// which case no header is set.
+func (c *CSPBuilder) Apply(w http.ResponseWriter) error {
+	policy, err := c.Build()
+	if err != nil {
+		return err
+	}
+	if c.reportOnly {
+		w.Header().Set("Content-Security-Policy-Report-Only", policy)
+	} else {
+		w.Header().Set("Content-Security-Policy", policy)
+	}
+	return nil
+}
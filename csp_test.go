@@ -0,0 +1,90 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSPBuild(t *testing.T) {
+	csp := new(CSPBuilder).
+		DefaultSrc("'self'").
+		ScriptSrc("'self'", "https://cdn.example.com").
+		ReportURI("/old-report").
+		Directive("upgrade-insecure-requests").
+		StyleSrc("'nonce-abc'").
+		ScriptSrc("'sha256-xyz'").
+		ReportURI("/csp-report")
+
+	got, err := csp.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "default-src 'self'; script-src 'self' https://cdn.example.com 'sha256-xyz'; report-uri /csp-report; upgrade-insecure-requests; style-src 'nonce-abc'"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	empty, err := new(CSPBuilder).Build()
+	if err != nil || empty != "" {
+		t.Errorf("empty policy: got %q, %v", empty, err)
+	}
+}
+
+func TestCSPBuildErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csp  *CSPBuilder
+	}{
+		{"none with source", new(CSPBuilder).ScriptSrc("'none'", "'self'")},
+		{"none added later", new(CSPBuilder).Directive("object-src", "'none'").DefaultSrc("'none'").DefaultSrc("https://example.com")},
+		{"unsafe-inline with nonce", new(CSPBuilder).ScriptSrc("'unsafe-inline'", "'nonce-abc'")},
+		{"unsafe-inline with hash", new(CSPBuilder).StyleSrc("'sha384-xyz'").StyleSrc("'unsafe-inline'")},
+	}
+	for _, test := range tests {
+		if policy, err := test.csp.Build(); err == nil {
+			t.Errorf("%s: got policy %q, want error", test.name, policy)
+		}
+		w := httptest.NewRecorder()
+		if err := test.csp.Apply(w); err == nil {
+			t.Errorf("%s: Apply did not return an error", test.name)
+		}
+		if len(w.Header()) != 0 {
+			t.Errorf("%s: Apply set headers %v", test.name, w.Header())
+		}
+	}
+
+	// 'unsafe-inline' is allowed alongside a nonce in a different directive.
+	if _, err := new(CSPBuilder).ScriptSrc("'nonce-abc'").StyleSrc("'unsafe-inline'").Build(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCSPApply(t *testing.T) {
+	csp := new(CSPBuilder).DefaultSrc("'self'")
+
+	w := httptest.NewRecorder()
+	if err := csp.Apply(w); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Get("Content-Security-Policy"); got != "default-src 'self'" {
+		t.Errorf("got Content-Security-Policy %q", got)
+	}
+	if got := w.Header().Get("Content-Security-Policy-Report-Only"); got != "" {
+		t.Errorf("got Content-Security-Policy-Report-Only %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	if err := csp.ReportOnly(true).Apply(w); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Get("Content-Security-Policy-Report-Only"); got != "default-src 'self'" {
+		t.Errorf("got Content-Security-Policy-Report-Only %q", got)
+	}
+	if got := w.Header().Get("Content-Security-Policy"); got != "" {
+		t.Errorf("got Content-Security-Policy %q with ReportOnly", got)
+	}
+}
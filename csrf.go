@@ -0,0 +1,149 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// csrfTokenSize is the number of random bytes in a CSRF token.
+const csrfTokenSize = 32
+
+// CSRFOptions controls how CSRF protects requests.
+type CSRFOptions struct {
+	// CookieName is the name of the cookie holding the
+	// token. If empty, "csrf_token" is used.
+	CookieName string
+
+	// FieldName is the name of the form field checked for
+	// the token. If empty, "csrf_token" is used.
+	FieldName string
+
+	// HeaderName is the name of the HTTP header checked for
+	// the token. If empty, "X-CSRF-Token" is used.
+	HeaderName string
+
+	// Secure sets the Secure attribute of the cookie, so
+	// it is only sent over HTTPS.
+	Secure bool
+
+	// ExemptPrefixes lists path prefixes, such as "/webhooks/",
+	// for which requests are not checked.
+	ExemptPrefixes []string
+
+	// FailureHandler is called for requests which fail the
+	// check. If nil, a 403 Forbidden response is sent.
+	FailureHandler http.Handler
+}
+
+// CSRF creates Middleware which protects against cross-site request
+// forgery, using the double-submit cookie pattern. Each client is given
+// a random token in a cookie, which can be retrieved with CSRFToken for
+// embedding in forms. Requests with unsafe methods, such as POST, PUT,
+// PATCH, and DELETE, must include the same token in the form field or
+// HTTP header given in opts, or they are passed to the failure handler.
+// Tokens are compared in constant time. Requests with the safe methods
+// GET, HEAD, OPTIONS, and TRACE, and requests for the exempt path
+// prefixes, are not checked.
+//
+//	csrf := web.CSRF(web.CSRFOptions{
+//		Secure:         true,
+//		ExemptPrefixes: []string{"/webhooks/"},
+//	})
+//	site.Use(csrf)
+//
+// Forms then include the token:
+//
+//	<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+func CSRF(opts CSRFOptions) Middleware {
+	if opts.CookieName == "" {
+		opts.CookieName = "csrf_token"
+	}
+	if opts.FieldName == "" {
+		opts.FieldName = "csrf_token"
+	}
+	if opts.HeaderName == "" {
+		opts.HeaderName = "X-CSRF-Token"
+	}
+	if opts.FailureHandler == nil {
+		opts.FailureHandler = Handler(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range opts.ExemptPrefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			token := ""
+			if cookie, err := r.Cookie(opts.CookieName); err == nil && validCSRFToken(cookie.Value) {
+				token = cookie.Value
+			}
+
+			switch r.Method {
+			case "GET", "HEAD", "OPTIONS", "TRACE":
+			default:
+				given := r.Header.Get(opts.HeaderName)
+				if given == "" {
+					given = r.PostFormValue(opts.FieldName)
+				}
+				if token == "" || !SecureCompare(given, token) {
+					opts.FailureHandler.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			if token == "" {
+				token = newCSRFToken()
+				http.SetCookie(w, &http.Cookie{
+					Name:     opts.CookieName,
+					Value:    token,
+					Path:     "/",
+					Secure:   opts.Secure,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+		})
+	}
+}
+
+// CSRFToken returns the CSRF token for the request, added to its
+// context by CSRF, or the empty string if there is none.
+//
+//	data.CSRFToken = web.CSRFToken(r)
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// csrfKey is the context key for the CSRF token.
+type csrfKey struct{}
+
+// newCSRFToken returns a random CSRF token.
+func newCSRFToken() string {
+	b := make([]byte, csrfTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// validCSRFToken reports whether a token provided
+// by the client could have been issued by CSRF.
+func validCSRFToken(token string) bool {
+	b, err := base64.RawURLEncoding.Strict().DecodeString(token)
+	return err == nil && len(b) == csrfTokenSize
+}
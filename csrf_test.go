@@ -0,0 +1,150 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// csrfHandler returns a handler protected by CSRF,
+// which sends the request's CSRF token.
+func csrfHandler(opts CSRFOptions) http.Handler {
+	return CSRF(opts)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r)))
+	}))
+}
+
+// csrfCookie makes a GET request, returning the CSRF cookie issued.
+func csrfCookie(t *testing.T, h http.Handler) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/form", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: got %d, want 200", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "csrf_token" {
+		t.Fatalf("got cookies %v, want csrf_token", cookies)
+	}
+	if got := w.Body.String(); got != cookies[0].Value {
+		t.Fatalf("got token %q, want cookie value %q", got, cookies[0].Value)
+	}
+	if !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Errorf("got cookie %v, want HttpOnly and SameSite=Lax", cookies[0])
+	}
+	return cookies[0]
+}
+
+func TestCSRFSafeMethods(t *testing.T) {
+	h := csrfHandler(CSRFOptions{})
+	cookie := csrfCookie(t, h)
+
+	// The existing token is reused.
+	r := httptest.NewRequest("HEAD", "/form", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 0 {
+		t.Errorf("HEAD: got %d with cookies %v, want 200 without cookies", w.Code, w.Result().Cookies())
+	}
+	if w.Body.String() != cookie.Value {
+		t.Errorf("HEAD: got token %q, want %q", w.Body.String(), cookie.Value)
+	}
+}
+
+func TestCSRFForm(t *testing.T) {
+	h := csrfHandler(CSRFOptions{})
+	cookie := csrfCookie(t, h)
+
+	form := url.Values{"csrf_token": {cookie.Value}}
+	r := httptest.NewRequest("POST", "/form", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d, want 200", w.Code)
+	}
+}
+
+func TestCSRFHeader(t *testing.T) {
+	h := csrfHandler(CSRFOptions{})
+	cookie := csrfCookie(t, h)
+
+	for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+		r := httptest.NewRequest(method, "/api", nil)
+		r.Header.Set("X-CSRF-Token", cookie.Value)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got %d, want 200", method, w.Code)
+		}
+	}
+}
+
+func TestCSRFMissingToken(t *testing.T) {
+	h := csrfHandler(CSRFOptions{})
+	cookie := csrfCookie(t, h)
+	other := csrfCookie(t, h)
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		token  string
+	}{
+		{"no token", cookie, ""},
+		{"wrong token", cookie, other.Value},
+		{"no cookie", nil, cookie.Value},
+		{"invalid cookie", &http.Cookie{Name: "csrf_token", Value: "x"}, "x"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/form", nil)
+		if test.token != "" {
+			r.Header.Set("X-CSRF-Token", test.token)
+		}
+		if test.cookie != nil {
+			r.AddCookie(test.cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", test.name, w.Code)
+		}
+	}
+}
+
+func TestCSRFFailureHandler(t *testing.T) {
+	h := csrfHandler(CSRFOptions{
+		FailureHandler: Handler(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Session expired", http.StatusBadRequest)
+		}),
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/form", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", w.Code)
+	}
+}
+
+func TestCSRFExemptPath(t *testing.T) {
+	h := csrfHandler(CSRFOptions{ExemptPrefixes: []string{"/webhooks/"}})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/github", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("exempt: got %d, want 200", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("not exempt: got %d, want 403", w.Code)
+	}
+}
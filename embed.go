@@ -0,0 +1,65 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+)
+
+// ServeEmbedded creates an http.Handler which serves the files in the
+// root directory of fsys, with the same caching as StaticHandler. If
+// root is empty, the whole of fsys is served. ServeEmbedded panics if
+// root is not a valid path.
+//
+// Embedded files have no modification time, so if opts.ETags is set,
+// the ETag of each file is derived from a hash of its contents instead.
+// The hashes are computed once, when ServeEmbedded is called.
+//
+//	//go:embed static
+//	var static embed.FS
+//
+//	site.HasPrefix(web.ServeEmbedded(static, "static", web.StaticOptions{
+//		CacheDuration: time.Hour,
+//		ETags:         true,
+//	}), "/")
+func ServeEmbedded(fsys embed.FS, root string, opts StaticOptions) http.Handler {
+	var sub fs.FS = fsys
+	if root != "" && root != "." {
+		var err error
+		if sub, err = fs.Sub(fsys, root); err != nil {
+			panic(err)
+		}
+	}
+
+	etags := make(map[string]string)
+	if opts.ETags {
+		err := fs.WalkDir(sub, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := fs.ReadFile(sub, name)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			etags["/"+name] = quoteETag(hex.EncodeToString(sum[:16]))
+			return nil
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return staticHandler(http.FS(sub), opts, func(name string, info fs.FileInfo) string {
+		if etag, ok := etags[name]; ok {
+			return etag
+		}
+		return fileETag(info, "")
+	})
+}
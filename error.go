@@ -0,0 +1,153 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Error is an error with an HTTP response status code. Message is
+// shown to the client, so should not contain sensitive details,
+// which can be kept in Err instead. If Message is empty, the text
+// for the status code, such as "Not Found", is shown.
+//
+//	return &web.Error{Code: http.StatusNotFound, Message: "no such user", Err: err}
+type Error struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Code, e.message(), e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Code, e.message())
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// message returns the message shown to the client.
+func (e *Error) message() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return http.StatusText(e.Code)
+}
+
+// Fail sends an error response for err. If err is, or wraps, an Error,
+// its status code and message are used. If err is, or wraps, ErrBodyTooLarge
+// or an *http.MaxBytesError, a 413 Request Entity Too Large response is
+// sent. Otherwise, a 500 Internal Server Error response is sent, without
+// revealing err to the client. An Error with an invalid status code,
+// such as zero, is sent as a 500 Internal Server Error. Errors with
+// status codes of 500 or above are logged.
+//
+// The response is sent by the ErrorHandler of the Site serving the
+// request, or DefaultErrorHandler if there is none.
+//
+//	user, err := findUser(web.Param(r, "id"))
+//	if err != nil {
+//		web.Fail(w, r, err)
+//		return
+//	}
+func Fail(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &e):
+	case errors.Is(err, ErrBodyTooLarge), errors.As(err, &maxBytesErr):
+		e = &Error{Code: http.StatusRequestEntityTooLarge, Err: err}
+	default:
+		e = &Error{Code: http.StatusInternalServerError, Err: err}
+	}
+	if e.Code < 100 || e.Code > 999 {
+		// WriteHeader panics on an invalid code, such as
+		// the zero value, so treat it as a server error.
+		invalid := *e
+		invalid.Code = http.StatusInternalServerError
+		e = &invalid
+	}
+	if e.Code >= 500 {
+		log.Printf("web: error serving %s: %v", r.URL, err)
+	}
+
+	if s := requestSite(r); s != nil && s.ErrorHandler != nil {
+		s.ErrorHandler(w, r, e)
+		return
+	}
+	DefaultErrorHandler(w, r, e)
+}
+
+// ErrHandler works like Handler, but returns an error if the request
+// could not be served, rather than writing its own error response.
+// Any error is sent using Fail.
+//
+//	site.Get(web.ErrHandler(func(w http.ResponseWriter, r *http.Request) error {
+//		user, err := findUser(web.Param(r, "id"))
+//		if err != nil {
+//			return err
+//		}
+//		return web.JSON(w, http.StatusOK, user)
+//	}), "/users/:id")
+type ErrHandler func(http.ResponseWriter, *http.Request) error
+
+func (h ErrHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h(w, r); err != nil {
+		Fail(w, r, err)
+	}
+}
+
+// DefaultErrorHandler sends the response for an Error, unless the
+// Site serving the request has an ErrorHandler. Clients which prefer
+// JSON in their Accept header receive a JSON body, as sent by
+// JSONError, such as
+//
+//	{"error":"Not Found"}
+//
+// Clients which prefer HTML, such as browsers, receive an HTML page,
+// and other clients receive plain text, as with http.Error.
+var DefaultErrorHandler = func(w http.ResponseWriter, r *http.Request, e *Error) {
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("X-Content-Type-Options", "nosniff")
+	addVary(header, "Accept")
+
+	accept := r.Header.Get("Accept")
+	jsonQ := acceptMediaQuality(accept, "application/json")
+	htmlQ := acceptMediaQuality(accept, "text/html")
+	switch {
+	case jsonQ > 0 && jsonQ > htmlQ:
+		JSONError(w, e.Code, e.message())
+	case htmlQ > 0:
+		header.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(e.Code)
+		message := html.EscapeString(e.message())
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<title>%d %s</title>\n<h1>%s</h1>\n", e.Code, message, message)
+	default:
+		http.Error(w, e.message(), e.Code)
+	}
+}
+
+// acceptMediaQuality returns the quality value given for the
+// media type in the Accept header, or 0 if it is not listed.
+// Wildcards are ignored.
+func acceptMediaQuality(accept, mediaType string) float64 {
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(name), mediaType) {
+			return quality(params)
+		}
+	}
+	return 0
+}
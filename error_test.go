@@ -0,0 +1,157 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveError serves a request with the given Accept
+// header using an ErrHandler which returns err.
+func serveError(h http.Handler, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestErrorNegotiation(t *testing.T) {
+	h := ErrHandler(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Length", "100")
+		return &Error{Code: http.StatusNotFound, Message: "no <such> user"}
+	})
+
+	const (
+		jsonBody = `{"error":"no \u003csuch\u003e user"}` + "\n"
+		htmlBody = "<!DOCTYPE html>\n<title>404 no &lt;such&gt; user</title>\n<h1>no &lt;such&gt; user</h1>\n"
+		textBody = "no <such> user\n"
+	)
+	tests := []struct {
+		accept, contentType, body string
+	}{
+		{"application/json", "application/json", jsonBody},
+		{"text/html", "text/html; charset=utf-8", htmlBody},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html; charset=utf-8", htmlBody},
+		{"text/html;q=0.5, application/json", "application/json", jsonBody},
+		{"application/json;q=0.5, text/html", "text/html; charset=utf-8", htmlBody},
+		{"application/json, text/html", "text/html; charset=utf-8", htmlBody},
+		{"Application/JSON", "application/json", jsonBody},
+		{"application/json;q=0", "text/plain; charset=utf-8", textBody},
+		{"*/*", "text/plain; charset=utf-8", textBody},
+		{"", "text/plain; charset=utf-8", textBody},
+	}
+	for _, test := range tests {
+		w := serveError(h, test.accept)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%q: got status %d, want 404", test.accept, w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("%q: got Content-Type %q, want %q", test.accept, got, test.contentType)
+		}
+		if got := w.Body.String(); got != test.body {
+			t.Errorf("%q: got body %q, want %q", test.accept, got, test.body)
+		}
+		if w.Header().Get("Content-Length") != "" || w.Header().Get("Vary") != "Accept" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%q: got headers %v", test.accept, w.Header())
+		}
+	}
+}
+
+func TestFail(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(io.Discard)
+
+	secret := errors.New("database password is hunter2")
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+		logged bool
+	}{
+		{"error", &Error{Code: http.StatusForbidden}, http.StatusForbidden, "Forbidden\n", false},
+		{"wrapped error", fmt.Errorf("loading user: %w", &Error{Code: http.StatusNotFound, Message: "no such user", Err: secret}), http.StatusNotFound, "no such user\n", false},
+		{"server error", &Error{Code: http.StatusServiceUnavailable, Err: secret}, http.StatusServiceUnavailable, "Service Unavailable\n", true},
+		{"body too large", fmt.Errorf("reading: %w", ErrBodyTooLarge), http.StatusRequestEntityTooLarge, "Request Entity Too Large\n", false},
+		{"max bytes", &http.MaxBytesError{Limit: 10}, http.StatusRequestEntityTooLarge, "Request Entity Too Large\n", false},
+		{"other", secret, http.StatusInternalServerError, "Internal Server Error\n", true},
+		{"no code", &Error{Message: "oops", Err: secret}, http.StatusInternalServerError, "oops\n", true},
+		{"invalid code", &Error{Code: 42, Err: secret}, http.StatusInternalServerError, "Internal Server Error\n", true},
+	}
+	for _, test := range tests {
+		logs.Reset()
+		w := serveError(ErrHandler(func(w http.ResponseWriter, r *http.Request) error {
+			return test.err
+		}), "")
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, w.Code, w.Body.String(), test.status, test.body)
+		}
+		if logged := strings.Contains(logs.String(), "hunter2"); logged != test.logged {
+			t.Errorf("%s: got log %q", test.name, logs.String())
+		}
+	}
+}
+
+func TestErrHandlerSuccess(t *testing.T) {
+	w := serveError(ErrHandler(func(w http.ResponseWriter, r *http.Request) error {
+		io.WriteString(w, "ok")
+		return nil
+	}), "")
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("got %d %q, want 200 ok", w.Code, w.Body.String())
+	}
+}
+
+func TestSiteErrorHandler(t *testing.T) {
+	var handled *Error
+	site := NewSite("example.com", 80, nil)
+	site.ErrorHandler = func(w http.ResponseWriter, r *http.Request, e *Error) {
+		handled = e
+		w.WriteHeader(e.Code)
+		io.WriteString(w, "custom")
+	}
+	site.Equals(ErrHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return &Error{Code: http.StatusConflict, Message: "taken"}
+	}), "/")
+
+	w := serveSite(site, "GET", "/")
+	if w.Code != http.StatusConflict || w.Body.String() != "custom" {
+		t.Errorf("got %d %q, want 409 custom", w.Code, w.Body.String())
+	}
+	if handled == nil || handled.Message != "taken" {
+		t.Errorf("ErrorHandler received %v", handled)
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		want string
+	}{
+		{&Error{Code: http.StatusNotFound}, "404 Not Found"},
+		{&Error{Code: http.StatusNotFound, Message: "no such user"}, "404 no such user"},
+		{&Error{Code: http.StatusInternalServerError, Err: io.EOF}, "500 Internal Server Error: EOF"},
+	}
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+	if !errors.Is(&Error{Code: http.StatusInternalServerError, Err: io.EOF}, io.EOF) {
+		t.Error("Error does not unwrap to its Err")
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileServerOptions controls how a FileServer serves files.
+type FileServerOptions struct {
+	// Index is the name of the file served for directories.
+	// If empty, "index.html" is used.
+	Index string
+
+	// DirectoryListing enables listing the contents of
+	// directories which have no index file. By default,
+	// these receive a 404 response.
+	DirectoryListing bool
+
+	// CacheDurations gives the duration for which files
+	// with each extension, such as ".css", are cached, as
+	// described in Cache. Other files are not cached.
+	CacheDurations map[string]time.Duration
+}
+
+// FileServer creates an http.Handler which serves files from fsys,
+// such as an embed.FS or os.DirFS, using the request path. Requests
+// for directories are served using their index file, and redirected
+// to add a trailing slash if necessary. Missing files receive the
+// 404 response of the Site serving the request.
+//
+// Request paths containing ".." receive a 400 Bad Request response.
+// Conditional and range requests are supported, as in http.ServeContent.
+//
+//	//go:embed static
+//	var static embed.FS
+//
+//	files, _ := fs.Sub(static, "static")
+//	site.HasPrefix(web.FileServer(files, web.FileServerOptions{
+//		CacheDurations: map[string]time.Duration{".css": web.OneYear, ".js": web.OneYear},
+//	}), "/")
+func FileServer(fsys fs.FS, opts FileServerOptions) http.Handler {
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		urlPath := r.URL.Path
+		if !strings.HasPrefix(urlPath, "/") {
+			urlPath = "/" + urlPath
+		}
+		for _, segment := range strings.Split(urlPath, "/") {
+			if segment == ".." {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+		}
+
+		name := strings.TrimPrefix(path.Clean(urlPath), "/")
+		if name == "" {
+			name = "."
+		}
+		if !fs.ValidPath(name) {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			DefaultPathErrorHandler(w, r, err)
+			return
+		}
+
+		if info.IsDir() {
+			if !strings.HasSuffix(urlPath, "/") {
+				u := &url.URL{Path: MountPrefix(r) + urlPath + "/", RawQuery: r.URL.RawQuery}
+				http.Redirect(w, r, localURL(u), http.StatusMovedPermanently)
+				return
+			}
+
+			index := path.Join(name, opts.Index)
+			if info, err = fs.Stat(fsys, index); err == nil && !info.IsDir() {
+				name = index
+			} else if opts.DirectoryListing {
+				listDirectory(w, r, fsys, name)
+				return
+			} else {
+				notFound(w, r)
+				return
+			}
+		}
+
+		if d, ok := opts.CacheDurations[path.Ext(name)]; ok {
+			Cache(w, info.ModTime(), d)
+		}
+		serveFile(w, r, fsys, name, info)
+	})
+}
+
+// serveFile serves the named file from fsys, using
+// http.ServeContent.
+func serveFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, info fs.FileInfo) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		DefaultPathErrorHandler(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			DefaultPathErrorHandler(w, r, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	http.ServeContent(w, r, path.Base(name), info.ModTime(), content)
+}
+
+// listDirectory sends an HTML page listing the
+// contents of the named directory.
+func listDirectory(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		DefaultPathErrorHandler(w, r, err)
+		return
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+		if entry.IsDir() {
+			names[i] += "/"
+		}
+	}
+	writeListing(w, names)
+}
+
+// writeListing sends an HTML page linking to each of the
+// given names, which end in a slash for directories.
+func writeListing(w http.ResponseWriter, names []string) {
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n<pre>\n")
+	for _, entryName := range names {
+		link := url.URL{Path: entryName}
+		fmt.Fprintf(&buf, "<a href=\"%s\">%s</a>\n", html.EscapeString(link.String()), html.EscapeString(entryName))
+	}
+	buf.WriteString("</pre>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// precompressedEncodings lists the content codings which
+// ServePrecompressed looks for, in order of preference,
+// with the extensions of their files.
+var precompressedEncodings = []struct {
+	coding, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// ServePrecompressed is a PathHandler which serves the named file,
+// or a precompressed version of it, if the client accepts it. For a
+// file "app.js", the Brotli-compressed "app.js.br" and gzip-compressed
+// "app.js.gz" are used if they exist, with the appropriate
+// Content-Encoding and the Content-Type of the original file.
+//
+// Each version has its own ETag, so caches keep them apart, and the
+// Vary HTTP header is set to Accept-Encoding. Range requests are
+// only supported for the uncompressed file. Requests whose path has
+// a ".." segment receive a 400 Bad Request response, so files outside
+// the directory cannot be served.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//	site.HasPrefix(web.UsePrefix("static", web.ServePrecompressed), "/js/", "/css/")
+func ServePrecompressed(w http.ResponseWriter, r *http.Request, name string) {
+	if containsDotDot(r.URL.Path) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	addVary(w.Header(), "Accept-Encoding")
+
+	accept := r.Header.Get("Accept-Encoding")
+	bestQ := 0.0
+	var best *os.File
+	var bestInfo fs.FileInfo
+	coding := ""
+	for _, enc := range precompressedEncodings {
+		q := acceptQuality(accept, enc.coding)
+		if q <= bestQ {
+			continue
+		}
+		f, info, err := openRegular(name + enc.ext)
+		if err != nil {
+			continue
+		}
+		if best != nil {
+			best.Close()
+		}
+		best, bestInfo, bestQ, coding = f, info, q, enc.coding
+	}
+
+	if best == nil {
+		f, info, err := openRegular(name)
+		if err != nil {
+			DefaultPathErrorHandler(w, r, err)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("ETag", fileETag(info, ""))
+		http.ServeContent(w, r, filepath.Base(name), info.ModTime(), f)
+		return
+	}
+	defer best.Close()
+
+	header := w.Header()
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Encoding", coding)
+	header.Set("ETag", fileETag(bestInfo, coding))
+
+	// Decline range requests for compressed content.
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = r.Header.Clone()
+	r2.Header.Del("Range")
+	r2.Header.Del("If-Range")
+	http.ServeContent(&noRangesResponseWriter{ResponseWriter: w}, r2, filepath.Base(name), bestInfo.ModTime(), best)
+}
+
+// noRangesResponseWriter removes the Accept-Ranges header
+// set by http.ServeContent.
+type noRangesResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *noRangesResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Del("Accept-Ranges")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *noRangesResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// ServeFileRange is a PathHandler which serves the named file with
+// support for range requests, as defined in RFC 7233, so clients can
+// resume downloads and seek within media. Single ranges receive a 206
+// Partial Content response, multiple ranges receive a multipart/byteranges
+// response, and unsatisfiable ranges receive a 416 Range Not Satisfiable
+// response. The If-Range header is checked against the file's ETag and
+// modification time. This uses http.ServeContent.
+//
+// If no ETag has been set, as with CacheWithETag, one is derived from
+// the file's size and modification time. Headers set with Cache also
+// apply to partial responses. As with ServePrecompressed, requests
+// whose path has a ".." segment receive a 400 Bad Request response.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//	site.HasSuffix(web.UsePrefix("videos", web.ServeFileRange), ".mp4")
+func ServeFileRange(w http.ResponseWriter, r *http.Request, name string) {
+	if containsDotDot(r.URL.Path) {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	f, info, err := openRegular(name)
+	if err != nil {
+		DefaultPathErrorHandler(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	if w.Header().Get("ETag") == "" {
+		w.Header().Set("ETag", fileETag(info, ""))
+	}
+	http.ServeContent(w, r, filepath.Base(name), info.ModTime(), f)
+}
+
+// containsDotDot reports whether the request path has a ".."
+// segment, which could escape the directory being served, as
+// the name is built from it. Backslashes are also treated as
+// separators, as they are by os.Open on Windows.
+func containsDotDot(urlPath string) bool {
+	for _, segment := range strings.FieldsFunc(urlPath, isSlash) {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func isSlash(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// openRegular opens the named file, returning an
+// error matching fs.ErrNotExist if it is a directory.
+func openRegular(name string) (*os.File, fs.FileInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return f, info, nil
+}
+
+// fileETag returns a strong ETag for a file, based on its
+// size and modification time, and the content coding used.
+func fileETag(info fs.FileInfo, coding string) string {
+	tag := strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36)
+	if coding != "" {
+		tag += "-" + coding
+	}
+	return quoteETag(tag)
+}
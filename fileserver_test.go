@@ -0,0 +1,372 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// fileTree creates the files in a temporary directory,
+// returning its path.
+func fileTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestServePrecompressedTraversal(t *testing.T) {
+	dir := fileTree(t, map[string]string{
+		"secret.txt":        "secret",
+		"secret.txt.gz":     "compressed secret",
+		"static/js/app.js":  "app",
+		"static/js/x/y.txt": "y",
+	})
+	h := UsePrefix(filepath.Join(dir, "static"), ServePrecompressed)
+
+	for _, path := range []string{"/js/../../secret.txt", "/js/x/../../../secret.txt", "/js/..\\..\\secret.txt"} {
+		for _, accept := range []string{"", "gzip"} {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.URL.Path = path
+			r.Header.Set("Accept-Encoding", accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s with Accept-Encoding %q: got %d %q, want 400", path, accept, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/js/app.js", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "app" {
+		t.Errorf("got %d %q, want app.js", w.Code, w.Body.String())
+	}
+}
+
+func TestServePrecompressedOnlyGzip(t *testing.T) {
+	dir := fileTree(t, map[string]string{
+		"app.js":    "plain",
+		"app.js.gz": "gzipped",
+	})
+	h := UsePrefix(dir, ServePrecompressed)
+
+	tests := []struct {
+		accept, body, encoding string
+	}{
+		{"br, gzip", "gzipped", "gzip"},
+		{"br", "plain", ""},
+		{"", "plain", ""},
+		{"gzip;q=0", "plain", ""},
+	}
+	etags := make(map[string]string)
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/app.js", nil)
+		r.Header.Set("Accept-Encoding", test.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		header := w.Header()
+		if w.Code != http.StatusOK || w.Body.String() != test.body || header.Get("Content-Encoding") != test.encoding {
+			t.Errorf("Accept-Encoding %q: got %d %q with Content-Encoding %q, want %q with %q", test.accept, w.Code, w.Body.String(), header.Get("Content-Encoding"), test.body, test.encoding)
+		}
+		if got := header.Get("Content-Type"); got != "text/javascript; charset=utf-8" {
+			t.Errorf("Accept-Encoding %q: got Content-Type %q", test.accept, got)
+		}
+		if got := header.Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: got Vary %q", test.accept, got)
+		}
+		if (test.encoding != "") != (header.Get("Accept-Ranges") == "") {
+			t.Errorf("Accept-Encoding %q: got Accept-Ranges %q", test.accept, header.Get("Accept-Ranges"))
+		}
+		etags[test.encoding] = header.Get("ETag")
+	}
+	if etags[""] == "" || etags[""] == etags["gzip"] {
+		t.Errorf("got ETags %q and %q, want distinct tags", etags[""], etags["gzip"])
+	}
+}
+
+func TestServeFileRangeTraversal(t *testing.T) {
+	dir := fileTree(t, map[string]string{
+		"secret.txt":        "secret",
+		"static/js/app.js":  "app",
+		"static/js/x/y.txt": "y",
+	})
+	site := NewSite("example.com", 80, nil)
+	site.HasPrefix(UsePrefix(filepath.Join(dir, "static"), ServeFileRange), "/js/")
+
+	for _, path := range []string{"/js/../../secret.txt", "/js/x/../../../secret.txt", "/js/..\\..\\secret.txt"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = path
+		w := httptest.NewRecorder()
+		site.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d %q, want 400", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestServeFileRange(t *testing.T) {
+	content := make([]byte, 2000)
+	for i := range content {
+		content[i] = byte('a' + i%26)
+	}
+	dir := fileTree(t, map[string]string{"video.mp4": string(content)})
+	h := UsePrefix(dir, ServeFileRange)
+
+	tests := []struct {
+		rng, contentRange string
+		want              int
+		body              []byte
+	}{
+		{"", "", http.StatusOK, content},
+		{"bytes=-500", "bytes 1500-1999/2000", http.StatusPartialContent, content[1500:]},
+		{"bytes=1990-", "bytes 1990-1999/2000", http.StatusPartialContent, content[1990:]},
+		{"bytes=10-19", "bytes 10-19/2000", http.StatusPartialContent, content[10:20]},
+		{"bytes=5000-", "bytes */2000", http.StatusRequestedRangeNotSatisfiable, nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/video.mp4", nil)
+		if test.rng != "" {
+			r.Header.Set("Range", test.rng)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want || w.Header().Get("Content-Range") != test.contentRange {
+			t.Errorf("%q: got %d with Content-Range %q, want %d with %q", test.rng, w.Code, w.Header().Get("Content-Range"), test.want, test.contentRange)
+		}
+		if test.body != nil && !bytes.Equal(w.Body.Bytes(), test.body) {
+			t.Errorf("%q: got body %q, want %q", test.rng, w.Body.Bytes(), test.body)
+		}
+	}
+}
+
+func TestServeFileRangeMultipart(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	dir := fileTree(t, map[string]string{"data.txt": content})
+	h := UsePrefix(dir, ServeFileRange)
+
+	r := httptest.NewRequest("GET", "/data.txt", nil)
+	r.Header.Set("Range", "bytes=0-4,30-")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("got status %d, want 206", w.Code)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("got Content-Type %q, want multipart/byteranges", w.Header().Get("Content-Type"))
+	}
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-4/36", "01234"},
+		{"bytes 30-35/36", "uvwxyz"},
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("got %d parts, want %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(want) {
+			t.Fatalf("got unexpected part %d", i)
+		}
+		body, _ := io.ReadAll(part)
+		if got := part.Header.Get("Content-Range"); got != want[i].contentRange || string(body) != want[i].body {
+			t.Errorf("part %d: got %q with Content-Range %q, want %q with %q", i, body, got, want[i].body, want[i].contentRange)
+		}
+	}
+}
+
+func TestServeFileRangeIfRange(t *testing.T) {
+	dir := fileTree(t, map[string]string{"data.txt": "0123456789"})
+	name := filepath.Join(dir, "data.txt")
+	modTime := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := fileETag(info, "")
+	h := UsePrefix(dir, ServeFileRange)
+
+	tests := []struct {
+		ifRange string
+		want    int
+	}{
+		{etag, http.StatusPartialContent},
+		{`"stale"`, http.StatusOK},
+		{"Wed, 02 Jan 2013 03:04:05 GMT", http.StatusPartialContent},
+		{"Tue, 01 Jan 2013 00:00:00 GMT", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/data.txt", nil)
+		r.Header.Set("Range", "bytes=2-3")
+		r.Header.Set("If-Range", test.ifRange)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("If-Range %q: got status %d, want %d", test.ifRange, w.Code, test.want)
+		}
+	}
+}
+
+// testFS is served by the FileServer tests.
+var testFS = fstest.MapFS{
+	"index.html":      {Data: []byte("home"), ModTime: time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)},
+	"style.css":       {Data: []byte("body {}"), ModTime: time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)},
+	"docs/index.html": {Data: []byte("docs")},
+	"images/logo.png": {Data: []byte("png")},
+	"images/a b.txt":  {Data: []byte("space")},
+}
+
+func TestFileServer(t *testing.T) {
+	h := FileServer(testFS, FileServerOptions{})
+	tests := []struct {
+		method, target string
+		want           int
+		body           string
+		location       string
+	}{
+		{"GET", "/", http.StatusOK, "home", ""},
+		{"GET", "/style.css", http.StatusOK, "body {}", ""},
+		{"HEAD", "/style.css", http.StatusOK, "", ""},
+		{"GET", "/docs/", http.StatusOK, "docs", ""},
+		{"GET", "/docs?page=2", http.StatusMovedPermanently, "", "/docs/?page=2"},
+		{"GET", "/images/", http.StatusNotFound, "404 page not found\n", ""},
+		{"GET", "/images/a%20b.txt", http.StatusOK, "space", ""},
+		{"GET", "/missing.txt", http.StatusNotFound, "404 page not found\n", ""},
+		{"POST", "/style.css", http.StatusMethodNotAllowed, "Method Not Allowed\n", ""},
+	}
+	for _, test := range tests {
+		w := serveSite(h, test.method, test.target)
+		if w.Code != test.want {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.target, w.Code, test.want)
+			continue
+		}
+		if test.location != "" {
+			if got := w.Header().Get("Location"); got != test.location {
+				t.Errorf("%s %s: got Location %q, want %q", test.method, test.target, got, test.location)
+			}
+			continue
+		}
+		if w.Body.String() != test.body {
+			t.Errorf("%s %s: got body %q, want %q", test.method, test.target, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestFileServerTraversal(t *testing.T) {
+	dir := fileTree(t, map[string]string{
+		"public/index.html": "public",
+		"secret.txt":        "secret",
+	})
+	fileServers := map[string]http.Handler{
+		"MapFS": FileServer(testFS, FileServerOptions{}),
+		"DirFS": FileServer(os.DirFS(filepath.Join(dir, "public")), FileServerOptions{}),
+	}
+	for name, h := range fileServers {
+		for _, target := range []string{
+			"/../secret.txt",
+			"/private/../secret",
+			"/docs/../../secret.txt",
+			"/..%2fsecret.txt",
+			"/%2e%2e/secret.txt",
+			"/docs/..",
+		} {
+			w := serveSite(h, "GET", target)
+			if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "secret") {
+				t.Errorf("%s %s: got %d %q, want 400", name, target, w.Code, w.Body.String())
+			}
+		}
+
+		// A path which is not rooted is treated as if it were.
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = "../secret.txt"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s unrooted: got status %d, want 400", name, w.Code)
+		}
+	}
+}
+
+func TestFileServerIfModifiedSince(t *testing.T) {
+	h := FileServer(testFS, FileServerOptions{
+		CacheDurations: map[string]time.Duration{".css": time.Hour},
+	})
+	modTime := testFS["style.css"].ModTime
+
+	w := serveSite(h, "GET", "/style.css")
+	if got := w.Header().Get("Last-Modified"); got != modTime.Format(http.TimeFormat) {
+		t.Fatalf("got Last-Modified %q", got)
+	}
+	if got := w.Header().Get("Cache-Control"); !strings.Contains(got, "max-age=3600") {
+		t.Errorf(".css: got Cache-Control %q", got)
+	}
+	if got := serveSite(h, "GET", "/").Header().Get("Cache-Control"); got != "" {
+		t.Errorf(".html: got Cache-Control %q, want none", got)
+	}
+
+	tests := []struct {
+		since time.Time
+		want  int
+	}{
+		{modTime, http.StatusNotModified},
+		{modTime.Add(time.Hour), http.StatusNotModified},
+		{modTime.Add(-time.Second), http.StatusOK},
+	}
+	for _, test := range tests {
+		for _, method := range []string{"GET", "HEAD"} {
+			r := httptest.NewRequest(method, "/style.css", nil)
+			r.Header.Set("If-Modified-Since", test.since.Format(http.TimeFormat))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != test.want {
+				t.Errorf("%s since %v: got status %d, want %d", method, test.since, w.Code, test.want)
+			}
+			if w.Code == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("%s since %v: 304 response has a body", method, test.since)
+			}
+		}
+	}
+}
+
+func TestFileServerDirectoryListing(t *testing.T) {
+	h := FileServer(testFS, FileServerOptions{DirectoryListing: true})
+	w := serveSite(h, "GET", "/images/")
+	want := "<!DOCTYPE html>\n<pre>\n<a href=\"a%20b.txt\">a b.txt</a>\n<a href=\"logo.png\">logo.png</a>\n</pre>\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("got %d %q, want 200 %q", w.Code, w.Body.String(), want)
+	}
+}
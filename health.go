@@ -0,0 +1,285 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheckTimeout is the time allowed for each check
+// run by HealthHandler. Checks which take longer fail.
+const HealthCheckTimeout = 5 * time.Second
+
+// HealthHandler creates an http.Handler which reports the health of
+// the server, by running the given checks concurrently. If all checks
+// return nil, it sends a 200 OK response with the JSON body
+//
+//	{"status":"ok"}
+//
+// Otherwise, it sends a 503 Service Unavailable response, with a JSON
+// body listing the failed checks, by their position in the arguments:
+//
+//	{"status":"unavailable","failures":[{"check":1,"error":"database unreachable"}]}
+//
+// Checks which take longer than HealthCheckTimeout fail. Responses
+// are not cached.
+//
+//	site.Equals(web.HealthHandler(db.Ping, cache.Ping), "/healthz")
+func HealthHandler(checks ...func() error) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		DoNotCache(w)
+		w.Header().Set("Content-Type", "application/json")
+
+		failures := runHealthChecks(checks)
+		if len(failures) == 0 {
+			w.Write([]byte(`{"status":"ok"}` + "\n"))
+			return
+		}
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(struct {
+			Status   string          `json:"status"`
+			Failures []healthFailure `json:"failures"`
+		}{"unavailable", failures})
+	})
+}
+
+// LivenessHandler creates an http.Handler for liveness probes, which
+// check whether the server is running. It always reports that the
+// server is healthy, as described in HealthHandler, since it can only
+// respond if the server is running. The server's dependencies should
+// be checked with ReadinessHandler instead, so that their failures do
+// not cause the server to be restarted.
+//
+//	site.Equals(web.LivenessHandler(), "/healthz")
+func LivenessHandler() http.Handler {
+	return HealthHandler()
+}
+
+// ReadinessHandler creates an http.Handler for readiness probes, which
+// check whether the server is ready to receive traffic, by running the
+// given checks, as described in HealthHandler.
+//
+//	site.Equals(web.ReadinessHandler(db.Ping), "/readyz")
+func ReadinessHandler(checks ...func() error) http.Handler {
+	return HealthHandler(checks...)
+}
+
+// healthFailure describes a failed health check.
+type healthFailure struct {
+	Check int    `json:"check"`
+	Error string `json:"error"`
+}
+
+// errHealthCheckTimeout is reported for
+// checks which take too long.
+var errHealthCheckTimeout = errors.New("timed out")
+
+// runHealthChecks runs the checks concurrently,
+// returning their failures in order.
+func runHealthChecks(checks []func() error) []healthFailure {
+	results := make([]chan error, len(checks))
+	for i, check := range checks {
+		results[i] = make(chan error, 1)
+		go func(check func() error, result chan<- error) {
+			result <- check()
+		}(check, results[i])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+	defer cancel()
+
+	var failures []healthFailure
+	for i, result := range results {
+		var err error
+		select {
+		case err = <-result:
+		case <-ctx.Done():
+			// Prefer a result which is already available.
+			select {
+			case err = <-result:
+			default:
+				err = errHealthCheckTimeout
+			}
+		}
+		if err != nil {
+			failures = append(failures, healthFailure{Check: i, Error: err.Error()})
+		}
+	}
+	return failures
+}
+
+// Health is a set of named health checks, which can be served for
+// liveness and readiness probes, such as by Kubernetes. It also has
+// a readiness flag, which can be cleared while the server warms up
+// or drains connections before shutting down. The zero value has
+// no checks, and is ready.
+//
+//	var health web.Health
+//	health.Add("database", db.PingContext)
+//	health.SetReady(false)
+//	site.Equals(health.Handler(), "/healthz")
+//	site.Equals(health.ReadyHandler(), "/readyz")
+//
+//	// Once caches are warm.
+//	health.SetReady(true)
+type Health struct {
+	// Timeout is the time allowed for each check. Checks
+	// which take longer fail. If zero, HealthCheckTimeout
+	// is used.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	checks   []namedCheck
+	notReady atomic.Bool
+}
+
+type namedCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// Add registers a check with the given name. The check's
+// context is cancelled when its timeout expires.
+func (h *Health) Add(name string, check func(ctx context.Context) error) {
+	h.mu.Lock()
+	h.checks = append(h.checks, namedCheck{name, check})
+	h.mu.Unlock()
+}
+
+// SetReady sets whether the server is ready to receive traffic.
+func (h *Health) SetReady(ready bool) {
+	h.notReady.Store(!ready)
+}
+
+// Ready reports whether the server is ready to receive traffic,
+// as set with SetReady.
+func (h *Health) Ready() bool {
+	return !h.notReady.Load()
+}
+
+// Handler creates an http.Handler which runs the checks concurrently.
+// If all pass, it sends a 200 OK response, with a JSON body listing
+// each check's status and latency in milliseconds:
+//
+//	{"status":"ok","checks":[{"name":"database","status":"ok","latency_ms":1.2}]}
+//
+// Otherwise, it sends a 503 Service Unavailable response, with the
+// status "unavailable", and the error of each failed check. Responses
+// are not cached.
+func (h *Health) Handler() http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r)
+	})
+}
+
+// ReadyHandler creates an http.Handler for readiness probes. If the
+// server is not ready, as set with SetReady, it sends a 503 Service
+// Unavailable response, with the JSON body
+//
+//	{"status":"not ready"}
+//
+// Otherwise, it runs the checks, as described in Handler.
+func (h *Health) ReadyHandler() http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if !h.Ready() {
+			DoNotCache(w)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":"not ready"}` + "\n"))
+			return
+		}
+		h.serve(w, r)
+	})
+}
+
+// checkResult describes the outcome of a named check.
+type checkResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// serve runs the checks and sends the results.
+func (h *Health) serve(w http.ResponseWriter, r *http.Request) {
+	DoNotCache(w)
+	w.Header().Set("Content-Type", "application/json")
+
+	results := h.run(r.Context())
+	status, code := "ok", http.StatusOK
+	for _, result := range results {
+		if result.Error != "" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status string        `json:"status"`
+		Checks []checkResult `json:"checks"`
+	}{status, results})
+}
+
+// run runs the checks concurrently, returning
+// their results in the order they were added.
+func (h *Health) run(ctx context.Context) []checkResult {
+	h.mu.Lock()
+	checks := append([]namedCheck(nil), h.checks...)
+	h.mu.Unlock()
+
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = HealthCheckTimeout
+	}
+
+	results := make([]checkResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() {
+				done <- c.check(ctx)
+			}()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				// Prefer a result which is already available.
+				select {
+				case err = <-done:
+				default:
+					err = errHealthCheckTimeout
+				}
+			}
+
+			results[i] = checkResult{
+				Name:      c.name,
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = "failed"
+				results[i].Error = err.Error()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
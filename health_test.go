@@ -0,0 +1,163 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ok := func() error { return nil }
+	failed := func() error { return errors.New("database unreachable") }
+	tests := []struct {
+		name string
+		h    http.Handler
+		code int
+		body string
+	}{
+		{"no checks", HealthHandler(), http.StatusOK, `{"status":"ok"}` + "\n"},
+		{"liveness", LivenessHandler(), http.StatusOK, `{"status":"ok"}` + "\n"},
+		{"passing", HealthHandler(ok, ok), http.StatusOK, `{"status":"ok"}` + "\n"},
+		{"readiness", ReadinessHandler(ok), http.StatusOK, `{"status":"ok"}` + "\n"},
+		{"failing", HealthHandler(ok, failed), http.StatusServiceUnavailable,
+			`{"status":"unavailable","failures":[{"check":1,"error":"database unreachable"}]}` + "\n"},
+		{"readiness failing", ReadinessHandler(failed, ok, failed), http.StatusServiceUnavailable,
+			`{"status":"unavailable","failures":[{"check":0,"error":"database unreachable"},{"check":2,"error":"database unreachable"}]}` + "\n"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		if w.Code != test.code || w.Body.String() != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, w.Code, w.Body.String(), test.code, test.body)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s: got Content-Type %q", test.name, got)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-cache, no-store, must-revalidate" {
+			t.Errorf("%s: got Cache-Control %q", test.name, got)
+		}
+	}
+}
+
+func TestHealthHandlerConcurrentChecks(t *testing.T) {
+	// Each check waits for the other, so they must run concurrently.
+	a, b := make(chan bool), make(chan bool)
+	h := HealthHandler(func() error {
+		a <- true
+		<-b
+		return nil
+	}, func() error {
+		<-a
+		b <- true
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d %q, want 200", w.Code, w.Body.String())
+	}
+}
+
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+// getHealth sends a request to the handler, returning
+// the response status and decoded body.
+func getHealth(t *testing.T, h http.Handler) (int, healthResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if got := w.Header().Get("Cache-Control"); got != "no-cache, no-store, must-revalidate" {
+		t.Errorf("got Cache-Control %q", got)
+	}
+	var body healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON %q: %v", w.Body, err)
+	}
+	return w.Code, body
+}
+
+func TestHealthPassing(t *testing.T) {
+	var health Health
+	health.Add("database", func(ctx context.Context) error { return nil })
+	health.Add("cache", func(ctx context.Context) error { return nil })
+
+	code, body := getHealth(t, health.Handler())
+	if code != http.StatusOK || body.Status != "ok" {
+		t.Errorf("got %d %q, want 200 \"ok\"", code, body.Status)
+	}
+	if len(body.Checks) != 2 || body.Checks[0].Name != "database" || body.Checks[1].Name != "cache" {
+		t.Errorf("got checks %+v", body.Checks)
+	}
+}
+
+func TestHealthFailingCheck(t *testing.T) {
+	var health Health
+	health.Add("database", func(ctx context.Context) error { return nil })
+	health.Add("cache", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	code, body := getHealth(t, health.Handler())
+	if code != http.StatusServiceUnavailable || body.Status != "unavailable" {
+		t.Errorf("got %d %q, want 503 \"unavailable\"", code, body.Status)
+	}
+	if got := body.Checks[0]; got.Status != "ok" || got.Error != "" {
+		t.Errorf("got passing check %+v", got)
+	}
+	if got := body.Checks[1]; got.Status != "failed" || got.Error != "connection refused" {
+		t.Errorf("got failing check %+v", got)
+	}
+}
+
+func TestHealthTimeout(t *testing.T) {
+	health := Health{Timeout: 50 * time.Millisecond}
+	block := make(chan struct{})
+	defer close(block)
+	health.Add("slow", func(ctx context.Context) error {
+		<-block // Ignores its context.
+		return nil
+	})
+
+	start := time.Now()
+	code, body := getHealth(t, health.Handler())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("handler took %v, despite the timeout", elapsed)
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want 503", code)
+	}
+	if got := body.Checks[0]; got.Error != "timed out" || got.LatencyMS < 50 {
+		t.Errorf("got check %+v", got)
+	}
+}
+
+func TestHealthReady(t *testing.T) {
+	var health Health
+	health.Add("database", func(ctx context.Context) error { return nil })
+	h := health.ReadyHandler()
+
+	if code, body := getHealth(t, h); code != http.StatusOK || body.Status != "ok" {
+		t.Errorf("got %d %q before SetReady, want 200 \"ok\"", code, body.Status)
+	}
+	health.SetReady(false)
+	if code, body := getHealth(t, h); code != http.StatusServiceUnavailable || body.Status != "not ready" {
+		t.Errorf("got %d %q when not ready, want 503 \"not ready\"", code, body.Status)
+	}
+	if code, _ := getHealth(t, health.Handler()); code != http.StatusOK {
+		t.Errorf("health check got status %d when not ready, want 200", code)
+	}
+	health.SetReady(true)
+	if code, _ := getHealth(t, h); code != http.StatusOK {
+		t.Errorf("got status %d when ready again, want 200", code)
+	}
+}
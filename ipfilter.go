@@ -0,0 +1,71 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// AllowIPs creates Middleware which only passes on requests from
+// clients whose IP address is in one of the given networks, in CIDR
+// notation, such as "10.0.0.0/8". Other requests receive a 403
+// Forbidden response. AllowIPs panics if a network is not valid, so
+// mistakes are found at startup.
+//
+// The address of the direct peer, from the request's RemoteAddr, is
+// used, as headers such as X-Forwarded-For can be set by the client.
+// Behind a reverse proxy, use RealIPMiddleware first, so RemoteAddr
+// is set to the client's address for requests from trusted proxies.
+//
+//	admin := web.AllowIPs("10.0.0.0/8", "fd00::/8")
+//	site.HasPrefix(admin(adminHandler), "/admin/")
+func AllowIPs(nets ...string) Middleware {
+	return filterIPs(nets, true)
+}
+
+// BlockIPs creates Middleware which rejects requests from clients
+// whose IP address is in one of the given networks, in CIDR notation,
+// with a 403 Forbidden response. Other requests are passed on. As with
+// AllowIPs, the request's RemoteAddr is used, so BlockIPs should come
+// after RealIPMiddleware behind a reverse proxy, and BlockIPs panics
+// if a network is not valid.
+//
+//	site.Always(web.BlockIPs("198.51.100.0/24", "2001:db8:bad::/48")(handler))
+func BlockIPs(nets ...string) Middleware {
+	return filterIPs(nets, false)
+}
+
+// filterIPs creates Middleware which passes on requests
+// from clients in the networks if allow is set, and from
+// clients not in them otherwise.
+func filterIPs(nets []string, allow bool) Middleware {
+	contains := trustedNetworks(mustParseCIDRs(nets))
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			if contains(remoteIP(r)) != allow {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// mustParseCIDRs parses the networks, panicking
+// if any is not valid.
+func mustParseCIDRs(nets []string) []net.IPNet {
+	networks := make([]net.IPNet, len(nets))
+	for i, s := range nets {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(fmt.Sprintf("web: invalid network %q: %v", s, err))
+		}
+		networks[i] = *network
+	}
+	return networks
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowIPs(t *testing.T) {
+	h := AllowIPs("10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32")(Handler(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"10.1.2.3:1234", "", http.StatusOK},
+		{"192.0.2.1:1234", "", http.StatusOK},
+		{"192.0.2.2:1234", "", http.StatusForbidden},
+		{"[2001:db8::1]:1234", "", http.StatusOK},
+		{"[2001:db9::1]:1234", "", http.StatusForbidden},
+		{"[::ffff:10.0.0.1]:1234", "", http.StatusOK},
+		{"203.0.113.7:1234", "", http.StatusForbidden},
+		{"10.1.2.3:1234", "203.0.113.7", http.StatusOK},
+		{"203.0.113.7:1234", "10.1.2.3", http.StatusForbidden},
+		{"invalid", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/admin/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s forwarded for %q: got %d, want %d", test.remoteAddr, test.forwarded, w.Code, test.want)
+		}
+	}
+}
+
+func TestAllowIPsBehindProxy(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	h := RealIPMiddleware([]net.IPNet{*proxies})(AllowIPs("192.0.2.0/24")(Handler(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"10.1.2.3:1234", "192.0.2.9", http.StatusOK},
+		{"10.1.2.3:1234", "203.0.113.7", http.StatusForbidden},
+		{"203.0.113.7:1234", "192.0.2.9", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/admin/", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-Forwarded-For", test.forwarded)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s forwarded for %q: got %d, want %d", test.remoteAddr, test.forwarded, w.Code, test.want)
+		}
+	}
+}
+
+func TestBlockIPs(t *testing.T) {
+	h := BlockIPs("198.51.100.0/24", "2001:db8:bad::/48")(Handler(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"198.51.100.9:1234", "", http.StatusForbidden},
+		{"198.51.101.9:1234", "", http.StatusOK},
+		{"[2001:db8:bad::1]:1234", "", http.StatusForbidden},
+		{"[2001:db8:beef::1]:1234", "", http.StatusOK},
+		{"[::ffff:198.51.100.1]:1234", "", http.StatusForbidden},
+		{"10.1.2.3:1234", "198.51.100.9", http.StatusOK},
+		{"198.51.100.9:1234", "10.1.2.3", http.StatusForbidden},
+		{"invalid", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s forwarded for %q: got %d, want %d", test.remoteAddr, test.forwarded, w.Code, test.want)
+		}
+	}
+}
+
+func TestBlockIPsBehindProxy(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	h := RealIPMiddleware([]net.IPNet{*proxies})(BlockIPs("198.51.100.0/24")(Handler(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"10.1.2.3:1234", "198.51.100.9", http.StatusForbidden},
+		{"10.1.2.3:1234", "203.0.113.7", http.StatusOK},
+		{"198.51.100.9:1234", "203.0.113.7", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-Forwarded-For", test.forwarded)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s forwarded for %q: got %d, want %d", test.remoteAddr, test.forwarded, w.Code, test.want)
+		}
+	}
+}
+
+func TestIPFilterInvalid(t *testing.T) {
+	filters := map[string]func(...string) Middleware{"AllowIPs": AllowIPs, "BlockIPs": BlockIPs}
+	for name, filter := range filters {
+		for _, network := range []string{"10.0.0.1", "10.0.0.0/33", "example.com/8", ""} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s(%q) did not panic", name, network)
+					}
+				}()
+				filter("192.0.2.0/24", network)
+			}()
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Errors returned by DecodeJSON.
+var (
+	ErrBadContentType = errors.New("web: request body is not JSON")
+	ErrMalformedJSON  = errors.New("web: malformed JSON in request body")
+)
+
+// DecodeError is the type of error returned by DecodeJSON and
+// DecodeJSONStrict. Status is the status code with which to
+// respond, which is 415 Unsupported Media Type if the request
+// is not JSON, 413 Request Entity Too Large if the body is too
+// large, or 400 Bad Request if the body is malformed. Err matches
+// ErrBadContentType, ErrBodyTooLarge, or ErrMalformedJSON
+// respectively, as reported by errors.Is.
+type DecodeError struct {
+	Status int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// JSON sends a response with the given status code, containing v
+// encoded as JSON. The value is encoded before anything is written,
+// so if encoding fails, the error is logged and a 500 Internal Server
+// Error response is sent instead. The error is also returned.
+//
+// If the Debug field of the Site serving the request is set, the JSON
+// is indented, to make it easier to read.
+//
+//	web.JSON(w, http.StatusOK, map[string]int{"count": count})
+func JSON(w http.ResponseWriter, status int, v interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if debugging(w) {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		log.Printf("web: failed to encode JSON response: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// JSONError sends an error response with the given status code, as
+// a JSON object with the message in its "error" field:
+//
+//	{"error":"no such user"}
+//
+// It is sent with JSON, so the same rules apply.
+//
+//	web.JSONError(w, http.StatusNotFound, "no such user")
+func JSONError(w http.ResponseWriter, status int, message string) error {
+	return JSON(w, status, struct {
+		Error string `json:"error"`
+	}{message})
+}
+
+// DecodeJSON decodes the JSON request body into dst. The body must
+// contain a single JSON value, no larger than maxBytes, and must have
+// a Content-Type of application/json, or another JSON media type, such
+// as application/problem+json. If maxBytes is zero or negative, the
+// body size is not limited.
+//
+// Any error returned is a *DecodeError, whose Status can be used
+// as the response status. Its Err matches ErrBadContentType,
+// ErrBodyTooLarge, or ErrMalformedJSON, as reported by errors.Is,
+// to distinguish the cases:
+//
+//	var order Order
+//	if err := web.DecodeJSON(r, &order, 1<<20); err != nil {
+//		var decodeErr *web.DecodeError
+//		errors.As(err, &decodeErr)
+//		http.Error(w, err.Error(), decodeErr.Status)
+//		return
+//	}
+func DecodeJSON(r *http.Request, dst interface{}, maxBytes int64) error {
+	return decodeJSON(r, dst, maxBytes, false)
+}
+
+// DecodeJSONStrict works like DecodeJSON, but also fails with
+// ErrMalformedJSON if the body contains object keys which do not
+// match any field in dst.
+func DecodeJSONStrict(r *http.Request, dst interface{}, maxBytes int64) error {
+	return decodeJSON(r, dst, maxBytes, true)
+}
+
+func decodeJSON(r *http.Request, dst interface{}, maxBytes int64, strict bool) error {
+	err := decodeJSONBody(r, dst, maxBytes, strict)
+	if err == nil {
+		return nil
+	}
+
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrBadContentType):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrBodyTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	}
+	return &DecodeError{Status: status, Err: err}
+}
+
+func decodeJSONBody(r *http.Request, dst interface{}, maxBytes int64, strict bool) error {
+	if !isJSONMediaType(r.Header.Get("Content-Type")) {
+		return ErrBadContentType
+	}
+	if r.Body == nil {
+		return fmt.Errorf("%w: empty body", ErrMalformedJSON)
+	}
+
+	var body io.Reader = r.Body
+	if maxBytes > 0 {
+		if r.ContentLength > maxBytes {
+			return ErrBodyTooLarge
+		}
+		body = &limitedBody{body: r.Body, remaining: maxBytes}
+	}
+
+	dec := json.NewDecoder(body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(dst); err != nil {
+		return jsonDecodeError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			return fmt.Errorf("%w: unexpected data after JSON value", ErrMalformedJSON)
+		}
+		return jsonDecodeError(err)
+	}
+	return nil
+}
+
+// jsonDecodeError returns the error to report
+// for a failure to decode a JSON body.
+func jsonDecodeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, ErrBodyTooLarge), errors.As(err, &maxBytesErr):
+		return ErrBodyTooLarge
+	case err == io.EOF:
+		return fmt.Errorf("%w: empty body", ErrMalformedJSON)
+	case err == io.ErrUnexpectedEOF:
+		return fmt.Errorf("%w: unexpected end of body", ErrMalformedJSON)
+	}
+	return fmt.Errorf("%w: %v", ErrMalformedJSON, err)
+}
+
+// isJSONMediaType reports whether the Content-Type
+// header describes JSON content.
+func isJSONMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")
+}
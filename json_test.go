@@ -0,0 +1,165 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testOrder struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+func TestJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := JSON(w, http.StatusCreated, testOrder{"tea", 2}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d with Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got, want := w.Body.String(), `{"item":"tea","quantity":2}`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	JSONError(w, http.StatusNotFound, "no such user")
+	if got, want := w.Body.String(), `{"error":"no such user"}`+"\n"; w.Code != http.StatusNotFound || got != want {
+		t.Errorf("JSONError: got %d %q, want 404 %q", w.Code, got, want)
+	}
+}
+
+func TestJSONDebug(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Debug = true
+	site.Always(Handler(func(w http.ResponseWriter, r *http.Request) {
+		JSON(w, http.StatusOK, testOrder{"tea", 2})
+	}))
+	want := "{\n  \"item\": \"tea\",\n  \"quantity\": 2\n}\n"
+	if got := serveSite(site, "GET", "/").Body.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestJSONEncodeError(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(io.Discard)
+
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Before", "yes")
+	if err := JSON(w, http.StatusOK, map[string]interface{}{"ch": make(chan int)}); err == nil {
+		t.Error("got no error encoding a channel")
+	}
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "Internal Server Error\n" {
+		t.Errorf("got %d %q, want a clean 500", w.Code, w.Body.String())
+	}
+	if !strings.Contains(logs.String(), "failed to encode JSON") {
+		t.Errorf("got log %q", logs.String())
+	}
+}
+
+// jsonRequest creates a request with the given body.
+// If contentLength is -1, the length is not declared.
+func jsonRequest(contentType, body string, contentLength int64) *http.Request {
+	r := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader(body)))
+	r.ContentLength = contentLength
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name, contentType, body string
+		undeclared              bool
+		strict                  bool
+		status                  int
+		err                     error
+	}{
+		{"valid", "application/json", `{"item":"tea","quantity":2}`, false, false, 0, nil},
+		{"charset", "application/json; charset=utf-8", `{"item":"tea","quantity":2}`, false, false, 0, nil},
+		{"problem", "application/problem+json", `{"item":"tea","quantity":2}`, false, false, 0, nil},
+		{"trailing space", "application/json", `{"item":"tea","quantity":2}` + "\n ", false, false, 0, nil},
+		{"unknown field", "application/json", `{"item":"tea","quantity":2,"gift":true}`, false, false, 0, nil},
+		{"strict unknown field", "application/json", `{"item":"tea","quantity":2,"gift":true}`, false, true, http.StatusBadRequest, ErrMalformedJSON},
+		{"text", "text/plain", `{"item":"tea","quantity":2}`, false, false, http.StatusUnsupportedMediaType, ErrBadContentType},
+		{"no content type", "", `{"item":"tea","quantity":2}`, false, false, http.StatusUnsupportedMediaType, ErrBadContentType},
+		{"json prefix", "application/jsonp", `{}`, false, false, http.StatusUnsupportedMediaType, ErrBadContentType},
+		{"empty", "application/json", ``, false, false, http.StatusBadRequest, ErrMalformedJSON},
+		{"truncated", "application/json", `{"item":"tea","quan`, false, false, http.StatusBadRequest, ErrMalformedJSON},
+		{"truncated undeclared", "application/json", `{"item":"tea"`, true, false, http.StatusBadRequest, ErrMalformedJSON},
+		{"syntax", "application/json", `{"item":tea}`, false, false, http.StatusBadRequest, ErrMalformedJSON},
+		{"wrong type", "application/json", `{"quantity":"two"}`, false, false, http.StatusBadRequest, ErrMalformedJSON},
+		{"two values", "application/json", `{"item":"tea"}{"item":"coffee"}`, false, false, http.StatusBadRequest, ErrMalformedJSON},
+		{"declared too large", "application/json", `{"item":"` + strings.Repeat("a", 64) + `"}`, false, false, http.StatusRequestEntityTooLarge, ErrBodyTooLarge},
+		{"undeclared too large", "application/json", `{"item":"` + strings.Repeat("a", 64) + `"}`, true, false, http.StatusRequestEntityTooLarge, ErrBodyTooLarge},
+	}
+	for _, test := range tests {
+		contentLength := int64(len(test.body))
+		if test.undeclared {
+			contentLength = -1
+		}
+		r := jsonRequest(test.contentType, test.body, contentLength)
+		decode := DecodeJSON
+		if test.strict {
+			decode = DecodeJSONStrict
+		}
+
+		var order testOrder
+		err := decode(r, &order, 64)
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("%s: got error %v", test.name, err)
+			} else if order != (testOrder{"tea", 2}) {
+				t.Errorf("%s: decoded %+v", test.name, order)
+			}
+			continue
+		}
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("%s: got error %T %v, want a *DecodeError", test.name, err, err)
+			continue
+		}
+		if decodeErr.Status != test.status || !errors.Is(err, test.err) {
+			t.Errorf("%s: got %d %v, want %d %v", test.name, decodeErr.Status, err, test.status, test.err)
+		}
+	}
+}
+
+func TestDecodeJSONUnlimited(t *testing.T) {
+	body := `{"item":"` + strings.Repeat("a", 1<<16) + `"}`
+	var order testOrder
+	if err := DecodeJSON(jsonRequest("application/json", body, -1), &order, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Item) != 1<<16 {
+		t.Errorf("decoded %d bytes", len(order.Item))
+	}
+}
+
+func TestDecodeJSONLimitBody(t *testing.T) {
+	// The limit applied by LimitBody is reported in the same way.
+	var err error
+	h := LimitBody(8)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		var order testOrder
+		err = DecodeJSON(r, &order, 0)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), jsonRequest("application/json", `{"item":"tea","quantity":2}`, -1))
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Status != http.StatusRequestEntityTooLarge || !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("got error %v, want ErrBodyTooLarge", err)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ErrBodyTooLarge is returned when reading a request
+// body which is larger than permitted.
+var ErrBodyTooLarge = errors.New("web: request body too large")
+
+// LimitBody creates Middleware which limits the size of request bodies
+// to maxBytes. The next handler is called with a request body which
+// returns ErrBodyTooLarge if the request's Content-Length exceeds the
+// limit, or once more than maxBytes are read. At that point, if the
+// handler has not yet written its response status, any response it
+// writes is discarded, and once it returns, a 413 Request Entity Too
+// Large response is sent with Fail, and the connection is closed, so
+// the client stops sending the body. Requests without a body are not
+// limited.
+//
+// The limit can be changed for particular routes with WithBodyLimit.
+//
+// LimitBody does not read the body itself. The next handler sees a
+// ContentLength of -1. LimitBody panics if maxBytes is negative.
+//
+//	site.HasPrefix(web.LimitBody(1<<20)(uploadHandler), "/upload/")
+func LimitBody(maxBytes int64) Middleware {
+	if maxBytes < 0 {
+		panic("web: body size limit is negative")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			lw := &limitResponseWriter{ResponseWriter: w, r: r}
+			body := &limitedBody{body: r.Body, remaining: maxBytes, declared: r.ContentLength, w: lw}
+			r2 := r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, body))
+			r2.Body = body
+			r2.ContentLength = -1
+			next.ServeHTTP(lw, r2)
+			lw.finish()
+		})
+	}
+}
+
+// WithBodyLimit creates Middleware which changes the limit applied by
+// LimitBody to maxBytes for the requests it handles, such as to allow
+// larger uploads on one route. If the request body is not limited by
+// LimitBody, or has already been read from, WithBodyLimit works like
+// LimitBody. WithBodyLimit panics if maxBytes is negative.
+//
+//	site.Use(web.LimitBody(1 << 20))
+//	site.Post(web.WithBodyLimit(100<<20)(uploadHandler), "/upload")
+func WithBodyLimit(maxBytes int64) Middleware {
+	limit := LimitBody(maxBytes)
+
+	return func(next http.Handler) http.Handler {
+		limited := limit(next)
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			if body, ok := r.Context().Value(bodyLimitKey{}).(*limitedBody); ok && body.setLimit(maxBytes) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyLimitKey is the context key for the
+// request body limited by LimitBody.
+type bodyLimitKey struct{}
+
+// bodyTooLarge sends a 413 Request Entity Too Large response,
+// closing the connection so the client stops sending the body.
+func bodyTooLarge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	Fail(w, r, &Error{Code: http.StatusRequestEntityTooLarge, Err: ErrBodyTooLarge})
+}
+
+// limitedBody is a request body which
+// returns an error once its limit is
+// exceeded, notifying w, if not nil.
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	declared  int64
+	started   bool
+	w         *limitResponseWriter
+	err       error
+}
+
+// setLimit changes the limit, reporting whether it
+// could be changed before the body was read.
+func (b *limitedBody) setLimit(maxBytes int64) bool {
+	if b.started {
+		return false
+	}
+	b.remaining = maxBytes
+	return true
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if !b.started {
+		b.started = true
+		if b.declared > b.remaining {
+			return 0, b.exceeded()
+		}
+	}
+
+	// Read one more byte than permitted, so
+	// we know whether the limit is exceeded.
+	// Comparing before adding one avoids an
+	// overflow when the limit is very large.
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.body.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+
+	n = int(b.remaining)
+	b.remaining = 0
+	return n, b.exceeded()
+}
+
+// exceeded records that the limit was exceeded.
+func (b *limitedBody) exceeded() error {
+	b.err = ErrBodyTooLarge
+	if b.w != nil {
+		b.w.exceeded()
+	}
+	return b.err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// limitResponseWriter sends a 413 Request
+// Entity Too Large response once the handler
+// returns, if the body limit was exceeded
+// before the response status was written.
+//
+// The body may be read by another goroutine,
+// so the response is only written by the
+// handler's goroutine.
+type limitResponseWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	mu          sync.Mutex
+	wroteHeader bool
+	tooLarge    bool
+}
+
+// exceeded records that the body limit was exceeded.
+func (w *limitResponseWriter) exceeded() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.wroteHeader {
+		w.tooLarge = true
+	}
+}
+
+// finish sends the 413 Request Entity Too Large
+// response, once the handler has returned.
+func (w *limitResponseWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tooLarge && !w.wroteHeader {
+		w.wroteHeader = true
+		bodyTooLarge(w.ResponseWriter, w.r)
+	}
+}
+
+// start records that the response status is being
+// written, reporting false if the response will be
+// discarded.
+func (w *limitResponseWriter) start() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tooLarge {
+		return false
+	}
+	w.wroteHeader = true
+	return true
+}
+
+func (w *limitResponseWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.start() {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *limitResponseWriter) Write(data []byte) (int, error) {
+	if !w.start() {
+		return 0, ErrBodyTooLarge
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *limitResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.start() {
+		f.Flush()
+	}
+}
+
+func (w *limitResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		// The connection can no longer be used
+		// to send a 413 response.
+		w.mu.Lock()
+		w.wroteHeader = true
+		w.tooLarge = false
+		w.mu.Unlock()
+	}
+	return conn, rw, err
+}
+
+func (w *limitResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,271 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoBody creates an http.Handler which sends the request body
+// back, or a 400 Bad Request response if it cannot be read. The
+// read error is stored in readErr.
+func echoBody(t *testing.T, readErr *error) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength != -1 {
+			t.Errorf("handler saw ContentLength %d, want -1", r.ContentLength)
+		}
+		body, err := io.ReadAll(r.Body)
+		*readErr = err
+		if err != nil {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	})
+}
+
+func TestLimitBody(t *testing.T) {
+	var readErr error
+	h := LimitBody(5)(echoBody(t, &readErr))
+
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		wantStatus    int
+		wantBody      string
+	}{
+		{"within limit", "12345", 5, http.StatusOK, "12345"},
+		{"declared too large", "1234567", 7, http.StatusRequestEntityTooLarge, "Request Entity Too Large\n"},
+		{"undeclared too large", "123456", -1, http.StatusRequestEntityTooLarge, "Request Entity Too Large\n"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader(test.body)))
+		r.ContentLength = test.contentLength
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.wantStatus || w.Body.String() != test.wantBody {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, w.Code, w.Body.String(), test.wantStatus, test.wantBody)
+		}
+		if test.wantStatus == http.StatusRequestEntityTooLarge && w.Header().Get("Connection") != "close" {
+			t.Errorf("%s: connection not closed", test.name)
+		}
+	}
+	if readErr != ErrBodyTooLarge {
+		t.Errorf("reading past the limit returned %v, want ErrBodyTooLarge", readErr)
+	}
+}
+
+func TestLimitBodyMaximumLimit(t *testing.T) {
+	var readErr error
+	h := LimitBody(math.MaxInt64)(echoBody(t, &readErr))
+
+	r := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader("hello")))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if readErr != nil {
+		t.Fatalf("reading the body returned %v", readErr)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("got %d %q, want 200 %q", w.Code, w.Body.String(), "hello")
+	}
+}
+
+func TestLimitBodyBoundary(t *testing.T) {
+	for _, size := range []int{0, 1, 4096, 4097} {
+		body := &limitedBody{body: io.NopCloser(strings.NewReader(strings.Repeat("x", size))), remaining: int64(size)}
+		data, err := io.ReadAll(body)
+		if err != nil || len(data) != size {
+			t.Errorf("size %d: read %d bytes, error %v", size, len(data), err)
+		}
+
+		body = &limitedBody{body: io.NopCloser(strings.NewReader(strings.Repeat("x", size+1))), remaining: int64(size)}
+		data, err = io.ReadAll(body)
+		if err != ErrBodyTooLarge || len(data) != size {
+			t.Errorf("size %d+1: read %d bytes, error %v", size, len(data), err)
+		}
+	}
+}
+
+// slowBody streams size bytes in small chunks, like
+// a client uploading a body without a Content-Length.
+type slowBody struct {
+	remaining int
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		return 0, io.EOF
+	}
+	n := 3
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > b.remaining {
+		n = b.remaining
+	}
+	for i := range p[:n] {
+		p[i] = 'x'
+	}
+	b.remaining -= n
+	return n, nil
+}
+
+func TestLimitBodyStreamed(t *testing.T) {
+	h := LimitBody(10)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		if err != ErrBodyTooLarge {
+			t.Errorf("reading the body returned %v, want ErrBodyTooLarge", err)
+		}
+		// The handler's error response is discarded.
+		Fail(w, r, err)
+		w.Write([]byte("more"))
+	}))
+
+	tests := []struct {
+		accept, contentType, body string
+	}{
+		{"application/json", "application/json", `{"error":"Request Entity Too Large"}` + "\n"},
+		{"text/html", "text/html; charset=utf-8", "<!DOCTYPE html>\n<title>413 Request Entity Too Large</title>\n<h1>Request Entity Too Large</h1>\n"},
+		{"", "text/plain; charset=utf-8", "Request Entity Too Large\n"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/", io.NopCloser(&slowBody{remaining: 100}))
+		r.ContentLength = -1
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Accept %q: got status %d, want 413", test.accept, w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("Accept %q: got Content-Type %q, want %q", test.accept, got, test.contentType)
+		}
+		if got := w.Body.String(); got != test.body {
+			t.Errorf("Accept %q: got body %q, want %q", test.accept, got, test.body)
+		}
+		if w.Header().Get("Connection") != "close" {
+			t.Errorf("Accept %q: connection not closed", test.accept)
+		}
+	}
+}
+
+func TestLimitBodyWithoutBody(t *testing.T) {
+	called := false
+	h := LimitBody(0)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if r.Body != http.NoBody {
+			t.Errorf("got body %T, want http.NoBody", r.Body)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !called {
+		t.Error("handler not called")
+	}
+}
+
+func TestWithBodyLimit(t *testing.T) {
+	var readErr error
+	limit := LimitBody(5)
+	upload := limit(WithBodyLimit(10)(echoBody(t, &readErr)))
+	small := limit(WithBodyLimit(2)(echoBody(t, &readErr)))
+
+	tests := []struct {
+		name       string
+		h          http.Handler
+		body       string
+		wantStatus int
+	}{
+		{"raised", upload, "1234567890", http.StatusOK},
+		{"raised exceeded", upload, "12345678901", http.StatusRequestEntityTooLarge},
+		{"lowered", small, "123", http.StatusRequestEntityTooLarge},
+		{"unlimited", WithBodyLimit(2)(echoBody(t, &readErr)), "123", http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		test.h.ServeHTTP(w, r)
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.wantStatus)
+		}
+		if test.wantStatus == http.StatusOK && w.Body.String() != test.body {
+			t.Errorf("%s: got body %q, want %q", test.name, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestLimitBodyReadInAnotherGoroutine(t *testing.T) {
+	h := LimitBody(10)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		errs := make(chan error)
+		go func() {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}()
+		if err := <-errs; err != ErrBodyTooLarge {
+			t.Errorf("reading the body returned %v, want ErrBodyTooLarge", err)
+		}
+	}))
+
+	r := httptest.NewRequest("POST", "/", io.NopCloser(&slowBody{remaining: 100}))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || w.Header().Get("Connection") != "close" {
+		t.Errorf("got status %d with Connection %q, want 413 close", w.Code, w.Header().Get("Connection"))
+	}
+}
+
+func TestLimitBodyAfterResponse(t *testing.T) {
+	// Once the handler has written its response
+	// status, its response is sent as usual.
+	h := LimitBody(10)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		if _, err := io.ReadAll(r.Body); err != ErrBodyTooLarge {
+			t.Errorf("reading the body returned %v, want ErrBodyTooLarge", err)
+		}
+		io.WriteString(w, "partial")
+	}))
+
+	r := httptest.NewRequest("POST", "/", io.NopCloser(&slowBody{remaining: 100}))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted || w.Body.String() != "partial" {
+		t.Errorf("got %d %q, want 202 partial", w.Code, w.Body.String())
+	}
+}
+
+func TestLimitBodyHijack(t *testing.T) {
+	srv := httptest.NewServer(LimitBody(10)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack returned %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	})))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != "hijacked" {
+		t.Errorf("got %d %q, want 200 hijacked", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LogFormat determines the format used by a Logger.
+type LogFormat int
+
+const (
+	// JSONLogFormat writes each request as a single-line JSON
+	// object, with the keys "time", "method", "path", "status",
+	// "size", "latency_ms", and "remote_ip", plus "request_id"
+	// if the request has an ID, as set by RequestID.
+	JSONLogFormat LogFormat = iota
+
+	// CommonLogFormat writes each request in the Common Log
+	// Format used by many web servers, escaping quotes and
+	// control characters in the request as Apache does. This
+	// format does not include the request latency. If the
+	// request has an ID, it is added in quotes at the end of
+	// the line.
+	CommonLogFormat
+)
+
+// Logger writes a line to its output for each request it handles.
+//
+// Logger must be created with NewLogger.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format LogFormat
+}
+
+// NewLogger creates a Logger which writes to out in the given
+// format. If out is nil, os.Stdout is used.
+//
+//	logger := web.NewLogger(os.Stdout, web.JSONLogFormat)
+//	site.HasPrefix(logger.Handler(apiHandler), "/api/")
+func NewLogger(out io.Writer, format LogFormat) *Logger {
+	if out == nil {
+		out = os.Stdout
+	}
+	return &Logger{out: out, format: format}
+}
+
+// Handler returns an http.Handler which calls next, then logs
+// the request and the response's status code and size.
+func (l *Logger) Handler(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		l.log(r, loggedRequestID(rec, r), rec.Status(), rec.Written(), start, time.Since(start))
+	})
+}
+
+type jsonLogEntry struct {
+	Time      string  `json:"time"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Size      int64   `json:"size"`
+	LatencyMs float64 `json:"latency_ms"`
+	RemoteIP  string  `json:"remote_ip"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+func (l *Logger) log(r *http.Request, requestID string, status int, size int64, start time.Time, latency time.Duration) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	var line []byte
+	switch l.format {
+	case CommonLogFormat:
+		user := ""
+		if r.Header.Get("Authorization") != "" {
+			user, _, _ = r.BasicAuth()
+		}
+		uri := r.RequestURI
+		if uri == "" {
+			uri = r.URL.RequestURI()
+		}
+		line = appendLogField(line, remoteIP(r))
+		line = append(line, " - "...)
+		line = appendLogField(line, user)
+		line = append(line, " ["...)
+		line = start.AppendFormat(line, "02/Jan/2006:15:04:05 -0700")
+		line = append(line, `] "`...)
+		line = appendLogString(line, r.Method)
+		line = append(line, ' ')
+		line = appendLogString(line, uri)
+		line = append(line, ' ')
+		line = appendLogString(line, r.Proto)
+		line = append(line, `" `...)
+		line = strconv.AppendInt(line, int64(status), 10)
+		line = append(line, ' ')
+		if size > 0 {
+			line = strconv.AppendInt(line, size, 10)
+		} else {
+			line = append(line, '-')
+		}
+		if requestID != "" {
+			line = append(line, ` "`...)
+			line = appendLogString(line, requestID)
+			line = append(line, '"')
+		}
+		line = append(line, '\n')
+
+	default:
+		var err error
+		line, err = json.Marshal(jsonLogEntry{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    status,
+			Size:      size,
+			LatencyMs: float64(latency) / float64(time.Millisecond),
+			RemoteIP:  remoteIP(r),
+			RequestID: requestID,
+		})
+		if err != nil {
+			return
+		}
+		line = append(line, '\n')
+	}
+
+	l.mu.Lock()
+	l.out.Write(line)
+	l.mu.Unlock()
+}
@@ -0,0 +1,74 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestLoggerJSON(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewLogger(&out, JSONLogFormat)
+	h := RequestID(logger.Handler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "hello")
+	})))
+
+	r := httptest.NewRequest("POST", "/users?name=a", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry jsonLogEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("got log line %q: %v", out.String(), err)
+	}
+	if entry.Method != "POST" || entry.Path != "/users" || entry.Status != http.StatusCreated ||
+		entry.Size != 5 || entry.RemoteIP != "203.0.113.7" || entry.RequestID == "" {
+		t.Errorf("got log entry %+v", entry)
+	}
+	if entry.Time == "" || entry.LatencyMs < 0 {
+		t.Errorf("got time %q and latency %v", entry.Time, entry.LatencyMs)
+	}
+	if bytes.Count(out.Bytes(), []byte("\n")) != 1 {
+		t.Errorf("got log %q, want a single line", out.String())
+	}
+}
+
+func TestLoggerCommonLogFormat(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewLogger(&out, CommonLogFormat)
+	h := logger.Handler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+
+	r := httptest.NewRequest("GET", `/a"b`, nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.SetBasicAuth("alice\"\n", "secret")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	pattern := `^203\.0\.113\.7 - alice\\"\\x0a \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /a\\"b HTTP/1\.1" 200 5` + "\n$"
+	if !regexp.MustCompile(pattern).MatchString(out.String()) {
+		t.Errorf("got log line %q", out.String())
+	}
+
+	// Empty fields are logged as "-", and IDs are appended.
+	out.Reset()
+	h = RequestID(logger.Handler(emptyHandler))
+	r = httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set("X-Request-ID", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	pattern = `^203\.0\.113\.7 - - \[[^]]+\] "GET / HTTP/1\.1" 200 - "abc"` + "\n$"
+	if !regexp.MustCompile(pattern).MatchString(out.String()) {
+		t.Errorf("got log line %q", out.String())
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// methodRouter matches request paths like patternRouter,
+// then dispatches on the request method.
+type methodRouter struct {
+	site     *Site
+	patterns patternRouter
+	paths    map[string]*methodHandler // Pattern to handler.
+}
+
+func (m *methodRouter) add(method, pattern string, handler http.Handler) {
+	if m.paths == nil {
+		m.paths = make(map[string]*methodHandler)
+	}
+	h, ok := m.paths[pattern]
+	if !ok {
+		h = &methodHandler{site: m.site, handlers: make(map[string]http.Handler)}
+		m.patterns.add(pattern, h, m.site.CaseInsensitive)
+		m.paths[pattern] = h
+	}
+	if _, ok := h.handlers[method]; ok {
+		panic(fmt.Sprintf("web: method %s already registered for pattern %q", method, pattern))
+	}
+	h.handlers[method] = handler
+}
+
+func (m *methodRouter) route(path, escaped string) http.Handler {
+	return m.patterns.route(path, escaped)
+}
+
+// methodHandler dispatches requests for a single
+// path pattern on the request method.
+type methodHandler struct {
+	site     *Site
+	handlers map[string]http.Handler // Method to handler.
+}
+
+func (m *methodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := m.handlers[r.Method]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	if handler, ok := m.handlers["GET"]; ok && r.Method == "HEAD" {
+		handler.ServeHTTP(headResponseWriter{w}, r)
+		return
+	}
+	if r.Method == "OPTIONS" && !m.site.noAutoOptions {
+		w.Header().Set("Allow", m.allow())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Allow", m.allow())
+	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+}
+
+// allow returns the value for an Allow header, listing
+// the permitted methods.
+func (m *methodHandler) allow() string {
+	methods := make([]string, 0, len(m.handlers)+2)
+	for method := range m.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := m.handlers["GET"]; ok {
+		if _, ok := m.handlers["HEAD"]; !ok {
+			methods = append(methods, "HEAD")
+		}
+	}
+	if _, ok := m.handlers["OPTIONS"]; !ok && !m.site.noAutoOptions {
+		methods = append(methods, "OPTIONS")
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// MethodOverride creates an http.Handler which lets clients which
+// can only send GET and POST requests use other methods. For POST
+// requests, the method given in the X-HTTP-Method-Override header,
+// or the _method form field if there is no header, replaces the
+// request method before the request is passed to next. Methods are
+// case-insensitive, and must be PUT, PATCH or DELETE, or a 400 Bad
+// Request response is sent. Safe methods, such as GET, cannot be
+// used, so a cross-site POST cannot be turned into a request which
+// skips CSRF checks. Requests with other methods are passed to next
+// unchanged.
+//
+// MethodOverride can be added to a Site with Use, as the method
+// is only used to choose a handler registered with Method after
+// the middleware has run.
+//
+//	site.Use(web.MethodOverride)
+//	site.Delete(web.Handler(deleteUser), "/users/:id")
+func MethodOverride(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.Header.Get("X-HTTP-Method-Override")
+		if method == "" {
+			method = r.PostFormValue("_method")
+		}
+		if method == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method = strings.ToUpper(method)
+		switch method {
+		case "PUT", "PATCH", "DELETE":
+		default:
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		r = r.WithContext(r.Context())
+		r.Method = method
+		next.ServeHTTP(w, r)
+	})
+}
+
+// headResponseWriter discards the response body, for
+// serving HEAD requests with a GET handler.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (w headResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
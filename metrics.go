@@ -0,0 +1,82 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"expvar"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// startTime is used to report the server's uptime.
+var startTime = time.Now()
+
+// MetricsHandler creates an http.Handler which sends a JSON document
+// describing the server, suitable for scraping, such as:
+//
+//	{"goroutines":12,"heap_alloc_bytes":1048576,"heap_objects":5120,"page_views":12345,"uptime_seconds":3600.5}
+//
+// The page_views key is only included if views is not nil. Each of
+// the extra functions is called for every request, and the keys and
+// values it returns are added to the document, replacing any keys
+// already present. Responses are not cached.
+//
+// To restrict access to the metrics, wrap the handler with BasicAuth.
+//
+//	guard := web.BasicAuth("metrics", checkPassword)
+//	site.Equals(guard(web.MetricsHandler(&views, queueStats)), "/metrics")
+func MetricsHandler(views *PageViews, extra ...func() map[string]interface{}) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		DoNotCache(w)
+
+		metrics := serverMetrics(views)
+		for _, fn := range extra {
+			for key, value := range fn() {
+				metrics[key] = value
+			}
+		}
+		JSON(w, http.StatusOK, metrics)
+	})
+}
+
+// PublishMetrics registers the page view count and uptime
+// reported by MetricsHandler with the expvar package, with
+// the given prefix, such as "web." for the variables
+// "web.page_views" and "web.uptime_seconds". The Go runtime
+// statistics are already published by expvar, as "memstats".
+// The page view count is only published if views is not nil.
+// Like expvar.Publish, PublishMetrics panics if a variable
+// is already registered with the same name.
+//
+//	web.PublishMetrics("web.", &views)
+//	site.Equals(expvar.Handler(), "/debug/vars")
+func PublishMetrics(prefix string, views *PageViews) {
+	if views != nil {
+		expvar.Publish(prefix+"page_views", expvar.Func(func() interface{} {
+			return views.Count()
+		}))
+	}
+	expvar.Publish(prefix+"uptime_seconds", expvar.Func(func() interface{} {
+		return time.Since(startTime).Seconds()
+	}))
+}
+
+// serverMetrics returns the metrics sent by MetricsHandler.
+func serverMetrics(views *PageViews) map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	metrics := map[string]interface{}{
+		"goroutines":       runtime.NumGoroutine(),
+		"heap_alloc_bytes": mem.HeapAlloc,
+		"heap_objects":     mem.HeapObjects,
+		"uptime_seconds":   time.Since(startTime).Seconds(),
+	}
+	if views != nil {
+		metrics["page_views"] = views.Count()
+	}
+	return metrics
+}
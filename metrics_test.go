@@ -0,0 +1,83 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getMetrics fetches the metrics from the handler.
+func getMetrics(t *testing.T, h http.Handler) map[string]interface{} {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-cache, no-store, must-revalidate" {
+		t.Errorf("got Cache-Control %q", got)
+	}
+
+	var metrics map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	return metrics
+}
+
+func TestMetricsHandler(t *testing.T) {
+	var views PageViews
+	page := Handler(func(w http.ResponseWriter, r *http.Request) {
+		views.Add()
+	})
+	h := MetricsHandler(&views, func() map[string]interface{} {
+		return map[string]interface{}{"queue_length": 3}
+	})
+
+	before := getMetrics(t, h)
+	for _, key := range []string{"page_views", "uptime_seconds", "goroutines", "heap_alloc_bytes", "heap_objects", "queue_length"} {
+		if _, ok := before[key]; !ok {
+			t.Errorf("metrics have no %q key", key)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		page.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	after := getMetrics(t, h)
+	if got := after["page_views"].(float64) - before["page_views"].(float64); got != 5 {
+		t.Errorf("page views increased by %v, want 5", got)
+	}
+	if after["uptime_seconds"].(float64) < before["uptime_seconds"].(float64) {
+		t.Error("uptime decreased")
+	}
+}
+
+// publishRuns counts the runs of TestPublishMetrics, so
+// each run publishes its variables with a unique prefix.
+var publishRuns int
+
+func TestPublishMetrics(t *testing.T) {
+	publishRuns++
+	prefix := fmt.Sprintf("test.metrics.%d.", publishRuns)
+	var views PageViews
+	PublishMetrics(prefix, &views)
+	views.AddN(7)
+
+	v := expvar.Get(prefix + "page_views")
+	if v == nil {
+		t.Fatal("page views were not published")
+	}
+	if got := v.String(); got != "7" {
+		t.Errorf("got published page views %s, want 7", got)
+	}
+	if expvar.Get(prefix+"uptime_seconds") == nil {
+		t.Error("uptime was not published")
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+)
+
+// Middleware wraps an http.Handler to provide additional behaviour.
+// The returned handler is responsible for calling the next handler,
+// so can end the request early by writing a response without doing
+// so.
+//
+//	func requireAuth(next http.Handler) http.Handler {
+//		return web.Handler(func(w http.ResponseWriter, r *http.Request) {
+//			if r.Header.Get("Authorization") == "" {
+//				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+//				return
+//			}
+//			next.ServeHTTP(w, r)
+//		})
+//	}
+type Middleware func(next http.Handler) http.Handler
+
+// Wrap applies the given middleware to the handler. The middleware
+// are called in the order given, so the first middleware sees each
+// request first.
+//
+//	// Requests pass through requireAuth, then logRequests, then serveAPI.
+//	handler := web.Wrap(web.Handler(serveAPI), requireAuth, logRequests)
+func Wrap(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Chain is a sequence of Middleware, which can be applied to
+// multiple handlers. The middleware are called in order, as
+// with Wrap.
+//
+//	chain := web.Chain{requireAuth, logRequests}
+//	site.Equals(chain.Then(web.Handler(serveAdmin)), "/admin")
+type Chain []Middleware
+
+// Then applies the chain's middleware to the handler.
+func (c Chain) Then(handler http.Handler) http.Handler {
+	return Wrap(handler, c...)
+}
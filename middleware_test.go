@@ -0,0 +1,107 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// traceMiddleware records its name in the
+// trace before and after calling next.
+func traceMiddleware(trace *[]string, name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			*trace = append(*trace, name)
+			next.ServeHTTP(w, r)
+			*trace = append(*trace, "/"+name)
+		})
+	}
+}
+
+// upperResponseWriter converts the
+// response body to upper case.
+type upperResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w upperResponseWriter) Write(data []byte) (int, error) {
+	return w.ResponseWriter.Write([]byte(strings.ToUpper(string(data))))
+}
+
+func TestWrapOrder(t *testing.T) {
+	var trace []string
+	handler := Handler(func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "handler")
+	})
+
+	chain := Chain{traceMiddleware(&trace, "a"), traceMiddleware(&trace, "b")}
+	serveSite(chain.Then(handler), "GET", "/")
+	if got := strings.Join(trace, " "); got != "a b handler /b /a" {
+		t.Errorf("Chain: got %q", got)
+	}
+
+	trace = nil
+	serveSite(Wrap(handler, chain...), "GET", "/")
+	if got := strings.Join(trace, " "); got != "a b handler /b /a" {
+		t.Errorf("Wrap: got %q", got)
+	}
+
+	trace = nil
+	serveSite(Chain(nil).Then(handler), "GET", "/")
+	if got := strings.Join(trace, " "); got != "handler" {
+		t.Errorf("empty Chain: got %q", got)
+	}
+}
+
+func TestSiteUse(t *testing.T) {
+	var trace []string
+	site := NewSite("example.com", 80, nil)
+	site.Equals(Handler(func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "before")
+	}), "/before")
+	site.Use(traceMiddleware(&trace, "a"))
+	site.Use(traceMiddleware(&trace, "b"), traceMiddleware(&trace, "c"))
+	site.Equals(Handler(func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "after")
+	}), "/after")
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/before", "a b c before /c /b /a"},
+		{"/after", "a b c after /c /b /a"},
+		{"/missing", ""},
+	}
+	for _, test := range tests {
+		trace = nil
+		serveSite(site, "GET", test.path)
+		if got := strings.Join(trace, " "); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestSiteUseWrapsResponseWriter(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Use(func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Middleware", "yes")
+			next.ServeHTTP(upperResponseWriter{w}, r)
+		})
+	})
+	site.Equals(Handler(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(upperResponseWriter); !ok {
+			t.Errorf("handler received %T, want upperResponseWriter", w)
+		}
+		w.Write([]byte("hello"))
+	}), "/")
+
+	w := serveSite(site, "GET", "/")
+	if w.Body.String() != "HELLO" || w.Header().Get("X-Middleware") != "yes" {
+		t.Errorf("got %q with X-Middleware %q", w.Body.String(), w.Header().Get("X-Middleware"))
+	}
+}
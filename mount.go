@@ -0,0 +1,68 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MountPrefix returns the path prefix removed from the request
+// by any sites it was passed through with Site.Mount, such as
+// "/api/v1" for nested mounts. This can be used to build absolute
+// URLs from within a mounted site.
+func MountPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(mountKey{}).(string)
+	return prefix
+}
+
+// mountKey is the context key for a request's mount prefix.
+type mountKey struct{}
+
+// mountRoute passes requests under its prefix to
+// another site, with the prefix removed.
+type mountRoute struct {
+	prefix string // No trailing slash.
+	site   *Site
+}
+
+func (m *mountRoute) route(path, _ string) http.Handler {
+	if path != m.prefix && !strings.HasPrefix(path, m.prefix+"/") {
+		return nil
+	}
+	return m
+}
+
+func (m *mountRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := new(url.URL)
+	*u = *r.URL
+	u.Path = trimPrefixFold(u.Path, m.prefix)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if u.RawPath != "" {
+		if rawPath := trimPrefixFold(u.RawPath, m.prefix); rawPath != u.RawPath && rawPath != "" {
+			u.RawPath = rawPath
+		} else {
+			u.RawPath = ""
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), mountKey{}, MountPrefix(r)+m.prefix)
+	r = r.WithContext(ctx)
+	r.URL = u
+	m.site.ServeHTTP(w, r)
+}
+
+// trimPrefixFold removes the prefix from s, ignoring case, as
+// the prefix may have been matched case-insensitively.
+func trimPrefixFold(s, prefix string) string {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):]
+	}
+	return s
+}
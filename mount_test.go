@@ -0,0 +1,81 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+// mountHandler writes its name, the path it sees,
+// and the mount prefix.
+func mountHandler(name string) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.Path+" "+MountPrefix(r))
+	})
+}
+
+func TestMountNested(t *testing.T) {
+	v1 := NewSite("example.com", 80, nil)
+	v1.Equals(mountHandler("v1 root"), "/")
+	v1.Equals(mountHandler("users"), "/users")
+	v1.HasSuffix(mountHandler("json"), ".json")
+
+	api := NewSite("example.com", 80, nil)
+	api.Equals(mountHandler("api root"), "/")
+	api.Mount("/v1", v1)
+	api.Always(mountHandler("api always"))
+
+	site := NewSite("example.com", 80, nil)
+	site.Equals(mountHandler("home"), "/")
+	site.Mount("/api/", api)
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/", "home / "},
+		{"/api", "api root / /api"},
+		{"/api/", "api root / /api"},
+		{"/api/v1", "v1 root / /api/v1"},
+		{"/api/v1/", "v1 root / /api/v1"},
+		{"/api/v1/users", "users /users /api/v1"},
+		{"/api/v1/data.json", "json /data.json /api/v1"},
+		{"/api/v2", "api always /v2 /api"},
+		{"/api/v1x", "api always /v1x /api"},
+		{"/apix", "404 page not found\n"},
+	}
+	for _, test := range tests {
+		if got := serveSite(site, "GET", test.path).Body.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestMountNotFound(t *testing.T) {
+	sub := NewSite("example.com", 80, nil)
+	sub.SetNotFound(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "sub not found "+r.URL.Path)
+	}))
+	site := NewSite("example.com", 80, nil)
+	site.Mount("/sub", sub)
+
+	w := serveSite(site, "GET", "/sub/missing")
+	if w.Code != http.StatusNotFound || w.Body.String() != "sub not found /missing" {
+		t.Errorf("got %d %q, want the sub-site's 404", w.Code, w.Body.String())
+	}
+}
+
+func TestMountEscapedPath(t *testing.T) {
+	sub := NewSite("example.com", 80, nil)
+	sub.Pattern(paramHandler("file", "name"), "/files/:name")
+	site := NewSite("example.com", 80, nil)
+	site.Mount("/api", sub)
+
+	if got := serveSite(site, "GET", "/api/files/a%2Fb").Body.String(); got != "file name=a/b" {
+		t.Errorf("got %q, want %q", got, "file name=a/b")
+	}
+}
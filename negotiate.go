@@ -0,0 +1,199 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Negotiate returns the offered media type most preferred by the
+// client, according to the request's Accept header, as described in
+// RFC 7231, section 5.3.2. Wildcards, such as "*/*" and "text/*", and
+// media type parameters are supported, with the most specific match
+// for each offer determining its quality value. Where offers are
+// equally preferred, the earliest is returned.
+//
+// Offers with a quality value of 0 are not acceptable, and if none is
+// acceptable, the empty string is returned. If the request has no
+// Accept header, any offer is acceptable, so the first is returned.
+// Malformed quality values are treated as 1.
+//
+//	switch web.Negotiate(r, "text/html", "application/json") {
+//	case "text/html":
+//		renderPage(w, data)
+//	case "application/json":
+//		web.JSON(w, http.StatusOK, data)
+//	default:
+//		http.Error(w, "Not Acceptable", http.StatusNotAcceptable)
+//	}
+func Negotiate(r *http.Request, offers ...string) string {
+	return negotiate(r.Header.Values("Accept"), offers, mediaRangeMatch, nil)
+}
+
+// NegotiateLanguage returns the offered language tag most preferred
+// by the client, according to the request's Accept-Language header,
+// as described in RFC 7231, section 5.3.5. A language range matches
+// a tag which is equal to it, or begins with it followed by "-", so
+// "en" matches "en-GB", and "*" matches any tag. Tags are compared
+// case-insensitively. Otherwise, NegotiateLanguage works like
+// Negotiate.
+//
+//	lang := web.NegotiateLanguage(r, "en-GB", "fr", "de")
+func NegotiateLanguage(r *http.Request, offers ...string) string {
+	return negotiate(r.Header.Values("Accept-Language"), offers, languageRangeMatch, nil)
+}
+
+// NegotiateEncoding returns the offered content coding most preferred
+// by the client, according to the request's Accept-Encoding header, as
+// described in RFC 7231, section 5.3.4. The "identity" coding is always
+// acceptable, unless excluded with a quality value of 0, either for
+// "identity" or for "*". Otherwise, NegotiateEncoding works like
+// Negotiate.
+//
+//	coding := web.NegotiateEncoding(r, "br", "gzip", "identity")
+func NegotiateEncoding(r *http.Request, offers ...string) string {
+	return negotiate(r.Header.Values("Accept-Encoding"), offers, encodingRangeMatch, identityQuality)
+}
+
+// acceptRange is an element of an Accept header with its
+// parameters, other than the quality value, both in lower
+// case.
+type acceptRange struct {
+	value  string
+	params []string
+	q      float64
+}
+
+// parseAccept parses the elements of the Accept headers.
+func parseAccept(headers []string) []acceptRange {
+	var ranges []acceptRange
+	for _, header := range headers {
+		for _, part := range strings.Split(header, ",") {
+			value, params := parseAcceptValue(part)
+			if value != "" {
+				ranges = append(ranges, acceptRange{value, params, quality(part)})
+			}
+		}
+	}
+	return ranges
+}
+
+// parseAcceptValue returns the value of an element of an
+// Accept header or an offer, and its sorted parameters,
+// other than the quality value, in lower case.
+func parseAcceptValue(s string) (value string, params []string) {
+	value, rest, _ := strings.Cut(s, ";")
+	for _, param := range strings.Split(rest, ";") {
+		key, val, _ := strings.Cut(param, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" || key == "q" {
+			continue
+		}
+		params = append(params, key+"="+strings.ToLower(strings.Trim(strings.TrimSpace(val), `"`)))
+	}
+	sort.Strings(params)
+	return strings.ToLower(strings.TrimSpace(value)), params
+}
+
+// rangeMatch reports whether an Accept header element
+// matches an offer, and if so, how specific the match is.
+// More specific matches have greater values.
+type rangeMatch func(ar acceptRange, offer string, offerParams []string) (specificity int, ok bool)
+
+// negotiate returns the offer with the highest quality value in
+// the Accept headers. Offers which match no element have the
+// quality value returned by unlisted, or 0 if unlisted is nil.
+func negotiate(headers, offers []string, match rangeMatch, unlisted func(offer string) float64) string {
+	ranges := parseAccept(headers)
+	if len(ranges) == 0 {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		value, params := parseAcceptValue(offer)
+		q, specificity := 0.0, -1
+		for _, ar := range ranges {
+			if s, ok := match(ar, value, params); ok && s > specificity {
+				q, specificity = ar.q, s
+			}
+		}
+		if specificity < 0 && unlisted != nil {
+			q = unlisted(value)
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// mediaRangeMatch matches media ranges, such as "text/*",
+// including any parameters, against media types.
+func mediaRangeMatch(ar acceptRange, offer string, offerParams []string) (int, bool) {
+	for _, param := range ar.params {
+		if !contains(offerParams, param) {
+			return 0, false
+		}
+	}
+
+	typ, subtype, _ := strings.Cut(offer, "/")
+	rangeType, rangeSubtype, _ := strings.Cut(ar.value, "/")
+	switch {
+	case rangeType == "*" && rangeSubtype == "*":
+		return len(ar.params), true
+	case rangeType == typ && rangeSubtype == "*":
+		return 100 + len(ar.params), true
+	case rangeType == typ && rangeSubtype == subtype:
+		return 200 + len(ar.params), true
+	}
+	return 0, false
+}
+
+// languageRangeMatch matches language ranges, such as
+// "en", against language tags, such as "en-GB".
+func languageRangeMatch(ar acceptRange, offer string, _ []string) (int, bool) {
+	switch {
+	case ar.value == "*":
+		return 0, true
+	case offer == ar.value || strings.HasPrefix(offer, ar.value+"-"):
+		return len(ar.value), true
+	}
+	return 0, false
+}
+
+// encodingRangeMatch matches content codings.
+func encodingRangeMatch(ar acceptRange, offer string, _ []string) (int, bool) {
+	switch {
+	case ar.value == "*":
+		return 0, true
+	case offer == ar.value:
+		return 1, true
+	}
+	return 0, false
+}
+
+// identityQuality gives the identity coding a quality
+// value of 1 if it is not listed in Accept-Encoding.
+func identityQuality(offer string) float64 {
+	if offer == "identity" {
+		return 1
+	}
+	return 0
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type negotiateTest struct {
+	accept string
+	offers []string
+	want   string
+}
+
+// runNegotiateTests checks each test, setting the
+// named header to its accept value, if it is not
+// empty.
+func runNegotiateTests(t *testing.T, header string, negotiate func(*http.Request, ...string) string, tests []negotiateTest) {
+	t.Helper()
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.accept != "" {
+			r.Header.Set(header, test.accept)
+		}
+		if got := negotiate(r, test.offers...); got != test.want {
+			t.Errorf("%s %q, offers %q: got %q, want %q", header, test.accept, strings.Join(test.offers, ", "), got, test.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	// The example from RFC 7231, section 5.3.2, where the
+	// quality values are:
+	//
+	//	text/html;level=1 1
+	//	text/html         0.7
+	//	text/plain        0.3
+	//	image/jpeg        0.5
+	//	text/html;level=2 0.4
+	//	text/html;level=3 0.7
+	const rfc = "text/*;q=0.3, text/html;q=0.7, text/html;level=1, text/html;level=2;q=0.4, */*;q=0.5"
+
+	runNegotiateTests(t, "Accept", Negotiate, []negotiateTest{
+		{rfc, []string{"text/html", "text/html;level=1"}, "text/html;level=1"},
+		{rfc, []string{"text/html;level=2", "text/html"}, "text/html"},
+		{rfc, []string{"text/plain", "image/jpeg"}, "image/jpeg"},
+		{rfc, []string{"text/plain", "text/html;level=2"}, "text/html;level=2"},
+		{rfc, []string{"text/html;level=2", "image/jpeg"}, "image/jpeg"},
+		{rfc, []string{"image/jpeg", "text/html;level=3"}, "text/html;level=3"},
+		{rfc, []string{"text/html;level=3", "text/html"}, "text/html;level=3"},
+		{rfc, []string{"text/html", "text/html;level=3"}, "text/html"},
+		{rfc, []string{"text/plain"}, "text/plain"},
+		{rfc, []string{`TEXT/HTML; Level="1"`}, `TEXT/HTML; Level="1"`},
+
+		// Wildcards, and the most specific match.
+		{"text/*", []string{"application/json", "text/plain"}, "text/plain"},
+		{"*/*", []string{"application/json", "text/plain"}, "application/json"},
+		{"*/*;q=0.1, application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"application/json;q=0, */*", []string{"application/json", "text/html"}, "text/html"},
+		{"text/*;q=0, text/html", []string{"text/plain", "text/html"}, "text/html"},
+
+		// Nothing acceptable.
+		{"application/json", []string{"text/html"}, ""},
+		{"text/html;q=0", []string{"text/html"}, ""},
+		{"text/html", nil, ""},
+
+		// No Accept header.
+		{"", []string{"text/html", "application/json"}, "text/html"},
+		{"", nil, ""},
+
+		// Malformed quality values are treated as 1.
+		{"text/html;q=0.5, application/json;q=x", []string{"text/html", "application/json"}, "application/json"},
+	})
+}
+
+func TestNegotiateMultipleHeaders(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Add("Accept", "text/html;q=0.5")
+	r.Header.Add("Accept", "application/json")
+	if got := Negotiate(r, "text/html", "application/json"); got != "application/json" {
+		t.Errorf("got %q, want application/json", got)
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	// The example from RFC 7231, section 5.3.5.
+	const rfc = "da, en-gb;q=0.8, en;q=0.7"
+
+	runNegotiateTests(t, "Accept-Language", NegotiateLanguage, []negotiateTest{
+		{rfc, []string{"en-GB", "da"}, "da"},
+		{rfc, []string{"en-US", "en-GB"}, "en-GB"},
+		{rfc, []string{"en-US"}, "en-US"},
+		{rfc, []string{"en"}, "en"},
+		{rfc, []string{"fr"}, ""},
+		{rfc, []string{"eng"}, ""},
+		{"en-GB", []string{"en"}, ""},
+		{"fr, *;q=0.1", []string{"de", "fr"}, "fr"},
+		{"fr, *;q=0.1", []string{"de"}, "de"},
+		{"*, en;q=0", []string{"en-GB", "fr"}, "fr"},
+		{"", []string{"de", "fr"}, "de"},
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	runNegotiateTests(t, "Accept-Encoding", NegotiateEncoding, []negotiateTest{
+		{"gzip;q=1.0, identity; q=0.5, *;q=0", []string{"br", "identity"}, "identity"},
+		{"gzip;q=1.0, identity; q=0.5, *;q=0", []string{"br"}, ""},
+		{"gzip;q=1.0, identity; q=0.5, *;q=0", []string{"identity", "gzip"}, "gzip"},
+		{"br, gzip", []string{"gzip", "br"}, "gzip"},
+		{"br;q=0.9, gzip", []string{"br", "gzip"}, "gzip"},
+		{"GZIP", []string{"gzip"}, "gzip"},
+
+		// The identity coding is acceptable unless excluded.
+		{"gzip", []string{"br", "identity"}, "identity"},
+		{"gzip;q=0", []string{"gzip", "identity"}, "identity"},
+		{"identity;q=0", []string{"identity"}, ""},
+		{"*;q=0", []string{"identity"}, ""},
+		{"*;q=0, identity", []string{"identity"}, "identity"},
+		{"", []string{"br", "identity"}, "br"},
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// OTelOption configures the Middleware created by OTelMiddleware.
+type OTelOption func(*otelConfig)
+
+type otelConfig struct {
+	spanName   func(r *http.Request) string
+	propagator propagation.TextMapPropagator
+}
+
+// OTelSpanName sets the function used to name each request's span.
+// By default, spans are named "HTTP " followed by the request method,
+// such as "HTTP GET".
+//
+//	web.OTelMiddleware(tracer, web.OTelSpanName(func(r *http.Request) string {
+//		return r.Method + " " + r.URL.Path
+//	}))
+func OTelSpanName(spanName func(r *http.Request) string) OTelOption {
+	return func(c *otelConfig) {
+		c.spanName = spanName
+	}
+}
+
+// OTelPropagator sets the propagator used to extract the trace context
+// from the request headers. By default, the W3C Trace Context traceparent
+// and tracestate headers are used.
+func OTelPropagator(propagator propagation.TextMapPropagator) OTelOption {
+	return func(c *otelConfig) {
+		c.propagator = propagator
+	}
+}
+
+// OTelMiddleware creates Middleware which traces each request with
+// OpenTelemetry, using the given tracer. If tracer is nil, a tracer
+// from the global TracerProvider is used. Each request's span continues
+// any trace given in its W3C traceparent header, and is stored in the
+// request context passed to the next handler, so it can be retrieved
+// with trace.SpanFromContext.
+//
+// Spans have the attributes http.method and http.status_code, and
+// http.route if the request matched a pattern registered with a Site's
+// Pattern or Method, such as "/users/:id". Responses with status codes
+// of 500 or above mark the span as failed.
+//
+//	site.Use(web.OTelMiddleware(otel.Tracer("example.com")))
+func OTelMiddleware(tracer trace.Tracer, opts ...OTelOption) Middleware {
+	if tracer == nil {
+		tracer = otel.Tracer("github.com/SlyMarbo/web")
+	}
+	config := otelConfig{
+		spanName: func(r *http.Request) string {
+			return "HTTP " + r.Method
+		},
+		propagator: propagation.TraceContext{},
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			ctx := config.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, config.spanName(r),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attribute.String("http.method", r.Method)))
+			defer span.End()
+
+			rec := NewResponseRecorder(w)
+			r, pattern := recordRoutePattern(r.WithContext(ctx))
+			next.ServeHTTP(rec, r)
+
+			status := rec.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if *pattern != "" {
+				span.SetAttributes(attribute.String("http.route", *pattern))
+			}
+			span.SetAttributes(attribute.Int("http.status_code", status))
+			if status >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordingTracer is a trace.Tracer which keeps the spans it starts
+// in memory, so tests can check them once they have ended.
+type recordingTracer struct {
+	noop.Tracer
+
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	traceID := parent.TraceID()
+	if !traceID.IsValid() {
+		traceID = trace.TraceID{0xff, byte(len(t.spans) + 1)}
+	}
+	span := &recordedSpan{
+		name:   name,
+		kind:   config.SpanKind(),
+		parent: parent,
+		attrs:  config.Attributes(),
+		sc: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{0xff, byte(len(t.spans) + 1)},
+			TraceFlags: trace.FlagsSampled,
+		}),
+	}
+	t.spans = append(t.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type recordedSpan struct {
+	noop.Span
+
+	name   string
+	kind   trace.SpanKind
+	parent trace.SpanContext
+	sc     trace.SpanContext
+	attrs  []attribute.KeyValue
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordedSpan) SpanContext() trace.SpanContext { return s.sc }
+func (s *recordedSpan) IsRecording() bool              { return !s.ended }
+func (s *recordedSpan) End(...trace.SpanEndOption)     { s.ended = true }
+
+func (s *recordedSpan) SetAttributes(attrs ...attribute.KeyValue) {
+	s.attrs = append(s.attrs, attrs...)
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, description string) {
+	s.status = code
+}
+
+// attr returns the value of the named attribute,
+// or an invalid value if it is not set.
+func (s *recordedSpan) attr(key attribute.Key) attribute.Value {
+	for _, kv := range s.attrs {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestOTelMiddleware(t *testing.T) {
+	var inner trace.Span
+	site := NewSite("example.com", 80, nil)
+	site.Pattern(Handler(func(w http.ResponseWriter, r *http.Request) {
+		inner = trace.SpanFromContext(r.Context())
+	}), "/users/:id")
+	site.Equals(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}), "/fail")
+
+	tracer := new(recordingTracer)
+	h := OTelMiddleware(tracer)(site)
+
+	r := httptest.NewRequest("GET", "/users/42", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/fail", nil))
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != "HTTP GET" || span.kind != trace.SpanKindServer || !span.ended {
+		t.Errorf("got span %q of kind %v, ended %v", span.name, span.kind, span.ended)
+	}
+	if inner != trace.Span(span) {
+		t.Error("handler did not receive the request's span")
+	}
+	if got := span.sc.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got trace ID %s, want the one from traceparent", got)
+	}
+	if got := span.parent.SpanID().String(); !span.parent.IsRemote() || got != "00f067aa0ba902b7" {
+		t.Errorf("got parent span %s, remote %v, want remote 00f067aa0ba902b7", got, span.parent.IsRemote())
+	}
+	if got := span.attr("http.method").AsString(); got != "GET" {
+		t.Errorf("got http.method %q, want GET", got)
+	}
+	if got := span.attr("http.route").AsString(); got != "/users/:id" {
+		t.Errorf("got http.route %q, want /users/:id", got)
+	}
+	if got := span.attr("http.status_code").AsInt64(); got != http.StatusOK {
+		t.Errorf("got http.status_code %d, want 200", got)
+	}
+	if span.status != codes.Unset {
+		t.Errorf("got status %v for a successful request", span.status)
+	}
+
+	span = tracer.spans[1]
+	if span.name != "HTTP POST" || span.parent.IsValid() {
+		t.Errorf("got span %q with parent %v, want a new trace", span.name, span.parent)
+	}
+	if got := span.attr("http.route"); got.Type() != attribute.INVALID {
+		t.Errorf("got http.route %q for an unmatched pattern", got.Emit())
+	}
+	if got := span.attr("http.status_code").AsInt64(); got != http.StatusBadGateway {
+		t.Errorf("got http.status_code %d, want 502", got)
+	}
+	if span.status != codes.Error {
+		t.Errorf("got status %v, want Error", span.status)
+	}
+}
+
+func TestOTelSpanName(t *testing.T) {
+	tracer := new(recordingTracer)
+	h := OTelMiddleware(tracer, OTelSpanName(func(r *http.Request) string {
+		return r.Method + " " + r.URL.Path
+	}))(emptyHandler)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/a", nil))
+
+	if len(tracer.spans) != 1 || tracer.spans[0].name != "DELETE /a" {
+		t.Errorf("got spans %v, want one named DELETE /a", tracer.spans)
+	}
+}
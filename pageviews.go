@@ -0,0 +1,251 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNegativeViews is returned by PageViews.AddN
+// when asked to add a negative number of views.
+var ErrNegativeViews = errors.New("web: negative page view count")
+
+// PageViews is a simple structure
+// for recording page view counts
+// in a thread-safe manner. The
+// count is updated atomically, so
+// PageViews can be used on busy
+// paths without lock contention.
+// The zero value is ready to use.
+type PageViews struct {
+	count atomic.Int64
+}
+
+// Add increments the count.
+func (p *PageViews) Add() {
+	p.count.Add(1)
+}
+
+// AddN increases the count by n, such as when replaying
+// historical logs. If n is negative, the count is left
+// unchanged and ErrNegativeViews is returned.
+func (p *PageViews) AddN(n int64) error {
+	if n < 0 {
+		return ErrNegativeViews
+	}
+	p.count.Add(n)
+	return nil
+}
+
+// Count returns the number of page views.
+func (p *PageViews) Count() int64 {
+	return p.count.Load()
+}
+
+// Reset sets the count to zero, returning
+// the number of page views beforehand, such
+// as for periodic flushing to a database.
+// No page views are lost between the two.
+func (p *PageViews) Reset() int64 {
+	return p.count.Swap(0)
+}
+
+// Swap returns the number of page views
+// and resets the count to zero. It is
+// equivalent to Reset.
+func (p *PageViews) Swap() int64 {
+	return p.Reset()
+}
+
+// ServeHTTP sends the number of page views as a JSON
+// object, such as {"count":12345}, so PageViews can be
+// used as an http.Handler for debugging. Requests with
+// methods other than GET receive a 405 Method Not
+// Allowed response.
+//
+//	var views web.PageViews
+//	site.Equals(&views, "/metrics/views")
+func (p *PageViews) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	JSON(w, http.StatusOK, struct {
+		Count int64 `json:"count"`
+	}{p.Count()})
+}
+
+// PathPageViews records separate page
+// view counts for each request path
+// in a thread-safe manner. The zero
+// value is ready to use.
+//
+// To limit memory use, at most MaxPaths
+// paths are counted separately, or
+// DefaultMaxPaths if MaxPaths is zero.
+// Views of any further paths are counted
+// under OverflowPath.
+type PathPageViews struct {
+	MaxPaths int
+
+	mu    sync.RWMutex
+	views map[string]*PageViews
+}
+
+// PageViewsByPath is an alternative name for PathPageViews.
+type PageViewsByPath = PathPageViews
+
+// DefaultMaxPaths is the number of paths counted
+// separately by a PathPageViews whose MaxPaths
+// field is zero.
+const DefaultMaxPaths = 1000
+
+// OverflowPath is the path under which a PathPageViews
+// counts views once it has reached its MaxPaths.
+const OverflowPath = "other"
+
+// Add increments the count for the given path.
+func (p *PathPageViews) Add(path string) {
+	p.mu.RLock()
+	views, ok := p.views[path]
+	p.mu.RUnlock()
+
+	if !ok {
+		p.mu.Lock()
+		if p.views == nil {
+			p.views = make(map[string]*PageViews)
+		}
+		if views, ok = p.views[path]; !ok {
+			max := p.MaxPaths
+			if max == 0 {
+				max = DefaultMaxPaths
+			}
+			if len(p.views) >= max {
+				path = OverflowPath
+				views = p.views[OverflowPath]
+			}
+			if views == nil {
+				views = new(PageViews)
+				p.views[path] = views
+			}
+		}
+		p.mu.Unlock()
+	}
+
+	views.Add()
+}
+
+// Count returns the number of page views for the given path.
+func (p *PathPageViews) Count(path string) int64 {
+	p.mu.RLock()
+	views, ok := p.views[path]
+	p.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return views.Count()
+}
+
+// Snapshot returns the number of page views for each path.
+// The returned map is a copy, so is safe to modify.
+func (p *PathPageViews) Snapshot() map[string]int64 {
+	p.mu.RLock()
+	views := make(map[string]*PageViews, len(p.views))
+	for path, v := range p.views {
+		views[path] = v
+	}
+	p.mu.RUnlock()
+
+	out := make(map[string]int64, len(views))
+	for path, v := range views {
+		out[path] = v.Count()
+	}
+	return out
+}
+
+// PathViewMap records separate page view counts for each
+// request path, like PathPageViews, but without any locking,
+// so it suits sites with many paths under heavy concurrent
+// load. Every distinct path is counted separately, so paths
+// should come from a bounded set, such as the patterns
+// registered with a Site. The zero value is ready to use.
+//
+// PathViewMap implements prometheus.Collector, so it can be
+// registered with a Prometheus registry, exporting the counts
+// as the page_views_total counter, by path.
+//
+//	var views web.PathViewMap
+//	prometheus.MustRegister(&views)
+type PathViewMap struct {
+	views sync.Map // Path to *atomic.Int64.
+}
+
+// Inc increments the count for the given path.
+func (m *PathViewMap) Inc(path string) {
+	count, ok := m.views.Load(path)
+	if !ok {
+		count, _ = m.views.LoadOrStore(path, new(atomic.Int64))
+	}
+	count.(*atomic.Int64).Add(1)
+}
+
+// Get returns the number of page views for the given path.
+func (m *PathViewMap) Get(path string) int64 {
+	count, ok := m.views.Load(path)
+	if !ok {
+		return 0
+	}
+	return count.(*atomic.Int64).Load()
+}
+
+// Snapshot returns the number of page views for each path.
+// The returned map is a copy, so is safe to modify.
+func (m *PathViewMap) Snapshot() map[string]int64 {
+	out := make(map[string]int64)
+	m.views.Range(func(path, count interface{}) bool {
+		out[path.(string)] = count.(*atomic.Int64).Load()
+		return true
+	})
+	return out
+}
+
+// Reset removes the counts for all paths. Views
+// recorded while Reset is running may be lost.
+func (m *PathViewMap) Reset() {
+	m.views.Range(func(path, _ interface{}) bool {
+		m.views.Delete(path)
+		return true
+	})
+}
+
+// CountViews creates an http.Handler which passes each request to
+// next, then counts it in views. Requests routed by a Site with
+// Pattern or Method are counted under the matched pattern, so
+// requests to /users/123 and /users/456 are both counted under
+// /users/:id. Other requests are counted under their path. The
+// pattern is only known if next is the Site, or the handler is
+// added to the Site with Use.
+//
+//	var views web.PathPageViews
+//	site.Pattern(web.Handler(serveUser), "/users/:id")
+//	site.Use(func(next http.Handler) http.Handler {
+//		return web.CountViews(&views, next)
+//	})
+func CountViews(views *PathPageViews, next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		r, pattern := recordRoutePattern(r)
+		next.ServeHTTP(w, r)
+
+		path := *pattern
+		if path == "" {
+			path = MountPrefix(r) + r.URL.Path
+		}
+		views.Add(path)
+	})
+}
@@ -0,0 +1,369 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPageViewsReset(t *testing.T) {
+	var views PageViews
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				views.Add()
+			}
+		}()
+	}
+
+	// Flush concurrently, as if to a database.
+	var flushed int64
+	for i := 0; i < 10; i++ {
+		flushed += views.Reset()
+	}
+	wg.Wait()
+	flushed += views.Reset()
+
+	if flushed != 8000 {
+		t.Errorf("flushed %d page views, want 8000", flushed)
+	}
+	if n := views.Count(); n != 0 {
+		t.Errorf("got count %d after Reset, want 0", n)
+	}
+}
+
+func TestPageViewsSwap(t *testing.T) {
+	var views PageViews
+	views.Add()
+	views.Add()
+	if n := views.Swap(); n != 2 {
+		t.Errorf("got %d page views from Swap, want 2", n)
+	}
+	if n := views.Swap(); n != 0 {
+		t.Errorf("got %d page views from a second Swap, want 0", n)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				views.Add()
+			}
+		}()
+	}
+	var swapped int64
+	for i := 0; i < 10; i++ {
+		swapped += views.Swap()
+	}
+	wg.Wait()
+	swapped += views.Swap()
+
+	if swapped != 8000 || views.Count() != 0 {
+		t.Errorf("swapped %d page views, leaving %d, want 8000 and 0", swapped, views.Count())
+	}
+}
+
+// mutexPageViews is the previous, mutex-based
+// implementation of PageViews, for comparison.
+type mutexPageViews struct {
+	sync.Mutex
+	count int64
+}
+
+func (p *mutexPageViews) Add() {
+	p.Lock()
+	p.count++
+	p.Unlock()
+}
+
+func BenchmarkPageViewsAdd(b *testing.B) {
+	var views PageViews
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			views.Add()
+		}
+	})
+}
+
+func BenchmarkPageViewsAddMutex(b *testing.B) {
+	var views mutexPageViews
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			views.Add()
+		}
+	})
+}
+
+func TestCountViews(t *testing.T) {
+	var views PageViewsByPath
+	site := NewSite("example.com", 80, nil)
+	site.Pattern(Handler(func(w http.ResponseWriter, r *http.Request) {}), "/users/:id")
+	site.Equals(Handler(func(w http.ResponseWriter, r *http.Request) {}), "/about")
+	h := CountViews(&views, site)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/"+strconv.Itoa(i*100+j), nil))
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/about", nil))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	want := map[string]int64{"/users/:id": 800, "/about": 800}
+	if got := views.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+}
+
+func TestPathPageViewsMaxPaths(t *testing.T) {
+	views := PathPageViews{MaxPaths: 2}
+	for _, path := range []string{"/a", "/b", "/c", "/a", "/d", "/c"} {
+		views.Add(path)
+	}
+
+	want := map[string]int64{"/a": 2, "/b": 1, OverflowPath: 3}
+	if got := views.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+	if n := views.Count("/c"); n != 0 {
+		t.Errorf("got count %d for an overflowed path, want 0", n)
+	}
+}
+
+func TestPathViewMap(t *testing.T) {
+	var views PathViewMap
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				views.Inc("/")
+				views.Inc("/about")
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := map[string]int64{"/": 800, "/about": 800}
+	if got := views.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+	if n := views.Get("/about"); n != 800 {
+		t.Errorf("got count %d, want 800", n)
+	}
+
+	views.Reset()
+	if got := views.Snapshot(); len(got) != 0 {
+		t.Errorf("got counts %v after Reset, want none", got)
+	}
+	if n := views.Get("/"); n != 0 {
+		t.Errorf("got count %d after Reset, want 0", n)
+	}
+}
+
+func TestPageViewsSaveLoad(t *testing.T) {
+	var views PageViews
+	views.AddN(42)
+	var buf bytes.Buffer
+	if err := views.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.String()
+
+	var loaded PageViews
+	if err := loaded.Load(strings.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if n := loaded.Count(); n != 42 {
+		t.Errorf("got count %d after Load, want 42", n)
+	}
+
+	// Partial and corrupt files leave the count untouched.
+	for _, bad := range []string{
+		saved[:len(saved)/2],
+		"",
+		`{"version":99,"count":1}`,
+		`{"version":1,"count":-1}`,
+		`{"version":1,"paths":{"/":1}}`,
+		"\x00\xff",
+	} {
+		if err := loaded.Load(strings.NewReader(bad)); !errors.Is(err, ErrBadViews) {
+			t.Errorf("Load(%q) returned %v, want ErrBadViews", bad, err)
+		}
+		if n := loaded.Count(); n != 42 {
+			t.Errorf("got count %d after Load(%q), want 42", n, bad)
+		}
+	}
+}
+
+func TestPathPageViewsSaveLoad(t *testing.T) {
+	var views PathPageViews
+	views.Add("/")
+	views.Add("/")
+	views.Add("/about")
+	var buf bytes.Buffer
+	if err := views.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.String()
+
+	var loaded PathPageViews
+	if err := loaded.Load(strings.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"/": 2, "/about": 1}
+	if got := loaded.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got counts %v after Load, want %v", got, want)
+	}
+
+	if err := loaded.Load(strings.NewReader(saved[:len(saved)-5])); !errors.Is(err, ErrBadViews) {
+		t.Errorf("Load of a partial file returned %v, want ErrBadViews", err)
+	}
+	if got := loaded.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got counts %v after a failed Load, want %v", got, want)
+	}
+}
+
+func TestPersistEvery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "views.json")
+	if err := os.WriteFile(path, []byte(`{"version":1,"count":10}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var views PageViews
+	stop := views.PersistEvery(path, 10*time.Millisecond)
+	if n := views.Count(); n != 10 {
+		t.Errorf("got count %d after loading, want 10", n)
+	}
+
+	// Check the file is always complete while it is being replaced.
+	for i := 0; i < 20; i++ {
+		views.Add()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := new(PageViews).Load(bytes.NewReader(data)); err != nil {
+			t.Fatalf("read incomplete file %q: %v", data, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	stop()
+	stop()
+
+	var loaded PageViews
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := loaded.Load(f); err != nil {
+		t.Fatal(err)
+	}
+	if n := loaded.Count(); n != 30 {
+		t.Errorf("got saved count %d, want 30", n)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files after saving, want 1: %v", len(entries), entries)
+	}
+}
+
+func TestPathPageViews(t *testing.T) {
+	var views PathPageViews
+	if n := views.Count("/"); n != 0 {
+		t.Errorf("got count %d before any views, want 0", n)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				views.Add("/")
+				views.Add("/about")
+			}
+		}()
+	}
+	wg.Wait()
+	views.Add("/about")
+
+	want := map[string]int64{"/": 800, "/about": 801}
+	snapshot := views.Snapshot()
+	if !reflect.DeepEqual(snapshot, want) {
+		t.Errorf("got counts %v, want %v", snapshot, want)
+	}
+	if n := views.Count("/about"); n != 801 {
+		t.Errorf("got count %d, want 801", n)
+	}
+
+	// The snapshot is a copy.
+	snapshot["/"] = 0
+	if n := views.Count("/"); n != 800 {
+		t.Errorf("got count %d after changing the snapshot, want 800", n)
+	}
+}
+
+func TestPageViewsAddN(t *testing.T) {
+	var views PageViews
+	views.Add()
+	if err := views.AddN(41); err != nil {
+		t.Fatal(err)
+	}
+	if err := views.AddN(-1); err != ErrNegativeViews {
+		t.Errorf("AddN(-1) returned %v, want ErrNegativeViews", err)
+	}
+	if n := views.Count(); n != 42 {
+		t.Errorf("got count %d, want 42", n)
+	}
+}
+
+func TestPageViewsServeHTTP(t *testing.T) {
+	var views PageViews
+	views.AddN(12345)
+
+	w := httptest.NewRecorder()
+	views.ServeHTTP(w, httptest.NewRequest("GET", "/metrics/views", nil))
+	if got, want := w.Body.String(), `{"count":12345}`+"\n"; w.Code != http.StatusOK || got != want {
+		t.Errorf("got %d %q, want 200 %q", w.Code, got, want)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	views.ServeHTTP(w, httptest.NewRequest("POST", "/metrics/views", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" {
+		t.Errorf("POST: got %d with Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Param returns the value of the named path parameter captured
+// when routing the request, or the empty string if there is no
+// such parameter.
+//
+//	site.Pattern(web.Handler(serveUser), "/users/:id")
+//
+//	func serveUser(w http.ResponseWriter, r *http.Request) {
+//		id := web.Param(r, "id")
+//		// ...
+//	}
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).([]param)
+	for i := len(params) - 1; i >= 0; i-- {
+		if params[i].name == name {
+			return params[i].value
+		}
+	}
+	return ""
+}
+
+// paramsKey is the context key for a request's path parameters.
+type paramsKey struct{}
+
+type param struct {
+	name  string
+	value string
+}
+
+// withParams creates an http.Handler which adds the given
+// path parameters to the request before calling handler.
+func withParams(handler http.Handler, names, values []string) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		outer, _ := ctx.Value(paramsKey{}).([]param)
+		params := make([]param, len(outer), len(outer)+len(names))
+		copy(params, outer)
+		for i, name := range names {
+			params = append(params, param{name, values[i]})
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(ctx, paramsKey{}, params)))
+	})
+}
+
+// regexRoute matches request paths against a regular
+// expression, capturing any named groups as parameters.
+type regexRoute struct {
+	regex   *regexp.Regexp
+	handler http.Handler
+}
+
+func (m *regexRoute) route(path, _ string) http.Handler {
+	match := m.regex.FindStringSubmatch(path)
+	if match == nil {
+		return nil
+	}
+
+	var names, values []string
+	for i, name := range m.regex.SubexpNames() {
+		if name != "" {
+			names = append(names, name)
+			values = append(values, match[i])
+		}
+	}
+	if len(names) == 0 {
+		return m.handler
+	}
+	return withParams(m.handler, names, values)
+}
+
+// patternRouter matches request paths against patterns
+// like "/users/:id/posts/:post" or "/files/*path", using
+// a trie of path segments.
+type patternRouter struct {
+	root patternNode
+}
+
+type patternNode struct {
+	static map[string]*patternNode
+	param  *patternNode
+	end    *patternRoute // Pattern ending at this node.
+	rest   *patternRoute // Pattern with a wildcard at this node.
+}
+
+type patternRoute struct {
+	pattern string
+	names   []string
+	handler http.Handler
+}
+
+// add registers the pattern, panicking if it is invalid or
+// has already been registered. If fold is true, the pattern's
+// static segments are converted to lower case.
+func (p *patternRouter) add(pattern string, handler http.Handler, fold bool) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic(fmt.Sprintf("web: pattern %q does not begin with a slash", pattern))
+	}
+
+	segments := strings.Split(pattern[1:], "/")
+	route := &patternRoute{pattern: pattern, handler: handler}
+	node := &p.root
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, "*"):
+			if i != len(segments)-1 {
+				panic(fmt.Sprintf("web: wildcard in pattern %q is not the last segment", pattern))
+			}
+			if node.rest != nil {
+				panic(fmt.Sprintf("web: pattern %q conflicts with %q", pattern, node.rest.pattern))
+			}
+			route.names = append(route.names, segment[1:])
+			node.rest = route
+			return
+
+		case strings.HasPrefix(segment, ":"):
+			if len(segment) == 1 {
+				panic(fmt.Sprintf("web: unnamed parameter in pattern %q", pattern))
+			}
+			route.names = append(route.names, segment[1:])
+			if node.param == nil {
+				node.param = new(patternNode)
+			}
+			node = node.param
+
+		default:
+			if fold {
+				segment = strings.ToLower(segment)
+			}
+			if node.static == nil {
+				node.static = make(map[string]*patternNode)
+			}
+			next, ok := node.static[segment]
+			if !ok {
+				next = new(patternNode)
+				node.static[segment] = next
+			}
+			node = next
+		}
+	}
+
+	if node.end != nil {
+		panic(fmt.Sprintf("web: pattern %q conflicts with %q", pattern, node.end.pattern))
+	}
+	node.end = route
+}
+
+// route matches the escaped path, which is split into segments
+// before they are decoded, so an encoded slash (%2F) is part of
+// a segment, rather than separating segments.
+func (p *patternRouter) route(_, escaped string) http.Handler {
+	if !strings.HasPrefix(escaped, "/") {
+		return nil
+	}
+	segments := strings.Split(escaped[1:], "/")
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil
+		}
+		segments[i] = decoded
+	}
+	route, values := p.root.match(segments, nil)
+	if route == nil {
+		return nil
+	}
+	return &patternMatch{route, values}
+}
+
+// patternMatch serves a request whose path matched
+// a pattern, recording the pattern and parameters.
+type patternMatch struct {
+	route  *patternRoute
+	values []string
+}
+
+func (m *patternMatch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if pattern, ok := r.Context().Value(routePatternKey{}).(*string); ok {
+		*pattern = MountPrefix(r) + m.route.pattern
+	}
+	if len(m.route.names) == 0 {
+		m.route.handler.ServeHTTP(w, r)
+		return
+	}
+	withParams(m.route.handler, m.route.names, m.values).ServeHTTP(w, r)
+}
+
+// routePatternKey is the context key for a string which
+// records the pattern matched when routing a request.
+type routePatternKey struct{}
+
+// recordRoutePattern returns a copy of the request which
+// records the pattern it matches when routed by a Site, as
+// registered with Pattern or Method, and a pointer to the
+// pattern, which is empty until the request is routed. If
+// the request already records its pattern, it is returned
+// unchanged.
+func recordRoutePattern(r *http.Request) (*http.Request, *string) {
+	if pattern, ok := r.Context().Value(routePatternKey{}).(*string); ok {
+		return r, pattern
+	}
+	pattern := new(string)
+	return r.WithContext(context.WithValue(r.Context(), routePatternKey{}, pattern)), pattern
+}
+
+// match finds the route for the remaining path segments. Static
+// segments are preferred over parameters, which are preferred
+// over wildcards.
+func (n *patternNode) match(segments, values []string) (*patternRoute, []string) {
+	if len(segments) == 0 {
+		return n.end, values
+	}
+
+	segment := segments[0]
+	if next, ok := n.static[segment]; ok {
+		if route, vals := next.match(segments[1:], values); route != nil {
+			return route, vals
+		}
+	}
+	if n.param != nil && segment != "" {
+		if route, vals := n.param.match(segments[1:], append(values, segment)); route != nil {
+			return route, vals
+		}
+	}
+	if n.rest != nil {
+		return n.rest, append(values, strings.Join(segments, "/"))
+	}
+	return nil, nil
+}
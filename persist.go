@@ -0,0 +1,199 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// viewsVersion is the version of the encoding
+// used to save page view counts.
+const viewsVersion = 1
+
+// ErrBadViews is returned when loading page view
+// counts which are not in the expected format.
+var ErrBadViews = errors.New("web: invalid saved page views")
+
+// Save writes the number of page views to w, as a versioned
+// JSON document, which can be read with Load.
+func (p *PageViews) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(savedViews{Version: viewsVersion, Count: p.Count()})
+}
+
+// Load replaces the number of page views with the count read
+// from r, as written by Save. If r cannot be read, or does not
+// contain a valid count, the count is left unchanged and an
+// error is returned.
+func (p *PageViews) Load(r io.Reader) error {
+	var saved savedViews
+	if err := loadViews(r, &saved); err != nil {
+		return err
+	}
+	if saved.Count < 0 || saved.Paths != nil {
+		return ErrBadViews
+	}
+	p.count.Store(saved.Count)
+	return nil
+}
+
+// PersistEvery keeps page view counts across restarts, by saving them
+// to the file at path. If the file exists, the counts are first loaded
+// from it. The counts are then saved at the given interval, and again
+// when the returned stop function is called, which should be done when
+// the server shuts down. The file is replaced atomically, by writing
+// a temporary file in the same directory, then renaming it, so it is
+// never left partially written. Errors are logged.
+//
+//	var views web.PageViews
+//	stop := views.PersistEvery("/var/lib/app/views.json", time.Minute)
+//	defer stop()
+func (p *PageViews) PersistEvery(path string, interval time.Duration) (stop func()) {
+	return persistEvery(p, path, interval)
+}
+
+// Save writes the number of page views for each path to w,
+// as a versioned JSON document, which can be read with Load.
+func (p *PathPageViews) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(savedViews{Version: viewsVersion, Paths: p.Snapshot()})
+}
+
+// Load replaces the page view counts with those read from r,
+// as written by Save. If r cannot be read, or does not contain
+// valid counts, the counts are left unchanged and an error is
+// returned. Load is intended for use at startup, as views of
+// the previous counts recorded while Load is running may be
+// lost.
+func (p *PathPageViews) Load(r io.Reader) error {
+	var saved savedViews
+	if err := loadViews(r, &saved); err != nil {
+		return err
+	}
+	if saved.Count != 0 {
+		return ErrBadViews
+	}
+	views := make(map[string]*PageViews, len(saved.Paths))
+	for path, count := range saved.Paths {
+		if count < 0 {
+			return ErrBadViews
+		}
+		views[path] = new(PageViews)
+		views[path].count.Store(count)
+	}
+
+	p.mu.Lock()
+	p.views = views
+	p.mu.Unlock()
+	return nil
+}
+
+// PersistEvery keeps the page view counts across restarts,
+// as described in PageViews.PersistEvery.
+func (p *PathPageViews) PersistEvery(path string, interval time.Duration) (stop func()) {
+	return persistEvery(p, path, interval)
+}
+
+// persistEvery implements PersistEvery for both counters.
+func persistEvery(v viewsSaver, path string, interval time.Duration) (stop func()) {
+	if f, err := os.Open(path); err == nil {
+		err = v.Load(f)
+		f.Close()
+		if err != nil {
+			log.Printf("web: failed to load page views from %s: %v", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("web: failed to load page views: %v", err)
+	}
+
+	save := func() {
+		if err := saveViewsFile(v, path); err != nil {
+			log.Printf("web: failed to save page views: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				save()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+			save()
+		})
+	}
+}
+
+// viewsSaver is implemented by the page view counters.
+type viewsSaver interface {
+	Save(w io.Writer) error
+	Load(r io.Reader) error
+}
+
+// savedViews is the JSON encoding of saved page view counts.
+type savedViews struct {
+	Version int              `json:"version"`
+	Count   int64            `json:"count,omitempty"`
+	Paths   map[string]int64 `json:"paths,omitempty"`
+}
+
+// loadViews decodes saved page view counts from r.
+func loadViews(r io.Reader, saved *savedViews) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(saved); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadViews, err)
+	}
+	if saved.Version != viewsVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadViews, saved.Version)
+	}
+	return nil
+}
+
+// saveViewsFile atomically replaces the file at path
+// with the saved page view counts.
+func saveViewsFile(v viewsSaver, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	err = v.Save(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
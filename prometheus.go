@@ -0,0 +1,105 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// PrometheusMiddleware creates Middleware which records metrics for
+// each request with the given Prometheus registerer. If reg is nil,
+// prometheus.DefaultRegisterer is used. The metrics are:
+//
+//	http_requests_total            counter, by method, path, and code
+//	http_request_duration_seconds  histogram, by method and path
+//	http_response_size_bytes_total counter, by method and path
+//
+// The path label is the pattern matched by the request, as registered
+// with a Site's Pattern or Method, such as "/users/:id", so that it does
+// not grow with the number of distinct request paths. Requests routed
+// by other means use the path "other". Unusual request methods use the
+// method "OTHER".
+//
+// The response is passed to the client as it is written, rather than
+// buffered. If the metrics have already been registered with reg, as
+// when PrometheusMiddleware is called more than once, the existing
+// metrics are used. PrometheusMiddleware panics if reg returns any
+// other error.
+//
+//	site.Use(web.PrometheusMiddleware(nil))
+func PrometheusMiddleware(reg prometheus.Registerer) Middleware {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	requests := registerCollector(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests.",
+	}, []string{"method", "path", "code"})).(*prometheus.CounterVec)
+	durations := registerCollector(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})).(*prometheus.HistogramVec)
+	written := registerCollector(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_response_size_bytes_total",
+		Help: "Total number of bytes written in HTTP responses.",
+	}, []string{"method", "path"})).(*prometheus.CounterVec)
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := NewResponseRecorder(w)
+			r, pattern := recordRoutePattern(r)
+			next.ServeHTTP(rec, r)
+
+			method := metricMethod(r.Method)
+			path := *pattern
+			if path == "" {
+				path = "other"
+			}
+			status := rec.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			requests.WithLabelValues(method, path, strconv.Itoa(status)).Inc()
+			durations.WithLabelValues(method, path).Observe(time.Since(start).Seconds())
+			written.WithLabelValues(method, path).Add(float64(rec.Written()))
+		})
+	}
+}
+
+// pathViewsDesc describes the metric exported by PathViewMap.
+var pathViewsDesc = prometheus.NewDesc("page_views_total", "Total number of page views.", []string{"path"}, nil)
+
+// Describe implements prometheus.Collector.
+func (m *PathViewMap) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pathViewsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (m *PathViewMap) Collect(ch chan<- prometheus.Metric) {
+	for path, count := range m.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(pathViewsDesc, prometheus.CounterValue, float64(count), path)
+	}
+}
+
+// registerCollector registers c with reg, returning the existing
+// collector instead if an equivalent one is already registered.
+func registerCollector(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if err := reg.Register(c); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) && reflect.TypeOf(registered.ExistingCollector) == reflect.TypeOf(c) {
+			return registered.ExistingCollector
+		}
+		panic("web: failed to register metrics: " + err.Error())
+	}
+	return c
+}
@@ -0,0 +1,69 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMiddleware(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Pattern(Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "user")
+	}), "/users/:id")
+	site.Equals(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}), "/fail")
+
+	reg := prometheus.NewRegistry()
+	h := PrometheusMiddleware(reg)(site)
+	for _, target := range []string{"/users/1", "/users/2", "/fail", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/users/3", nil))
+
+	const want = `
+# HELP http_requests_total Total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200",method="GET",path="/users/:id"} 2
+http_requests_total{code="200",method="OTHER",path="/users/:id"} 1
+http_requests_total{code="404",method="GET",path="other"} 1
+http_requests_total{code="502",method="GET",path="other"} 1
+# HELP http_response_size_bytes_total Total number of bytes written in HTTP responses.
+# TYPE http_response_size_bytes_total counter
+http_response_size_bytes_total{method="GET",path="/users/:id"} 8
+http_response_size_bytes_total{method="GET",path="other"} 19
+http_response_size_bytes_total{method="OTHER",path="/users/:id"} 4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "http_requests_total", "http_response_size_bytes_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(reg, "http_request_duration_seconds"); n != 3 {
+		t.Errorf("got %d duration series, want 3", n)
+	}
+}
+
+func TestPrometheusMiddlewareRegisteredTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first := PrometheusMiddleware(reg)(emptyHandler)
+	second := PrometheusMiddleware(reg)(emptyHandler)
+	first.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	second.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	const want = `
+# HELP http_requests_total Total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200",method="GET",path="other"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "http_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
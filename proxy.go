@@ -5,9 +5,15 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"strings"
+	"syscall"
+	"time"
 )
 
 // ReverseProxy is used to serve multiple domains simultaneously
@@ -66,3 +72,141 @@ func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		p.NotFound(w, r)
 	}
 }
+
+// ProxyOptions controls how Proxy forwards requests.
+type ProxyOptions struct {
+	// StripPrefix is removed from the start of the request
+	// path before it is forwarded, such as "/api" to forward
+	// requests for "/api/users" to "/users".
+	StripPrefix string
+
+	// Timeout limits the time taken to forward each request and
+	// read the response, including its body. Upgraded connections,
+	// such as WebSockets, are not limited. If zero, there is no
+	// limit.
+	Timeout time.Duration
+
+	// RetryRefused enables retrying requests once if the backend
+	// refuses the connection, such as while it restarts. Only
+	// requests with idempotent methods and no body are retried.
+	RetryRefused bool
+
+	// Transport is used to make requests to the backend. If
+	// nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+}
+
+// Proxy creates an http.Handler which forwards requests to the backend
+// at target, such as "http://127.0.0.1:9000", using httputil.ReverseProxy.
+// The target's path, if any, is prepended to the request path. The
+// X-Forwarded-For, X-Forwarded-Host, and X-Forwarded-Proto headers are
+// set to the client's address, and the host and scheme it used, and the
+// Host header is set to the target's host. Upgrade requests, such as
+// for WebSockets, are passed through to the backend.
+//
+// If the backend cannot be reached, the error is sent with Fail, as a
+// 502 Bad Gateway response, or 504 Gateway Timeout if opts.Timeout is
+// exceeded, so the Site's error page is shown. Proxy panics if target
+// is not an absolute URL.
+//
+//	site.HasPrefix(web.Proxy("http://127.0.0.1:9000", web.ProxyOptions{
+//		StripPrefix: "/api",
+//		Timeout:     30 * time.Second,
+//	}), "/api/")
+func Proxy(target string, opts ProxyOptions) http.Handler {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		panic("web: invalid proxy target " + target)
+	}
+
+	transport := opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if opts.RetryRefused {
+		transport = retryRefusedTransport{transport}
+	}
+
+	base := httputil.NewSingleHostReverseProxy(u)
+	proxy := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			proto := "http"
+			if out.TLS != nil {
+				proto = "https"
+			}
+			out.Header.Set("X-Forwarded-Host", out.Host)
+			out.Header.Set("X-Forwarded-Proto", proto)
+			stripProxyPrefix(out.URL, opts.StripPrefix)
+			base.Director(out)
+			out.Host = u.Host
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			code := http.StatusBadGateway
+			if errors.Is(err, context.DeadlineExceeded) {
+				code = http.StatusGatewayTimeout
+			}
+			Fail(w, r, &Error{Code: code, Err: err})
+		},
+	}
+
+	if opts.Timeout <= 0 {
+		return proxy
+	}
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			ctx, cancel := context.WithTimeout(r.Context(), opts.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// stripProxyPrefix removes the prefix from the URL's path.
+func stripProxyPrefix(u *url.URL, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" || !strings.HasPrefix(u.Path, prefix) {
+		return
+	}
+	rest := u.Path[len(prefix):]
+	if rest != "" && rest[0] != '/' {
+		return
+	}
+	if !strings.HasPrefix(rest, "/") {
+		rest = "/" + rest
+	}
+	u.Path = rest
+	if raw := strings.TrimPrefix(u.RawPath, prefix); raw != u.RawPath {
+		u.RawPath = "/" + strings.TrimPrefix(raw, "/")
+	} else {
+		u.RawPath = ""
+	}
+}
+
+// retryRefusedTransport retries requests once
+// if the connection is refused.
+type retryRefusedTransport struct {
+	http.RoundTripper
+}
+
+func (t retryRefusedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(r)
+	if err == nil || !errors.Is(err, syscall.ECONNREFUSED) || !idempotent(r.Method) {
+		return resp, err
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		return resp, err
+	}
+	return t.RoundTripper.RoundTrip(r)
+}
+
+// idempotent reports whether requests with the
+// method can be safely repeated.
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// echoBackend sends the details of each request it
+// receives as JSON.
+func echoBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewServer(Handler(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"path":   r.URL.Path,
+			"query":  r.URL.RawQuery,
+			"host":   r.Host,
+			"for":    r.Header.Get("X-Forwarded-For"),
+			"fhost":  r.Header.Get("X-Forwarded-Host"),
+			"fproto": r.Header.Get("X-Forwarded-Proto"),
+		})
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func proxied(t *testing.T, h http.Handler, r *http.Request) map[string]string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", w.Code, w.Body.String())
+	}
+	var got map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestProxyHeaders(t *testing.T) {
+	backend := echoBackend(t)
+	h := Proxy(backend.URL, ProxyOptions{})
+
+	r := httptest.NewRequest("GET", "https://example.com/users?page=2", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	got := proxied(t, h, r)
+
+	want := map[string]string{
+		"path":   "/users",
+		"query":  "page=2",
+		"host":   strings.TrimPrefix(backend.URL, "http://"),
+		"for":    "203.0.113.7",
+		"fhost":  "example.com",
+		"fproto": "https",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("got %s %q, want %q", key, got[key], value)
+		}
+	}
+
+	// Existing X-Forwarded-For headers are appended to.
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := proxied(t, h, r); got["for"] != "198.51.100.1, 203.0.113.7" || got["fproto"] != "http" {
+		t.Errorf("got X-Forwarded-For %q and X-Forwarded-Proto %q", got["for"], got["fproto"])
+	}
+}
+
+func TestProxyStripPrefix(t *testing.T) {
+	backend := echoBackend(t)
+
+	tests := []struct {
+		target, prefix, path, want string
+	}{
+		{backend.URL, "/api", "/api/users", "/users"},
+		{backend.URL, "/api/", "/api/users", "/users"},
+		{backend.URL, "/api", "/api", "/"},
+		{backend.URL, "/api", "/apiary", "/apiary"},
+		{backend.URL, "/api", "/other", "/other"},
+		{backend.URL + "/v1", "/api", "/api/users", "/v1/users"},
+	}
+	for _, test := range tests {
+		h := Proxy(test.target, ProxyOptions{StripPrefix: test.prefix})
+		got := proxied(t, h, httptest.NewRequest("GET", test.path, nil))
+		if got["path"] != test.want {
+			t.Errorf("%s with prefix %q: backend got %q, want %q", test.path, test.prefix, got["path"], test.want)
+		}
+	}
+}
+
+func TestProxyBackendDown(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+
+	h := Proxy(backend.URL, ProxyOptions{})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway || w.Body.String() != `{"error":"Bad Gateway"}`+"\n" {
+		t.Errorf("got %d %q, want 502 Bad Gateway", w.Code, w.Body.String())
+	}
+}
+
+func TestProxyTimeout(t *testing.T) {
+	backend := httptest.NewServer(Handler(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+
+	h := Proxy(backend.URL, ProxyOptions{Timeout: 10 * time.Millisecond})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("got %d, want 504", w.Code)
+	}
+}
+
+// refusingTransport refuses the first refusals
+// connections, then uses http.DefaultTransport.
+type refusingTransport struct {
+	refusals int
+	calls    int
+}
+
+func (t *refusingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.calls++
+	if t.calls <= t.refusals {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestProxyRetryRefused(t *testing.T) {
+	backend := echoBackend(t)
+
+	tests := []struct {
+		method    string
+		body      string
+		refusals  int
+		wantCalls int
+		wantCode  int
+	}{
+		{"GET", "", 1, 2, http.StatusOK},
+		{"DELETE", "", 1, 2, http.StatusOK},
+		{"GET", "", 2, 2, http.StatusBadGateway},
+		{"POST", "", 1, 1, http.StatusBadGateway},
+		{"PUT", "data", 1, 1, http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		transport := &refusingTransport{refusals: test.refusals}
+		h := Proxy(backend.URL, ProxyOptions{RetryRefused: true, Transport: transport})
+
+		var body io.Reader
+		if test.body != "" {
+			body = strings.NewReader(test.body)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, "/", body))
+		if w.Code != test.wantCode || transport.calls != test.wantCalls {
+			t.Errorf("%s with %d refusals: got %d after %d calls, want %d after %d", test.method, test.refusals, w.Code, transport.calls, test.wantCode, test.wantCalls)
+		}
+	}
+}
+
+func TestProxyUpgrade(t *testing.T) {
+	// The backend switches to an echo protocol.
+	backend := httptest.NewServer(Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		fmt.Fprint(rw, line)
+		rw.Flush()
+	}))
+	defer backend.Close()
+
+	front := httptest.NewServer(Proxy(backend.URL, ProxyOptions{StripPrefix: "/ws", Timeout: time.Second}))
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /ws/echo HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want 101", resp.StatusCode)
+	}
+
+	fmt.Fprint(conn, "hello\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := br.ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("got %q, %v, want %q", line, err, "hello\n")
+	}
+}
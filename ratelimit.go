@@ -0,0 +1,311 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate at which each client can make requests,
+// using a sliding window. Clients which exceed the limit receive a
+// 429 Too Many Requests response, with a Retry-After header.
+//
+// By default, clients are identified by their remote IP address.
+// Use WithKeyFunc to identify them differently, such as by API token.
+//
+// RateLimiter must be created with NewRateLimiter.
+type RateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	keyFunc   func(*http.Request) string
+	clients   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+// rateWindow records the requests made by a
+// client in the current and previous windows.
+type rateWindow struct {
+	start    time.Time
+	current  int
+	previous int
+}
+
+// NewRateLimiter creates a RateLimiter which allows each client rps
+// requests per second on average, and up to burst requests at once.
+// If burst is less than one, rps is used instead. NewRateLimiter
+// panics if rps is less than one.
+//
+//	limiter := web.NewRateLimiter(10, 20)
+//	site.HasPrefix(limiter.Wrap(apiHandler), "/api/")
+func NewRateLimiter(rps int, burst int) *RateLimiter {
+	if rps < 1 {
+		panic("web: rate limit must be positive")
+	}
+	if burst < 1 {
+		burst = rps
+	}
+	return &RateLimiter{
+		limit:   burst,
+		window:  time.Duration(burst) * time.Second / time.Duration(rps),
+		keyFunc: remoteIP,
+		clients: make(map[string]*rateWindow),
+	}
+}
+
+// WithKeyFunc sets the function used to identify clients.
+// WithKeyFunc returns the limiter, so it can be chained with
+// NewRateLimiter.
+//
+//	limiter := web.NewRateLimiter(10, 20).WithKeyFunc(func(r *http.Request) string {
+//		return r.Header.Get("X-API-Token")
+//	})
+func (l *RateLimiter) WithKeyFunc(keyFunc func(*http.Request) string) *RateLimiter {
+	l.mu.Lock()
+	l.keyFunc = keyFunc
+	l.mu.Unlock()
+	return l
+}
+
+// Wrap returns an http.Handler which applies the rate limit
+// before calling next.
+func (l *RateLimiter) Wrap(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if wait, ok := l.allow(r, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow records a request, reporting whether it is within the limit.
+// If not, allow also returns how long the client should wait before
+// trying again.
+func (l *RateLimiter) allow(r *http.Request, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	key := l.keyFunc(r)
+	client, ok := l.clients[key]
+	if !ok {
+		client = &rateWindow{start: now}
+		l.clients[key] = client
+	}
+
+	// Move the window forward if necessary.
+	if elapsed := now.Sub(client.start); elapsed >= l.window {
+		if elapsed < 2*l.window {
+			client.previous = client.current
+		} else {
+			client.previous = 0
+		}
+		client.current = 0
+		client.start = client.start.Add(elapsed / l.window * l.window)
+	}
+
+	// Estimate the number of requests in the last window, assuming
+	// the previous window's requests were evenly distributed.
+	remaining := l.window - now.Sub(client.start)
+	estimate := float64(client.previous)*float64(remaining)/float64(l.window) + float64(client.current)
+	if estimate >= float64(l.limit) {
+		return remaining, false
+	}
+
+	client.current++
+	return 0, true
+}
+
+// sweep removes clients which have not made a request
+// recently enough to affect the limit.
+func (l *RateLimiter) sweep(now time.Time) {
+	interval := 2 * l.window
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	if now.Sub(l.lastSweep) < interval {
+		return
+	}
+
+	l.lastSweep = now
+	for key, client := range l.clients {
+		if now.Sub(client.start) >= 2*l.window {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Clock tells the time. It allows time-dependent types such as
+// TokenBucket to be tested without waiting.
+type Clock interface {
+	Now() time.Time
+}
+
+// RateLimit creates Middleware which limits the rate at which each
+// client can make requests, using a TokenBucket. If keyFunc is nil,
+// clients are identified by their remote IP address.
+//
+//	site.HasPrefix(web.RateLimit(5, 10, nil)(apiHandler), "/api/")
+func RateLimit(limit float64, burst int, keyFunc func(*http.Request) string) Middleware {
+	return NewTokenBucket(limit, burst, keyFunc).Wrap
+}
+
+// TokenBucket limits the rate at which each client can make requests,
+// using a token bucket. Each client's bucket holds up to burst tokens,
+// which refill continuously at the given rate, and each request uses
+// a token. Clients with no tokens left receive a 429 Too Many Requests
+// response, with a Retry-After header. All responses include the
+// X-RateLimit-Limit and X-RateLimit-Remaining headers.
+//
+// The buckets of clients which have not made requests recently are
+// discarded, so memory use is limited to recently active clients.
+//
+// TokenBucket must be created with NewTokenBucket. Its fields must be
+// set before it is used.
+type TokenBucket struct {
+	// Clock is used to tell the time. If nil,
+	// the system clock is used.
+	Clock Clock
+
+	// TrustedProxies is used to identify clients behind reverse
+	// proxies, as described in RealIPMiddleware, when no keyFunc
+	// was given. If empty, the remote IP address is always used.
+	TrustedProxies []net.IPNet
+
+	mu        sync.Mutex
+	rate      float64
+	burst     int
+	keyFunc   func(*http.Request) string
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket records a client's tokens
+// when it last made a request.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a TokenBucket which allows each client limit
+// requests per second on average, and up to burst requests at once.
+// If keyFunc is nil, clients are identified by their IP address.
+// NewTokenBucket panics if limit is not positive or burst is less
+// than one.
+//
+//	limiter := web.NewTokenBucket(5, 10, nil)
+//	limiter.TrustedProxies = proxies
+//	site.HasPrefix(limiter.Wrap(apiHandler), "/api/")
+func NewTokenBucket(limit float64, burst int, keyFunc func(*http.Request) string) *TokenBucket {
+	if !(limit > 0) {
+		panic("web: rate limit must be positive")
+	}
+	if burst < 1 {
+		panic("web: rate limit burst must be positive")
+	}
+	return &TokenBucket{
+		rate:    limit,
+		burst:   burst,
+		keyFunc: keyFunc,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Wrap returns an http.Handler which applies the rate limit
+// before calling next.
+func (l *TokenBucket) Wrap(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		remaining, wait, ok := l.take(l.key(r))
+		header := w.Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(l.burst))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			header.Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// key returns the key identifying the request's client.
+func (l *TokenBucket) key(r *http.Request) string {
+	if l.keyFunc != nil {
+		return l.keyFunc(r)
+	}
+	if len(l.TrustedProxies) > 0 {
+		if ip := proxiedIP(r, trustedNetworks(l.TrustedProxies)); ip != "" {
+			return ip
+		}
+	}
+	return remoteIP(r)
+}
+
+// take uses a token from the client's bucket, if there is one,
+// returning the number of tokens remaining. If there are none,
+// take also returns how long the client should wait for one.
+func (l *TokenBucket) take(key string) (remaining int, wait time.Duration, ok bool) {
+	var now time.Time
+	if l.Clock != nil {
+		now = l.Clock.Now()
+	} else {
+		now = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill the bucket for the time since the last request.
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * l.rate
+		if b.tokens > float64(l.burst) {
+			b.tokens = float64(l.burst)
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return 0, wait, false
+	}
+
+	b.tokens--
+	return int(b.tokens), 0, true
+}
+
+// sweep removes the buckets of clients which have
+// not made a request since their bucket was full.
+func (l *TokenBucket) sweep(now time.Time) {
+	full := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	interval := full
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	if now.Sub(l.lastSweep) < interval {
+		return
+	}
+
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock which tells the time it is set to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// newTestTokenBucket creates a TokenBucket with a fake clock.
+func newTestTokenBucket(limit float64, burst int, keyFunc func(*http.Request) string) (*TokenBucket, *fakeClock) {
+	limiter := NewTokenBucket(limit, burst, keyFunc)
+	clock := &fakeClock{time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)}
+	limiter.Clock = clock
+	return limiter, clock
+}
+
+// serveLimited serves a request from the given remote address.
+func serveLimited(h http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestTokenBucket(t *testing.T) {
+	limiter, clock := newTestTokenBucket(1, 3, nil)
+	h := limiter.Wrap(emptyHandler)
+
+	steps := []struct {
+		advance    time.Duration
+		status     int
+		remaining  int
+		retryAfter string
+	}{
+		// The burst is available immediately.
+		{0, http.StatusOK, 2, ""},
+		{0, http.StatusOK, 1, ""},
+		{0, http.StatusOK, 0, ""},
+		{0, http.StatusTooManyRequests, 0, "1"},
+
+		// Tokens refill continuously.
+		{500 * time.Millisecond, http.StatusTooManyRequests, 0, "1"},
+		{500 * time.Millisecond, http.StatusOK, 0, ""},
+		{time.Second, http.StatusOK, 0, ""},
+
+		// The bucket holds at most burst tokens.
+		{time.Hour, http.StatusOK, 2, ""},
+	}
+	for i, step := range steps {
+		clock.now = clock.now.Add(step.advance)
+		w := serveLimited(h, "192.0.2.1:1234")
+		if w.Code != step.status {
+			t.Errorf("step %d: got status %d, want %d", i, w.Code, step.status)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(step.remaining) {
+			t.Errorf("step %d: got X-RateLimit-Remaining %q, want %d", i, got, step.remaining)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("step %d: got X-RateLimit-Limit %q, want 3", i, got)
+		}
+		if got := w.Header().Get("Retry-After"); got != step.retryAfter {
+			t.Errorf("step %d: got Retry-After %q, want %q", i, got, step.retryAfter)
+		}
+	}
+}
+
+func TestTokenBucketRetryAfter(t *testing.T) {
+	limiter, _ := newTestTokenBucket(0.25, 1, nil)
+	h := limiter.Wrap(emptyHandler)
+	serveLimited(h, "192.0.2.1:1234")
+	if w := serveLimited(h, "192.0.2.1:1234"); w.Header().Get("Retry-After") != "4" {
+		t.Errorf("got Retry-After %q, want 4", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestTokenBucketClients(t *testing.T) {
+	limiter, _ := newTestTokenBucket(1, 1, nil)
+	h := limiter.Wrap(emptyHandler)
+	if w := serveLimited(h, "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("first client: got status %d", w.Code)
+	}
+	if w := serveLimited(h, "192.0.2.1:5678"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("first client on another port: got status %d, want 429", w.Code)
+	}
+	if w := serveLimited(h, "192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("second client: got status %d, want 200", w.Code)
+	}
+}
+
+func TestTokenBucketKeyFunc(t *testing.T) {
+	limiter, _ := newTestTokenBucket(1, 1, func(r *http.Request) string {
+		return r.Header.Get("X-API-Token")
+	})
+	h := limiter.Wrap(emptyHandler)
+	for _, test := range []struct {
+		remoteAddr, token string
+		want              int
+	}{
+		{"192.0.2.1:1234", "a", http.StatusOK},
+		{"192.0.2.2:1234", "a", http.StatusTooManyRequests},
+		{"192.0.2.1:1234", "b", http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-API-Token", test.token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s with token %q: got status %d, want %d", test.remoteAddr, test.token, w.Code, test.want)
+		}
+	}
+}
+
+func TestTokenBucketTrustedProxies(t *testing.T) {
+	limiter, _ := newTestTokenBucket(1, 1, nil)
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	limiter.TrustedProxies = []net.IPNet{*proxies}
+	h := limiter.Wrap(emptyHandler)
+
+	serve := func(remoteAddr, forwarded string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Clients behind the proxy have separate limits.
+	if code := serve("10.0.0.1:1234", "192.0.2.1"); code != http.StatusOK {
+		t.Errorf("first proxied client: got status %d", code)
+	}
+	if code := serve("10.0.0.1:1234", "192.0.2.2"); code != http.StatusOK {
+		t.Errorf("second proxied client: got status %d", code)
+	}
+	if code := serve("10.0.0.2:1234", "192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("first proxied client via another proxy: got status %d, want 429", code)
+	}
+
+	// Untrusted clients cannot choose their key.
+	if code := serve("192.0.2.3:1234", "198.51.100.1"); code != http.StatusOK {
+		t.Errorf("untrusted client: got status %d", code)
+	}
+	if code := serve("192.0.2.3:1234", "198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Errorf("untrusted client with a new X-Forwarded-For: got status %d, want 429", code)
+	}
+}
+
+func TestTokenBucketSweep(t *testing.T) {
+	limiter, clock := newTestTokenBucket(1, 2, nil)
+	h := limiter.Wrap(emptyHandler)
+	serveLimited(h, "192.0.2.1:1234")
+	clock.now = clock.now.Add(time.Minute)
+	serveLimited(h, "192.0.2.2:1234")
+	if _, ok := limiter.buckets["192.0.2.1"]; ok || len(limiter.buckets) != 1 {
+		t.Errorf("got %d buckets after sweeping", len(limiter.buckets))
+	}
+}
+
+func TestNewTokenBucketInvalid(t *testing.T) {
+	for _, test := range []struct {
+		limit float64
+		burst int
+	}{
+		{0, 1},
+		{-1, 1},
+		{1, 0},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewTokenBucket(%v, %d) did not panic", test.limit, test.burst)
+				}
+			}()
+			NewTokenBucket(test.limit, test.burst, nil)
+		}()
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1, 2)
+	r := httptest.NewRequest("GET", "/", nil)
+	now := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	steps := []struct {
+		advance time.Duration
+		ok      bool
+	}{
+		{0, true},
+		{0, true},
+		{0, false},
+
+		// The previous window's requests count in proportion
+		// to its overlap with the last window.
+		{2 * time.Second, false},
+		{time.Second, true},
+		{0, false},
+
+		// Old windows are forgotten.
+		{time.Minute, true},
+		{0, true},
+		{0, false},
+	}
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		wait, ok := limiter.allow(r, now)
+		if ok != step.ok {
+			t.Errorf("step %d: got %v, want %v", i, ok, step.ok)
+		}
+		if !ok && wait <= 0 {
+			t.Errorf("step %d: got wait %v", i, wait)
+		}
+	}
+}
+
+func TestRateLimiterWrap(t *testing.T) {
+	limiter := NewRateLimiter(1, 1).WithKeyFunc(func(r *http.Request) string {
+		return r.Header.Get("X-API-Token")
+	})
+	h := limiter.Wrap(Handler(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"a", http.StatusOK},
+		{"a", http.StatusTooManyRequests},
+		{"b", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-API-Token", test.token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.token, w.Code, test.want)
+		}
+		if test.want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("%s: got Retry-After %q, want 1", test.token, w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestNewRateLimiterInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewRateLimiter did not panic")
+		}
+	}()
+	NewRateLimiter(0, 1)
+}
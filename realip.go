@@ -0,0 +1,145 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP returns the IP address of the client which made the request,
+// using the X-Forwarded-For, X-Real-IP, and Forwarded HTTP headers set
+// by reverse proxies, in that order. If none of these is present and
+// valid, the address of the direct peer is used instead.
+//
+// These headers can be set by the client, so RealIP should only be used
+// behind a trusted proxy. RealIPMiddleware can be used to check this.
+func RealIP(r *http.Request) string {
+	for _, addr := range strings.Split(r.Header.Get("X-Forwarded-For"), ",") {
+		if ip := parseIP(addr); ip != "" {
+			return ip
+		}
+	}
+	if ip := parseIP(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	for _, addr := range forwardedFor(r) {
+		if ip := parseIP(addr); ip != "" {
+			return ip
+		}
+	}
+	return remoteIP(r)
+}
+
+// RealIPMiddleware creates Middleware which sets the request's
+// RemoteAddr to the client's IP address, as described by RealIP,
+// but only when the direct peer's address is in one of the trusted
+// networks. Where the headers list several addresses, the last
+// address not in a trusted network is used, so clients cannot
+// spoof their address by adding to the list.
+//
+//	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+//	site.Always(web.RealIPMiddleware([]net.IPNet{*proxies})(handler))
+func RealIPMiddleware(trustedProxies []net.IPNet) Middleware {
+	trusted := trustedNetworks(trustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			client := proxiedIP(r, trusted)
+			if client == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			port := "0"
+			if _, p, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				port = p
+			}
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.RemoteAddr = net.JoinHostPort(client, port)
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
+
+// trustedNetworks returns a function which reports whether
+// an IP address is in one of the given networks.
+func trustedNetworks(networks []net.IPNet) func(addr string) bool {
+	return func(addr string) bool {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false
+		}
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// proxiedIP returns the client's IP address, as described
+// by RealIPMiddleware, or the empty string if the request
+// did not come from a trusted proxy.
+func proxiedIP(r *http.Request, trusted func(addr string) bool) string {
+	if !trusted(remoteIP(r)) {
+		return ""
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return lastUntrusted(strings.Split(forwarded, ","), trusted)
+	}
+	if ip := parseIP(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	return lastUntrusted(forwardedFor(r), trusted)
+}
+
+// lastUntrusted returns the last valid address in the list
+// which is not trusted. If all are trusted, the first valid
+// address is returned.
+func lastUntrusted(addrs []string, trusted func(string) bool) string {
+	first := ""
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := parseIP(addrs[i])
+		if ip == "" {
+			continue
+		}
+		if !trusted(ip) {
+			return ip
+		}
+		first = ip
+	}
+	return first
+}
+
+// forwardedFor returns the for parameters of the
+// request's Forwarded headers, as defined in RFC 7239.
+func forwardedFor(r *http.Request) []string {
+	var addrs []string
+	for _, header := range r.Header["Forwarded"] {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					addrs = append(addrs, strings.Trim(pair[4:], `"`))
+				}
+			}
+		}
+	}
+	return addrs
+}
+
+// parseIP returns the IP address in addr, which may include
+// a port, or the empty string if addr is not a valid address.
+func parseIP(addr string) string {
+	ip := net.ParseIP(hostname(strings.TrimSpace(addr)))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
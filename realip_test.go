@@ -0,0 +1,90 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"peer", nil, "192.0.2.1"},
+		{"X-Forwarded-For", map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1"}, "203.0.113.7"},
+		{"invalid X-Forwarded-For", map[string]string{"X-Forwarded-For": "unknown, 203.0.113.7"}, "203.0.113.7"},
+		{"X-Real-IP", map[string]string{"X-Real-IP": "203.0.113.8"}, "203.0.113.8"},
+		{"Forwarded", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.0.0.1`}, "2001:db8::1"},
+		{"X-Forwarded-For first", map[string]string{
+			"X-Forwarded-For": "203.0.113.7",
+			"X-Real-IP":       "203.0.113.8",
+			"Forwarded":       "for=203.0.113.9",
+		}, "203.0.113.7"},
+		{"X-Real-IP before Forwarded", map[string]string{
+			"X-Real-IP": "203.0.113.8",
+			"Forwarded": "for=203.0.113.9",
+		}, "203.0.113.8"},
+		{"all invalid", map[string]string{
+			"X-Forwarded-For": "unknown",
+			"X-Real-IP":       "nope",
+			"Forwarded":       "for=_hidden",
+		}, "192.0.2.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		if got := RealIP(r); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	var got string
+	h := RealIPMiddleware([]net.IPNet{*proxies})(Handler(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+
+	tests := []struct {
+		name, peer string
+		headers    map[string]string
+		want       string
+	}{
+		{"untrusted peer", "203.0.113.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.1:1234"},
+		{"trusted peer", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7:1234"},
+		{"no headers", "10.0.0.1:1234", nil, "10.0.0.1:1234"},
+		{"spoofed X-Forwarded-For", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.0.0.2"}, "203.0.113.7:1234"},
+		{"all trusted", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3:1234"},
+		{"X-Real-IP", "10.0.0.1:1234", map[string]string{"X-Real-IP": "203.0.113.8"}, "203.0.113.8:1234"},
+		{"X-Forwarded-For before X-Real-IP", "10.0.0.1:1234", map[string]string{
+			"X-Forwarded-For": "203.0.113.7",
+			"X-Real-IP":       "203.0.113.8",
+		}, "203.0.113.7:1234"},
+		{"spoofed Forwarded", "10.0.0.1:1234", map[string]string{"Forwarded": "for=198.51.100.1, for=203.0.113.9"}, "203.0.113.9:1234"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.peer
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got != test.want {
+			t.Errorf("%s: got RemoteAddr %q, want %q", test.name, got, test.want)
+		}
+		if r.RemoteAddr != test.peer {
+			t.Errorf("%s: original request's RemoteAddr changed to %q", test.name, r.RemoteAddr)
+		}
+	}
+}
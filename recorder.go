@@ -0,0 +1,112 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ResponseRecorder wraps an http.ResponseWriter, recording the
+// status code and number of bytes written by a handler. Unlike
+// httptest.ResponseRecorder, the response is passed straight to
+// the wrapped ResponseWriter, rather than buffered.
+//
+// ResponseRecorder implements http.Flusher and http.Hijacker by
+// delegating to the wrapped ResponseWriter, and supports
+// http.ResponseController through its Unwrap method.
+//
+//	rec := web.NewResponseRecorder(w)
+//	next.ServeHTTP(rec, r)
+//	log.Printf("%s: %d (%d bytes)", r.URL.Path, rec.Status(), rec.Written())
+type ResponseRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// NewResponseRecorder creates a ResponseRecorder wrapping w.
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w}
+}
+
+// Status returns the response status code sent, or 0 if
+// the response status has not yet been written.
+func (w *ResponseRecorder) Status() int {
+	return w.status
+}
+
+// Written returns the number of bytes of the response
+// body written so far.
+func (w *ResponseRecorder) Written() int64 {
+	return w.written
+}
+
+// WriteHeader sends the response status code. Informational
+// 1xx responses, such as 103 Early Hints, are passed on, but
+// not recorded, as the final status is sent afterwards.
+func (w *ResponseRecorder) WriteHeader(status int) {
+	if w.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes data to the response body.
+func (w *ResponseRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.written += int64(n)
+	return n, err
+}
+
+// ReadFrom copies src to the response body, using the wrapped
+// ResponseWriter's ReadFrom method if it has one, so files can
+// still be sent efficiently, such as with sendfile.
+func (w *ResponseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, src)
+	}
+	w.written += n
+	return n, err
+}
+
+// Flush sends any buffered data to the client, if the
+// wrapped ResponseWriter supports it.
+func (w *ResponseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection, if the wrapped
+// ResponseWriter supports it. Otherwise, it returns
+// http.ErrNotSupported.
+func (w *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped ResponseWriter.
+func (w *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
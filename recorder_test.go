@@ -0,0 +1,52 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := NewResponseRecorder(w)
+	rec.WriteHeader(http.StatusCreated)
+	io.WriteString(rec, "hello, ")
+	io.Copy(rec, strings.NewReader("world"))
+
+	if got := rec.Status(); got != http.StatusCreated {
+		t.Errorf("got status %d, want %d", got, http.StatusCreated)
+	}
+	if got := rec.Written(); got != 12 {
+		t.Errorf("got %d bytes written, want 12", got)
+	}
+	if got := w.Body.String(); got != "hello, world" {
+		t.Errorf("got body %q, want %q", got, "hello, world")
+	}
+}
+
+func TestResponseRecorderInformational(t *testing.T) {
+	rec := NewResponseRecorder(httptest.NewRecorder())
+	rec.WriteHeader(http.StatusEarlyHints)
+	if got := rec.Status(); got != 0 {
+		t.Errorf("got status %d after an informational response, want 0", got)
+	}
+	rec.WriteHeader(http.StatusNotFound)
+	if got := rec.Status(); got != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", got, http.StatusNotFound)
+	}
+}
+
+func TestResponseRecorderImplicitStatus(t *testing.T) {
+	rec := NewResponseRecorder(httptest.NewRecorder())
+	var w http.ResponseWriter = rec
+	w.(http.Flusher).Flush()
+	if got := rec.Status(); got != http.StatusOK {
+		t.Errorf("got status %d after Flush, want %d", got, http.StatusOK)
+	}
+}
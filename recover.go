@@ -0,0 +1,194 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover creates an http.Handler which calls next, recovering
+// from any panic and sending a 500 Internal Server Error response
+// instead, so the panic does not crash the server. The panic is
+// logged using the standard logger. Recover is equivalent to
+// Recoverer{}.Handler(next).
+//
+//	site.Always(web.Recover(web.Handler(serveContent)))
+func Recover(next http.Handler) http.Handler {
+	return Recoverer{}.Handler(next)
+}
+
+// RecoverWith creates Middleware which works like Recover, but calls
+// onPanic with the recovered value and a formatted stack trace, instead
+// of logging the panic. If onPanic is nil, the panic is not reported.
+//
+//	logPanics := web.RecoverWith(func(recovered interface{}, stack []byte) {
+//		log.Printf("panic: %v\n%s", recovered, stack)
+//	})
+//	site.Always(logPanics(web.Handler(serveContent)))
+func RecoverWith(onPanic func(recovered interface{}, stack []byte)) Middleware {
+	if onPanic == nil {
+		onPanic = func(interface{}, []byte) {}
+	}
+	return Recoverer{Log: onPanic}.Handler
+}
+
+// Recover is shorthand for web.Recover(h).
+//
+//	site.Always(web.Handler(serveContent).Recover())
+func (h Handler) Recover() http.Handler {
+	return Recover(h)
+}
+
+// Recoverer is used to recover from panics in handlers, with
+// control over how they are reported and the response sent.
+//
+// Panics with the value http.ErrAbortHandler are not recovered, so
+// handlers can still use it to abort a response. If the handler had
+// already written the response status when it panicked, the panic
+// is reported, then the response is aborted by panicking with
+// http.ErrAbortHandler, so the client does not mistake a truncated
+// response for a complete one.
+//
+//	recoverer := web.Recoverer{
+//		Log: func(recovered interface{}, stack []byte) {
+//			logger.Printf("panic: %v\n%s", recovered, stack)
+//		},
+//		ErrorHandler: func(w http.ResponseWriter, r *http.Request, recovered interface{}) {
+//			serveErrorPage(w, r, http.StatusInternalServerError)
+//		},
+//	}
+//	site.Use(recoverer.Handler)
+type Recoverer struct {
+	// Log is called with the recovered value and a stack trace,
+	// trimmed to start at the panic. If nil, the panic is logged
+	// using the standard logger.
+	Log func(recovered interface{}, stack []byte)
+
+	// ErrorHandler is called to write the response, if the handler
+	// had not yet written the response status when it panicked. If
+	// nil, a plain 500 Internal Server Error response is sent.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, recovered interface{})
+}
+
+// Handler returns an http.Handler which calls next, recovering
+// from any panic.
+func (rc Recoverer) Handler(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverResponseWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			stack := trimStack(debug.Stack())
+			if rc.Log != nil {
+				rc.Log(recovered, stack)
+			} else {
+				log.Printf("web: panic serving %s: %v\n%s", r.URL.Path, recovered, stack)
+			}
+
+			// The response can't be changed once the header
+			// has been written, so abort the connection.
+			if rw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+
+			// Discard any headers set before the panic.
+			header := w.Header()
+			for key := range header {
+				delete(header, key)
+			}
+			if rc.ErrorHandler != nil {
+				rc.ErrorHandler(w, r, recovered)
+			} else {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// trimStack removes the frames for the recovery and panic
+// machinery from a stack trace, keeping the goroutine header.
+func trimStack(stack []byte) []byte {
+	i := bytes.Index(stack, []byte("\npanic("))
+	if i < 0 {
+		return stack
+	}
+
+	// Skip the panic call and its file and line.
+	rest := stack[i+1:]
+	for n := 0; n < 2; n++ {
+		j := bytes.IndexByte(rest, '\n')
+		if j < 0 {
+			return stack
+		}
+		rest = rest[j+1:]
+	}
+
+	header := stack
+	if j := bytes.IndexByte(stack, '\n'); j >= 0 {
+		header = stack[:j+1]
+	}
+	return append(append([]byte(nil), header...), rest...)
+}
+
+// recoverResponseWriter records whether the
+// response status has been written.
+type recoverResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoverResponseWriter) WriteHeader(status int) {
+	// Informational responses, such as 103 Early
+	// Hints, may be followed by the final status,
+	// so only 101 Switching Protocols counts.
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoverResponseWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recoverResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (w *recoverResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		// The connection can no longer be used
+		// to send an error response.
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+func (w *recoverResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
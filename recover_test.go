@@ -0,0 +1,164 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// hijackRecorder is an httptest.ResponseRecorder
+// which can be hijacked.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func TestRecoverPanicsPassesThroughHijackAndUnwrap(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.RecoverPanics = true
+	var unwrapped http.ResponseWriter
+	site.Always(Handler(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := w.(http.Hijacker)
+		if !ok {
+			t.Fatal("ResponseWriter does not implement http.Hijacker")
+		}
+		if _, _, err := h.Hijack(); err != nil {
+			t.Fatalf("Hijack returned %v", err)
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			t.Fatal("ResponseWriter has no Unwrap method")
+		}
+		unwrapped = u.Unwrap()
+	}))
+
+	w := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	site.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !w.hijacked {
+		t.Error("the underlying ResponseWriter was not hijacked")
+	}
+	if unwrapped != http.ResponseWriter(w) {
+		t.Errorf("Unwrap returned %T, want the underlying ResponseWriter", unwrapped)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var logged interface{}
+	h := RecoverWith(func(recovered interface{}, stack []byte) { logged = recovered })(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Partial", "yes")
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", w.Code)
+	}
+	if w.Header().Get("X-Partial") != "" {
+		t.Error("headers set before the panic were sent")
+	}
+	if logged != "boom" {
+		t.Errorf("logged %v, want boom", logged)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.RecoverPanics = true
+	var logged interface{}
+	site.Recoverer.Log = func(recovered interface{}, stack []byte) { logged = recovered }
+	site.Always(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Partial", "yes")
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	site.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", w.Code)
+	}
+	if w.Header().Get("X-Partial") != "" {
+		t.Error("headers set before the panic were sent")
+	}
+	if logged != "boom" {
+		t.Errorf("logged %v, want boom", logged)
+	}
+}
+
+func TestRecoverRepanicsErrAbortHandler(t *testing.T) {
+	h := Recover(Handler(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", recovered)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestRecoverPanicsAfterEarlyHints(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.RecoverPanics = true
+	site.Recoverer.Log = func(recovered interface{}, stack []byte) {}
+	site.Always(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		panic("boom")
+	}))
+
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", res.StatusCode)
+	}
+}
+
+func TestRecoverPanicAfterPartialWrite(t *testing.T) {
+	logged := make(chan interface{}, 2)
+	h := RecoverWith(func(recovered interface{}, stack []byte) { logged <- recovered })(Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		panic("boom")
+	}))
+
+	// The response is aborted, rather than ended cleanly.
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err == nil {
+		t.Errorf("got complete response %q, want an error", body)
+	}
+	if recovered := <-logged; recovered != "boom" {
+		t.Errorf("logged %v, want boom", recovered)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", recovered)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
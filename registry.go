@@ -0,0 +1,382 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry is a small collection of metrics, which can be served
+// in the Prometheus text exposition format with PrometheusHandler,
+// without depending on the Prometheus client library. It supports
+// counters, gauges, and histograms, each with any number of labels.
+//
+// Metrics are created with the Registry's Counter, Gauge and
+// Histogram methods, and are written in the order they were
+// created. A Registry is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*registryMetric
+	names   map[string]*registryMetric
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]*registryMetric)}
+}
+
+// DefaultBuckets are the histogram buckets used by MetricsMiddleware
+// if none are given, suitable for request durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Counter is a metric whose values only increase, such as
+// the number of requests served, created with Registry.Counter.
+type Counter struct {
+	m *registryMetric
+}
+
+// Inc increments the counter with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter with the given label values by v.
+// Add panics if v is negative, or if the number of label values
+// does not match the counter's labels.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("web: counter " + c.m.name + " cannot decrease")
+	}
+	c.m.update(labelValues, func(s *registrySeries) { s.value += v })
+}
+
+// Gauge is a metric whose values can go up and down, such as
+// the number of requests in progress, created with Registry.Gauge.
+type Gauge struct {
+	m *registryMetric
+}
+
+// Set sets the gauge with the given label values to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.update(labelValues, func(s *registrySeries) { s.value = v })
+}
+
+// Add adds v, which may be negative, to the gauge with
+// the given label values.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.update(labelValues, func(s *registrySeries) { s.value += v })
+}
+
+// Histogram is a metric which counts observations, such as
+// request durations, in buckets, created with Registry.Histogram.
+type Histogram struct {
+	m *registryMetric
+}
+
+// Observe adds v to the histogram with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.update(labelValues, func(s *registrySeries) {
+		for i, bound := range h.m.buckets {
+			if v <= bound {
+				s.buckets[i]++
+				break
+			}
+		}
+		s.sum += v
+		s.count++
+	})
+}
+
+// Counter returns the counter with the given name and labels,
+// creating it if necessary. Counter panics if a different kind
+// of metric, or one with different labels, has the same name.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register("counter", name, help, labels, nil)}
+}
+
+// Gauge returns the gauge with the given name and labels,
+// creating it if necessary. Gauge panics if a different kind
+// of metric, or one with different labels, has the same name.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register("gauge", name, help, labels, nil)}
+}
+
+// Histogram returns the histogram with the given name, bucket
+// upper bounds, and labels, creating it if necessary. The +Inf
+// bucket is added automatically. Histogram panics if the buckets
+// are not in increasing order, or if a different kind of metric,
+// or one with different labels, has the same name.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	if n := len(buckets); n > 0 && math.IsInf(buckets[n-1], +1) {
+		buckets = buckets[:n-1]
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("web: histogram " + name + " has unsorted buckets")
+	}
+	return &Histogram{r.register("histogram", name, help, labels, buckets)}
+}
+
+func (r *Registry) register(kind, name, help string, labels []string, buckets []float64) *registryMetric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.names[name]; ok {
+		if m.kind != kind || strings.Join(m.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("web: metric %s is already registered as a different %s", name, m.kind))
+		}
+		return m
+	}
+
+	m := &registryMetric{
+		kind:    kind,
+		name:    name,
+		help:    help,
+		labels:  append([]string(nil), labels...),
+		buckets: buckets,
+		series:  make(map[string]*registrySeries),
+	}
+	if r.names == nil {
+		r.names = make(map[string]*registryMetric)
+	}
+	r.names[name] = m
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// WriteTo writes the metrics to w in the Prometheus text
+// exposition format. The series of each metric are sorted
+// by their label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*registryMetric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	buf := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	err := buf.Flush()
+	return cw.n, err
+}
+
+// PrometheusHandler creates an http.Handler which sends the metrics
+// in reg in the Prometheus text exposition format, for scraping by
+// a Prometheus server. Responses are not cached.
+//
+//	reg := web.NewRegistry()
+//	site.Use(web.MetricsMiddleware(reg, nil))
+//	site.Equals(web.PrometheusHandler(reg), "/metrics")
+func PrometheusHandler(reg *Registry) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		DoNotCache(w)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.WriteTo(w)
+	})
+}
+
+// MetricsMiddleware creates Middleware which records metrics for each
+// request in reg, which can be served with PrometheusHandler. It works
+// like PrometheusMiddleware, but without depending on the Prometheus
+// client library. The metrics are:
+//
+//	http_requests_total            counter, by method, code, and route
+//	http_request_duration_seconds  histogram, by method and route
+//
+// The route label is the pattern matched by the request, as described
+// in PrometheusMiddleware. The histogram uses the given buckets, or
+// DefaultBuckets if nil.
+//
+//	site.Use(web.MetricsMiddleware(reg, []float64{.01, .1, 1}))
+func MetricsMiddleware(reg *Registry, buckets []float64) Middleware {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	requests := reg.Counter("http_requests_total", "Total number of HTTP requests.", "method", "code", "route")
+	durations := reg.Histogram("http_request_duration_seconds", "Duration of HTTP requests in seconds.", buckets, "method", "route")
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := NewResponseRecorder(w)
+			r, pattern := recordRoutePattern(r)
+			next.ServeHTTP(rec, r)
+
+			method := metricMethod(r.Method)
+			route := *pattern
+			if route == "" {
+				route = "other"
+			}
+			status := rec.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			requests.Inc(method, strconv.Itoa(status), route)
+			durations.Observe(time.Since(start).Seconds(), method, route)
+		})
+	}
+}
+
+// metricMethod returns the method label for metrics,
+// limiting the number of distinct values.
+func metricMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "CONNECT", "OPTIONS", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
+
+// registryMetric holds the series of one metric in a Registry.
+type registryMetric struct {
+	kind    string // "counter", "gauge", or "histogram".
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // Upper bounds, without +Inf.
+
+	mu     sync.Mutex
+	series map[string]*registrySeries // By label values.
+}
+
+type registrySeries struct {
+	labelValues []string
+	value       float64  // Counters and gauges.
+	buckets     []uint64 // Histograms, not cumulative.
+	sum         float64
+	count       uint64
+}
+
+// update calls fn with the series for the label values,
+// creating it if necessary.
+func (m *registryMetric) update(labelValues []string, fn func(*registrySeries)) {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("web: metric %s has %d labels, but got %d values", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	m.mu.Lock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &registrySeries{labelValues: append([]string(nil), labelValues...)}
+		if m.kind == "histogram" {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	fn(s)
+	m.mu.Unlock()
+}
+
+// write writes the metric in the text exposition format.
+func (m *registryMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelString(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelString(s.labelValues, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelString(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelString(s.labelValues, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.labelString(s.labelValues, ""), s.count)
+	}
+}
+
+// labelString formats the labels of a series, such as
+// {method="GET",route="/"}, adding the le label of a
+// histogram bucket if le is not empty.
+func (m *registryMetric) labelString(values []string, le string) string {
+	if len(values) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(m.labels[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(value))
+		b.WriteByte('"')
+	}
+	if le != "" {
+		if len(values) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`le="`)
+		b.WriteString(le)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// escapeHelp escapes backslashes and newlines in help text.
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// escapeLabelValue escapes backslashes, double quotes
+// and newlines in a label value.
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// formatFloat formats a sample value.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(data []byte) (int, error) {
+	n, err := c.w.Write(data)
+	c.n += int64(n)
+	return n, err
+}
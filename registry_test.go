@@ -0,0 +1,93 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// checkGolden compares got with the named file in testdata,
+// updating the file instead if the -update flag is set.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match %s:\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestRegistryExposition(t *testing.T) {
+	reg := NewRegistry()
+	jobs := reg.Counter("jobs_total", "Total number of jobs.\nBy queue.", "queue")
+	jobs.Inc("email")
+	jobs.Add(2.5, "email")
+	jobs.Inc(`C:\queue "a"` + "\n")
+	reg.Gauge("temperature", "Current temperature.").Set(-3.25)
+	latency := reg.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.Observe(v, "read")
+	}
+	latency.Observe(0.2, "write")
+	reg.Counter("unused_total", "Never incremented.")
+
+	var buf bytes.Buffer
+	n, err := reg.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, but wrote %d bytes", n, buf.Len())
+	}
+	checkGolden(t, "registry.golden", buf.Bytes())
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	reg := NewRegistry()
+	site := NewSite("example.com", 80, nil)
+	site.Use(MetricsMiddleware(reg, []float64{60}))
+	site.Pattern(Handler(func(w http.ResponseWriter, r *http.Request) {}), "/users/:id")
+	site.Equals(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), "/tea")
+
+	for _, path := range []string{"/users/1", "/users/2", "/tea"} {
+		site.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	PrometheusHandler(reg).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got, want := w.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("got Content-Type %q, want %q", got, want)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",code="200",route="/users/:id"} 2`,
+		`http_requests_total{method="GET",code="418",route="other"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/users/:id",le="60"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="other"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RequestID creates an http.Handler which identifies each request,
+// using the X-Request-ID HTTP header if the client provided a valid
+// one, or a new random UUID otherwise. The ID is stored in the
+// request's context, where it can be retrieved with GetRequestID,
+// and is sent in the X-Request-ID header of the response. AccessLog,
+// AccessLogFunc, and Logger include the ID in their output.
+//
+// Valid request IDs have between 1 and 128 characters, which must be
+// ASCII letters, digits, or any of "-_.:+/=".
+//
+//	site.Always(web.RequestID(handler))
+func RequestID(next http.Handler) http.Handler {
+	return RequestIDWith(newUUID)(next)
+}
+
+// RequestIDWith creates Middleware which works like RequestID, but
+// calls generate to create new request IDs.
+//
+//	var next int64
+//	requestID := web.RequestIDWith(func() string {
+//		return strconv.FormatInt(atomic.AddInt64(&next, 1), 10)
+//	})
+func RequestIDWith(generate func() string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get("X-Request-ID")
+			if !validRequestID(id) {
+				id = generate()
+			}
+			w.Header().Set("X-Request-ID", id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+// validRequestID reports whether a request
+// ID provided by the client can be used.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("-_.:+/=", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// GetRequestID returns the request ID stored in the context by
+// RequestID, or the empty string if there is none.
+//
+//	log.Printf("[%s] serving %s", web.GetRequestID(r.Context()), r.URL.Path)
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// loggedRequestID returns the request's ID for logging. If
+// RequestID was applied inside the logging middleware, its
+// context is not visible, so the response header is used.
+func loggedRequestID(w http.ResponseWriter, r *http.Request) string {
+	if id := GetRequestID(r.Context()); id != "" {
+		return id
+	}
+	return w.Header().Get("X-Request-ID")
+}
+
+// requestIDKey is the context key for a request's ID.
+type requestIDKey struct{}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4.
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant.
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// requestIDHandler writes the request ID from the context.
+var requestIDHandler = Handler(func(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, GetRequestID(r.Context()))
+})
+
+func serveRequestID(h http.Handler, id string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if id != "" {
+		r.Header.Set("X-Request-ID", id)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestRequestIDGenerated(t *testing.T) {
+	h := RequestID(requestIDHandler)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		w := serveRequestID(h, "")
+		id := w.Header().Get("X-Request-ID")
+		if !uuidPattern.MatchString(id) {
+			t.Fatalf("got request ID %q, want a version 4 UUID", id)
+		}
+		if w.Body.String() != id {
+			t.Fatalf("context has request ID %q, want %q", w.Body.String(), id)
+		}
+		if seen[id] {
+			t.Fatalf("request ID %q was repeated", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestRequestIDPassthrough(t *testing.T) {
+	var next int
+	h := RequestIDWith(func() string {
+		next++
+		return "generated-" + strconv.Itoa(next)
+	})(requestIDHandler)
+
+	tests := []struct {
+		given, want string
+	}{
+		{"abc-123", "abc-123"},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1", "generated-1"},
+		{"a.b_c:d+e/f=", "a.b_c:d+e/f="},
+		{strings.Repeat("a", 128), strings.Repeat("a", 128)},
+		{strings.Repeat("a", 129), "generated-2"},
+		{"has space", "generated-3"},
+		{"new\nline", "generated-4"},
+		{"ünïcode", "generated-5"},
+		{"", "generated-6"},
+	}
+	for _, test := range tests {
+		w := serveRequestID(h, test.given)
+		if got := w.Header().Get("X-Request-ID"); got != test.want {
+			t.Errorf("%q: got X-Request-ID %q, want %q", test.given, got, test.want)
+		}
+		if w.Body.String() != test.want {
+			t.Errorf("%q: context has request ID %q, want %q", test.given, w.Body.String(), test.want)
+		}
+	}
+}
+
+func TestGetRequestIDWithout(t *testing.T) {
+	if w := serveRequestID(requestIDHandler, "abc"); w.Body.String() != "" {
+		t.Errorf("got request ID %q without RequestID", w.Body.String())
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedBody is the size of the largest response
+// body stored by ResponseCache.
+const maxCachedBody = 1 << 20
+
+// ResponseCache creates Middleware which caches the responses to GET
+// and HEAD requests in memory, using the request URI as the key. On a
+// hit, the stored status, headers, and body are sent without calling
+// the next handler, with an Age header giving the age of the response
+// in seconds and an X-Cache header of HIT. On a miss, the response from
+// the next handler is sent with an X-Cache header of MISS, and stored.
+//
+// As described in RFC 7234, section 4.1, responses with a Vary header
+// are only used for requests with the same values of the headers it
+// lists, such as Accept and Accept-Encoding, ignoring differences in
+// whitespace. A separate response is stored for each combination of
+// values. Responses with "Vary: *" are not stored.
+//
+// Only responses which are explicitly cacheable, with a max-age or
+// s-maxage directive in their Cache-Control header, or an Expires
+// header, are stored, as with Cache. They are kept until they expire,
+// or for at most ttl, and at most maxEntries are kept, with the least
+// recently used responses evicted first. Responses with the no-store,
+// no-cache, or private directives are not stored, and requests with
+// the no-store directive bypass the cache entirely.
+//
+// Requests with an Authorization or Cookie header are not answered
+// from the cache, as the response may be specific to the user, and
+// their responses are only stored if they have the public or s-maxage
+// directive, as described in RFC 7234, section 3.2.
+//
+// Only responses with the status codes which are cacheable by default,
+// such as 200 OK and 404 Not Found, are stored. Responses which set
+// cookies, or have bodies larger than 1 MB, are not stored. HEAD
+// requests are only answered from responses stored for GET requests.
+// ResponseCache panics if ttl or maxEntries is not positive.
+//
+//	cache := web.ResponseCache(time.Minute, 1000)
+//	site.Get(cache(web.Handler(serveReport)), "/reports/:id")
+func ResponseCache(ttl time.Duration, maxEntries int) Middleware {
+	if ttl <= 0 {
+		panic("web: response cache TTL must be positive")
+	}
+	if maxEntries < 1 {
+		panic("web: response cache size must be positive")
+	}
+	return newResponseCache(ttl, maxEntries).wrap
+}
+
+// responseCache is an LRU cache of responses.
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+	clock      Clock
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string][]*list.Element // The variants stored for each key.
+}
+
+// cachedResponse is a response stored by responseCache.
+type cachedResponse struct {
+	key     string
+	vary    []string // The canonical names of the headers in Vary.
+	values  []string // The normalized values of the vary headers.
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration, maxEntries int) *responseCache {
+	return &responseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string][]*list.Element),
+	}
+}
+
+func (c *responseCache) wrap(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != "GET" && r.Method != "HEAD") || hasDirective(r.Header, "no-store") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.URL.RequestURI()
+		now := c.now()
+		credentials := hasCredentials(r)
+		if resp := c.get(key, r.Header, now); resp != nil && !credentials {
+			header := w.Header()
+			for name, values := range resp.header {
+				header[name] = append([]string(nil), values...)
+			}
+			header.Set("Age", strconv.Itoa(int(now.Sub(resp.stored)/time.Second)))
+			header.Set("X-Cache", "HIT")
+			w.WriteHeader(resp.status)
+			if r.Method == "GET" {
+				w.Write(resp.body)
+			}
+			return
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		if r.Method == "HEAD" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &cacheResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		if !cw.cacheable() {
+			return
+		}
+		if credentials && !hasDirective(cw.header, "public") && !hasDirective(cw.header, "s-maxage") {
+			return
+		}
+		lifetime, ok := freshness(cw.header, now)
+		if !ok {
+			return
+		}
+		if lifetime > c.ttl {
+			lifetime = c.ttl
+		}
+		vary, ok := varyHeaders(cw.header)
+		if !ok {
+			return
+		}
+		c.add(&cachedResponse{
+			key:     key,
+			vary:    vary,
+			values:  varyValues(r.Header, vary),
+			status:  cw.status,
+			header:  cw.header,
+			body:    cw.body.Bytes(),
+			stored:  now,
+			expires: now.Add(lifetime),
+		})
+	})
+}
+
+// now returns the current time.
+func (c *responseCache) now() time.Time {
+	if c.clock != nil {
+		return c.clock.Now()
+	}
+	return time.Now()
+}
+
+// get returns the fresh response stored for key whose
+// vary headers match the request headers, or nil if
+// there is none.
+func (c *responseCache) get(key string, header http.Header, now time.Time) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, elem := range c.entries[key] {
+		resp := elem.Value.(*cachedResponse)
+		if !equalStrings(varyValues(header, resp.vary), resp.values) {
+			continue
+		}
+		if !now.Before(resp.expires) {
+			c.remove(elem)
+			return nil
+		}
+		c.lru.MoveToFront(elem)
+		return resp
+	}
+	return nil
+}
+
+// add stores a response, replacing any variant with the
+// same vary headers and values, and evicting the least
+// recently used responses if necessary.
+func (c *responseCache) add(resp *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, elem := range c.entries[resp.key] {
+		old := elem.Value.(*cachedResponse)
+		if equalStrings(old.vary, resp.vary) && equalStrings(old.values, resp.values) {
+			elem.Value = resp
+			c.lru.MoveToFront(elem)
+			return
+		}
+	}
+	c.entries[resp.key] = append(c.entries[resp.key], c.lru.PushFront(resp))
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes a stored response. The
+// caller must hold c.mu.
+func (c *responseCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	key := elem.Value.(*cachedResponse).key
+	variants := c.entries[key]
+	for i, variant := range variants {
+		if variant == elem {
+			variants = append(variants[:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(c.entries, key)
+	} else {
+		c.entries[key] = variants
+	}
+}
+
+// hasCredentials reports whether the request has an
+// Authorization or Cookie header, so the response may
+// be specific to the user.
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// cacheableStatus reports whether responses with the
+// status code are cacheable by default, as listed in
+// RFC 7231, section 6.1.
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// freshness returns the time for which a response with the
+// given headers may be stored by a shared cache, reporting
+// whether it may be stored at all.
+func freshness(header http.Header, now time.Time) (time.Duration, bool) {
+	if hasDirective(header, "no-store") || hasDirective(header, "no-cache") || hasDirective(header, "private") {
+		return 0, false
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directive(header, name); ok {
+			secs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || secs <= 0 {
+				return 0, false
+			}
+			if secs > int64(OneYear/time.Second) {
+				secs = int64(OneYear / time.Second)
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	expires := header.Get("Expires")
+	if expires == "" {
+		return 0, false
+	}
+	t, err := http.ParseTime(expires)
+	if err != nil {
+		return 0, false
+	}
+	date := now
+	if d, err := http.ParseTime(header.Get("Date")); err == nil {
+		date = d
+	}
+	lifetime := t.Sub(date)
+	return lifetime, lifetime > 0
+}
+
+// directive returns the value of the named directive in the
+// Cache-Control header, reporting whether it is present.
+func directive(header http.Header, name string) (string, bool) {
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			key, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if strings.EqualFold(strings.TrimSpace(key), name) {
+				return strings.Trim(strings.TrimSpace(arg), `"`), true
+			}
+		}
+	}
+	return "", false
+}
+
+// hasDirective reports whether the named directive
+// is present in the Cache-Control header.
+func hasDirective(header http.Header, name string) bool {
+	_, ok := directive(header, name)
+	return ok
+}
+
+// varyHeaders returns the canonical names of the headers listed
+// in the Vary header, reporting false if it contains "*".
+func varyHeaders(header http.Header) ([]string, bool) {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			switch field {
+			case "":
+			case "*":
+				return nil, false
+			default:
+				names = append(names, http.CanonicalHeaderKey(field))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// varyValues returns the normalized values of the named request
+// headers, with the whitespace between list elements removed.
+func varyValues(header http.Header, names []string) []string {
+	values := make([]string, len(names))
+	for i, name := range names {
+		var elements []string
+		for _, value := range header.Values(name) {
+			for _, element := range strings.Split(value, ",") {
+				elements = append(elements, strings.Join(strings.Fields(element), " "))
+			}
+		}
+		values[i] = strings.Join(elements, ",")
+	}
+	return values
+}
+
+// equalStrings reports whether a and b are equal.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheResponseWriter passes the response on to the
+// wrapped ResponseWriter, keeping a copy of it.
+type cacheResponseWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+
+	// skip is set if the response cannot be stored, as it
+	// is too large, or was not sent completely.
+	skip bool
+}
+
+// cacheable reports whether the response can be stored.
+func (w *cacheResponseWriter) cacheable() bool {
+	return w.status != 0 && !w.skip && cacheableStatus(w.status) && len(w.header.Values("Set-Cookie")) == 0
+}
+
+func (w *cacheResponseWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+		w.header = w.Header().Clone()
+		w.header.Del("X-Cache")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(data)
+	switch {
+	case w.skip:
+	case err != nil || w.body.Len()+n > maxCachedBody:
+		w.skip = true
+		w.body = bytes.Buffer{}
+	default:
+		w.body.Write(data[:n])
+	}
+	return n, err
+}
+
+func (w *cacheResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+func (w *cacheResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.skip = true
+	return h.Hijack()
+}
+
+func (w *cacheResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
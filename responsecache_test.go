@@ -0,0 +1,295 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// countingHandler counts its calls, sending the count and
+// request URI, with the Cache-Control and Vary headers given
+// in the query.
+func countingHandler(calls *int) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		header := w.Header()
+		header.Set("Content-Type", "text/plain")
+		header.Set("Cache-Control", "max-age=3600")
+		if cc, ok := r.URL.Query()["cc"]; ok {
+			header.Set("Cache-Control", cc[0])
+		}
+		for _, name := range []string{"Vary", "Expires", "Date"} {
+			if value := r.URL.Query().Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		if r.URL.Path == "/cookie" {
+			http.SetCookie(w, &http.Cookie{Name: "a", Value: "b"})
+		}
+		fmt.Fprintf(w, "%d %s", *calls, r.URL.RequestURI())
+	})
+}
+
+// newTestResponseCache creates a response cache
+// with a fake clock, wrapping countingHandler.
+func newTestResponseCache(maxEntries int, calls *int) (http.Handler, *fakeClock) {
+	c := newResponseCache(time.Minute, maxEntries)
+	clock := &fakeClock{time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)}
+	c.clock = clock
+	return c.wrap(countingHandler(calls)), clock
+}
+
+func serveCached(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	return serveCachedWith(h, method, target, nil)
+}
+
+func serveCachedWith(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestResponseCache(t *testing.T) {
+	var calls int
+	h, clock := newTestResponseCache(10, &calls)
+
+	w := serveCached(h, "GET", "/report?id=1")
+	if w.Body.String() != "1 /report?id=1" || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("miss: got %q with X-Cache %q", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	w = serveCached(h, "GET", "/report?id=1")
+	if w.Code != http.StatusOK || w.Body.String() != "1 /report?id=1" {
+		t.Errorf("hit: got %d %q, want 200 %q", w.Code, w.Body.String(), "1 /report?id=1")
+	}
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Age") != "30" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("hit: got headers %v", w.Header())
+	}
+
+	w = serveCached(h, "HEAD", "/report?id=1")
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.Len() != 0 {
+		t.Errorf("HEAD: got X-Cache %q with %d bytes", w.Header().Get("X-Cache"), w.Body.Len())
+	}
+
+	// The query is part of the key.
+	if w = serveCached(h, "GET", "/report?id=2"); w.Body.String() != "2 /report?id=2" {
+		t.Errorf("other query: got %q", w.Body.String())
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	if w = serveCached(h, "GET", "/report?id=1"); w.Body.String() != "3 /report?id=1" || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("expired: got %q with X-Cache %q", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+}
+
+func TestResponseCacheStatuses(t *testing.T) {
+	var calls int
+	h, _ := newTestResponseCache(10, &calls)
+
+	tests := []struct {
+		method, target string
+		cached         bool
+	}{
+		{"GET", "/missing", true},
+		{"GET", "/error", false},
+		{"GET", "/cookie", false},
+		{"POST", "/report", false},
+		{"HEAD", "/head", false},
+	}
+	for _, test := range tests {
+		serveCached(h, test.method, test.target)
+		before := calls
+		serveCached(h, test.method, test.target)
+		if cached := calls == before; cached != test.cached {
+			t.Errorf("%s %s: got cached %v, want %v", test.method, test.target, cached, test.cached)
+		}
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	var calls int
+	h, _ := newTestResponseCache(2, &calls)
+
+	serveCached(h, "GET", "/a")
+	serveCached(h, "GET", "/b")
+	serveCached(h, "GET", "/a") // Makes /b the least recently used.
+	serveCached(h, "GET", "/c")
+
+	for _, test := range []struct {
+		target string
+		hit    bool
+	}{
+		{"/a", true},
+		{"/c", true},
+		{"/b", false},
+	} {
+		if got := serveCached(h, "GET", test.target).Header().Get("X-Cache") == "HIT"; got != test.hit {
+			t.Errorf("%s: got hit %v, want %v", test.target, got, test.hit)
+		}
+	}
+}
+
+func TestResponseCacheFreshness(t *testing.T) {
+	var calls int
+	h, clock := newTestResponseCache(10, &calls)
+	date := clock.now.Format(http.TimeFormat)
+	expires := clock.now.Add(10 * time.Second).Format(http.TimeFormat)
+
+	tests := []struct {
+		name, query string
+		lifetime    time.Duration // Zero if the response is not stored.
+	}{
+		{"max-age", "cc=public,+max-age=20", 20 * time.Second},
+		{"s-maxage", "cc=max-age=20,+s-maxage=30", 30 * time.Second},
+		{"capped by ttl", "cc=max-age=3600", time.Minute},
+		{"expires", "cc=&Expires=" + url.QueryEscape(expires), 10 * time.Second},
+		{"expires with date", "cc=&Date=" + url.QueryEscape(date) + "&Expires=" + url.QueryEscape(expires), 10 * time.Second},
+		{"invalid expires", "cc=&Expires=0", 0},
+		{"no lifetime", "cc=public", 0},
+		{"zero max-age", "cc=max-age=0", 0},
+		{"no-store", "cc=no-store,+max-age=20", 0},
+		{"no-cache", "cc=no-cache,+max-age=20", 0},
+		{"private", "cc=private,+max-age=20", 0},
+	}
+	for _, test := range tests {
+		start := clock.now
+		target := "/fresh?" + test.query
+		serveCached(h, "GET", target)
+
+		hit := serveCached(h, "GET", target).Header().Get("X-Cache") == "HIT"
+		if hit != (test.lifetime > 0) {
+			t.Errorf("%s: got hit %v, want %v", test.name, hit, test.lifetime > 0)
+			continue
+		}
+		if test.lifetime > 0 {
+			clock.now = start.Add(test.lifetime - time.Second)
+			if serveCached(h, "GET", target).Header().Get("X-Cache") != "HIT" {
+				t.Errorf("%s: expired early", test.name)
+			}
+			clock.now = start.Add(test.lifetime)
+			if serveCached(h, "GET", target).Header().Get("X-Cache") != "MISS" {
+				t.Errorf("%s: not expired after %v", test.name, test.lifetime)
+			}
+		}
+		clock.now = start
+	}
+}
+
+func TestResponseCacheRequestNoStore(t *testing.T) {
+	var calls int
+	h, _ := newTestResponseCache(10, &calls)
+	noStore := http.Header{"Cache-Control": {"no-store"}}
+
+	w := serveCachedWith(h, "GET", "/a", noStore)
+	if w.Header().Get("X-Cache") != "" {
+		t.Errorf("got X-Cache %q, want none", w.Header().Get("X-Cache"))
+	}
+	if serveCached(h, "GET", "/a").Header().Get("X-Cache") != "MISS" {
+		t.Error("no-store request stored its response")
+	}
+	serveCachedWith(h, "GET", "/a", noStore)
+	if calls != 3 {
+		t.Errorf("no-store request answered from the cache")
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	var calls int
+	h, _ := newTestResponseCache(10, &calls)
+	target := "/vary?Vary=Accept-Encoding,+accept"
+	gzipJSON := http.Header{"Accept-Encoding": {"gzip, br"}, "Accept": {"application/json"}}
+
+	if w := serveCachedWith(h, "GET", target, gzipJSON); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: got X-Cache %q", w.Header().Get("X-Cache"))
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		hit    bool
+	}{
+		{"same headers", gzipJSON, true},
+		{"different whitespace", http.Header{"Accept-Encoding": {"gzip,br"}, "Accept": {" application/json"}}, true},
+		{"split header", http.Header{"Accept-Encoding": {"gzip", "br"}, "Accept": {"application/json"}}, true},
+		{"different encoding", http.Header{"Accept-Encoding": {"gzip"}, "Accept": {"application/json"}}, false},
+		{"different accept", http.Header{"Accept-Encoding": {"gzip, br"}, "Accept": {"text/html"}}, false},
+		{"missing header", http.Header{"Accept": {"application/json"}}, false},
+	}
+	for _, test := range tests {
+		if hit := serveCachedWith(h, "GET", target, test.header).Header().Get("X-Cache") == "HIT"; hit != test.hit {
+			t.Errorf("%s: got hit %v, want %v", test.name, hit, test.hit)
+		}
+	}
+
+	// Each variant is stored separately.
+	before := calls
+	for _, test := range tests {
+		serveCachedWith(h, "GET", target, test.header)
+	}
+	if calls != before {
+		t.Errorf("variants were not stored: %d more calls", calls-before)
+	}
+
+	// Responses which vary on anything are not stored.
+	serveCached(h, "GET", "/vary?Vary=*")
+	if serveCached(h, "GET", "/vary?Vary=*").Header().Get("X-Cache") != "MISS" {
+		t.Error("stored a response with Vary: *")
+	}
+}
+
+func TestResponseCacheCredentials(t *testing.T) {
+	var calls int
+	h, _ := newTestResponseCache(10, &calls)
+
+	// Responses to requests with credentials are
+	// neither stored nor answered from the cache.
+	for i, header := range []http.Header{
+		{"Authorization": {"Bearer alice"}},
+		{"Cookie": {"session=alice"}},
+	} {
+		calls = 0
+		target := fmt.Sprintf("/account?%d", i)
+		serveCachedWith(h, "GET", target, header)
+		w := serveCachedWith(h, "GET", target, header)
+		if calls != 2 || w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("%v: got %d calls, X-Cache %q, want 2 misses", header, calls, w.Header().Get("X-Cache"))
+		}
+		w = serveCached(h, "GET", target)
+		if calls != 3 || w.Body.String() != "3 "+target {
+			t.Errorf("%v: anonymous request got %q, want a fresh response", header, w.Body.String())
+		}
+		serveCached(h, "GET", target)
+		if w = serveCachedWith(h, "GET", target, header); calls != 4 || w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("%v: got X-Cache %q after %d calls, want a miss", header, w.Header().Get("X-Cache"), calls)
+		}
+	}
+
+	// Unless the response allows shared caches to store it.
+	for _, cc := range []string{"public,+max-age%3D60", "s-maxage%3D60"} {
+		calls = 0
+		target := "/shared?cc=" + cc
+		serveCachedWith(h, "GET", target, http.Header{"Authorization": {"Bearer alice"}})
+		w := serveCached(h, "GET", target)
+		if calls != 1 || w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("%s: got %d calls, X-Cache %q, want a hit", cc, calls, w.Header().Get("X-Cache"))
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ResponseWriterProxy wraps an http.ResponseWriter, recording the
+// status code and number of bytes written, for Middleware such as
+// logging and metrics. The response is passed straight to the wrapped
+// ResponseWriter.
+//
+// Unlike ResponseRecorder, which always implements http.Flusher and
+// http.Hijacker, the http.ResponseWriter returned by the ResponseWriter
+// method only implements them if the wrapped ResponseWriter does, so
+// handlers which check for them with type assertions behave as they
+// would without the wrapper. It always implements io.ReaderFrom, using
+// the wrapped ResponseWriter's ReadFrom method if it has one, so files
+// can still be sent efficiently, such as with sendfile. Both support
+// http.ResponseController through their Unwrap methods.
+//
+//	proxy := web.WrapResponseWriter(w)
+//	next.ServeHTTP(proxy.ResponseWriter(), r)
+//	log.Printf("%s: %d (%d bytes)", r.URL.Path, proxy.Status(), proxy.BytesWritten())
+type ResponseWriterProxy struct {
+	w       http.ResponseWriter
+	rw      http.ResponseWriter
+	status  int
+	written int64
+}
+
+// WrapResponseWriter creates a ResponseWriterProxy wrapping w.
+func WrapResponseWriter(w http.ResponseWriter) *ResponseWriterProxy {
+	p := &ResponseWriterProxy{w: w}
+	_, flusher := w.(http.Flusher)
+	_, hijacker := w.(http.Hijacker)
+	switch {
+	case flusher && hijacker:
+		p.rw = flushHijackProxy{p}
+	case flusher:
+		p.rw = flushProxy{p}
+	case hijacker:
+		p.rw = hijackProxy{p}
+	default:
+		p.rw = p
+	}
+	return p
+}
+
+// ResponseWriter returns the http.ResponseWriter to pass to
+// the handler, which implements the same optional interfaces
+// as the wrapped ResponseWriter.
+func (p *ResponseWriterProxy) ResponseWriter() http.ResponseWriter {
+	return p.rw
+}
+
+// Status returns the response status code sent, or 0 if
+// the response status has not yet been written.
+func (p *ResponseWriterProxy) Status() int {
+	return p.status
+}
+
+// BytesWritten returns the number of bytes of the
+// response body written so far.
+func (p *ResponseWriterProxy) BytesWritten() int64 {
+	return p.written
+}
+
+// Written reports whether the response status
+// has been written.
+func (p *ResponseWriterProxy) Written() bool {
+	return p.status != 0
+}
+
+// Header returns the header map of the wrapped ResponseWriter.
+func (p *ResponseWriterProxy) Header() http.Header {
+	return p.w.Header()
+}
+
+// WriteHeader sends the response status code. Informational
+// 1xx responses, such as 103 Early Hints, are passed on, but
+// not recorded, as the final status is sent afterwards.
+func (p *ResponseWriterProxy) WriteHeader(status int) {
+	if p.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		p.status = status
+	}
+	p.w.WriteHeader(status)
+}
+
+// Write writes data to the response body.
+func (p *ResponseWriterProxy) Write(data []byte) (int, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	n, err := p.w.Write(data)
+	p.written += int64(n)
+	return n, err
+}
+
+// ReadFrom copies src to the response body, using the
+// wrapped ResponseWriter's ReadFrom method if it has one.
+func (p *ResponseWriterProxy) ReadFrom(src io.Reader) (int64, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := p.w.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		// Hide ReadFrom, so io.Copy does not call it again.
+		n, err = io.Copy(struct{ io.Writer }{p.w}, src)
+	}
+	p.written += n
+	return n, err
+}
+
+// Unwrap returns the wrapped ResponseWriter.
+func (p *ResponseWriterProxy) Unwrap() http.ResponseWriter {
+	return p.w
+}
+
+func (p *ResponseWriterProxy) flush() {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	p.w.(http.Flusher).Flush()
+}
+
+func (p *ResponseWriterProxy) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return p.w.(http.Hijacker).Hijack()
+}
+
+// flushProxy, hijackProxy, and flushHijackProxy add the
+// optional interfaces supported by the wrapped ResponseWriter.
+type flushProxy struct{ *ResponseWriterProxy }
+
+type hijackProxy struct{ *ResponseWriterProxy }
+
+type flushHijackProxy struct{ *ResponseWriterProxy }
+
+func (p flushProxy) Flush() { p.flush() }
+
+func (p hijackProxy) Hijack() (net.Conn, *bufio.ReadWriter, error) { return p.hijack() }
+
+func (p flushHijackProxy) Flush() { p.flush() }
+
+func (p flushHijackProxy) Hijack() (net.Conn, *bufio.ReadWriter, error) { return p.hijack() }
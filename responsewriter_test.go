@@ -0,0 +1,177 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// basicWriter implements only http.ResponseWriter.
+type basicWriter struct {
+	header http.Header
+	status int
+	body   strings.Builder
+}
+
+func (w *basicWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *basicWriter) WriteHeader(status int) { w.status = status }
+
+func (w *basicWriter) Write(data []byte) (int, error) { return w.body.Write(data) }
+
+type flushingWriter struct {
+	*basicWriter
+	flushed bool
+}
+
+func (w *flushingWriter) Flush() { w.flushed = true }
+
+type hijackingWriter struct{ *basicWriter }
+
+func (w hijackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errHijacked
+}
+
+type flushingHijackingWriter struct{ *flushingWriter }
+
+func (w flushingHijackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errHijacked
+}
+
+var errHijacked = io.ErrClosedPipe
+
+func TestWrapResponseWriterInterfaces(t *testing.T) {
+	tests := []struct {
+		name              string
+		w                 http.ResponseWriter
+		flusher, hijacker bool
+	}{
+		{"basic", &basicWriter{}, false, false},
+		{"flusher", &flushingWriter{basicWriter: &basicWriter{}}, true, false},
+		{"hijacker", hijackingWriter{&basicWriter{}}, false, true},
+		{"both", flushingHijackingWriter{&flushingWriter{basicWriter: &basicWriter{}}}, true, true},
+	}
+	for _, test := range tests {
+		proxy := WrapResponseWriter(test.w)
+		w := proxy.ResponseWriter()
+		if _, ok := w.(http.Flusher); ok != test.flusher {
+			t.Errorf("%s: implements http.Flusher: %v, want %v", test.name, ok, test.flusher)
+		}
+		if _, ok := w.(http.Hijacker); ok != test.hijacker {
+			t.Errorf("%s: implements http.Hijacker: %v, want %v", test.name, ok, test.hijacker)
+		}
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Errorf("%s: does not implement io.ReaderFrom", test.name)
+		}
+
+		// http.ResponseController finds the same
+		// interfaces through Unwrap.
+		rc := http.NewResponseController(w)
+		if err := rc.Flush(); (err == nil) != test.flusher {
+			t.Errorf("%s: got Flush error %v", test.name, err)
+		}
+		if _, _, err := rc.Hijack(); (err == errHijacked) != test.hijacker {
+			t.Errorf("%s: got Hijack error %v", test.name, err)
+		}
+		if test.flusher && proxy.Status() != http.StatusOK {
+			t.Errorf("%s: got status %d after Flush, want 200", test.name, proxy.Status())
+		}
+	}
+}
+
+func TestWrapResponseWriterRecords(t *testing.T) {
+	w := httptest.NewRecorder()
+	proxy := WrapResponseWriter(w)
+	if proxy.Written() {
+		t.Error("new proxy reports the status as written")
+	}
+
+	rw := proxy.ResponseWriter()
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.WriteHeader(http.StatusCreated)
+	io.WriteString(rw, "hello, ")
+	io.Copy(rw, strings.NewReader("world"))
+
+	if !proxy.Written() || proxy.Status() != http.StatusCreated {
+		t.Errorf("got status %d, want %d", proxy.Status(), http.StatusCreated)
+	}
+	if got := proxy.BytesWritten(); got != 12 {
+		t.Errorf("got %d bytes written, want 12", got)
+	}
+	if w.Body.String() != "hello, world" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("got body %q and Content-Type %q", w.Body.String(), w.Header().Get("Content-Type"))
+	}
+	if proxy.Unwrap() != w {
+		t.Error("Unwrap did not return the wrapped ResponseWriter")
+	}
+}
+
+func TestWrapResponseWriterInformational(t *testing.T) {
+	proxy := WrapResponseWriter(&basicWriter{})
+	proxy.ResponseWriter().WriteHeader(http.StatusEarlyHints)
+	if proxy.Written() {
+		t.Errorf("got status %d after an informational response, want none", proxy.Status())
+	}
+	proxy.ResponseWriter().WriteHeader(http.StatusNotFound)
+	if got := proxy.Status(); got != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", got, http.StatusNotFound)
+	}
+}
+
+func TestWrapResponseWriterReadFrom(t *testing.T) {
+	content := strings.Repeat("sendfile ", 10000)
+	name := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(chan int64, 1)
+	srv := httptest.NewServer(Handler(func(w http.ResponseWriter, r *http.Request) {
+		proxy := WrapResponseWriter(w)
+		f, err := os.Open(name)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+		// io.Copy uses the server's ReadFrom, and so sendfile.
+		io.Copy(proxy.ResponseWriter(), f)
+		written <- proxy.BytesWritten()
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != content {
+		t.Errorf("got %d bytes, %v, want %d", len(body), err, len(content))
+	}
+	if got := <-written; got != int64(len(content)) {
+		t.Errorf("got %d bytes written, want %d", got, len(content))
+	}
+
+	// Without ReadFrom, the data is written instead.
+	basic := &basicWriter{}
+	proxy := WrapResponseWriter(basic)
+	if n, err := proxy.ReadFrom(strings.NewReader("copied")); n != 6 || err != nil || basic.body.String() != "copied" {
+		t.Errorf("got %d, %v, and body %q", n, err, basic.body.String())
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SetHSTS uses the Strict-Transport-Security HTTP header to advise
+// the client to use only HTTPS for the site for the given duration.
+// This should only be used when serving HTTPS.
+//
+// The preload flag requests inclusion in browsers' HSTS preload
+// lists, which requires includeSubDomains and a maxAge of at least
+// 365 days. If these requirements are not met, SetHSTS returns an
+// error and does not set the header.
+//
+//	// Strict-Transport-Security: max-age=31536000; includeSubDomains; preload
+//	err := web.SetHSTS(w, 365*24*time.Hour, true, true)
+func SetHSTS(w http.ResponseWriter, maxAge time.Duration, includeSubDomains bool, preload bool) error {
+	value, err := hstsValue(maxAge, includeSubDomains, preload)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Strict-Transport-Security", value)
+	return nil
+}
+
+// hstsPreloadMaxAge is the minimum max-age accepted by
+// the HSTS preload list. Note that this is longer than
+// OneYear.
+const hstsPreloadMaxAge = 365 * 24 * time.Hour
+
+func hstsValue(maxAge time.Duration, includeSubDomains bool, preload bool) (string, error) {
+	if maxAge < 0 {
+		return "", errors.New("web: HSTS max age is negative")
+	}
+	if preload && !includeSubDomains {
+		return "", errors.New("web: HSTS preload requires includeSubDomains")
+	}
+	if preload && maxAge < hstsPreloadMaxAge {
+		return "", errors.New("web: HSTS preload requires a max age of at least one year")
+	}
+
+	value := seconds("max-age", maxAge)
+	if includeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+	return value, nil
+}
+
+// SecureHeaders describes a set of HTTP headers which harden a site
+// against common attacks. Empty fields are not sent.
+//
+// The Strict-Transport-Security header is only sent for requests made
+// over TLS. If TrustProxy is true, requests with an X-Forwarded-Proto
+// HTTP header of "https" are also treated as being made over TLS, so
+// this should only be set behind a proxy which sets the header.
+//
+//	headers := web.DefaultSecureHeaders
+//	headers.ContentSecurityPolicy = "default-src 'self'"
+//	site.Use(headers.Middleware())
+type SecureHeaders struct {
+	// HSTSMaxAge, HSTSIncludeSubDomains, and HSTSPreload
+	// are used as described in SetHSTS. If HSTSMaxAge is
+	// zero, the Strict-Transport-Security header is not
+	// sent.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubDomains bool
+	HSTSPreload           bool
+
+	// ContentTypeNosniff sets X-Content-Type-Options to
+	// "nosniff", preventing MIME type sniffing.
+	ContentTypeNosniff bool
+
+	FrameOptions          string // X-Frame-Options, such as "DENY".
+	ReferrerPolicy        string // Referrer-Policy, such as "no-referrer".
+	ContentSecurityPolicy string // Content-Security-Policy. See also CSPBuilder.
+
+	// TrustProxy treats requests forwarded
+	// over HTTPS as being made over TLS.
+	TrustProxy bool
+}
+
+// DefaultSecureHeaders contains SecureHeaders suitable for most
+// sites. It does not include a Content-Security-Policy, as this
+// depends on the site's content.
+var DefaultSecureHeaders = SecureHeaders{
+	HSTSMaxAge:         365 * 24 * time.Hour,
+	ContentTypeNosniff: true,
+	FrameOptions:       "DENY",
+	ReferrerPolicy:     "strict-origin-when-cross-origin",
+}
+
+// Middleware returns Middleware which adds the headers to each
+// response. Middleware panics if the HSTS settings are invalid,
+// as described in SetHSTS.
+func (h SecureHeaders) Middleware() Middleware {
+	hsts := ""
+	if h.HSTSMaxAge != 0 {
+		var err error
+		hsts, err = hstsValue(h.HSTSMaxAge, h.HSTSIncludeSubDomains, h.HSTSPreload)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			if hsts != "" && (r.TLS != nil || (h.TrustProxy && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https"))) {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			if h.ContentTypeNosniff {
+				header.Set("X-Content-Type-Options", "nosniff")
+			}
+			if h.FrameOptions != "" {
+				header.Set("X-Frame-Options", h.FrameOptions)
+			}
+			if h.ReferrerPolicy != "" {
+				header.Set("Referrer-Policy", h.ReferrerPolicy)
+			}
+			if h.ContentSecurityPolicy != "" {
+				header.Set("Content-Security-Policy", h.ContentSecurityPolicy)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
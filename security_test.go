@@ -0,0 +1,113 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetHSTS(t *testing.T) {
+	tests := []struct {
+		maxAge            time.Duration
+		includeSubDomains bool
+		preload           bool
+		want              string
+		err               bool
+	}{
+		{time.Hour, false, false, "max-age=3600", false},
+		{0, false, false, "max-age=0", false},
+		{365 * 24 * time.Hour, true, false, "max-age=31536000; includeSubDomains", false},
+		{365 * 24 * time.Hour, true, true, "max-age=31536000; includeSubDomains; preload", false},
+		{365 * 24 * time.Hour, false, true, "", true},
+		{30 * 24 * time.Hour, true, true, "", true},
+		{-time.Second, false, false, "", true},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		err := SetHSTS(w, test.maxAge, test.includeSubDomains, test.preload)
+		if (err != nil) != test.err {
+			t.Errorf("%v %v %v: got error %v", test.maxAge, test.includeSubDomains, test.preload, err)
+		}
+		if got := w.Header().Get("Strict-Transport-Security"); got != test.want {
+			t.Errorf("%v %v %v: got %q, want %q", test.maxAge, test.includeSubDomains, test.preload, got, test.want)
+		}
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+	headers := DefaultSecureHeaders
+	headers.ContentSecurityPolicy = "default-src 'self'"
+	h := headers.Middleware()(emptyHandler)
+
+	want := map[string]string{
+		"Strict-Transport-Security": "max-age=31536000",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Content-Security-Policy":   "default-src 'self'",
+	}
+	w := serveSite(h, "GET", "https://example.com/")
+	for name, value := range want {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("%s: got %q, want %q", name, got, value)
+		}
+	}
+
+	// Empty fields are not sent.
+	h = SecureHeaders{FrameOptions: "SAMEORIGIN"}.Middleware()(emptyHandler)
+	w = serveSite(h, "GET", "https://example.com/")
+	for name := range want {
+		if got := w.Header().Get(name); name != "X-Frame-Options" && got != "" {
+			t.Errorf("%s: got %q, want no header", name, got)
+		}
+	}
+	if got := w.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options: got %q", got)
+	}
+}
+
+func TestSecureHeadersHSTSOnlyOverTLS(t *testing.T) {
+	tests := []struct {
+		trustProxy bool
+		target     string
+		proto      string
+		want       bool
+	}{
+		{false, "https://example.com/", "", true},
+		{false, "http://example.com/", "", false},
+		{false, "http://example.com/", "https", false},
+		{true, "http://example.com/", "https", true},
+		{true, "http://example.com/", "HTTPS", true},
+		{true, "http://example.com/", "http", false},
+		{true, "http://example.com/", "", false},
+	}
+	for _, test := range tests {
+		headers := DefaultSecureHeaders
+		headers.TrustProxy = test.trustProxy
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+		w := httptest.NewRecorder()
+		headers.Middleware()(emptyHandler).ServeHTTP(w, r)
+		if got := w.Header().Get("Strict-Transport-Security") != ""; got != test.want {
+			t.Errorf("TrustProxy %v, %s with X-Forwarded-Proto %q: got HSTS %v, want %v", test.trustProxy, test.target, test.proto, got, test.want)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("TrustProxy %v, %s: other headers were not sent", test.trustProxy, test.target)
+		}
+	}
+}
+
+func TestSecureHeadersInvalidHSTS(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Middleware did not panic")
+		}
+	}()
+	SecureHeaders{HSTSMaxAge: time.Hour, HSTSPreload: true}.Middleware()
+}
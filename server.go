@@ -5,21 +5,50 @@
 package web
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/SlyMarbo/spdy"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // Server serves requests for all attached sites.
 // If multiple sites use the same port, it will provide
 // a reverse proxy service.
+//
+// Graceful shutdown is provided by the Server, with Shutdown
+// and RunUntilSignal, rather than by each Site, as a Site is
+// only an http.Handler. The Server creates the underlying
+// http.Server for each port, which may be shared by several
+// sites, so it is the Server which must stop listening.
+//
+// The ShutdownTimeout field sets the time RunUntilSignal
+// allows in-flight requests to complete. If zero,
+// DefaultShutdownTimeout is used.
 type Server struct {
 	sites []*Site
+
+	ShutdownTimeout time.Duration
+
+	mu       sync.Mutex
+	servers  []*http.Server
+	cancel   context.CancelFunc // Cancels the requests' base context.
+	shutdown bool
 }
 
+// DefaultShutdownTimeout is the time RunUntilSignal allows
+// in-flight requests to complete, if the Server's
+// ShutdownTimeout is zero.
+const DefaultShutdownTimeout = 30 * time.Second
+
 // NewServer creates and initialises a Server.
 func NewServer() *Server {
 	s := new(Server)
@@ -45,13 +74,31 @@ func (s *Server) Add(site *Site) *Server {
 
 // Serve starts listening and serving requests to the
 // provided sites. Serve uses an extra goroutine for
-// each port used by its sites.
+// each port used by its sites. After Shutdown is
+// called, Serve returns http.ErrServerClosed.
 func (s *Server) Serve() error {
-	errChan := make(chan error)
+	errChan, err := s.start()
+	if err != nil {
+		return err
+	}
+
+	// Keep running until an error occurs.
+	return <-errChan
+}
+
+// start starts serving requests on each port used by
+// the sites, returning a channel which receives any
+// errors from serving.
+func (s *Server) start() (<-chan error, error) {
 	portMap := make(map[int][]*Site)
+	var listenerSites []*Site
 
 	// Collect sites by port.
 	for _, site := range s.sites {
+		if site.listener != nil || isUnixSocket(site.Name) {
+			listenerSites = append(listenerSites, site)
+			continue
+		}
 		if sites, ok := portMap[site.Port]; ok {
 			portMap[site.Port] = append(sites, site)
 		} else {
@@ -59,20 +106,43 @@ func (s *Server) Serve() error {
 		}
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return nil, http.ErrServerClosed
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	baseContext := func(net.Listener) context.Context { return ctx }
+	errChan := make(chan error, len(portMap)+len(listenerSites))
+
+	// Sites with their own listener are served separately.
+	for _, site := range listenerSites {
+		listener, err := site.listen()
+		if err != nil {
+			return nil, err
+		}
+		server := site.newServer()
+		server.BaseContext = baseContext
+		s.servers = append(s.servers, server)
+		go serveListener(server, listener, site, errChan)
+	}
+
 	// Iterate through site groups by port.
 	for port, sites := range portMap {
+		addr := fmt.Sprintf(":%d", port)
 
 		// Single sites on a port are simple.
 		if len(sites) == 1 {
 			site := sites[0]
+			server := site.newServer()
+			server.Addr = addr
+			server.BaseContext = baseContext
+			s.servers = append(s.servers, server)
 			if site.auth != nil {
-				if site.SPDY {
-					go serveSPDY(site.Port, site, site.auth[0], site.auth[1], errChan)
-				} else {
-					go serveHTTPS(site.Port, site, site.auth[0], site.auth[1], errChan)
-				}
+				go serveHTTPS(server, site.auth[0], site.auth[1], errChan)
 			} else {
-				go serveHTTP(site.Port, site, errChan)
+				go serveHTTP(server, errChan)
 			}
 		} else {
 
@@ -81,17 +151,16 @@ func (s *Server) Serve() error {
 			usingSpdy := sites[0].SPDY
 			for i := 1; i < len(sites); i++ {
 				if auth != (sites[i].auth != nil) {
-					return errors.New("Multiple sites on the same port with mixed HTTPS usage.")
+					return nil, errors.New("Multiple sites on the same port with mixed HTTPS usage.")
 				}
 				if usingSpdy != sites[i].SPDY {
-					return errors.New("Multiple sites on the same port with mixed SPDY usage.")
+					return nil, errors.New("Multiple sites on the same port with mixed SPDY usage.")
 				}
 			}
 
 			// Build reverse proxy and Server Name Identification (SNI) configuration.
-			addr := fmt.Sprintf(":%d", port)
 			proxy := NewProxy()
-			server := &http.Server{Addr: addr, Handler: proxy}
+			server := &http.Server{Addr: addr, Handler: proxy, BaseContext: baseContext}
 			if usingSpdy {
 				spdy.AddSPDY(server)
 			}
@@ -101,10 +170,14 @@ func (s *Server) Serve() error {
 			for i, site := range sites {
 				proxy.RegisterSite(site)
 				if auth {
+					if site.ConfigureTLS != nil {
+						site.ConfigureTLS(tlsConf)
+					}
+
 					// Add certificate pair if using TLS.
 					tlsConf.Certificates[i], err = tls.LoadX509KeyPair(site.auth[0], site.auth[1])
 					if err != nil {
-						return err
+						return nil, err
 					}
 				}
 			}
@@ -115,7 +188,7 @@ func (s *Server) Serve() error {
 			// Create the TCP listener.
 			listener, err := net.Listen("tcp", addr)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			// Add TLS if necessary.
@@ -126,33 +199,202 @@ func (s *Server) Serve() error {
 			}
 
 			// Start serving.
+			s.servers = append(s.servers, server)
 			go serveMany(server, listener, errChan)
 		}
 	}
 
-	// Keep running until an error occurs.
-	return <-errChan
+	return errChan, nil
 }
 
-func serveSPDY(port int, handler http.Handler, certFile, keyFile string, errChan chan<- error) {
-	addr := fmt.Sprintf(":%d", port)
-	err := spdy.ListenAndServeTLS(addr, certFile, keyFile, handler)
+// Shutdown gracefully shuts down the Server. It stops listening for
+// new connections, then waits for in-flight requests to complete, as
+// described in http.Server's Shutdown method. If the context expires
+// first, the remaining connections are closed, cancelling the requests'
+// contexts, and the context's error is returned. Once Shutdown has been
+// called, Serve returns http.ErrServerClosed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	servers, cancel := s.servers, s.cancel
+	s.mu.Unlock()
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			err := server.Shutdown(ctx)
+			if err != nil {
+				server.Close()
+			}
+			errs <- err
+		}(server)
+	}
+
+	var err error
+	for range servers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if cancel != nil {
+		cancel()
+	}
+	return err
+}
+
+// RunUntilSignal serves requests, as with Serve, until the process
+// receives one of the given signals, or os.Interrupt or syscall.SIGTERM
+// if none are given. The Server is then shut down, allowing in-flight
+// requests up to its ShutdownTimeout to complete, as described in
+// Shutdown. If serving fails first, RunUntilSignal returns the error.
+// Otherwise, it returns any error from shutting down.
+//
+//	server := web.NewServerFromSites(site)
+//	server.ShutdownTimeout = 10 * time.Second
+//	if err := server.RunUntilSignal(); err != nil {
+//		log.Fatal(err)
+//	}
+func (s *Server) RunUntilSignal(signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-received:
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Serve serves requests to the Site which are accepted by
+// the given listener, using TLS if the Site was created with
+// NewSecureSite. Serve always returns a non-nil error, and
+// closes the listener when it returns. Use a Server to shut
+// the Site down gracefully.
+//
+//	l, err := net.Listen("tcp", "127.0.0.1:0")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(site.Serve(l))
+func (s *Site) Serve(l net.Listener) error {
+	server := s.newServer()
+	if s.auth != nil {
+		return server.ServeTLS(l, s.auth[0], s.auth[1])
+	}
+	return server.Serve(l)
+}
+
+// newServer creates an http.Server which serves the Site,
+// configuring TLS if the Site is secure.
+func (s *Site) newServer() *http.Server {
+	server := &http.Server{Handler: s}
+	if s.auth != nil {
+		s.secure(server, nil)
+	}
+	return server
+}
+
+// secure sets the server's TLS configuration, starting from
+// the given configuration, if any, and applying the Site's
+// ConfigureTLS hook. SPDY is added if it is enabled.
+func (s *Site) secure(server *http.Server, config *tls.Config) {
+	if config == nil {
+		config = new(tls.Config)
+	}
+	if s.ConfigureTLS != nil {
+		s.ConfigureTLS(config)
+	}
+	server.TLSConfig = config
+	if s.SPDY {
+		spdy.AddSPDY(server)
+	}
+}
+
+// listen returns the Site's listener, or creates a unix
+// domain socket if the Site's name is a unix socket URL.
+func (s *Site) listen() (net.Listener, error) {
+	if s.listener != nil {
+		return s.listener, nil
+	}
+	mode := s.SocketMode
+	if mode == 0 {
+		mode = 0666
+	}
+	return listenUnix(strings.TrimPrefix(s.Name, unixScheme), mode)
+}
+
+const unixScheme = "unix://"
+
+// isUnixSocket returns whether the site name is a
+// unix socket URL, such as unix:///var/run/app.sock.
+func isUnixSocket(name string) bool {
+	return strings.HasPrefix(name, unixScheme)
+}
+
+// listenUnix listens on a unix domain socket at the given path,
+// with the given permissions. If a socket file already exists at
+// the path, but nothing is listening on it, the file is removed
+// first.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("web: unix socket %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func serveListener(server *http.Server, listener net.Listener, site *Site, errChan chan<- error) {
+	var err error
+	if site.auth != nil {
+		err = server.ServeTLS(listener, site.auth[0], site.auth[1])
+	} else {
+		err = server.Serve(listener)
+	}
 	if err != nil {
 		errChan <- err
 	}
 }
 
-func serveHTTPS(port int, handler http.Handler, certFile, keyFile string, errChan chan<- error) {
-	addr := fmt.Sprintf(":%d", port)
-	err := http.ListenAndServeTLS(addr, certFile, keyFile, handler)
+func serveHTTPS(server *http.Server, certFile, keyFile string, errChan chan<- error) {
+	err := server.ListenAndServeTLS(certFile, keyFile)
 	if err != nil {
 		errChan <- err
 	}
 }
 
-func serveHTTP(port int, handler http.Handler, errChan chan<- error) {
-	addr := fmt.Sprintf(":%d", port)
-	err := http.ListenAndServe(addr, handler)
+func serveHTTP(server *http.Server, errChan chan<- error) {
+	err := server.ListenAndServe()
 	if err != nil {
 		errChan <- err
 	}
@@ -0,0 +1,175 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// freePort returns a TCP port which is not in use.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startRequest sends a GET request to the port in the background,
+// returning a channel which receives the response body, or the
+// error.
+func startRequest(port int) <-chan string {
+	result := make(chan string, 1)
+	go func() {
+		url := "http://127.0.0.1:" + strconv.Itoa(port) + "/"
+		for i := 0; ; i++ {
+			res, err := http.Get(url)
+			if err != nil {
+				// Wait for the server to start listening.
+				if i < 50 {
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+				result <- err.Error()
+				return
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			result <- string(body)
+			return
+		}
+	}()
+	return result
+}
+
+// slowSite returns a Site on the port whose handler closes started,
+// then waits for the delay before responding, recording whether its
+// context was cancelled.
+func slowSite(port int, delay time.Duration, started chan<- struct{}, cancelled chan<- bool) *Site {
+	site := NewSite("localhost", port, nil)
+	site.Always(Handler(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-time.After(delay):
+			cancelled <- false
+		case <-r.Context().Done():
+			cancelled <- true
+			return
+		}
+		io.WriteString(w, "done")
+	}))
+	return site
+}
+
+func TestServerShutdownDrainsRequests(t *testing.T) {
+	port := freePort(t)
+	started, cancelled := make(chan struct{}), make(chan bool, 1)
+	server := NewServerFromSites(slowSite(port, 200*time.Millisecond, started, cancelled))
+	served := make(chan error, 1)
+	go func() { served <- server.Serve() }()
+
+	result := startRequest(port)
+	select {
+	case <-started:
+	case body := <-result:
+		t.Fatalf("request failed before reaching the handler: %s", body)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	if <-cancelled {
+		t.Error("request context was cancelled while draining")
+	}
+	if body := <-result; body != "done" {
+		t.Errorf("got response %q, want %q", body, "done")
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Serve returned %v, want http.ErrServerClosed", err)
+	}
+	if err := server.Serve(); err != http.ErrServerClosed {
+		t.Errorf("Serve after Shutdown returned %v, want http.ErrServerClosed", err)
+	}
+}
+
+func TestServerShutdownClosesAfterDeadline(t *testing.T) {
+	port := freePort(t)
+	started, cancelled := make(chan struct{}), make(chan bool, 1)
+	server := NewServerFromSites(slowSite(port, time.Minute, started, cancelled))
+	go server.Serve()
+
+	result := startRequest(port)
+	select {
+	case <-started:
+	case body := <-result:
+		t.Fatalf("request failed before reaching the handler: %s", body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown returned %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Shutdown returned after %v, before the deadline", elapsed)
+	}
+	select {
+	case wasCancelled := <-cancelled:
+		if !wasCancelled {
+			t.Error("handler finished without its context being cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request context was not cancelled after the deadline")
+	}
+}
+
+func TestServerServesSiteListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := NewSiteListener(l, nil)
+	site.Always(Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "done")
+	}))
+	server := NewServerFromSites(site)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve() }()
+
+	if body := <-startRequest(l.Addr().(*net.TCPAddr).Port); body != "done" {
+		t.Errorf("got response %q, want %q", body, "done")
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Serve returned %v, want http.ErrServerClosed", err)
+	}
+}
+
+func TestSiteServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	site := NewSite("localhost", 0, nil)
+	site.Always(Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "done")
+	}))
+	go site.Serve(l)
+
+	if body := <-startRequest(l.Addr().(*net.TCPAddr).Port); body != "done" {
+		t.Errorf("got response %q, want %q", body, "done")
+	}
+}
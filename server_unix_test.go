@@ -0,0 +1,151 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package web
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunUntilSignalDrainsRequests(t *testing.T) {
+	port := freePort(t)
+	started, cancelled := make(chan struct{}), make(chan bool, 1)
+	server := NewServerFromSites(slowSite(port, 200*time.Millisecond, started, cancelled))
+	done := make(chan error, 1)
+	go func() { done <- server.RunUntilSignal(syscall.SIGUSR1) }()
+
+	result := startRequest(port)
+	select {
+	case <-started:
+	case body := <-result:
+		t.Fatalf("request failed before reaching the handler: %s", body)
+	}
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	if body := <-result; body != "done" {
+		t.Errorf("got response %q, want %q", body, "done")
+	}
+	if <-cancelled {
+		t.Error("request context was cancelled while draining")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("RunUntilSignal returned %v", err)
+	}
+}
+
+func TestRunUntilSignalShutdownTimeout(t *testing.T) {
+	port := freePort(t)
+	started, cancelled := make(chan struct{}), make(chan bool, 1)
+	server := NewServerFromSites(slowSite(port, time.Minute, started, cancelled))
+	server.ShutdownTimeout = 100 * time.Millisecond
+	done := make(chan error, 1)
+	go func() { done <- server.RunUntilSignal(syscall.SIGUSR1) }()
+
+	result := startRequest(port)
+	select {
+	case <-started:
+	case body := <-result:
+		t.Fatalf("request failed before reaching the handler: %s", body)
+	}
+
+	start := time.Now()
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	if err := <-done; err != context.DeadlineExceeded {
+		t.Fatalf("RunUntilSignal returned %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("RunUntilSignal returned after %v, before the grace period", elapsed)
+	}
+	if !<-cancelled {
+		t.Error("handler finished without its context being cancelled")
+	}
+}
+
+// unixClient returns an http.Client which sends all
+// requests to the unix domain socket at the path.
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+
+	// Leave a stale socket file behind.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	site := NewSite("unix://"+path, 0, nil)
+	site.SocketMode = 0600
+	site.Always(Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "done")
+	}))
+	server := NewServerFromSites(site)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve() }()
+
+	var res *http.Response
+	for i := 0; ; i++ {
+		res, err = unixClient(path).Get("http://app/")
+		if err == nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "done" {
+		t.Errorf("got response %q, want %q", body, "done")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("got socket mode %v, want %v", mode, os.FileMode(0600))
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Serve returned %v, want http.ErrServerClosed", err)
+	}
+}
+
+func TestServerUnixSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	server := NewServerFromSites(NewSite("unix://"+path, 0, nil))
+	if err := server.Serve(); err == nil {
+		t.Error("Serve succeeded on a socket in use")
+	}
+}
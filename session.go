@@ -0,0 +1,403 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionTooLarge is returned by Session.Save when the
+// session's values do not fit in a cookie.
+var ErrSessionTooLarge = errors.New("web: session is too large for a cookie")
+
+// MaxSessionCookieSize is the largest Set-Cookie header value,
+// including the cookie's name and attributes, written for a
+// session. Browsers are only required to store cookies up to
+// 4096 bytes.
+const MaxSessionCookieSize = 4096
+
+// DefaultSessionMaxAge is the time for which sessions are kept
+// by Sessions created with NewSessions.
+const DefaultSessionMaxAge = 7 * 24 * time.Hour
+
+// Sessions stores sessions in cookies on the client. Each cookie
+// is signed with HMAC-SHA256, so it cannot be changed by the client,
+// and optionally encrypted with AES-GCM, so its values cannot be
+// read. Cookies which have been tampered with, or have expired, are
+// ignored, so the request has a new, empty session.
+//
+// The fields set the attributes of the session cookie, and must be
+// set before Sessions is used. MaxAge is the time for which each
+// session is kept after it was last saved.
+//
+// Sessions must be created with NewSessions.
+type Sessions struct {
+	CookieName string
+	Domain     string
+	Path       string
+	MaxAge     time.Duration
+	Secure     bool
+	SameSite   http.SameSite
+
+	// Clock is used to tell the time. If nil,
+	// the system clock is used.
+	Clock Clock
+
+	signingKey []byte
+	aead       cipher.AEAD
+}
+
+// NewSessions creates Sessions which sign cookies with the given
+// signing key, which must be at least 32 bytes long. If encryptionKey
+// is not nil, cookies are also encrypted with AES-GCM, using the key,
+// which must be 16, 24, or 32 bytes long, to select AES-128, AES-192,
+// or AES-256. Keys should be generated randomly, and kept secret.
+//
+// The cookie is named "session", with the path "/", the SameSite
+// attribute Lax, and a MaxAge of DefaultSessionMaxAge. These can be
+// changed with the Sessions' fields.
+//
+//	sessions, err := web.NewSessions(signingKey, nil)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	sessions.Secure = true
+//	site.Use(sessions.Middleware)
+func NewSessions(signingKey, encryptionKey []byte) (*Sessions, error) {
+	if len(signingKey) < 32 {
+		return nil, errors.New("web: session signing key is shorter than 32 bytes")
+	}
+
+	s := &Sessions{
+		CookieName: "session",
+		Path:       "/",
+		MaxAge:     DefaultSessionMaxAge,
+		SameSite:   http.SameSiteLaxMode,
+		signingKey: append([]byte(nil), signingKey...),
+	}
+	if encryptionKey != nil {
+		block, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return nil, errors.New("web: session encryption key must be 16, 24, or 32 bytes long")
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Session holds the values stored for a client. Its methods are
+// safe for concurrent use. Changes are only sent to the client
+// when the session is saved, either with Save, or automatically
+// by Sessions.Middleware.
+type Session struct {
+	sessions *Sessions
+
+	mu      sync.Mutex
+	values  map[string]string
+	flashes []string
+	dirty   bool
+}
+
+// sessionData is the JSON encoding of a session.
+type sessionData struct {
+	Values  map[string]string `json:"v,omitempty"`
+	Flashes []string          `json:"f,omitempty"`
+	Expires int64             `json:"e"`
+}
+
+// sessionKey is the context key for a request's Session.
+type sessionKey struct{}
+
+// Get returns the session for the request. If the request was passed
+// through the Sessions' Middleware, the same Session is returned each
+// time. Otherwise, the session is read from the request's cookie. If
+// there is no valid cookie, a new, empty session is returned.
+func (s *Sessions) Get(r *http.Request) *Session {
+	if session, ok := r.Context().Value(sessionKey{}).(*Session); ok && session.sessions == s {
+		return session
+	}
+
+	session := &Session{sessions: s, values: make(map[string]string)}
+	cookie, err := r.Cookie(s.CookieName)
+	if err != nil {
+		return session
+	}
+	var data sessionData
+	if !s.decode(cookie.Value, &data) || data.Expires <= s.now().Unix() {
+		return session
+	}
+	if data.Values != nil {
+		session.values = data.Values
+	}
+	session.flashes = data.Flashes
+	return session
+}
+
+// GetSession returns the session added to the request by
+// Sessions.Middleware, or nil if there is none.
+//
+//	session := web.GetSession(r)
+//	session.Set("user", user.ID)
+func GetSession(r *http.Request) *Session {
+	session, _ := r.Context().Value(sessionKey{}).(*Session)
+	return session
+}
+
+// Middleware creates an http.Handler which adds the request's
+// session to its context, for GetSession, then calls next. If the
+// session has been changed, it is saved before the response status
+// is written. Any error saving the session, such as when it is too
+// large, is logged. To handle the error instead, call Save before
+// writing the response.
+func (s *Sessions) Middleware(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		session := s.Get(r)
+		r = r.WithContext(context.WithValue(r.Context(), sessionKey{}, session))
+		sw := &sessionResponseWriter{ResponseWriter: w, session: session}
+		next.ServeHTTP(sw, r)
+		sw.save()
+	})
+}
+
+// Get returns the value stored for key, or
+// the empty string if there is none.
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Set stores the value for key.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	s.values[key] = value
+	s.dirty = true
+	s.mu.Unlock()
+}
+
+// Delete removes the value stored for key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.dirty = true
+	}
+	s.mu.Unlock()
+}
+
+// Clear removes all values and flash messages from the
+// session, such as when the user logs out. Once saved,
+// the client's cookie is deleted.
+func (s *Session) Clear() {
+	s.mu.Lock()
+	if len(s.values) > 0 || len(s.flashes) > 0 {
+		s.values = make(map[string]string)
+		s.flashes = nil
+		s.dirty = true
+	}
+	s.mu.Unlock()
+}
+
+// AddFlash adds a flash message to the session, which is
+// kept until it is read with Flashes, such as on the page
+// shown after a form is submitted.
+//
+//	session.AddFlash("Your changes have been saved.")
+//	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+func (s *Session) AddFlash(message string) {
+	s.mu.Lock()
+	s.flashes = append(s.flashes, message)
+	s.dirty = true
+	s.mu.Unlock()
+}
+
+// Flashes returns the session's flash messages, in the order
+// they were added, and removes them from the session.
+func (s *Session) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.flashes
+	if len(flashes) > 0 {
+		s.flashes = nil
+		s.dirty = true
+	}
+	return flashes
+}
+
+// Save sends the session to the client in a Set-Cookie header, if it
+// has been changed since it was read or last saved. It must be called
+// before the response status is written. If the session is empty, the
+// client's cookie is deleted. If the cookie would be larger than
+// MaxSessionCookieSize, no cookie is sent, and ErrSessionTooLarge is
+// returned.
+func (s *Session) Save(w http.ResponseWriter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+
+	ss := s.sessions
+	cookie := &http.Cookie{
+		Name:     ss.CookieName,
+		Domain:   ss.Domain,
+		Path:     ss.Path,
+		Secure:   ss.Secure,
+		HttpOnly: true,
+		SameSite: ss.SameSite,
+	}
+	if len(s.values) == 0 && len(s.flashes) == 0 {
+		cookie.MaxAge = -1
+	} else {
+		expires := ss.now().Add(ss.MaxAge)
+		value, err := ss.encode(sessionData{Values: s.values, Flashes: s.flashes, Expires: expires.Unix()})
+		if err != nil {
+			return err
+		}
+		cookie.Value = value
+		cookie.MaxAge = int(ss.MaxAge / time.Second)
+	}
+
+	header := cookie.String()
+	if len(header) > MaxSessionCookieSize {
+		return ErrSessionTooLarge
+	}
+	w.Header().Add("Set-Cookie", header)
+	s.dirty = false
+	return nil
+}
+
+// now returns the current time.
+func (s *Sessions) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock.Now()
+	}
+	return time.Now()
+}
+
+// encode returns the signed, and optionally
+// encrypted, cookie value for the session.
+func (s *Sessions) encode(data sessionData) (string, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = s.aead.Seal(nonce, nonce, payload, []byte(s.CookieName))
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	return value + "." + base64.RawURLEncoding.EncodeToString(s.sign(value)), nil
+}
+
+// decode reads a cookie value written by encode, reporting
+// whether it is valid.
+func (s *Sessions) decode(value string, data *sessionData) bool {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return false
+	}
+	mac, err := base64.RawURLEncoding.Strict().DecodeString(value[i+1:])
+	if err != nil || !hmac.Equal(mac, s.sign(value[:i])) {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value[:i])
+	if err != nil {
+		return false
+	}
+
+	if s.aead != nil {
+		size := s.aead.NonceSize()
+		if len(payload) < size {
+			return false
+		}
+		payload, err = s.aead.Open(nil, payload[:size], payload[size:], []byte(s.CookieName))
+		if err != nil {
+			return false
+		}
+	}
+	return json.Unmarshal(payload, data) == nil
+}
+
+// sign returns the signature of the cookie value. The
+// cookie's name is included, so a value cannot be
+// moved to another cookie.
+func (s *Sessions) sign(value string) []byte {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(s.CookieName))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// sessionResponseWriter saves the session before
+// writing the response status.
+type sessionResponseWriter struct {
+	http.ResponseWriter
+	session *Session
+	saved   bool
+}
+
+func (w *sessionResponseWriter) save() {
+	if w.saved {
+		return
+	}
+	w.saved = true
+	if err := w.session.Save(w.ResponseWriter); err != nil {
+		log.Printf("web: failed to save session: %v", err)
+	}
+}
+
+func (w *sessionResponseWriter) WriteHeader(status int) {
+	if status >= 200 || status == http.StatusSwitchingProtocols {
+		w.save()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionResponseWriter) Write(data []byte) (int, error) {
+	w.save()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sessionResponseWriter) Flush() {
+	w.save()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *sessionResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+func (w *sessionResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,219 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSessions creates Sessions with a fake clock,
+// encrypting cookies if encrypt is true.
+func newTestSessions(t *testing.T, encrypt bool) (*Sessions, *fakeClock) {
+	t.Helper()
+	var encryptionKey []byte
+	if encrypt {
+		encryptionKey = bytes.Repeat([]byte{2}, 32)
+	}
+	sessions, err := NewSessions(bytes.Repeat([]byte{1}, 32), encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)}
+	sessions.Clock = clock
+	return sessions, clock
+}
+
+// saveSession saves a session with the given values,
+// returning the cookie sent.
+func saveSession(t *testing.T, sessions *Sessions, values map[string]string) *http.Cookie {
+	t.Helper()
+	session := sessions.Get(httptest.NewRequest("GET", "/", nil))
+	for key, value := range values {
+		session.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	if err := session.Save(w); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	return cookies[0]
+}
+
+// requestWithCookie returns a request with the given cookie.
+func requestWithCookie(cookie *http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	return r
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		sessions, _ := newTestSessions(t, encrypt)
+		cookie := saveSession(t, sessions, map[string]string{"user": "alice"})
+		payload, _ := base64.RawURLEncoding.DecodeString(strings.Split(cookie.Value, ".")[0])
+		if encrypt == bytes.Contains(payload, []byte("alice")) {
+			t.Errorf("encrypt=%v: got cookie payload %q", encrypt, payload)
+		}
+
+		session := sessions.Get(requestWithCookie(cookie))
+		if got := session.Get("user"); got != "alice" {
+			t.Errorf("encrypt=%v: got user %q, want %q", encrypt, got, "alice")
+		}
+	}
+}
+
+func TestSessionTampered(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		sessions, _ := newTestSessions(t, encrypt)
+		cookie := saveSession(t, sessions, map[string]string{"user": "alice"})
+
+		value := []byte(cookie.Value)
+		for _, i := range []int{0, len(value) / 2, len(value) - 1} {
+			tampered := *cookie
+			changed := append([]byte(nil), value...)
+			changed[i] ^= 1
+			tampered.Value = string(changed)
+			if got := sessions.Get(requestWithCookie(&tampered)).Get("user"); got != "" {
+				t.Errorf("encrypt=%v: tampered cookie gave user %q", encrypt, got)
+			}
+		}
+
+		// A cookie signed with another key is rejected.
+		other, err := NewSessions(bytes.Repeat([]byte{3}, 32), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := other.Get(requestWithCookie(cookie)).Get("user"); got != "" {
+			t.Errorf("encrypt=%v: cookie with another key gave user %q", encrypt, got)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	sessions, clock := newTestSessions(t, false)
+	sessions.MaxAge = time.Hour
+	cookie := saveSession(t, sessions, map[string]string{"user": "alice"})
+	if cookie.MaxAge != 3600 {
+		t.Errorf("got Max-Age %d, want 3600", cookie.MaxAge)
+	}
+
+	clock.now = clock.now.Add(59 * time.Minute)
+	if got := sessions.Get(requestWithCookie(cookie)).Get("user"); got != "alice" {
+		t.Errorf("got user %q before expiry, want %q", got, "alice")
+	}
+	clock.now = clock.now.Add(time.Minute)
+	if got := sessions.Get(requestWithCookie(cookie)).Get("user"); got != "" {
+		t.Errorf("got user %q after expiry, want none", got)
+	}
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	for _, test := range []struct {
+		sameSite http.SameSite
+		want     string
+	}{
+		{http.SameSiteLaxMode, "SameSite=Lax"},
+		{http.SameSiteStrictMode, "SameSite=Strict"},
+		{http.SameSiteNoneMode, "SameSite=None"},
+	} {
+		sessions, _ := newTestSessions(t, false)
+		sessions.SameSite = test.sameSite
+		sessions.Secure = true
+		sessions.Domain = "example.com"
+		session := sessions.Get(httptest.NewRequest("GET", "/", nil))
+		session.Set("user", "alice")
+		w := httptest.NewRecorder()
+		if err := session.Save(w); err != nil {
+			t.Fatal(err)
+		}
+
+		header := w.Header().Get("Set-Cookie")
+		for _, attr := range []string{test.want, "HttpOnly", "Secure", "Path=/", "Domain=example.com"} {
+			if !strings.Contains(header, "; "+attr) {
+				t.Errorf("Set-Cookie %q does not contain %q", header, attr)
+			}
+		}
+	}
+}
+
+func TestSessionSaveOnlyWhenDirty(t *testing.T) {
+	sessions, _ := newTestSessions(t, false)
+	cookie := saveSession(t, sessions, map[string]string{"user": "alice"})
+
+	session := sessions.Get(requestWithCookie(cookie))
+	w := httptest.NewRecorder()
+	session.Get("user")
+	session.Save(w)
+	if got := w.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("unchanged session sent Set-Cookie %q", got)
+	}
+
+	session.Clear()
+	session.Save(w)
+	if got := w.Result().Cookies(); len(got) != 1 || got[0].MaxAge >= 0 {
+		t.Errorf("cleared session sent cookies %v, want one deleting the session", got)
+	}
+}
+
+func TestSessionTooLarge(t *testing.T) {
+	sessions, _ := newTestSessions(t, false)
+	session := sessions.Get(httptest.NewRequest("GET", "/", nil))
+	session.Set("data", strings.Repeat("x", MaxSessionCookieSize))
+	w := httptest.NewRecorder()
+	if err := session.Save(w); err != ErrSessionTooLarge {
+		t.Errorf("Save returned %v, want ErrSessionTooLarge", err)
+	}
+	if got := w.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("oversized session sent Set-Cookie %q", got)
+	}
+}
+
+func TestSessionMiddlewareFlashes(t *testing.T) {
+	sessions, _ := newTestSessions(t, true)
+	var flashes []string
+	h := sessions.Middleware(Handler(func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		if r.Method == "POST" {
+			session.AddFlash("saved")
+			session.AddFlash("again")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		flashes = session.Flashes()
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	cookie := w.Result().Cookies()[0]
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, requestWithCookie(cookie))
+	if want := []string{"saved", "again"}; !reflect.DeepEqual(flashes, want) {
+		t.Errorf("got flashes %q, want %q", flashes, want)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Fatalf("reading flashes sent cookies %v, want one deleting the session", cookies)
+	}
+}
+
+func TestNewSessionsKeys(t *testing.T) {
+	if _, err := NewSessions(make([]byte, 16), nil); err == nil {
+		t.Error("NewSessions accepted a short signing key")
+	}
+	if _, err := NewSessions(make([]byte, 32), make([]byte, 20)); err == nil {
+		t.Error("NewSessions accepted an invalid encryption key")
+	}
+}
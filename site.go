@@ -5,8 +5,14 @@
 package web
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -21,19 +27,73 @@ import (
 // will still accept HTTPS requests, in addition to SPDY. This
 // can only be enabled on secure sites.
 //
-// Site must be created with NewSite or NewSecureSite.
+// If the CaseInsensitive field is set to true, request paths are
+// matched in lower case, and patterns registered with the Site's
+// methods are compared case-insensitively. This must be set before
+// any handlers are registered. Handlers still see the original
+// request path, but parameters are captured in lower case, and any
+// MatchFunc is passed the lower case path.
+//
+// If the Debug field is set to true, responses are formatted to
+// make them easier to read, such as by indenting JSON sent with
+// the JSON function.
+//
+// The ErrorHandler field can be set to control how errors sent
+// with Fail are presented. If nil, DefaultErrorHandler is used.
+//
+// If the RecoverPanics field is set to true, panics in the Site's
+// handlers are recovered, as with Recover. The Recoverer field can
+// be set to control how panics are reported and the response sent.
+//
+// The ConfigureTLS field can be set to customise the TLS
+// configuration used by a secure Site, such as to set the minimum
+// TLS version or the cipher suites. Sites sharing a port share one
+// configuration, to which each Site's ConfigureTLS is applied in
+// turn.
+//
+// The HTTPRedirectPort field is used by ServeAutocert, to listen
+// for ACME challenges and redirect other requests to HTTPS.
+//
+// A Site whose name has the form "unix:///path/to/socket" listens
+// on a unix domain socket at that path, rather than a TCP port. Any
+// stale socket file is removed before listening, and the socket's
+// permissions are set from the SocketMode field, or 0666 if zero.
+//
+// Site must be created with NewSite, NewSecureSite or NewSiteListener.
 type Site struct {
-	Name     string
-	Port     int
-	SPDY     bool
-	auth     []string
-	handlers []*Matcher
-	notFound Handler
+	Name       string
+	Port       int
+	SPDY       bool
+	SocketMode os.FileMode
+	auth       []string
+	listener   net.Listener
+	handlers   []route
+	prefixes   *prefixRouter
+	patterns   *patternRouter
+	methods    *methodRouter
+	notFound   http.Handler
+	chain      Chain
+
+	CaseInsensitive  bool
+	Debug            bool
+	ErrorHandler     func(w http.ResponseWriter, r *http.Request, e *Error)
+	RecoverPanics    bool
+	Recoverer        Recoverer
+	ConfigureTLS     func(*tls.Config)
+	HTTPRedirectPort int
+	noAutoOptions    bool
+	slashPolicy      SlashPolicy
 }
 
+// NotFoundHandler is used by a Site when a request path does not
+// match any handlers, unless another handler has been provided. It
+// sends a plain 404 Not Found response, so can be used by custom
+// handlers to delegate to the default behaviour.
+var NotFoundHandler = Handler(http.NotFound)
+
 // NewSite builds a new HTTP Site, using the given domain name
 // and port number. The provided handler is called when a request
-// path does not match any handlers. If nil, http.NotFoundHandler
+// path does not match any handlers. If nil, NotFoundHandler
 // is used instead.
 //
 //		// http://example.com
@@ -41,19 +101,42 @@ type Site struct {
 //
 //		// http://example.com:8080
 //		site := NewSite("example.com", 8080, nil)
+//
+//		// A unix domain socket, used by a local reverse proxy.
+//		site := NewSite("unix:///var/run/app.sock", 0, nil)
 func NewSite(name string, port int, notFound Handler) *Site {
-	return &Site{
+	s := &Site{
 		Name:     name,
 		Port:     port,
-		handlers: make([]*Matcher, 0, 1),
-		notFound: notFound,
+		handlers: make([]route, 0, 1),
 	}
+	if notFound != nil {
+		s.notFound = notFound
+	}
+	return s
+}
+
+// NewSiteListener builds a new HTTP Site, which serves requests
+// accepted by the given listener, rather than listening on a port.
+// This allows a Site to use a unix domain socket, or a random port
+// in tests. The provided handler is called when a request path does
+// not match any handlers. If nil, NotFoundHandler is used instead.
+//
+//		l, err := net.Listen("tcp", "127.0.0.1:0")
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		site := NewSiteListener(l, nil)
+func NewSiteListener(l net.Listener, notFound Handler) *Site {
+	s := NewSite(l.Addr().String(), 0, notFound)
+	s.listener = l
+	return s
 }
 
 // NewSite builds a new HTTPS Site, using the given domain name,
 // port number, and certificate files. The provided handler is
 // called when a request path does not match any handlers. If
-// nil, http.NotFoundHandler is used instead.
+// nil, NotFoundHandler is used instead.
 //
 //		// https://example.com
 //		site := NewSecureSite("example.com", 443, "cert.pem", "key.pem", nil)
@@ -61,13 +144,29 @@ func NewSite(name string, port int, notFound Handler) *Site {
 //		// https://example.com:8080
 //		site := NewSecureSite("example.com", 8080, "cert.pem", "key.pem", nil)
 func NewSecureSite(name string, port int, certFile, keyFile string, notFound Handler) *Site {
-	return &Site{
+	s := &Site{
 		Name:     name,
 		Port:     port,
 		auth:     []string{certFile, keyFile},
-		handlers: make([]*Matcher, 0, 1),
-		notFound: notFound,
+		handlers: make([]route, 0, 1),
 	}
+	if notFound != nil {
+		s.notFound = notFound
+	}
+	return s
+}
+
+// SetNotFound sets the handler called when a request path does not
+// match any handlers. The handler receives the original request and
+// is responsible for writing the response status. If nil,
+// NotFoundHandler is used.
+//
+//	site.SetNotFound(web.Handler(func(w http.ResponseWriter, r *http.Request) {
+//		log.Printf("not found: %s", r.URL)
+//		web.NotFoundHandler.ServeHTTP(w, r)
+//	}))
+func (s *Site) SetNotFound(handler http.Handler) {
+	s.notFound = handler
 }
 
 // Always uses the given handler for any request.
@@ -80,7 +179,7 @@ func (s *Site) Always(handler http.Handler) {
 // any of the given pattern strings.
 func (s *Site) Contains(handler http.Handler, patterns ...string) {
 	for _, pattern := range patterns {
-		matchFunc := makeMatchFunc(pattern, strings.Contains)
+		matchFunc := makeMatchFunc(s.fold(pattern), strings.Contains)
 		s.handlers = append(s.handlers, &Matcher{matchFunc, handler})
 	}
 }
@@ -89,7 +188,7 @@ func (s *Site) Contains(handler http.Handler, patterns ...string) {
 // as any of the given pattern strings.
 func (s *Site) Equals(handler http.Handler, patterns ...string) {
 	for _, pattern := range patterns {
-		matchFunc := makeMatchFunc(pattern, stringEquals)
+		matchFunc := makeMatchFunc(s.fold(pattern), stringEquals)
 		s.handlers = append(s.handlers, &Matcher{matchFunc, handler})
 	}
 }
@@ -105,10 +204,23 @@ func (s *Site) EqualFold(handler http.Handler, patterns ...string) {
 
 // HasPrefix uses the given handler when the request path starts with
 // any of the given pattern strings.
+//
+// Unlike the other methods, the prefixes registered with HasPrefix
+// are compared with each other, rather than tried in order, so the
+// longest matching prefix is used. Together, they are tried at the
+// position of the first call to HasPrefix.
+//
+//	// Requests to /static/js/app.js use serveScript, and
+//	// requests to /static/style.css use serveFile.
+//	site.HasPrefix(web.UsePrefix("files", serveFile), "/static/")
+//	site.HasPrefix(web.UsePrefix("scripts", serveScript), "/static/js/")
 func (s *Site) HasPrefix(handler http.Handler, patterns ...string) {
+	if s.prefixes == nil {
+		s.prefixes = new(prefixRouter)
+		s.handlers = append(s.handlers, s.prefixes)
+	}
 	for _, pattern := range patterns {
-		matchFunc := makeMatchFunc(pattern, strings.HasPrefix)
-		s.handlers = append(s.handlers, &Matcher{matchFunc, handler})
+		s.prefixes.add(s.fold(pattern), handler)
 	}
 }
 
@@ -116,7 +228,7 @@ func (s *Site) HasPrefix(handler http.Handler, patterns ...string) {
 // any of the given pattern strings.
 func (s *Site) HasSuffix(handler http.Handler, patterns ...string) {
 	for _, pattern := range patterns {
-		matchFunc := makeMatchFunc(pattern, strings.HasSuffix)
+		matchFunc := makeMatchFunc(s.fold(pattern), strings.HasSuffix)
 		s.handlers = append(s.handlers, &Matcher{matchFunc, handler})
 	}
 }
@@ -125,12 +237,165 @@ func (s *Site) HasSuffix(handler http.Handler, patterns ...string) {
 // any of the given regex pattern strings.
 func (s *Site) UseRegex(handler http.Handler, patterns ...string) {
 	for _, pattern := range patterns {
-		regex := regexp.MustCompile(pattern)
+		regex := regexp.MustCompile(s.foldRegex(pattern))
 		matchFunc := regex.MatchString
 		s.handlers = append(s.handlers, &Matcher{matchFunc, handler})
 	}
 }
 
+// Matches uses the given handler when the entire request path matches
+// any of the given regex pattern strings. The values of any named
+// capture groups can be retrieved with Param. Matches panics if a
+// pattern cannot be compiled.
+//
+//	// Requests to /img/640x480/cat.png call serveImage, with
+//	// web.Param(r, "width") returning "640".
+//	site.Matches(web.Handler(serveImage), `/img/(?P<width>\d+)x(?P<height>\d+)/.+\.png`)
+func (s *Site) Matches(handler http.Handler, patterns ...string) {
+	for _, pattern := range patterns {
+		regex := regexp.MustCompile(s.foldRegex(`^(?:` + pattern + `)$`))
+		s.handlers = append(s.handlers, &regexRoute{regex, handler})
+	}
+}
+
+// Pattern uses the given handler when the request path matches any
+// of the given patterns. Each pattern is a sequence of path segments,
+// which must match exactly, except for those starting with ":", which
+// match any non-empty segment, and a final segment starting with "*",
+// which matches the remainder of the path. The request path is split
+// into segments before they are decoded, so an encoded slash (%2F)
+// does not end a segment. The matched values, which are decoded, can
+// be retrieved with Param.
+//
+// Like HasPrefix, the patterns are compared with each other rather
+// than tried in order, and are tried at the position of the first call
+// to Pattern. Where patterns overlap, static segments are preferred over
+// parameters, which are preferred over wildcards. Pattern panics if
+// a pattern is invalid or has already been registered.
+//
+//	// Requests to /users/123/posts/hello call serveUser,
+//	// with web.Param(r, "id") returning "123", and
+//	// web.Param(r, "post") returning "hello".
+//	site.Pattern(web.Handler(serveUser), "/users/:id/posts/:post")
+//
+//	// Requests to /users/new call newUser instead.
+//	site.Pattern(web.Handler(newUser), "/users/new")
+//
+//	// Requests to /files/a/b.txt call serveFile, with
+//	// web.Param(r, "path") returning "a/b.txt".
+//	site.Pattern(web.Handler(serveFile), "/files/*path")
+func (s *Site) Pattern(handler http.Handler, patterns ...string) {
+	if s.patterns == nil {
+		s.patterns = new(patternRouter)
+		s.handlers = append(s.handlers, s.patterns)
+	}
+	for _, pattern := range patterns {
+		s.patterns.add(pattern, handler, s.CaseInsensitive)
+	}
+}
+
+// Method uses the given handler for requests with the given method
+// when the request path matches any of the given patterns, which use
+// the same syntax as Pattern.
+//
+// If a request path matches a pattern but no handler has been
+// registered for its method, a 405 Method Not Allowed response is
+// sent, with an Allow header listing the registered methods. HEAD
+// requests use the GET handler if no HEAD handler is registered,
+// with the response body discarded. Similarly, OPTIONS requests
+// receive a 204 No Content response with an Allow header if no
+// OPTIONS handler is registered, unless disabled with
+// SetAutoOptions.
+//
+// Like Pattern, the patterns of all calls to Method are compared with
+// each other, and are tried at the position of the first call to
+// Method. Method panics if a pattern is invalid, if the method has
+// already been registered for the pattern, or if the same path is
+// registered using a different parameter name.
+//
+//	site.Method("GET", web.Handler(getUser), "/users/:id")
+//	site.Method("DELETE", web.Handler(deleteUser), "/users/:id")
+func (s *Site) Method(method string, handler http.Handler, patterns ...string) {
+	if s.methods == nil {
+		s.methods = &methodRouter{site: s}
+		s.handlers = append(s.handlers, s.methods)
+	}
+	for _, pattern := range patterns {
+		s.methods.add(method, pattern, handler)
+	}
+}
+
+// Use adds middleware which is applied to every handler the site
+// dispatches a request to, including handlers registered before
+// the call to Use. The middleware are applied after the request
+// has been matched to a handler, in the order they were added,
+// so the first middleware sees each request first. The not-found
+// handler is not wrapped.
+//
+//	site.Use(requireAuth, logRequests)
+func (s *Site) Use(middleware ...Middleware) {
+	s.chain = append(s.chain, middleware...)
+}
+
+// SetAutoOptions determines whether OPTIONS requests to paths
+// registered with Method are answered automatically. This is
+// enabled by default.
+func (s *Site) SetAutoOptions(enabled bool) {
+	s.noAutoOptions = !enabled
+}
+
+// SetSlashPolicy determines how request paths which do not match
+// any handlers, but would with a trailing slash added or removed,
+// are handled. The root path is never redirected. By default, the
+// Strict policy is used.
+//
+//	// Requests to /about/ are redirected to /about.
+//	site.Equals(web.Handler(serveAbout), "/about")
+//	site.SetSlashPolicy(web.RedirectTrailingSlash)
+func (s *Site) SetSlashPolicy(policy SlashPolicy) {
+	s.slashPolicy = policy
+}
+
+// Get is shorthand for Method("GET", handler, patterns...).
+func (s *Site) Get(handler http.Handler, patterns ...string) {
+	s.Method("GET", handler, patterns...)
+}
+
+// Post is shorthand for Method("POST", handler, patterns...).
+func (s *Site) Post(handler http.Handler, patterns ...string) {
+	s.Method("POST", handler, patterns...)
+}
+
+// Put is shorthand for Method("PUT", handler, patterns...).
+func (s *Site) Put(handler http.Handler, patterns ...string) {
+	s.Method("PUT", handler, patterns...)
+}
+
+// Delete is shorthand for Method("DELETE", handler, patterns...).
+func (s *Site) Delete(handler http.Handler, patterns ...string) {
+	s.Method("DELETE", handler, patterns...)
+}
+
+// Patch is shorthand for Method("PATCH", handler, patterns...).
+func (s *Site) Patch(handler http.Handler, patterns ...string) {
+	s.Method("PATCH", handler, patterns...)
+}
+
+// Mount passes requests whose path is the given prefix, or starts
+// with the prefix followed by a slash, to the given site. The sub-site
+// sees the request path with the prefix removed, so requests to the
+// prefix itself have the path "/". The removed prefix can be retrieved
+// with MountPrefix.
+//
+//	api := web.NewSite("example.com", 443, notFound)
+//	api.Equals(web.Handler(listUsers), "/users")
+//
+//	// Requests to /api/users call listUsers.
+//	site.Mount("/api", api)
+func (s *Site) Mount(prefix string, sub *Site) {
+	s.handlers = append(s.handlers, &mountRoute{s.fold(strings.TrimSuffix(prefix, "/")), sub})
+}
+
 // Match uses the given handler when the given pattern returns true
 // when called with the request path.
 func (s *Site) Match(handler http.Handler, matchFunc MatchFunc) {
@@ -139,14 +404,150 @@ func (s *Site) Match(handler http.Handler, matchFunc MatchFunc) {
 
 // ServeHTTP allows Site to fulfil the http.Handler interface.
 func (s *Site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	for _, handler := range s.handlers {
-		if handler.Match(path) {
-			handler.Handler.ServeHTTP(w, r)
+	if s.RecoverPanics {
+		s.Recoverer.Handler(Handler(s.serve)).ServeHTTP(w, r)
+		return
+	}
+	s.serve(w, r)
+}
+
+// serve dispatches the request to the matching handler.
+func (s *Site) serve(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(context.WithValue(r.Context(), siteKey{}, s))
+	if s.Debug {
+		w = &debugResponseWriter{ResponseWriter: w}
+	}
+	path, escaped := r.URL.Path, r.URL.EscapedPath()
+	if s.CaseInsensitive {
+		path, escaped = strings.ToLower(path), strings.ToLower(escaped)
+	}
+	if handler := s.find(path, escaped); handler != nil {
+		s.chain.Then(handler).ServeHTTP(w, r)
+		return
+	}
+
+	switch s.slashPolicy {
+	case StripTrailingSlash:
+		if alt := toggleTrailingSlash(path); strings.HasSuffix(path, "/") && alt != "" {
+			if handler := s.find(alt, toggleTrailingSlash(escaped)); handler != nil {
+				s.chain.Then(handler).ServeHTTP(w, r)
+				return
+			}
+		}
+	case RedirectTrailingSlash:
+		if alt := toggleTrailingSlash(path); alt != "" && s.find(alt, toggleTrailingSlash(escaped)) != nil {
+			redirectSlash(w, r, alt)
 			return
 		}
 	}
-	s.notFound(w, r)
+
+	s.serveNotFound(w, r)
+}
+
+// serveNotFound sends the Site's 404 response.
+func (s *Site) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	if s.notFound != nil {
+		s.notFound.ServeHTTP(w, r)
+	} else {
+		NotFoundHandler.ServeHTTP(w, r)
+	}
+}
+
+// debugResponseWriter marks responses sent by
+// a Site whose Debug field is set.
+type debugResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *debugResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *debugResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+func (w *debugResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// debugging reports whether the response is being
+// sent by a Site whose Debug field is set. Wrapped
+// ResponseWriters are checked if they have an Unwrap
+// method, as used by http.ResponseController.
+func debugging(w http.ResponseWriter) bool {
+	for {
+		switch rw := w.(type) {
+		case *debugResponseWriter:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// siteKey is the context key for the Site serving a request.
+type siteKey struct{}
+
+// requestSite returns the Site serving the request, or
+// nil if it is not being served by a Site.
+func requestSite(r *http.Request) *Site {
+	s, _ := r.Context().Value(siteKey{}).(*Site)
+	return s
+}
+
+// notFound sends the 404 response of the Site serving
+// the request, or uses NotFoundHandler if there is none.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	if s := requestSite(r); s != nil {
+		s.serveNotFound(w, r)
+		return
+	}
+	NotFoundHandler.ServeHTTP(w, r)
+}
+
+// fold converts a pattern to lower case
+// if the site is case-insensitive.
+func (s *Site) fold(pattern string) string {
+	if s.CaseInsensitive {
+		return strings.ToLower(pattern)
+	}
+	return pattern
+}
+
+// foldRegex makes a regex pattern case-insensitive
+// if the site is case-insensitive.
+func (s *Site) foldRegex(pattern string) string {
+	if s.CaseInsensitive {
+		return "(?i)" + pattern
+	}
+	return pattern
+}
+
+// find returns the first handler matching the path, or nil.
+func (s *Site) find(path, escaped string) http.Handler {
+	for _, m := range s.handlers {
+		if handler := m.route(path, escaped); handler != nil {
+			return handler
+		}
+	}
+	return nil
+}
+
+// route is used to find the handler for a request path.
+type route interface {
+	// route returns the handler for the path, or nil if the
+	// path does not match. The path is given both decoded and
+	// escaped, as returned by url.URL's EscapedPath method.
+	route(path, escaped string) http.Handler
 }
 
 // Matcher is used to detect and supply an http.Handler.
@@ -155,9 +556,44 @@ type Matcher struct {
 	Handler http.Handler
 }
 
+func (m *Matcher) route(path, _ string) http.Handler {
+	if m.Match(path) {
+		return m.Handler
+	}
+	return nil
+}
+
 // MatchFunc is used to identify desired request paths.
 type MatchFunc func(string) bool
 
+// prefixRouter matches the longest of its prefixes.
+type prefixRouter struct {
+	prefixes []prefixHandler // Longest first.
+}
+
+type prefixHandler struct {
+	prefix  string
+	handler http.Handler
+}
+
+func (p *prefixRouter) add(prefix string, handler http.Handler) {
+	i := sort.Search(len(p.prefixes), func(i int) bool {
+		return len(p.prefixes[i].prefix) < len(prefix)
+	})
+	p.prefixes = append(p.prefixes, prefixHandler{})
+	copy(p.prefixes[i+1:], p.prefixes[i:])
+	p.prefixes[i] = prefixHandler{prefix, handler}
+}
+
+func (p *prefixRouter) route(path, _ string) http.Handler {
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(path, prefix.prefix) {
+			return prefix.handler
+		}
+	}
+	return nil
+}
+
 func makeMatchFunc(pattern string, m func(string, string) bool) MatchFunc {
 	return func(path string) bool {
 		return m(path, pattern)
@@ -0,0 +1,461 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// namedHandler writes its name as the response body.
+func namedHandler(name string) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	})
+}
+
+func serveSite(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestHasPrefixLongestMatch(t *testing.T) {
+	// The longest prefix wins, whatever the registration order.
+	for _, reversed := range []bool{false, true} {
+		site := NewSite("example.com", 80, nil)
+		registrations := []struct{ name, prefix string }{
+			{"static", "/static/"},
+			{"js", "/static/js/"},
+			{"vendor", "/static/js/vendor/"},
+		}
+		if reversed {
+			for i, j := 0, len(registrations)-1; i < j; i, j = i+1, j-1 {
+				registrations[i], registrations[j] = registrations[j], registrations[i]
+			}
+		}
+		for _, reg := range registrations {
+			site.HasPrefix(namedHandler(reg.name), reg.prefix)
+		}
+
+		tests := []struct {
+			path, want string
+		}{
+			{"/static/style.css", "static"},
+			{"/static/js/app.js", "js"},
+			{"/static/js/vendor/lib.js", "vendor"},
+			{"/static/jsx/app.js", "static"},
+			{"/static/", "static"},
+			{"/static", "404 page not found\n"},
+			{"/other", "404 page not found\n"},
+		}
+		for _, test := range tests {
+			if got := serveSite(site, "GET", test.path).Body.String(); got != test.want {
+				t.Errorf("reversed %v: %s got %q, want %q", reversed, test.path, got, test.want)
+			}
+		}
+	}
+}
+
+func TestHasPrefixPosition(t *testing.T) {
+	// Prefixes are tried at the position of the first call
+	// to HasPrefix, so earlier handlers take precedence.
+	site := NewSite("example.com", 80, nil)
+	site.Equals(namedHandler("equals"), "/static/js/special.js")
+	site.HasPrefix(namedHandler("static"), "/static/")
+	site.HasSuffix(namedHandler("suffix"), ".js")
+	site.HasPrefix(namedHandler("js"), "/static/js/")
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/static/js/special.js", "equals"},
+		{"/static/js/app.js", "js"},
+		{"/static/app.js", "static"},
+		{"/other/app.js", "suffix"},
+	}
+	for _, test := range tests {
+		if got := serveSite(site, "GET", test.path).Body.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestHasPrefixUsePrefix(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.HasPrefix(UsePrefix("files", func(w http.ResponseWriter, r *http.Request, name string) {
+		io.WriteString(w, name)
+	}), "/static/")
+
+	if got := serveSite(site, "GET", "/static/css/site.css").Body.String(); got != "files/static/css/site.css" {
+		t.Errorf("got name %q, want %q", got, "files/static/css/site.css")
+	}
+}
+
+// paramHandler writes its name and the values
+// of the named parameters.
+func paramHandler(name string, params ...string) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+		for _, param := range params {
+			io.WriteString(w, " "+param+"="+Param(r, param))
+		}
+	})
+}
+
+func TestPatternPrecedence(t *testing.T) {
+	// Registration order does not matter.
+	for _, reversed := range []bool{false, true} {
+		site := NewSite("example.com", 80, nil)
+		registrations := []struct {
+			handler http.Handler
+			pattern string
+		}{
+			{paramHandler("user", "id"), "/users/:id"},
+			{paramHandler("new"), "/users/new"},
+			{paramHandler("files", "path"), "/users/*path"},
+			{paramHandler("post", "id", "post"), "/users/:id/posts/:post"},
+			{paramHandler("latest", "id"), "/users/:id/posts/latest"},
+		}
+		if reversed {
+			for i, j := 0, len(registrations)-1; i < j; i, j = i+1, j-1 {
+				registrations[i], registrations[j] = registrations[j], registrations[i]
+			}
+		}
+		for _, reg := range registrations {
+			site.Pattern(reg.handler, reg.pattern)
+		}
+
+		tests := []struct {
+			path, want string
+		}{
+			{"/users/new", "new"},
+			{"/users/123", "user id=123"},
+			{"/users/new/posts/hello", "post id=new post=hello"},
+			{"/users/123/posts/latest", "latest id=123"},
+			{"/users/123/posts/hello", "post id=123 post=hello"},
+			{"/users/123/posts", "files path=123/posts"},
+			{"/users/123/posts/hello/extra", "files path=123/posts/hello/extra"},
+			{"/users/", "files path="},
+			{"/users", "404 page not found\n"},
+		}
+		for _, test := range tests {
+			if got := serveSite(site, "GET", test.path).Body.String(); got != test.want {
+				t.Errorf("reversed %v: %s got %q, want %q", reversed, test.path, got, test.want)
+			}
+		}
+	}
+}
+
+func TestPatternTrailingSegments(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Pattern(paramHandler("user", "id"), "/users/:id")
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/users/123", "user id=123"},
+		{"/users/123/", "404 page not found\n"},
+		{"/users/123/edit", "404 page not found\n"},
+		{"/users/", "404 page not found\n"},
+	}
+	for _, test := range tests {
+		if got := serveSite(site, "GET", test.path).Body.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestPatternEscapedSegments(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Pattern(paramHandler("file", "name"), "/files/:name")
+	site.Pattern(paramHandler("nested", "dir", "name"), "/files/:dir/:name")
+	site.Pattern(paramHandler("space"), "/a b")
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/files/report.pdf", "file name=report.pdf"},
+		{"/files/a%2Fb", "file name=a/b"},
+		{"/files/a%2fb", "file name=a/b"},
+		{"/files/a/b", "nested dir=a name=b"},
+		{"/files/hello%20world", "file name=hello world"},
+		{"/files/100%25", "file name=100%"},
+		{"/a%20b", "space"},
+	}
+	for _, test := range tests {
+		if got := serveSite(site, "GET", test.path).Body.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Matches(paramHandler("image", "width", "height"), `/img/(?P<width>\d+)x(?P<height>\d+)/.+\.png`)
+	site.Matches(paramHandler("year", "year"), `/archive/(?P<year>\d{4})`, `/old/(?P<year>\d{4})`)
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/img/640x480/cat.png", "image width=640 height=480"},
+		{"/img/640x480/cat.png.bak", "404 page not found\n"},
+		{"/prefix/img/640x480/cat.png", "404 page not found\n"},
+		{"/archive/2013", "year year=2013"},
+		{"/old/2012", "year year=2012"},
+		{"/archive/20133", "404 page not found\n"},
+	}
+	for _, test := range tests {
+		if got := serveSite(site, "GET", test.path).Body.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("invalid pattern did not panic")
+		}
+	}()
+	site.Matches(namedHandler("bad"), `/img/(\d+`)
+}
+
+// emptyHandler writes nothing, so benchmarks
+// measure routing alone.
+var emptyHandler = Handler(func(w http.ResponseWriter, r *http.Request) {})
+
+// benchmarkRoutes registers ten routes with register,
+// then routes requests to the last one.
+func benchmarkRoutes(b *testing.B, register func(site *Site, i int), path string) {
+	site := NewSite("example.com", 80, nil)
+	for i := 0; i < 10; i++ {
+		register(site, i)
+	}
+	r := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		site.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkRouteEquals(b *testing.B) {
+	benchmarkRoutes(b, func(site *Site, i int) {
+		site.Equals(emptyHandler, fmt.Sprintf("/section%d/page", i))
+	}, "/section9/page")
+}
+
+func BenchmarkRouteHasSuffix(b *testing.B) {
+	benchmarkRoutes(b, func(site *Site, i int) {
+		site.HasSuffix(emptyHandler, fmt.Sprintf(".ext%d", i))
+	}, "/section/page.ext9")
+}
+
+func BenchmarkRouteHasPrefix(b *testing.B) {
+	benchmarkRoutes(b, func(site *Site, i int) {
+		site.HasPrefix(emptyHandler, fmt.Sprintf("/section%d/", i))
+	}, "/section9/page")
+}
+
+func BenchmarkRoutePattern(b *testing.B) {
+	benchmarkRoutes(b, func(site *Site, i int) {
+		site.Pattern(emptyHandler, fmt.Sprintf("/section%d/:page", i))
+	}, "/section9/page")
+}
+
+func BenchmarkRouteMatches(b *testing.B) {
+	benchmarkRoutes(b, func(site *Site, i int) {
+		site.Matches(emptyHandler, fmt.Sprintf(`/section%d/\w+`, i))
+	}, "/section9/page")
+}
+
+func BenchmarkRouteMatchesCaptures(b *testing.B) {
+	benchmarkRoutes(b, func(site *Site, i int) {
+		site.Matches(emptyHandler, fmt.Sprintf(`/section%d/(?P<page>\w+)`, i))
+	}, "/section9/page")
+}
+
+func TestMethodAllow(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Get(namedHandler("get"), "/users/:id")
+	site.Delete(namedHandler("delete"), "/users/:id")
+	site.Post(namedHandler("create"), "/users")
+
+	tests := []struct {
+		method, path string
+		want         int
+		body, allow  string
+	}{
+		{"GET", "/users/1", http.StatusOK, "get", ""},
+		{"DELETE", "/users/1", http.StatusOK, "delete", ""},
+		{"HEAD", "/users/1", http.StatusOK, "", ""},
+		{"PUT", "/users/1", http.StatusMethodNotAllowed, "Method Not Allowed\n", "DELETE, GET, HEAD, OPTIONS"},
+		{"POST", "/users/1", http.StatusMethodNotAllowed, "Method Not Allowed\n", "DELETE, GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/users/1", http.StatusNoContent, "", "DELETE, GET, HEAD, OPTIONS"},
+		{"GET", "/users", http.StatusMethodNotAllowed, "Method Not Allowed\n", "OPTIONS, POST"},
+		{"GET", "/other", http.StatusNotFound, "404 page not found\n", ""},
+	}
+	for _, test := range tests {
+		w := serveSite(site, test.method, test.path)
+		if w.Code != test.want || w.Body.String() != test.body || w.Header().Get("Allow") != test.allow {
+			t.Errorf("%s %s: got %d %q with Allow %q, want %d %q with Allow %q", test.method, test.path, w.Code, w.Body.String(), w.Header().Get("Allow"), test.want, test.body, test.allow)
+		}
+	}
+
+	site.SetAutoOptions(false)
+	if w := serveSite(site, "PUT", "/users/1"); w.Header().Get("Allow") != "DELETE, GET, HEAD" {
+		t.Errorf("without automatic OPTIONS: got Allow %q", w.Header().Get("Allow"))
+	}
+}
+
+func TestMethodHeadResponseWriter(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Get(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		io.WriteString(w, "hello")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("%s: got Flush error %v", r.Method, err)
+		}
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("%s: ResponseWriter is not an http.Flusher", r.Method)
+		}
+	}), "/")
+
+	w := serveSite(site, "HEAD", "/")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "5" || !w.Flushed {
+		t.Errorf("got %d %q with Content-Length %q, flushed %v", w.Code, w.Body.String(), w.Header().Get("Content-Length"), w.Flushed)
+	}
+}
+
+func TestSetNotFound(t *testing.T) {
+	var paths []string
+	gone := Handler(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		http.Error(w, "Gone", http.StatusGone)
+	})
+
+	site := NewSite("example.com", 80, nil)
+	site.Equals(namedHandler("home"), "/")
+	site.HasPrefix(FileServer(fstest.MapFS{"files/a.txt": {Data: []byte("a")}}, FileServerOptions{}), "/files/")
+	site.SetNotFound(gone)
+
+	tests := []struct {
+		path string
+		want int
+		body string
+	}{
+		{"/", http.StatusOK, "home"},
+		{"/missing", http.StatusGone, "Gone\n"},
+		{"/files/a.txt", http.StatusOK, "a"},
+		{"/files/b.txt", http.StatusGone, "Gone\n"},
+	}
+	for _, test := range tests {
+		w := serveSite(site, "GET", test.path)
+		if w.Code != test.want || w.Body.String() != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.path, w.Code, w.Body.String(), test.want, test.body)
+		}
+	}
+	if want := []string{"/missing", "/files/b.txt"}; fmt.Sprint(paths) != fmt.Sprint(want) {
+		t.Errorf("not found handler received %q, want %q", paths, want)
+	}
+
+	site.SetNotFound(nil)
+	if w := serveSite(site, "GET", "/missing"); w.Code != http.StatusNotFound || w.Body.String() != "404 page not found\n" {
+		t.Errorf("after SetNotFound(nil): got %d %q", w.Code, w.Body.String())
+	}
+
+	site = NewSite("example.com", 80, gone)
+	if w := serveSite(site, "GET", "/missing"); w.Code != http.StatusGone {
+		t.Errorf("NewSite handler: got status %d, want 410", w.Code)
+	}
+}
+
+func TestMethodOverride(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.Use(MethodOverride)
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		site.Method(method, namedHandler(method), "/users/:id")
+	}
+
+	tests := []struct {
+		method, header, field string
+		want                  int
+		body                  string
+	}{
+		{"POST", "", "", http.StatusOK, "POST"},
+		{"POST", "DELETE", "", http.StatusOK, "DELETE"},
+		{"POST", "put", "", http.StatusOK, "PUT"},
+		{"POST", "", "patch", http.StatusOK, "PATCH"},
+		{"POST", "DELETE", "PUT", http.StatusOK, "DELETE"},
+
+		// Only unsafe methods other than POST can be used.
+		{"POST", "GET", "", http.StatusBadRequest, "Bad Request\n"},
+		{"POST", "", "GET", http.StatusBadRequest, "Bad Request\n"},
+		{"POST", "HEAD", "", http.StatusBadRequest, "Bad Request\n"},
+		{"POST", "OPTIONS", "", http.StatusBadRequest, "Bad Request\n"},
+		{"POST", "POST", "", http.StatusBadRequest, "Bad Request\n"},
+		{"POST", "TRACE", "", http.StatusBadRequest, "Bad Request\n"},
+
+		// Other requests are not changed.
+		{"GET", "DELETE", "", http.StatusOK, "GET"},
+		{"PUT", "DELETE", "", http.StatusOK, "PUT"},
+	}
+	for _, test := range tests {
+		var body io.Reader
+		if test.field != "" {
+			body = strings.NewReader(url.Values{"_method": {test.field}}.Encode())
+		}
+		r := httptest.NewRequest(test.method, "/users/1", body)
+		if test.field != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if test.header != "" {
+			r.Header.Set("X-HTTP-Method-Override", test.header)
+		}
+		w := httptest.NewRecorder()
+		site.ServeHTTP(w, r)
+		if w.Code != test.want || w.Body.String() != test.body {
+			t.Errorf("%s with header %q and field %q: got %d %q, want %d %q", test.method, test.header, test.field, w.Code, w.Body.String(), test.want, test.body)
+		}
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	newSite := func(caseInsensitive bool) *Site {
+		site := NewSite("example.com", 80, nil)
+		site.CaseInsensitive = caseInsensitive
+		site.Equals(namedHandler("about"), "/About")
+		site.HasPrefix(namedHandler("docs"), "/Docs/")
+		site.Pattern(paramHandler("user", "id"), "/Users/:id")
+		return site
+	}
+
+	tests := []struct {
+		caseInsensitive bool
+		path, want      string
+	}{
+		{true, "/about", "about"},
+		{true, "/ABOUT", "about"},
+		{true, "/About", "about"},
+		{true, "/docs/Intro", "docs"},
+		{true, "/USERS/Bob", "user id=bob"},
+		{true, "/abouts", "404 page not found\n"},
+		{false, "/About", "about"},
+		{false, "/about", "404 page not found\n"},
+		{false, "/docs/intro", "404 page not found\n"},
+		{false, "/Users/Bob", "user id=Bob"},
+	}
+	for _, test := range tests {
+		if got := serveSite(newSite(test.caseInsensitive), "GET", test.path).Body.String(); got != test.want {
+			t.Errorf("case-insensitive %v: %s: got %q, want %q", test.caseInsensitive, test.path, got, test.want)
+		}
+	}
+}
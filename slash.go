@@ -0,0 +1,102 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SlashPolicy determines how a Site handles request paths which
+// do not match any handlers, but would with a trailing slash added
+// or removed.
+type SlashPolicy int
+
+const (
+	// Strict treats paths with and without a trailing
+	// slash as different paths. This is the default.
+	Strict SlashPolicy = iota
+
+	// RedirectTrailingSlash redirects requests to the form of the
+	// path, with or without a trailing slash, which has a handler.
+	// GET and HEAD requests receive a 301 Moved Permanently and other
+	// requests receive a 308 Permanent Redirect, so the request body
+	// is preserved.
+	RedirectTrailingSlash
+
+	// StripTrailingSlash uses the handler for the path without its
+	// trailing slash, if there is one. The handler still sees the
+	// original request path.
+	StripTrailingSlash
+)
+
+// toggleTrailingSlash adds or removes the trailing slash
+// from a path, returning the empty string for the root path.
+func toggleTrailingSlash(path string) string {
+	if path == "/" || path == "" {
+		return ""
+	}
+	if strings.HasSuffix(path, "/") {
+		return strings.TrimSuffix(path, "/")
+	}
+	return path + "/"
+}
+
+// redirectSlash redirects the request to the given path,
+// which has had its trailing slash added or removed.
+func redirectSlash(w http.ResponseWriter, r *http.Request, path string) {
+	u := &url.URL{
+		Path:     MountPrefix(r) + path,
+		RawQuery: r.URL.RawQuery,
+	}
+	if r.URL.RawPath != "" {
+		u.RawPath = MountPrefix(r) + toggleTrailingSlash(r.URL.RawPath)
+	}
+
+	target := localURL(u)
+
+	code := http.StatusPermanentRedirect
+	if r.Method == "GET" || r.Method == "HEAD" {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, target, code)
+}
+
+// TrailingSlashMode determines the canonical form of request
+// paths used by TrailingSlashRedirect.
+type TrailingSlashMode int
+
+const (
+	// TrailingSlashAdd redirects paths without a trailing
+	// slash to the same path with one.
+	TrailingSlashAdd TrailingSlashMode = iota
+
+	// TrailingSlashStrip redirects paths with a trailing
+	// slash to the same path without one.
+	TrailingSlashStrip
+)
+
+// TrailingSlashRedirect creates an http.Handler which redirects
+// requests to the canonical form of their path, as given by mode,
+// calling next for requests which already use it. The query string
+// is preserved, and the root path is never redirected. Redirects
+// are permanent, as with RedirectTrailingSlash.
+//
+//	site.Always(web.TrailingSlashRedirect(web.TrailingSlashStrip, handler))
+func TrailingSlashRedirect(mode TrailingSlashMode, next http.Handler) http.Handler {
+	if mode != TrailingSlashAdd && mode != TrailingSlashStrip {
+		panic("web: invalid TrailingSlashMode")
+	}
+
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if path == "/" || path == "" || strings.HasSuffix(path, "/") == (mode == TrailingSlashAdd) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		redirectSlash(w, r, toggleTrailingSlash(path))
+	})
+}
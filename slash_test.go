@@ -0,0 +1,122 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSlashPolicies(t *testing.T) {
+	newSite := func(policy SlashPolicy) *Site {
+		site := NewSite("example.com", 80, nil)
+		site.Equals(mountHandler("root"), "/")
+		site.Equals(mountHandler("about"), "/about")
+		site.Equals(mountHandler("docs"), "/docs/")
+		site.HasPrefix(mountHandler("blog"), "/blog/")
+		site.SetSlashPolicy(policy)
+		return site
+	}
+
+	tests := []struct {
+		policy       SlashPolicy
+		method, path string
+		want         int
+		body, target string
+	}{
+		{Strict, "GET", "/about", http.StatusOK, "about /about ", ""},
+		{Strict, "GET", "/about/", http.StatusNotFound, "", ""},
+		{Strict, "GET", "/docs", http.StatusNotFound, "", ""},
+		{Strict, "GET", "/blog", http.StatusNotFound, "", ""},
+
+		{RedirectTrailingSlash, "GET", "/about/", http.StatusMovedPermanently, "", "/about"},
+		{RedirectTrailingSlash, "GET", "/about/?a=1&b=2", http.StatusMovedPermanently, "", "/about?a=1&b=2"},
+		{RedirectTrailingSlash, "HEAD", "/about/", http.StatusMovedPermanently, "", "/about"},
+		{RedirectTrailingSlash, "POST", "/about/", http.StatusPermanentRedirect, "", "/about"},
+		{RedirectTrailingSlash, "GET", "/docs?q=x", http.StatusMovedPermanently, "", "/docs/?q=x"},
+		{RedirectTrailingSlash, "PUT", "/docs", http.StatusPermanentRedirect, "", "/docs/"},
+		{RedirectTrailingSlash, "GET", "/blog", http.StatusMovedPermanently, "", "/blog/"},
+		{RedirectTrailingSlash, "GET", "/blog/post", http.StatusOK, "blog /blog/post ", ""},
+		{RedirectTrailingSlash, "GET", "/", http.StatusOK, "root / ", ""},
+		{RedirectTrailingSlash, "GET", "/missing/", http.StatusNotFound, "", ""},
+
+		{StripTrailingSlash, "GET", "/about/", http.StatusOK, "about /about/ ", ""},
+		{StripTrailingSlash, "POST", "/about/", http.StatusOK, "about /about/ ", ""},
+		{StripTrailingSlash, "GET", "/docs", http.StatusNotFound, "", ""},
+		{StripTrailingSlash, "GET", "/blog", http.StatusNotFound, "", ""},
+		{StripTrailingSlash, "GET", "/", http.StatusOK, "root / ", ""},
+	}
+	for _, test := range tests {
+		w := serveSite(newSite(test.policy), test.method, test.path)
+		if w.Code != test.want || w.Header().Get("Location") != test.target {
+			t.Errorf("policy %d: %s %s: got %d to %q, want %d to %q", test.policy, test.method, test.path, w.Code, w.Header().Get("Location"), test.want, test.target)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("policy %d: %s %s: got body %q, want %q", test.policy, test.method, test.path, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestSlashPolicyRootNotRedirected(t *testing.T) {
+	// The root path is never redirected to "".
+	site := NewSite("example.com", 80, nil)
+	site.Equals(mountHandler("empty"), "")
+	site.SetSlashPolicy(RedirectTrailingSlash)
+	if w := serveSite(site, "GET", "/"); w.Code != http.StatusNotFound {
+		t.Errorf("got %d to %q, want 404", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestSlashPolicyMounted(t *testing.T) {
+	sub := NewSite("example.com", 80, nil)
+	sub.Equals(mountHandler("about"), "/about")
+	sub.SetSlashPolicy(RedirectTrailingSlash)
+	site := NewSite("example.com", 80, nil)
+	site.Mount("/sub", sub)
+
+	if w := serveSite(site, "GET", "/sub/about/?x=1"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/sub/about?x=1" {
+		t.Errorf("got %d to %q, want 301 to /sub/about?x=1", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	tests := []struct {
+		mode         TrailingSlashMode
+		method, path string
+		want         int
+		target       string
+	}{
+		{TrailingSlashAdd, "GET", "/about", http.StatusMovedPermanently, "/about/"},
+		{TrailingSlashAdd, "GET", "/about?a=1&b=2", http.StatusMovedPermanently, "/about/?a=1&b=2"},
+		{TrailingSlashAdd, "POST", "/about", http.StatusPermanentRedirect, "/about/"},
+		{TrailingSlashAdd, "GET", "/a%2Fb", http.StatusMovedPermanently, "/a%2Fb/"},
+		{TrailingSlashAdd, "GET", "/about/", http.StatusOK, ""},
+		{TrailingSlashAdd, "GET", "/", http.StatusOK, ""},
+
+		{TrailingSlashStrip, "GET", "/about/", http.StatusMovedPermanently, "/about"},
+		{TrailingSlashStrip, "HEAD", "/about/?q=x", http.StatusMovedPermanently, "/about?q=x"},
+		{TrailingSlashStrip, "DELETE", "/about/", http.StatusPermanentRedirect, "/about"},
+		{TrailingSlashStrip, "GET", "/about", http.StatusOK, ""},
+		{TrailingSlashStrip, "GET", "/", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		w := serveSite(TrailingSlashRedirect(test.mode, namedHandler("next")), test.method, test.path)
+		if w.Code != test.want || w.Header().Get("Location") != test.target {
+			t.Errorf("mode %d: %s %s: got %d to %q, want %d to %q", test.mode, test.method, test.path, w.Code, w.Header().Get("Location"), test.want, test.target)
+		}
+		if test.want == http.StatusOK && w.Body.String() != "next" {
+			t.Errorf("mode %d: %s %s: next was not called", test.mode, test.method, test.path)
+		}
+	}
+}
+
+func TestTrailingSlashRedirectInvalidMode(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("TrailingSlashRedirect did not panic")
+		}
+	}()
+	TrailingSlashRedirect(TrailingSlashMode(2), emptyHandler)
+}
@@ -0,0 +1,264 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrFlushUnsupported is returned when a response must be
+// streamed, but the http.ResponseWriter cannot be flushed.
+var ErrFlushUnsupported = errors.New("web: response writer does not support flushing")
+
+// SSEWriter sends Server-Sent Events to a client, as described in
+// the HTML Living Standard. Each event is flushed to the client
+// as soon as it is sent. SSEWriter is safe for concurrent use.
+//
+//	func serveUpdates(w http.ResponseWriter, r *http.Request) {
+//		events, err := web.NewSSEWriter(w, r)
+//		if err != nil {
+//			http.Error(w, err.Error(), http.StatusInternalServerError)
+//			return
+//		}
+//		for {
+//			select {
+//			case update := <-updates:
+//				events.Send("update", update)
+//			case <-events.Done():
+//				return
+//			}
+//		}
+//	}
+type SSEWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+}
+
+// NewSSEWriter starts a stream of Server-Sent Events, sending a 200 OK
+// response with a Content-Type of text/event-stream, and asking for it
+// not to be cached. It returns ErrFlushUnsupported, having written
+// nothing, if w does not implement http.Flusher.
+func NewSSEWriter(w http.ResponseWriter, r *http.Request) (*SSEWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrFlushUnsupported
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	return startSSE(w, r, flusher), nil
+}
+
+// startSSE sends the response header for
+// a stream of Server-Sent Events.
+func startSSE(w http.ResponseWriter, r *http.Request, flusher http.Flusher) *SSEWriter {
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &SSEWriter{w: w, flusher: flusher, ctx: r.Context()}
+}
+
+// Send sends an event with the given type and data. If event is empty,
+// the client treats it as a "message" event. Data containing newlines
+// is sent as multiple lines, which the client joins with newlines. Send
+// returns the context's error once the client has disconnected.
+func (s *SSEWriter) Send(event, data string) error {
+	return s.send(event, "", data)
+}
+
+// SendComment sends a comment, which the client ignores. This can be
+// used to keep the connection open.
+func (s *SSEWriter) SendComment(comment string) error {
+	return s.write(appendSSEField(nil, "", comment))
+}
+
+// Done returns a channel which is closed when
+// the client disconnects.
+func (s *SSEWriter) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// send sends an event with the given type, ID, and data.
+func (s *SSEWriter) send(event, id, data string) error {
+	if strings.ContainsAny(event, "\r\n") {
+		return errors.New("web: event type contains a newline")
+	}
+	if strings.ContainsAny(id, "\r\n\x00") {
+		return errors.New("web: event ID contains a newline or null character")
+	}
+
+	var buf []byte
+	if event != "" {
+		buf = appendSSEField(buf, "event", event)
+	}
+	if id != "" {
+		buf = appendSSEField(buf, "id", id)
+	}
+	buf = appendSSEField(buf, "data", data)
+	return s.write(append(buf, '\n'))
+}
+
+// EventStream sends Server-Sent Events to a client, like SSEWriter,
+// with support for event IDs and keep-alives. When the client
+// reconnects, it sends the ID of the last event it received, which
+// is given by LastEventID, so any missed events can be sent.
+//
+// The handler must call Close before it returns, and must not use
+// the stream afterwards. EventStream is safe for concurrent use.
+//
+//	func serveDashboard(w http.ResponseWriter, r *http.Request) {
+//		stream, err := web.ServeSSE(w, r)
+//		if err != nil {
+//			http.Error(w, err.Error(), http.StatusInternalServerError)
+//			return
+//		}
+//		defer stream.Close()
+//		stream.KeepAlive(15 * time.Second)
+//
+//		ticker := time.NewTicker(time.Second)
+//		defer ticker.Stop()
+//		for {
+//			select {
+//			case <-ticker.C:
+//				count := strconv.FormatInt(views.Count(), 10)
+//				stream.Send("views", "", []byte(count))
+//			case <-stream.Done():
+//				return
+//			}
+//		}
+//	}
+type EventStream struct {
+	w           *SSEWriter
+	lastEventID string
+
+	mu            sync.Mutex
+	stopKeepAlive chan struct{} // Closed to stop keep-alives.
+	keepAliveDone chan struct{} // Closed once keep-alives stop.
+}
+
+// ServeSSE starts a stream of Server-Sent Events, sending a 200 OK
+// response with a Content-Type of text/event-stream, and headers set
+// with DoNotCache. Any Cache-Control, ETag, and Last-Modified headers
+// set beforehand are replaced or removed. It returns ErrFlushUnsupported,
+// having written nothing, if w does not implement http.Flusher.
+func ServeSSE(w http.ResponseWriter, r *http.Request) (*EventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrFlushUnsupported
+	}
+
+	DoNotCache(w)
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+	return &EventStream{
+		w:           startSSE(w, r, flusher),
+		lastEventID: r.Header.Get("Last-Event-ID"),
+	}, nil
+}
+
+// Send sends an event with the given type, ID, and data, as described
+// in SSEWriter's Send method. If id is empty, the event has no ID.
+func (s *EventStream) Send(event, id string, data []byte) error {
+	return s.w.send(event, id, string(data))
+}
+
+// LastEventID returns the ID of the last event received by the
+// client before it reconnected, or the empty string if it is
+// connecting for the first time.
+func (s *EventStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// KeepAlive sends a comment whenever the given interval passes, so
+// that proxies do not close an idle connection, replacing any previous
+// interval. If interval is zero or negative, keep-alives are stopped.
+func (s *EventStream) KeepAlive(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+	if interval <= 0 {
+		return
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.stopKeepAlive, s.keepAliveDone = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s.w.SendComment("") != nil {
+					return
+				}
+			case <-stop:
+				return
+			case <-s.w.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Done returns a channel which is closed when
+// the client disconnects.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.w.Done()
+}
+
+// Close stops any keep-alives, waiting for them to finish
+// writing, so that the handler can return.
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+}
+
+// stop stops any keep-alives.
+func (s *EventStream) stop() {
+	if s.stopKeepAlive != nil {
+		close(s.stopKeepAlive)
+		<-s.keepAliveDone
+		s.stopKeepAlive, s.keepAliveDone = nil, nil
+	}
+}
+
+// write writes data to the stream and flushes it, unless
+// the client has disconnected.
+func (s *SSEWriter) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// appendSSEField appends a field of an event, using a
+// separate line for each line of the value. An empty
+// name produces a comment.
+func appendSSEField(buf []byte, name, value string) []byte {
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	value = strings.ReplaceAll(value, "\r", "\n")
+	for _, line := range strings.Split(value, "\n") {
+		buf = append(buf, name...)
+		buf = append(buf, ':', ' ')
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	return buf
+}
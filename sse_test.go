@@ -0,0 +1,169 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Length", "10")
+	events, err := NewSSEWriter(w, httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !w.Flushed {
+		t.Errorf("got status %d, flushed %v", w.Code, w.Flushed)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":   "text/event-stream",
+		"Cache-Control":  "no-cache",
+		"Content-Length": "",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+
+	events.Send("", "hello")
+	events.Send("update", "line 1\nline 2\r\nline 3\rline 4")
+	events.Send("empty", "")
+	events.SendComment("keep-alive")
+	want := "data: hello\n\n" +
+		"event: update\ndata: line 1\ndata: line 2\ndata: line 3\ndata: line 4\n\n" +
+		"event: empty\ndata: \n\n" +
+		": keep-alive\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := events.Send("bad\nevent", "data"); err == nil {
+		t.Error("event type with a newline: got no error")
+	}
+}
+
+func TestSSEWriterDisconnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	events, err := NewSSEWriter(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case <-events.Done():
+	default:
+		t.Error("Done was not closed")
+	}
+	if err := events.Send("", "late"); err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if strings.Contains(w.Body.String(), "late") {
+		t.Error("event was written after the client disconnected")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("Last-Modified", "Wed, 02 Jan 2013 03:04:05 GMT")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Last-Event-ID", "41")
+	stream, err := ServeSSE(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	if stream.LastEventID() != "41" {
+		t.Errorf("got last event ID %q, want 41", stream.LastEventID())
+	}
+	if w.Header().Get("ETag") != "" || w.Header().Get("Last-Modified") != "" || !strings.Contains(w.Header().Get("Cache-Control"), "no-cache") {
+		t.Errorf("got headers %v", w.Header())
+	}
+
+	stream.Send("count", "42", []byte("42"))
+	stream.Send("", "", []byte("{\"a\":1}\n{\"b\":2}"))
+	want := "event: count\nid: 42\ndata: 42\n\n" +
+		"data: {\"a\":1}\ndata: {\"b\":2}\n\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, id := range []string{"a\nb", "a\rb", "a\x00b"} {
+		if err := stream.Send("", id, nil); err == nil {
+			t.Errorf("ID %q: got no error", id)
+		}
+	}
+	if got := w.Body.String(); got != want {
+		t.Errorf("invalid events were written: got %q", got)
+	}
+}
+
+func TestEventStreamKeepAlive(t *testing.T) {
+	w := httptest.NewRecorder()
+	stream, err := ServeSSE(w, httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.KeepAlive(time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	stream.KeepAlive(0)
+	stream.Close()
+
+	body := w.Body.String()
+	if body == "" || strings.Trim(body, ": \n") != "" {
+		t.Errorf("got %q, want only keep-alive comments", body)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if w.Body.String() != body {
+		t.Error("keep-alives continued after being stopped")
+	}
+}
+
+func TestSSEDisconnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	stream, err := ServeSSE(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	cancel()
+	select {
+	case <-stream.Done():
+	default:
+		t.Error("Done was not closed")
+	}
+	if err := stream.Send("", "", []byte("late")); err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if strings.Contains(w.Body.String(), "late") {
+		t.Error("event was written after the client disconnected")
+	}
+}
+
+func TestSSEFlushUnsupported(t *testing.T) {
+	w := httptest.NewRecorder()
+	noFlush := struct{ http.ResponseWriter }{w}
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := NewSSEWriter(noFlush, r); err != ErrFlushUnsupported {
+		t.Errorf("NewSSEWriter: got error %v, want ErrFlushUnsupported", err)
+	}
+	if _, err := ServeSSE(noFlush, r); err != ErrFlushUnsupported {
+		t.Errorf("ServeSSE: got error %v, want ErrFlushUnsupported", err)
+	}
+	if len(w.Header()) != 0 || w.Body.Len() != 0 {
+		t.Errorf("wrote headers %v and body %q", w.Header(), w.Body.String())
+	}
+}
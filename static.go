@@ -0,0 +1,165 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StaticOptions controls how a StaticHandler serves files.
+type StaticOptions struct {
+	// CacheDuration is the duration for which successful
+	// responses are cached, as described in Cache.
+	CacheDuration time.Duration
+
+	// ETags enables sending an ETag derived from each
+	// file's modification time and size, which clients
+	// can use in the If-None-Match header.
+	ETags bool
+
+	// DirectoryListing enables listing the contents of
+	// directories which have no index.html file. By
+	// default, these receive a 404 response.
+	DirectoryListing bool
+}
+
+// StaticHandler creates an http.Handler which serves files from root,
+// such as an http.Dir, using the request path, like http.FileServer.
+// Successful responses are cached, using Cache, and error responses
+// are not, using DoNotCache. Requests for directories are served using
+// their index.html file, and redirected to add a trailing slash if
+// necessary. Missing files receive the 404 response of the Site serving
+// the request.
+//
+// Conditional requests are answered with a 304 Not Modified response
+// if the If-None-Match header matches the file's ETag or, if there is
+// no If-None-Match header, if the file has not been modified since the
+// time in the If-Modified-Since header. Range requests are supported,
+// as in http.ServeContent.
+//
+//	site.HasPrefix(web.StaticHandler(http.Dir("public"), web.StaticOptions{
+//		CacheDuration: time.Hour,
+//		ETags:         true,
+//	}), "/")
+func StaticHandler(root http.FileSystem, opts StaticOptions) http.Handler {
+	return staticHandler(root, opts, func(name string, info fs.FileInfo) string {
+		return fileETag(info, "")
+	})
+}
+
+// staticHandler implements StaticHandler, using etag to
+// find the ETag of the named file.
+func staticHandler(root http.FileSystem, opts StaticOptions, etag func(name string, info fs.FileInfo) string) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			DoNotCache(w)
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		urlPath := r.URL.Path
+		if !strings.HasPrefix(urlPath, "/") {
+			urlPath = "/" + urlPath
+		}
+		for _, segment := range strings.Split(urlPath, "/") {
+			if segment == ".." {
+				DoNotCache(w)
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+		}
+		name := path.Clean(urlPath)
+
+		f, info, err := openStatic(root, name)
+		if err != nil {
+			DoNotCache(w)
+			DefaultPathErrorHandler(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		if info.IsDir() {
+			if !strings.HasSuffix(urlPath, "/") {
+				u := &url.URL{Path: MountPrefix(r) + urlPath + "/", RawQuery: r.URL.RawQuery}
+				http.Redirect(w, r, localURL(u), http.StatusMovedPermanently)
+				return
+			}
+
+			indexName := path.Join(name, "index.html")
+			index, indexInfo, err := openStatic(root, indexName)
+			switch {
+			case err == nil && !indexInfo.IsDir():
+				defer index.Close()
+				f, info, name = index, indexInfo, indexName
+			case opts.DirectoryListing:
+				if err == nil {
+					index.Close()
+				}
+				listStatic(w, r, f, info, opts)
+				return
+			default:
+				if err == nil {
+					index.Close()
+				}
+				DoNotCache(w)
+				notFound(w, r)
+				return
+			}
+		}
+
+		if opts.ETags {
+			if CacheWithETagModTime(w, r, etag(name, info), info.ModTime(), opts.CacheDuration) {
+				return
+			}
+		} else {
+			Cache(w, info.ModTime(), opts.CacheDuration)
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	})
+}
+
+// openStatic opens the named file in root, returning
+// its information.
+func openStatic(root http.FileSystem, name string) (http.File, fs.FileInfo, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// listStatic sends an HTML page listing the
+// contents of the directory dir.
+func listStatic(w http.ResponseWriter, r *http.Request, dir http.File, info fs.FileInfo, opts StaticOptions) {
+	entries, err := dir.Readdir(-1)
+	if err != nil {
+		DoNotCache(w)
+		DefaultPathErrorHandler(w, r, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+		if entry.IsDir() {
+			names[i] += "/"
+		}
+	}
+	Cache(w, info.ModTime(), opts.CacheDuration)
+	writeListing(w, names)
+}
@@ -0,0 +1,195 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"embed"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//go:embed testdata/static
+var testStatic embed.FS
+
+// staticDir creates a directory for testing StaticHandler.
+func staticDir(t *testing.T) (string, time.Time) {
+	dir := t.TempDir()
+	modTime := time.Date(2013, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.WriteFile(filepath.Join(dir, "app.css"), []byte("body {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "app.css"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docs", "b.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "docs", "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir, modTime
+}
+
+func serveStatic(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestStaticHandler(t *testing.T) {
+	dir, modTime := staticDir(t)
+	h := StaticHandler(http.Dir(dir), StaticOptions{CacheDuration: time.Hour, ETags: true})
+
+	w := serveStatic(h, "GET", "/app.css", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "body {}" {
+		t.Fatalf("got %d %q, want 200 %q", w.Code, w.Body.String(), "body {}")
+	}
+	if got, want := w.Header().Get("Cache-Control"), "public, max-age=3600"; got != want {
+		t.Errorf("got Cache-Control %q, want %q", got, want)
+	}
+	if got, want := w.Header().Get("Last-Modified"), modTime.Format(http.TimeFormat); got != want {
+		t.Errorf("got Last-Modified %q, want %q", got, want)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	w = serveStatic(h, "GET", "/app.css", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: got %d with %d bytes, want 304", w.Code, w.Body.Len())
+	}
+
+	// If-Modified-Since is ignored with If-None-Match.
+	w = serveStatic(h, "GET", "/app.css", http.Header{
+		"If-None-Match":     {`"other"`},
+		"If-Modified-Since": {modTime.Format(http.TimeFormat)},
+	})
+	if w.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match: got %d, want 200", w.Code)
+	}
+
+	w = serveStatic(h, "GET", "/app.css", http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: got %d, want 304", w.Code)
+	}
+	w = serveStatic(h, "GET", "/app.css", http.Header{"If-Modified-Since": {modTime.Add(-time.Hour).Format(http.TimeFormat)}})
+	if w.Code != http.StatusOK {
+		t.Errorf("old If-Modified-Since: got %d, want 200", w.Code)
+	}
+}
+
+func TestStaticHandlerWithoutETags(t *testing.T) {
+	dir, modTime := staticDir(t)
+	h := StaticHandler(http.Dir(dir), StaticOptions{CacheDuration: time.Hour})
+
+	w := serveStatic(h, "GET", "/app.css", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+	if etag := w.Header().Get("ETag"); etag != "" {
+		t.Errorf("got ETag %q, want none", etag)
+	}
+
+	w = serveStatic(h, "GET", "/app.css", http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: got %d, want 304", w.Code)
+	}
+}
+
+func TestStaticHandlerErrors(t *testing.T) {
+	dir, _ := staticDir(t)
+	h := StaticHandler(http.Dir(dir), StaticOptions{CacheDuration: time.Hour, ETags: true})
+
+	tests := []struct {
+		method, target string
+		status         int
+	}{
+		{"GET", "/missing.css", http.StatusNotFound},
+		{"GET", "/docs/", http.StatusNotFound},
+		{"GET", "/docs/../app.css", http.StatusBadRequest},
+		{"POST", "/app.css", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		w := serveStatic(h, test.method, test.target, nil)
+		if w.Code != test.status {
+			t.Errorf("%s %s: got %d, want %d", test.method, test.target, w.Code, test.status)
+		}
+		if got, want := w.Header().Get("Cache-Control"), "no-cache, no-store, must-revalidate"; got != want {
+			t.Errorf("%s %s: got Cache-Control %q, want %q", test.method, test.target, got, want)
+		}
+	}
+}
+
+func TestStaticHandlerDirectories(t *testing.T) {
+	dir, _ := staticDir(t)
+	h := StaticHandler(http.Dir(dir), StaticOptions{DirectoryListing: true})
+
+	w := serveStatic(h, "GET", "/docs?q=1", nil)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/docs/?q=1" {
+		t.Errorf("got %d to %q, want 301 to %q", w.Code, w.Header().Get("Location"), "/docs/?q=1")
+	}
+
+	w = serveStatic(h, "GET", "/docs/", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+	body := w.Body.String()
+	a, b := strings.Index(body, `href="a/"`), strings.Index(body, `href="b.txt"`)
+	if a < 0 || b < a {
+		t.Errorf("got listing %q, want a/ then b.txt", body)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "docs", "index.html"), []byte("index"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = serveStatic(h, "GET", "/docs/", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "index" {
+		t.Errorf("got %d %q, want 200 %q", w.Code, w.Body.String(), "index")
+	}
+}
+
+func TestServeEmbedded(t *testing.T) {
+	h := ServeEmbedded(testStatic, "testdata/static", StaticOptions{CacheDuration: time.Hour, ETags: true})
+
+	w := serveStatic(h, "GET", "/app.css", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "body {}\n" {
+		t.Fatalf("got %d %q, want 200 %q", w.Code, w.Body.String(), "body {}\n")
+	}
+	// The first 16 bytes of the SHA-256 hash of "body {}\n".
+	if got, want := w.Header().Get("ETag"), `"a06fd750de7374983daf40016564b1fb"`; got != want {
+		t.Errorf("got ETag %q, want %q", got, want)
+	}
+	if got := w.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("got Last-Modified %q, want none", got)
+	}
+
+	w = serveStatic(h, "GET", "/app.css", http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %d, want 304", w.Code)
+	}
+
+	w = serveStatic(h, "GET", "/docs/", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "<h1>Docs</h1>\n" || w.Header().Get("ETag") == "" {
+		t.Errorf("index: got %d %q with ETag %q", w.Code, w.Body.String(), w.Header().Get("ETag"))
+	}
+
+	w = serveStatic(h, "GET", "/missing.css", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing: got %d, want 404", w.Code)
+	}
+}
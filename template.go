@@ -0,0 +1,175 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+)
+
+// TemplateOptions controls how Templates are parsed and rendered.
+type TemplateOptions struct {
+	// Shared is a glob pattern matching files parsed with every
+	// page, such as layouts and partial templates. If empty, each
+	// page is parsed alone.
+	Shared string
+
+	// Layout is the name of the template executed to render each
+	// page, such as "base.html", which can use blocks defined by
+	// the page. If empty, the page itself is executed.
+	Layout string
+
+	// Funcs are added to the templates' functions before
+	// they are parsed.
+	Funcs template.FuncMap
+
+	// Reload causes the templates to be parsed again for every
+	// page rendered, so changes are seen without restarting the
+	// server. This is useful during development.
+	Reload bool
+}
+
+// Templates renders pages using HTML templates parsed from a file
+// system. Each page is a file matching the pattern given to
+// NewTemplates, parsed with the files matching the Shared pattern,
+// so that pages can each define the blocks used by a common layout.
+//
+// In addition to those in the Funcs field of TemplateOptions, the
+// templates can use the functions "request", which returns the
+// *http.Request being served, and "requestID", which returns the
+// request ID stored by RequestID, if any.
+//
+// Templates is safe for concurrent use.
+//
+//	// base.html:
+//	//	<title>{{block "title" .}}Example{{end}}</title>
+//	//	<main>{{block "content" .}}{{end}}</main>
+//	//
+//	// pages/user.html:
+//	//	{{define "title"}}{{.Name}}{{end}}
+//	//	{{define "content"}}<p>Hello, {{.Name}}.</p>{{end}}
+//	pages, err := web.NewTemplates(files, "pages/*.html", web.TemplateOptions{
+//		Shared: "base.html",
+//		Layout: "base.html",
+//	})
+//
+//	pages.Render(w, r, "pages/user.html", user, http.StatusOK)
+type Templates struct {
+	fsys    fs.FS
+	pattern string
+	opts    TemplateOptions
+	pages   map[string]*template.Template // Not executed, only cloned.
+}
+
+// NewTemplates parses the pages matching the glob pattern in fsys,
+// as described in fs.Glob, returning an error if the pattern
+// matches no files, or any template cannot be parsed. The pages'
+// names are their paths in fsys.
+func NewTemplates(fsys fs.FS, pattern string, opts TemplateOptions) (*Templates, error) {
+	t := &Templates{fsys: fsys, pattern: pattern, opts: opts}
+	pages, err := t.parse()
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Reload {
+		t.pages = pages
+	}
+	return t, nil
+}
+
+// parse parses the templates for each page.
+func (t *Templates) parse() (map[string]*template.Template, error) {
+	names, err := fs.Glob(t.fsys, t.pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("web: template pattern %q matches no files", t.pattern)
+	}
+
+	shared := template.New("").Funcs(template.FuncMap{
+		"request":   func() *http.Request { return nil },
+		"requestID": func() string { return "" },
+	}).Funcs(t.opts.Funcs)
+	if t.opts.Shared != "" {
+		if shared, err = shared.ParseFS(t.fsys, t.opts.Shared); err != nil {
+			return nil, err
+		}
+	}
+
+	pages := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		page, err := shared.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if pages[name], err = page.ParseFS(t.fsys, name); err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
+}
+
+// Render renders the named page with the given data, and sends it
+// with the given status code and a Content-Type of text/html. The
+// page is rendered into a buffer before anything is written, so if
+// the page does not exist or cannot be rendered, the error is sent
+// with Fail, producing a 500 Internal Server Error response, and
+// returned. Headers set beforehand, such as with Cache or DoNotCache,
+// are sent with the page.
+//
+//	web.DoNotCache(w)
+//	pages.Render(w, r, "pages/account.html", account, http.StatusOK)
+func (t *Templates) Render(w http.ResponseWriter, r *http.Request, name string, data interface{}, status int) error {
+	pages := t.pages
+	if t.opts.Reload {
+		var err error
+		if pages, err = t.parse(); err != nil {
+			Fail(w, r, err)
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := t.execute(&buf, pages, r, name, data); err != nil {
+		Fail(w, r, err)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// execute renders the named page into buf.
+func (t *Templates) execute(buf *bytes.Buffer, pages map[string]*template.Template, r *http.Request, name string, data interface{}) error {
+	page, ok := pages[name]
+	if !ok {
+		return fmt.Errorf("web: no template page %q", name)
+	}
+
+	// The parsed templates are cloned, so the request
+	// functions can be bound without affecting other
+	// requests.
+	page, err := page.Clone()
+	if err != nil {
+		return err
+	}
+	page.Funcs(template.FuncMap{
+		"request":   func() *http.Request { return r },
+		"requestID": func() string { return GetRequestID(r.Context()) },
+	})
+
+	root := t.opts.Layout
+	if root == "" {
+		root = path.Base(name)
+	}
+	return page.ExecuteTemplate(buf, root, data)
+}
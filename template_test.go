@@ -0,0 +1,118 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// templateFS returns the files used by the template tests.
+func templateFS() fstest.MapFS {
+	return fstest.MapFS{
+		"base.html":       {Data: []byte(`<title>{{block "title" .}}Example{{end}}</title>{{block "content" .}}{{end}}`)},
+		"pages/user.html": {Data: []byte(`{{define "title"}}{{.}}{{end}}{{define "content"}}<p>Hello, {{.}}. {{request.URL.Path}} {{requestID}}</p>{{end}}`)},
+		"pages/home.html": {Data: []byte(`{{define "content"}}{{shout "home"}}{{end}}`)},
+		"pages/fail.html": {Data: []byte(`{{define "content"}}partial output{{fail}}{{end}}`)},
+	}
+}
+
+func newTestTemplates(t *testing.T, fsys fstest.MapFS, reload bool) *Templates {
+	t.Helper()
+	pages, err := NewTemplates(fsys, "pages/*.html", TemplateOptions{
+		Shared: "base.html",
+		Layout: "base.html",
+		Funcs: template.FuncMap{
+			"shout": strings.ToUpper,
+			"fail":  func() (string, error) { return "", errors.New("template failed") },
+		},
+		Reload: reload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pages
+}
+
+func TestTemplatesRender(t *testing.T) {
+	pages := newTestTemplates(t, templateFS(), false)
+	h := RequestIDWith(func() string { return "abc" })(Handler(func(w http.ResponseWriter, r *http.Request) {
+		pages.Render(w, r, "pages"+r.URL.Path+".html", "<Jamie>", http.StatusAccepted)
+	}))
+
+	w := serveSite(h, "GET", "/user")
+	want := "<title>&lt;Jamie&gt;</title><p>Hello, &lt;Jamie&gt;. /user abc</p>"
+	if w.Code != http.StatusAccepted || w.Body.String() != want {
+		t.Errorf("got %d %q, want 202 %q", w.Code, w.Body.String(), want)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+
+	w = serveSite(h, "GET", "/home")
+	if want := "<title>Example</title>HOME"; w.Body.String() != want {
+		t.Errorf("got %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestTemplatesRenderError(t *testing.T) {
+	log.SetOutput(io.Discard)
+	pages := newTestTemplates(t, templateFS(), false)
+
+	for _, name := range []string{"pages/fail.html", "pages/missing.html"} {
+		var err error
+		h := Handler(func(w http.ResponseWriter, r *http.Request) {
+			DoNotCache(w)
+			err = pages.Render(w, r, name, nil, http.StatusOK)
+		})
+		w := serveSite(h, "GET", "/")
+		if err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+		// Nothing is written before rendering finishes,
+		// so the error response is clean.
+		if w.Code != http.StatusInternalServerError || w.Body.String() != "Internal Server Error\n" {
+			t.Errorf("%s: got %d %q, want a clean 500", name, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("%s: got Content-Type %q", name, got)
+		}
+	}
+}
+
+func TestTemplatesReload(t *testing.T) {
+	fsys := templateFS()
+	pages := newTestTemplates(t, fsys, true)
+	render := func() string {
+		return serveSite(Handler(func(w http.ResponseWriter, r *http.Request) {
+			pages.Render(w, r, "pages/home.html", nil, http.StatusOK)
+		}), "GET", "/").Body.String()
+	}
+
+	if got := render(); got != "<title>Example</title>HOME" {
+		t.Fatalf("got %q", got)
+	}
+	fsys["pages/home.html"] = &fstest.MapFile{Data: []byte(`{{define "title"}}Home{{end}}`)}
+	if got := render(); got != "<title>Home</title>" {
+		t.Errorf("after reload: got %q", got)
+	}
+}
+
+func TestNewTemplatesErrors(t *testing.T) {
+	fsys := templateFS()
+	if _, err := NewTemplates(fsys, "missing/*.html", TemplateOptions{}); err == nil {
+		t.Error("no matching files: got no error")
+	}
+	fsys["pages/bad.html"] = &fstest.MapFile{Data: []byte(`{{define "content"}}{{end`)}
+	if _, err := NewTemplates(fsys, "pages/*.html", TemplateOptions{Shared: "base.html"}); err == nil {
+		t.Error("malformed template: got no error")
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"io"
+	"net/http"
+)
+
+// Text sends a plain text response with the given status code,
+// returning any error from writing the body.
+//
+//	web.Text(w, http.StatusOK, "pong")
+func Text(w http.ResponseWriter, status int, body string) error {
+	return writeString(w, status, "text/plain; charset=utf-8", body)
+}
+
+// HTML sends an HTML response with the given status code,
+// returning any error from writing the body. The body is
+// sent as is, so must already be escaped. To render HTML
+// templates, use Templates instead.
+//
+//	web.HTML(w, http.StatusOK, "<p>Hello, world.</p>")
+func HTML(w http.ResponseWriter, status int, body string) error {
+	return writeString(w, status, "text/html; charset=utf-8", body)
+}
+
+// writeString sends a response with the given
+// status code, Content-Type, and body.
+func writeString(w http.ResponseWriter, status int, contentType, body string) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err := io.WriteString(w, body)
+	return err
+}
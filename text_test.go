@@ -0,0 +1,39 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name        string
+		send        func(w http.ResponseWriter, status int, body string) error
+		status      int
+		body        string
+		contentType string
+	}{
+		{"Text", Text, http.StatusOK, "pong", "text/plain; charset=utf-8"},
+		{"Text error", Text, http.StatusTeapot, "<short and stout>", "text/plain; charset=utf-8"},
+		{"HTML", HTML, http.StatusOK, "<p>Hello, world.</p>", "text/html; charset=utf-8"},
+		{"HTML error", HTML, http.StatusNotFound, "<h1>Not Found</h1>", "text/html; charset=utf-8"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/json")
+		if err := test.send(w, test.status, test.body); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, w.Code, w.Body.String(), test.status, test.body)
+		}
+		if got := w.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("%s: got Content-Type %q, want %q", test.name, got, test.contentType)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timeout creates Middleware which limits the time taken by each
+// request. The next handler is called with a request whose context
+// has the given deadline. If the next handler has not written a
+// response by the deadline, a 503 Service Unavailable response is
+// sent, with a Retry-After header. Once the deadline has passed,
+// further writes by the next handler fail with http.ErrHandlerTimeout.
+// Timeout is equivalent to RequestTimeout{Duration: d}.Handler.
+//
+//	site.HasPrefix(web.Timeout(5*time.Second)(apiHandler), "/api/")
+func Timeout(d time.Duration) Middleware {
+	return RequestTimeout{Duration: d}.Handler
+}
+
+// RequestTimeout limits the time taken by requests, as described
+// in Timeout, with control over the response sent and a hook which
+// is called when a request times out.
+//
+// Handlers which stream their response can flush it within the
+// deadline as usual.
+//
+//	timeout := web.RequestTimeout{
+//		Duration: 5 * time.Second,
+//		Message:  "The server is busy. Please try again later.",
+//		OnTimeout: func(r *http.Request) {
+//			log.Printf("timed out serving %s", r.URL.Path)
+//		},
+//	}
+//	site.Use(timeout.Handler)
+type RequestTimeout struct {
+	// Duration is the time allowed for each request.
+	Duration time.Duration
+
+	// Message is the body of the 503 Service Unavailable
+	// response sent when a request times out. If empty,
+	// "Service Unavailable" is used.
+	Message string
+
+	// OnTimeout, if not nil, is called with
+	// each request which times out.
+	OnTimeout func(r *http.Request)
+}
+
+// Handler returns an http.Handler which calls next,
+// applying the timeout.
+func (t RequestTimeout) Handler(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), t.Duration)
+		defer cancel()
+
+		tw := &timeoutResponseWriter{w: w, header: make(http.Header), ctx: ctx}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					tw.panicked(r, p, panicked)
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			if tw.finish() {
+				return
+			}
+		case <-ctx.Done():
+			tw.stop()
+			select {
+			case p := <-panicked:
+				// The handler panicked before the deadline.
+				panic(p)
+			default:
+			}
+			if ctx.Err() != context.DeadlineExceeded {
+				// The client disconnected, so there
+				// is nobody to send a response to.
+				return
+			}
+		}
+
+		tw.timeout(t.Duration, t.Message)
+		if t.OnTimeout != nil {
+			t.OnTimeout(r)
+		}
+	})
+}
+
+// timeoutResponseWriter coordinates writes by a handler with
+// the response sent when the handler times out. The handler
+// uses its own header map, so it can continue to modify its
+// headers after the timeout without a data race.
+type timeoutResponseWriter struct {
+	mu          sync.Mutex
+	w           http.ResponseWriter
+	header      http.Header
+	ctx         context.Context // The handler's context.
+	wroteHeader bool
+	timedOut    bool
+}
+
+// expired reports whether the deadline has passed, even if
+// the request has not yet been marked as timed out, so that
+// a handler cannot write after seeing its context expire.
+// tw.mu must be held.
+func (tw *timeoutResponseWriter) expired() bool {
+	return tw.timedOut || tw.ctx.Err() == context.DeadlineExceeded
+}
+
+func (tw *timeoutResponseWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired() || tw.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		// Informational responses are followed by
+		// the final status, so send them as they are.
+		tw.copyHeader()
+		tw.w.WriteHeader(status)
+		return
+	}
+	tw.writeHeader(status)
+}
+
+// writeHeader copies the handler's headers and
+// sends the response status. tw.mu must be held.
+func (tw *timeoutResponseWriter) writeHeader(status int) {
+	tw.wroteHeader = true
+	tw.copyHeader()
+	tw.w.WriteHeader(status)
+}
+
+// copyHeader copies the handler's headers to the
+// response. tw.mu must be held.
+func (tw *timeoutResponseWriter) copyHeader() {
+	header := tw.w.Header()
+	for key, values := range tw.header {
+		header[key] = append([]string(nil), values...)
+	}
+}
+
+func (tw *timeoutResponseWriter) Write(data []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.Write(data)
+}
+
+func (tw *timeoutResponseWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired() {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sends the handler's headers with an implicit
+// 200 OK status if the handler returned without writing
+// its response. It reports false if the deadline passed
+// before the response was written, so the request has
+// timed out.
+func (tw *timeoutResponseWriter) finish() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wroteHeader {
+		return true
+	}
+	if tw.expired() {
+		return false
+	}
+	tw.writeHeader(http.StatusOK)
+	return true
+}
+
+// stop prevents further writes by the handler.
+func (tw *timeoutResponseWriter) stop() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+}
+
+// panicked passes a panic in the handler on to be raised again
+// by the serving goroutine. If the deadline has already passed,
+// nothing is waiting for the handler, so the panic is logged
+// instead, unless it is http.ErrAbortHandler.
+func (tw *timeoutResponseWriter) panicked(r *http.Request, p interface{}, panicked chan<- interface{}) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.expired() {
+		panicked <- p
+		return
+	}
+	if p != http.ErrAbortHandler {
+		log.Printf("web: panic serving %s after timeout: %v\n%s", r.URL, p, debug.Stack())
+	}
+}
+
+// timeout prevents further writes by the handler, sending
+// a 503 Service Unavailable response with the given message
+// if the handler has not yet written its response status.
+func (tw *timeoutResponseWriter) timeout(d time.Duration, message string) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+	if tw.wroteHeader {
+		return
+	}
+
+	retry := int((d + time.Second - 1) / time.Second)
+	if retry < 1 {
+		retry = 1
+	}
+	if message == "" {
+		message = "Service Unavailable"
+	}
+	tw.w.Header().Set("Retry-After", strconv.Itoa(retry))
+	http.Error(tw.w, message, http.StatusServiceUnavailable)
+}
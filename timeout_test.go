@@ -0,0 +1,262 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimeoutSendsHeadersWithoutBody(t *testing.T) {
+	h := Timeout(time.Second)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Foo", "bar")
+		w.Header().Set("Set-Cookie", "session=1")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("X-Foo"); got != "bar" {
+		t.Errorf("got X-Foo %q, want %q", got, "bar")
+	}
+	if got := w.Header().Get("Set-Cookie"); got != "session=1" {
+		t.Errorf("got Set-Cookie %q, want %q", got, "session=1")
+	}
+}
+
+func TestTimeoutResponse(t *testing.T) {
+	var timedOut string
+	lateWrite := make(chan error, 1)
+	h := RequestTimeout{
+		Duration:  10 * time.Millisecond,
+		Message:   "busy",
+		OnTimeout: func(r *http.Request) { timedOut = r.URL.Path },
+	}.Handler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		_, err := io.WriteString(w, "late")
+		lateWrite <- err
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want 503", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q, want %q", got, "1")
+	}
+	if timedOut != "/slow" {
+		t.Errorf("OnTimeout called with %q, want %q", timedOut, "/slow")
+	}
+	if err := <-lateWrite; err != http.ErrHandlerTimeout {
+		t.Errorf("late write returned %v, want http.ErrHandlerTimeout", err)
+	}
+	if got := w.Body.String(); got != "busy\n" {
+		t.Errorf("got body %q, want %q", got, "busy\n")
+	}
+}
+
+// TestTimeoutLateWritesRace checks, when run with the race detector,
+// that a handler writing after the deadline does not race with the
+// timeout response.
+func TestTimeoutLateWritesRace(t *testing.T) {
+	var wg sync.WaitGroup
+	h := Timeout(5 * time.Millisecond)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			w.Header().Set("X-Count", strings.Repeat("x", i))
+			w.Write([]byte("data"))
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}))
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		// Read the response while the handler may still be writing.
+		_ = w.Header().Get("X-Count")
+		_ = w.Body.Len()
+	}
+	wg.Wait()
+}
+
+// TestTimeoutConcurrentDeadlines checks, when run with the race
+// detector, that concurrent handlers finishing just before or just
+// after the deadline send either their whole response or the
+// timeout response, never a mixture of the two.
+func TestTimeoutConcurrentDeadlines(t *testing.T) {
+	const d = 2 * time.Millisecond
+	var handlers sync.WaitGroup
+	h := Timeout(d)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		defer handlers.Done()
+		delay, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+		time.Sleep(delay)
+		w.Header().Set("X-Handler", "yes")
+		io.WriteString(w, "done")
+	}))
+
+	var requests sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		requests.Add(1)
+		handlers.Add(1)
+		delay := d - time.Millisecond + time.Duration(i%20)*100*time.Microsecond
+		go func() {
+			defer requests.Done()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/?delay="+delay.String(), nil))
+			switch w.Code {
+			case http.StatusOK:
+				if w.Body.String() != "done" || w.Header().Get("X-Handler") != "yes" {
+					t.Errorf("delay %v: got 200 %q with X-Handler %q", delay, w.Body.String(), w.Header().Get("X-Handler"))
+				}
+			case http.StatusServiceUnavailable:
+				if w.Body.String() != "Service Unavailable\n" || w.Header().Get("X-Handler") != "" {
+					t.Errorf("delay %v: got 503 %q with X-Handler %q", delay, w.Body.String(), w.Header().Get("X-Handler"))
+				}
+			default:
+				t.Errorf("delay %v: got status %d", delay, w.Code)
+			}
+		}()
+	}
+	requests.Wait()
+	handlers.Wait()
+}
+
+func TestTimeoutHandlerReturnsAfterDeadline(t *testing.T) {
+	// The handler sees its context expire, then returns
+	// without writing, before the timeout response is sent.
+	h := Timeout(time.Millisecond)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Header().Set("X-Handler", "yes")
+	}))
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("X-Handler") != "" {
+			t.Fatalf("got status %d with X-Handler %q, want 503", w.Code, w.Header().Get("X-Handler"))
+		}
+	}
+}
+
+func TestTimeoutPanicBeforeDeadline(t *testing.T) {
+	h := Timeout(time.Second)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	defer func() {
+		if recovered := recover(); recovered != "boom" {
+			t.Errorf("recovered %v, want boom", recovered)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestTimeoutPanicAfterDeadlineIsLogged(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	log.SetOutput(lockedWriter{&mu, &buf})
+	defer log.SetOutput(io.Discard)
+
+	panicked := make(chan struct{})
+	h := Timeout(5 * time.Millisecond)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		defer close(panicked)
+		panic("late boom")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/late", nil))
+	<-panicked
+
+	// Wait for the log message, which is written as the panic unwinds.
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		logged := buf.String()
+		mu.Unlock()
+		if strings.Contains(logged, "late boom") {
+			if !strings.Contains(logged, "/late") {
+				t.Errorf("log message %q does not include the request URL", logged)
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("panic after the deadline was not logged")
+}
+
+// lockedWriter serialises writes to an io.Writer.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func TestTimeoutClientDisconnected(t *testing.T) {
+	h := Timeout(time.Minute)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if w.Code == http.StatusServiceUnavailable || w.Body.Len() != 0 {
+		t.Errorf("got %d %q, want no response", w.Code, w.Body.String())
+	}
+}
+
+func TestTimeoutEarlyHints(t *testing.T) {
+	h := Timeout(time.Second)(Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	}))
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusCreated || string(body) != "created" {
+		t.Errorf("got %d %q, want 201 created", res.StatusCode, body)
+	}
+}
+
+func TestRequestTimeoutClientDisconnected(t *testing.T) {
+	timedOut := false
+	h := RequestTimeout{
+		Duration:  time.Minute,
+		OnTimeout: func(r *http.Request) { timedOut = true },
+	}.Handler(Handler(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if timedOut {
+		t.Error("OnTimeout was called for a disconnected client")
+	}
+}
@@ -5,65 +5,289 @@
 package web
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
-	"sync"
-	"time"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
-// DoNotCache uses the Cache-Control, Pragma, and Expires HTTP headers
-// to advise the client not to cache the response.
-func DoNotCache(w http.ResponseWriter) {
-	header := w.Header()
-	header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	header.Set("Pragma", "no-cache")
-	header.Set("Expires", "0")
-}
-
-// Cache uses the Last-Modified, Expires, and Vary HTTP headers to
-// advise the client to cache the response for the given duration.
-func Cache(w http.ResponseWriter, modTime time.Time, duration time.Duration) {
-	header := w.Header()
-	if !modTime.IsZero() {
-		header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
-	}
-	header.Set("Expires", time.Now().Add(duration).UTC().Format(http.TimeFormat))
-	header.Set("Vary", "Accept-Encoding")
-}
-
-// May be useful in cache durations.
-// Slightly less than one year, to conform to RFC 2616.
-var OneYear time.Duration = time.Hour * 24 * 364
-
 // RedirectToHTTPS takes an HTTP request and redirects it to the same
 // page, but using HTTPS. Be careful not to use in serving HTTPS, or
 // an infinite redirection loop will occur.
+//
+// If the request was made to port 80, the port is removed, so the
+// client uses the default HTTPS port instead. For other ports, use
+// RedirectToHTTPSPort.
 func RedirectToHTTPS(w http.ResponseWriter, r *http.Request) {
-	url := r.URL
-	url.Scheme = "https"
-	url.Host = r.Host
-	http.Redirect(w, r, url.String(), 301)
+	redirectToHTTPS(w, r, http.StatusMovedPermanently)
 }
 
 // RedirectToHttpsHandler can be used as an http.Handler which uses
 // RedirectToHTTPS above.
 var RedirectToHttpsHandler = Handler(RedirectToHTTPS)
 
+// RedirectToHTTPSWithCode creates an http.Handler which works like
+// RedirectToHTTPS, but uses the given redirect status code. This is
+// useful while testing a migration to HTTPS, as browsers cache 301
+// responses aggressively. RedirectToHTTPSWithCode panics if the code
+// is not a 3xx status code.
+func RedirectToHTTPSWithCode(code int) http.Handler {
+	checkRedirectCode(code)
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		redirectToHTTPS(w, r, code)
+	})
+}
+
+// TemporaryRedirectToHTTPS can be used as an http.Handler which works
+// like RedirectToHttpsHandler, but uses 307 Temporary Redirect, so is
+// not cached by the client and preserves the request method.
+var TemporaryRedirectToHTTPS = RedirectToHTTPSWithCode(http.StatusTemporaryRedirect)
+
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request, code int) {
+	u := requestURL(r, "https")
+	u.Host = stripPort(u.Host, 80)
+	http.Redirect(w, r, u.String(), code)
+}
+
+// RedirectToHTTPSPort creates an http.Handler which works like
+// RedirectToHTTPS, but replaces any port in the request's host
+// with the given port. If the port is 443, it is omitted.
+//
+//	// Redirects http://example.com:8080/ to https://example.com:8443/.
+//	site := web.NewSite("example.com", 8080, nil)
+//	site.Always(web.RedirectToHTTPSPort(8443))
+func RedirectToHTTPSPort(port int) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		u := requestURL(r, "https")
+		u.Host = joinHostPort(hostname(u.Host), port, 443)
+		http.Redirect(w, r, u.String(), 301)
+	})
+}
+
 // RedirectToHTTP takes an HTTPS request and redirects it to the same
 // page, but using HTTP. Be careful not to use in serving HTTP, or
 // an infinite redirection loop will occur.
+//
+// If the request was made to port 443, the port is removed, so the
+// client uses the default HTTP port instead.
 func RedirectToHTTP(w http.ResponseWriter, r *http.Request) {
-	url := r.URL
-	url.Scheme = "http"
-	url.Host = r.Host
-	http.Redirect(w, r, url.String(), 301)
+	redirectToHTTP(w, r, http.StatusMovedPermanently)
 }
 
 // RedirectToHttpHandler can be used as an http.Handler which uses
 // RedirectToHTTP above.
 var RedirectToHttpHandler = Handler(RedirectToHTTP)
 
+// RedirectToHTTPWithCode creates an http.Handler which works like
+// RedirectToHTTP, but uses the given redirect status code.
+// RedirectToHTTPWithCode panics if the code is not a 3xx status code.
+func RedirectToHTTPWithCode(code int) http.Handler {
+	checkRedirectCode(code)
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		redirectToHTTP(w, r, code)
+	})
+}
+
+func redirectToHTTP(w http.ResponseWriter, r *http.Request, code int) {
+	u := requestURL(r, "http")
+	u.Host = stripPort(u.Host, 443)
+	http.Redirect(w, r, u.String(), code)
+}
+
+// CanonicalHost creates Middleware which redirects requests made to
+// any host other than the given host to the same URL on that host,
+// using the given redirect status code, such as 301 Moved Permanently.
+// Hosts are compared case-insensitively, ignoring any port, and the
+// request's scheme and port are preserved, unless host includes a port.
+// CanonicalHost panics if the code is not a 3xx status code.
+//
+// CanonicalHost is equivalent to CanonicalHostRedirect{Host: host,
+// Code: code}.Middleware, so ignores any X-Forwarded-Host and
+// X-Forwarded-Proto HTTP headers. Behind a reverse proxy, use
+// CanonicalHostRedirect with TrustProxy set.
+//
+//	// Redirects example.com to www.example.com.
+//	site.Use(web.CanonicalHost("www.example.com", http.StatusMovedPermanently))
+func CanonicalHost(host string, code int) Middleware {
+	return CanonicalHostRedirect{Host: host, Code: code}.Middleware()
+}
+
+// CanonicalHostRedirect redirects requests to a canonical host, as
+// described in CanonicalHost.
+//
+// If TrustProxy is true, the X-Forwarded-Host and X-Forwarded-Proto
+// HTTP headers are used to determine the host and scheme the client
+// used, if present. Clients can set these headers to anything, so
+// this should only be set behind a proxy which sets them.
+//
+//	redirect := web.CanonicalHostRedirect{
+//		Host:       "www.example.com",
+//		Code:       http.StatusMovedPermanently,
+//		TrustProxy: true,
+//	}
+//	site.Use(redirect.Middleware())
+type CanonicalHostRedirect struct {
+	Host       string
+	Code       int
+	TrustProxy bool
+}
+
+// Middleware returns Middleware which performs the redirect.
+// Middleware panics if the code is not a 3xx status code.
+func (c CanonicalHostRedirect) Middleware() Middleware {
+	checkRedirectCode(c.Code)
+	host, code := c.Host, c.Code
+	canonical := strings.ToLower(hostname(host))
+
+	return func(next http.Handler) http.Handler {
+		return Handler(func(w http.ResponseWriter, r *http.Request) {
+			current := r.Host
+			if forwarded := r.Header.Get("X-Forwarded-Host"); c.TrustProxy && forwarded != "" {
+				current = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+			}
+			if strings.ToLower(strings.TrimSuffix(hostname(current), ".")) == canonical {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			if c.TrustProxy {
+				switch proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto {
+				case "http", "https":
+					scheme = proto
+				}
+			}
+
+			u := requestURL(r, scheme)
+			u.Host = host
+			if _, port, err := net.SplitHostPort(current); err == nil && hostname(host) == host {
+				u.Host = net.JoinHostPort(host, port)
+			}
+			http.Redirect(w, r, u.String(), code)
+		})
+	}
+}
+
+// checkRedirectCode panics if code is not a 3xx status code.
+func checkRedirectCode(code int) {
+	if code < 300 || code > 399 {
+		panic(fmt.Sprintf("web: invalid redirect status code %d", code))
+	}
+}
+
+// requestURL builds a fresh absolute URL for the given request, using
+// the given scheme. The path and query are taken from the request URI
+// as sent by the client, so the request's URL is never modified.
+func requestURL(r *http.Request, scheme string) *url.URL {
+	src := r.URL
+	if r.RequestURI != "" {
+		if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			src = u
+		}
+	}
+
+	out := &url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     src.Path,
+		RawPath:  src.RawPath,
+		RawQuery: src.RawQuery,
+		Fragment: src.Fragment,
+	}
+
+	// Absolute-form request URIs, as sent to proxies, carry their own host.
+	if out.Host == "" {
+		out.Host = src.Host
+	}
+	if out.Fragment == "" && r.URL != nil {
+		out.Fragment = r.URL.Fragment
+	}
+	return out
+}
+
+// localURL returns the given URL, which has no scheme or host,
+// as a string. Leading slashes are collapsed, so the result is
+// never a protocol-relative URL like //example.com.
+func localURL(u *url.URL) string {
+	target := u.String()
+	if strings.HasPrefix(target, "//") {
+		target = "/" + strings.TrimLeft(target, "/")
+	}
+	return target
+}
+
+// CanonicalizePath creates an http.Handler which redirects requests
+// whose path contains upper case letters to the same path in lower
+// case, using a 301 Moved Permanently response. Other requests are
+// passed to next. The query string is preserved.
+//
+//	site.CaseInsensitive = true
+//	site.Always(web.CanonicalizePath(web.Handler(serveContent)))
+func CanonicalizePath(next http.Handler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if lower := strings.ToLower(r.URL.Path); lower != r.URL.Path {
+			u := &url.URL{Path: MountPrefix(r) + lower, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, localURL(u), http.StatusMovedPermanently)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LowercasePathRedirect is equivalent to CanonicalizePath. Only the
+// path is changed; the host and query string are left untouched. The
+// fragment is never sent to the server, so browsers keep it across
+// the redirect.
+//
+//	site.Always(web.LowercasePathRedirect(web.Handler(serveContent)))
+func LowercasePathRedirect(next http.Handler) http.Handler {
+	return CanonicalizePath(next)
+}
+
+// hostname returns the host part of a host[:port] string, without
+// any brackets around IPv6 literals.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// remoteIP returns the IP address of the request's direct peer.
+func remoteIP(r *http.Request) string {
+	return hostname(r.RemoteAddr)
+}
+
+// joinHostPort combines the host and port into a host[:port] string,
+// omitting the port if it matches the scheme's default port.
+func joinHostPort(host string, port, defaultPort int) string {
+	if port != defaultPort {
+		return net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// stripPort removes the given port from a host[:port] string.
+func stripPort(host string, port int) string {
+	if h, p, err := net.SplitHostPort(host); err == nil && p == strconv.Itoa(port) {
+		return joinHostPort(h, port, port)
+	}
+	return host
+}
+
 // Redirect can be used as an http.Handler which redirects all requests
-// to the enclosed string.
+// to the enclosed string, using a 301 Moved Permanently response. For
+// other status codes, use NewRedirectWithCode.
 //
 //	site := web.NewSite("example.com", 80, nil)
 //	site.Always(Redirect("www.example.com")) // Redirects all requests to this URL.
@@ -74,6 +298,138 @@ func (s Redirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, string(s), 301)
 }
 
+// RedirectWithCode can be used as an http.Handler which redirects all
+// requests to URL, using the given status code, such as 302 Found or
+// 307 Temporary Redirect. ServeHTTP panics if the code is not a 3xx
+// status code. NewRedirectWithCode checks the code when the handler is
+// created instead, so is preferred.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//	site.Equals(web.RedirectWithCode{URL: "/new", Code: 307}, "/old")
+type RedirectWithCode struct {
+	URL  string
+	Code int
+}
+
+func (s RedirectWithCode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	checkRedirectCode(s.Code)
+	http.Redirect(w, r, s.URL, s.Code)
+}
+
+// NewRedirectWithCode creates an http.Handler which redirects all
+// requests to url, using the given status code. It panics if the code
+// is not a 3xx status code.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//	site.Equals(web.NewRedirectWithCode("/new", http.StatusTemporaryRedirect), "/old")
+func NewRedirectWithCode(url string, code int) http.Handler {
+	checkRedirectCode(code)
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, url, code)
+	})
+}
+
+// RedirectMap creates an http.Handler which redirects requests whose
+// path is a key in redirects to the corresponding URL, using the given
+// status code. Any query string in the request is added to the URL.
+// Other requests receive the 404 response of the Site serving them.
+// The map is copied, so it can safely be changed afterwards, and the
+// handler can be used concurrently. RedirectMap panics if the code is
+// not a 3xx status code.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//	site.Always(web.RedirectMap(map[string]string{
+//		"/about.php":   "/about",
+//		"/contact.php": "/contact",
+//	}, http.StatusMovedPermanently))
+func RedirectMap(redirects map[string]string, code int) http.Handler {
+	checkRedirectCode(code)
+	targets := make(map[string]string, len(redirects))
+	for from, to := range redirects {
+		targets[from] = to
+	}
+
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		target, ok := targets[r.URL.Path]
+		if !ok {
+			notFound(w, r)
+			return
+		}
+		http.Redirect(w, r, appendQuery(target, r.URL.RawQuery), code)
+	})
+}
+
+// RedirectStripPrefix creates an http.Handler which redirects requests
+// whose path starts with prefix to target, followed by the rest of the
+// path, using the given status code. The prefix must be followed by a
+// slash or the end of the path, so "/app" matches "/app" and "/app/foo",
+// but not "/application". Any query string in the request is added to
+// the URL. Other requests receive the 404 response of the Site serving
+// them. RedirectStripPrefix panics if the code is not a 3xx status code.
+//
+//	// Redirects /app/foo?x=1 to /foo?x=1.
+//	site := web.NewSite("example.com", 80, nil)
+//	site.HasPrefix(web.RedirectStripPrefix("/app", "", http.StatusMovedPermanently), "/app/")
+func RedirectStripPrefix(prefix, target string, code int) http.Handler {
+	checkRedirectCode(code)
+	prefix = strings.TrimSuffix(prefix, "/")
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, prefix)
+		if !strings.HasPrefix(r.URL.Path, prefix) || rest != "" && rest[0] != '/' {
+			notFound(w, r)
+			return
+		}
+		switch {
+		case strings.HasSuffix(target, "/"):
+			rest = strings.TrimPrefix(rest, "/")
+		case !strings.HasPrefix(rest, "/"):
+			rest = "/" + rest
+		}
+		http.Redirect(w, r, appendQuery(target+rest, r.URL.RawQuery), code)
+	})
+}
+
+// RedirectWithQuery creates an http.Handler which redirects all
+// requests to target, using the given status code. The request's
+// query parameters are added to those in target, except for those
+// which target already sets, so target's values take precedence.
+// RedirectWithQuery panics if target is not a valid URL, or if the
+// code is not a 3xx status code.
+//
+//	// Redirects /api/v1/users?page=2 to /api/users?page=2&version=1.
+//	site := web.NewSite("example.com", 80, nil)
+//	site.Equals(web.RedirectWithQuery("/api/users?version=1", http.StatusMovedPermanently), "/api/v1/users")
+func RedirectWithQuery(target string, code int) http.Handler {
+	checkRedirectCode(code)
+	u, err := url.Parse(target)
+	if err != nil {
+		panic(fmt.Sprintf("web: invalid redirect target %q: %v", target, err))
+	}
+	targetQuery := u.Query()
+
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for key, values := range targetQuery {
+			query[key] = values
+		}
+		dst := *u
+		dst.RawQuery = query.Encode()
+		http.Redirect(w, r, dst.String(), code)
+	})
+}
+
+// appendQuery adds the given raw query string to the URL.
+func appendQuery(target, query string) string {
+	switch {
+	case query == "":
+		return target
+	case strings.Contains(target, "?"):
+		return target + "&" + query
+	default:
+		return target + "?" + query
+	}
+}
+
 // UsePath creates a Handler which will call the given
 // PathHandler with a fixed path, allowing multiple URLs
 // to refer to the same content more simply.
@@ -107,36 +463,112 @@ func UsePrefix(prefix string, handler PathHandler) http.Handler {
 	})
 }
 
+// UseSuffix works similarly to UsePrefix, but will simply
+// append the given suffix to the request path.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//
+//	// Requests under /docs/ will all be served by calling
+//	// serveTemplate with the http.ResponseWriter, the
+//	// *http.Request, and the path request.URL.Path + ".tmpl".
+//	site.HasPrefix(web.UseSuffix(".tmpl", serveTemplate), "/docs/")
+//
+func UseSuffix(suffix string, handler PathHandler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, r.URL.Path+suffix)
+	})
+}
+
+// UseReplace works similarly to UsePrefix, but will replace
+// the first instance of old in the request path with new.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//
+//	// Requests under /assets/ will all be served by calling
+//	// serveFile with the http.ResponseWriter, the *http.Request,
+//	// and the request path, with /assets/ replaced by
+//	// /var/www/static/.
+//	site.HasPrefix(web.UseReplace("/assets/", "/var/www/static/", serveFile), "/assets/")
+//
+func UseReplace(old, new string, handler PathHandler) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, strings.Replace(r.URL.Path, old, new, 1))
+	})
+}
+
 // PathHandler represents a handler which takes a string
 // describing the filepath to the resource to serve.
 type PathHandler func(http.ResponseWriter, *http.Request, string)
 
-// Handler can be used as a shorter http.HandlerFunc.
-type Handler func(http.ResponseWriter, *http.Request)
-
-func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h(w, r)
+// UsePathWithError works like UsePath, but calls an
+// ErrorPathHandler, passing any error it returns to
+// onError. If onError is nil, DefaultPathErrorHandler
+// is used.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//
+//	// If serveHTML cannot find "content/index.html",
+//	// DefaultPathErrorHandler sends the 404 page.
+//	site.Equals(web.UsePathWithError("content/index.html", serveHTML, nil), "/", "/index.html")
+//
+func UsePathWithError(path string, handler ErrorPathHandler, onError PathErrorHandler) http.Handler {
+	if onError == nil {
+		onError = DefaultPathErrorHandler
+	}
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r, path); err != nil {
+			onError(w, r, err)
+		}
+	})
 }
 
-// PageViews is a simple structure
-// for recording page view counts
-// in a thread-safe manner.
-type PageViews struct {
-	sync.Mutex
-	count int64
+// UsePrefixWithError works like UsePrefix, but calls an
+// ErrorPathHandler, passing any error it returns to
+// onError. If onError is nil, DefaultPathErrorHandler
+// is used.
+//
+//	site := web.NewSite("example.com", 80, nil)
+//	site.HasSuffix(web.UsePrefixWithError("images", serveImage, serveImageError), ".jpg", ".png")
+//
+func UsePrefixWithError(prefix string, handler ErrorPathHandler, onError PathErrorHandler) http.Handler {
+	if onError == nil {
+		onError = DefaultPathErrorHandler
+	}
+	return Handler(func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r, prefix+r.URL.Path); err != nil {
+			onError(w, r, err)
+		}
+	})
 }
 
-// Add increments the count.
-func (p *PageViews) Add() {
-	p.Lock()
-	p.count++
-	p.Unlock()
+// ErrorPathHandler works like PathHandler, but returns an error
+// if the resource could not be served, rather than writing its
+// own error response.
+type ErrorPathHandler func(http.ResponseWriter, *http.Request, string) error
+
+// PathErrorHandler sends the response for an error returned by
+// an ErrorPathHandler, such as a custom error page.
+type PathErrorHandler func(http.ResponseWriter, *http.Request, error)
+
+// DefaultPathErrorHandler sends the response for an error serving
+// a path. Errors matching fs.ErrNotExist receive the 404 response of
+// the Site serving the request, errors matching fs.ErrPermission
+// receive a 403 Forbidden response, and other errors receive a 500
+// Internal Server Error response.
+func DefaultPathErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		notFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
 }
 
-// Count returns the number of page views.
-func (p *PageViews) Count() (count int64) {
-	p.Lock()
-	count = p.count
-	p.Unlock()
-	return count
+// Handler can be used as a shorter http.HandlerFunc.
+type Handler func(http.ResponseWriter, *http.Request)
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h(w, r)
 }
@@ -0,0 +1,455 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name, host, target, want string
+	}{
+		{"root", "example.com", "/", "https://example.com/"},
+		{"query", "example.com", "/search?q=foo&page=2", "https://example.com/search?q=foo&page=2"},
+		{"encoded query", "example.com", "/search?q=a%26b%3Dc", "https://example.com/search?q=a%26b%3Dc"},
+		{"escaped path", "example.com", "/a%20b", "https://example.com/a%20b"},
+		{"escaped slash", "example.com", "/files/a%2Fb", "https://example.com/files/a%2Fb"},
+		{"port 80", "example.com:80", "/a?b=c", "https://example.com/a?b=c"},
+		{"other port", "example.com:8080", "/", "https://example.com:8080/"},
+		{"absolute form", "", "http://proxy.example/a%20b?q=1", "https://proxy.example/a%20b?q=1"},
+		{"absolute form with host", "example.com", "http://proxy.example/x", "https://example.com/x"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		r.Host = test.host
+		before := *r.URL
+		w := httptest.NewRecorder()
+		RedirectToHTTPS(w, r)
+
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != test.want {
+			t.Errorf("%s: got %d to %q, want 301 to %q", test.name, w.Code, w.Header().Get("Location"), test.want)
+		}
+		if *r.URL != before {
+			t.Errorf("%s: request URL changed from %#v to %#v", test.name, before, *r.URL)
+		}
+	}
+}
+
+func TestRedirectToHTTP(t *testing.T) {
+	tests := []struct {
+		host, target, want string
+	}{
+		{"example.com:443", "/a%20b?q=1", "http://example.com/a%20b?q=1"},
+		{"example.com:8443", "/", "http://example.com:8443/"},
+		{"[::1]:443", "/", "http://[::1]/"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "https://"+test.host+test.target, nil)
+		r.RequestURI = test.target
+		w := httptest.NewRecorder()
+		RedirectToHTTP(w, r)
+		if got := w.Header().Get("Location"); got != test.want {
+			t.Errorf("%s%s: got %q, want %q", test.host, test.target, got, test.want)
+		}
+		if r.URL.Scheme != "https" {
+			t.Errorf("%s%s: request URL scheme changed to %q", test.host, test.target, r.URL.Scheme)
+		}
+	}
+}
+
+func TestRedirectToHTTPSPort(t *testing.T) {
+	tests := []struct {
+		port       int
+		host, want string
+	}{
+		{8443, "example.com:8080", "https://example.com:8443/a?b=c"},
+		{8443, "example.com", "https://example.com:8443/a?b=c"},
+		{443, "example.com:8080", "https://example.com/a?b=c"},
+		{8443, "[::1]:8080", "https://[::1]:8443/a?b=c"},
+		{8443, "[::1]", "https://[::1]:8443/a?b=c"},
+		{443, "[::1]:8080", "https://[::1]/a?b=c"},
+		{443, "[2001:db8::1]", "https://[2001:db8::1]/a?b=c"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/a?b=c", nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		RedirectToHTTPSPort(test.port).ServeHTTP(w, r)
+		if got := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || got != test.want {
+			t.Errorf("%s to port %d: got %d to %q, want 301 to %q", test.host, test.port, w.Code, got, test.want)
+		}
+	}
+}
+
+func TestRedirectStripPrefix(t *testing.T) {
+	tests := []struct {
+		prefix, target, path string
+		want                 string
+	}{
+		{"/app", "", "/app/foo?x=1", "/foo?x=1"},
+		{"/app", "", "/app", "/"},
+		{"/app", "", "/app/", "/"},
+		{"/app/", "", "/app/foo", "/foo"},
+		{"/app", "/v2", "/app/foo", "/v2/foo"},
+		{"/app", "/v2/", "/app/foo", "/v2/foo"},
+		{"/app", "https://example.org", "/app/a/b", "https://example.org/a/b"},
+
+		// The prefix must end at a path segment boundary.
+		{"/app", "", "/application", ""},
+		{"/app", "", "/apps/foo", ""},
+		{"/app", "", "/other", ""},
+	}
+	for _, test := range tests {
+		h := RedirectStripPrefix(test.prefix, test.target, http.StatusMovedPermanently)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if test.want == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%q, %q: %s got %d to %q, want 404", test.prefix, test.target, test.path, w.Code, w.Header().Get("Location"))
+			}
+			continue
+		}
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != test.want {
+			t.Errorf("%q, %q: %s got %d to %q, want 301 to %q", test.prefix, test.target, test.path, w.Code, w.Header().Get("Location"), test.want)
+		}
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		host, target string
+		forwarded    map[string]string
+		trustProxy   bool
+		want         string
+	}{
+		{"www.example.com", "http://www.example.com/a", nil, false, ""},
+		{"www.example.com", "http://WWW.Example.com:8080/a", nil, false, ""},
+		{"www.example.com", "http://www.example.com./a", nil, false, ""},
+		{"www.example.com", "http://example.com/a?b=c", nil, false, "http://www.example.com/a?b=c"},
+		{"www.example.com", "https://example.com/a", nil, false, "https://www.example.com/a"},
+		{"www.example.com", "http://example.com:8080/a", nil, false, "http://www.example.com:8080/a"},
+		{"www.example.com:9090", "http://example.com:8080/a", nil, false, "http://www.example.com:9090/a"},
+
+		// Forwarded headers are ignored unless the proxy is trusted.
+		{"www.example.com", "http://example.com/a", map[string]string{"X-Forwarded-Host": "www.example.com"}, false, "http://www.example.com/a"},
+		{"www.example.com", "http://example.com/a", map[string]string{"X-Forwarded-Proto": "https"}, false, "http://www.example.com/a"},
+		{"www.example.com", "http://www.example.com/a", map[string]string{"X-Forwarded-Host": "evil.example"}, false, ""},
+		{"www.example.com", "http://internal/a", map[string]string{"X-Forwarded-Host": "www.example.com, internal"}, true, ""},
+		{"www.example.com", "http://internal/a", map[string]string{"X-Forwarded-Host": "example.com", "X-Forwarded-Proto": "https"}, true, "https://www.example.com/a"},
+		{"www.example.com", "http://internal/a", map[string]string{"X-Forwarded-Proto": "ftp"}, true, "http://www.example.com/a"},
+	}
+	for _, test := range tests {
+		h := CanonicalHostRedirect{Host: test.host, Code: http.StatusMovedPermanently, TrustProxy: test.trustProxy}.Middleware()(namedHandler("next"))
+		if !test.trustProxy {
+			h = CanonicalHost(test.host, http.StatusMovedPermanently)(namedHandler("next"))
+		}
+		r := httptest.NewRequest("GET", test.target, nil)
+		for key, value := range test.forwarded {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if test.want == "" {
+			if w.Code != http.StatusOK || w.Body.String() != "next" {
+				t.Errorf("%s %v: got %d to %q, want no redirect", test.target, test.forwarded, w.Code, w.Header().Get("Location"))
+			}
+			continue
+		}
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != test.want {
+			t.Errorf("%s %v: got %d to %q, want 301 to %q", test.target, test.forwarded, w.Code, w.Header().Get("Location"), test.want)
+		}
+	}
+}
+
+func TestCanonicalHostInvalidCode(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("CanonicalHost did not panic")
+		}
+	}()
+	CanonicalHost("www.example.com", http.StatusOK)
+}
+
+func TestUsePathWithError(t *testing.T) {
+	serve := func(w http.ResponseWriter, r *http.Request, path string) error {
+		switch path {
+		case "content/index.html", "images/a.png":
+			io.WriteString(w, path)
+			return nil
+		case "content/private.html", "images/private.png":
+			return fs.ErrPermission
+		case "content/broken.html":
+			return errors.New("disk on fire")
+		}
+		return &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	var handled error
+	custom := func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.WriteHeader(http.StatusTeapot)
+	}
+
+	tests := []struct {
+		name   string
+		h      http.Handler
+		target string
+		status int
+		body   string
+	}{
+		{"path", UsePathWithError("content/index.html", serve, nil), "/", http.StatusOK, "content/index.html"},
+		{"path missing", UsePathWithError("content/missing.html", serve, nil), "/", http.StatusNotFound, "404 page not found\n"},
+		{"path forbidden", UsePathWithError("content/private.html", serve, nil), "/", http.StatusForbidden, "Forbidden\n"},
+		{"path failed", UsePathWithError("content/broken.html", serve, nil), "/", http.StatusInternalServerError, "Internal Server Error\n"},
+		{"prefix", UsePrefixWithError("images", serve, nil), "/a.png", http.StatusOK, "images/a.png"},
+		{"prefix missing", UsePrefixWithError("images", serve, nil), "/b.png", http.StatusNotFound, "404 page not found\n"},
+		{"prefix forbidden", UsePrefixWithError("images", serve, nil), "/private.png", http.StatusForbidden, "Forbidden\n"},
+		{"custom path", UsePathWithError("content/missing.html", serve, custom), "/", http.StatusTeapot, ""},
+		{"custom prefix", UsePrefixWithError("images", serve, custom), "/b.png", http.StatusTeapot, ""},
+	}
+	for _, test := range tests {
+		handled = nil
+		w := httptest.NewRecorder()
+		test.h.ServeHTTP(w, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, w.Code, w.Body.String(), test.status, test.body)
+		}
+		if custom := test.status == http.StatusTeapot; custom != errors.Is(handled, fs.ErrNotExist) {
+			t.Errorf("%s: custom error handler received %v", test.name, handled)
+		}
+	}
+}
+
+// writePath is a PathHandler which writes the path it is given.
+func writePath(w http.ResponseWriter, r *http.Request, path string) {
+	io.WriteString(w, path)
+}
+
+func TestUseSuffix(t *testing.T) {
+	site := NewSite("example.com", 80, nil)
+	site.HasPrefix(UseSuffix(".tmpl", writePath), "/docs/")
+
+	for target, want := range map[string]string{
+		"/docs/intro":      "/docs/intro.tmpl",
+		"/docs/api/v1":     "/docs/api/v1.tmpl",
+		"/docs/page.html":  "/docs/page.html.tmpl",
+		"/docs/a?b=c.tmpl": "/docs/a.tmpl",
+	} {
+		if got := serveSite(site, "GET", target).Body.String(); got != want {
+			t.Errorf("%s: got path %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestRedirectToHTTPSWithCode(t *testing.T) {
+	tests := []struct {
+		name string
+		h    http.Handler
+		code int
+	}{
+		{"found", RedirectToHTTPSWithCode(http.StatusFound), http.StatusFound},
+		{"temporary", TemporaryRedirectToHTTPS, http.StatusTemporaryRedirect},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/a?b=c", nil)
+		r.Host = "example.com:80"
+		w := httptest.NewRecorder()
+		test.h.ServeHTTP(w, r)
+		if got := w.Header().Get("Location"); w.Code != test.code || got != "https://example.com/a?b=c" {
+			t.Errorf("%s: got %d to %q, want %d to https://example.com/a?b=c", test.name, w.Code, got, test.code)
+		}
+	}
+}
+
+func TestRedirectWithCode(t *testing.T) {
+	tests := []struct {
+		name string
+		h    http.Handler
+	}{
+		{"type", RedirectWithCode{URL: "/new", Code: http.StatusTemporaryRedirect}},
+		{"constructor", NewRedirectWithCode("/new", http.StatusTemporaryRedirect)},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.h.ServeHTTP(w, httptest.NewRequest("POST", "/old", nil))
+		if got := w.Header().Get("Location"); w.Code != http.StatusTemporaryRedirect || got != "/new" {
+			t.Errorf("%s: got %d to %q, want 307 to /new", test.name, w.Code, got)
+		}
+	}
+}
+
+func TestRedirectWithCodeInvalidCode(t *testing.T) {
+	for _, code := range []int{0, http.StatusOK, http.StatusNotFound} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewRedirectWithCode did not panic with code %d", code)
+				}
+			}()
+			NewRedirectWithCode("/new", code)
+		}()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RedirectWithCode did not panic with code %d", code)
+				}
+			}()
+			RedirectWithCode{URL: "/new", Code: code}.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("RedirectToHTTPSWithCode did not panic")
+		}
+	}()
+	RedirectToHTTPSWithCode(http.StatusOK)
+}
+
+func TestCanonicalizePath(t *testing.T) {
+	h := CanonicalizePath(namedHandler("next"))
+	tests := []struct {
+		target string
+		want   int
+		path   string
+	}{
+		{"/About", http.StatusMovedPermanently, "/about"},
+		{"/Docs/Intro?Q=X", http.StatusMovedPermanently, "/docs/intro?Q=X"},
+		{"//Example.com/", http.StatusMovedPermanently, "/example.com/"},
+		{"/about?Q=X", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		w := serveSite(h, "GET", test.target)
+		if w.Code != test.want || w.Header().Get("Location") != test.path {
+			t.Errorf("%s: got %d to %q, want %d to %q", test.target, w.Code, w.Header().Get("Location"), test.want, test.path)
+		}
+		if test.want == http.StatusOK && w.Body.String() != "next" {
+			t.Errorf("%s: next was not called", test.target)
+		}
+	}
+}
+
+func TestLowercasePathRedirect(t *testing.T) {
+	h := LowercasePathRedirect(namedHandler("next"))
+	w := serveSite(h, "GET", "/Blog/Post?Ref=Home")
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/blog/post?Ref=Home" {
+		t.Errorf("got %d to %q, want 301 to /blog/post?Ref=Home", w.Code, w.Header().Get("Location"))
+	}
+	if w := serveSite(h, "GET", "/blog/post"); w.Code != http.StatusOK || w.Body.String() != "next" {
+		t.Errorf("got %d %q for a lower case path, want 200 next", w.Code, w.Body.String())
+	}
+
+	// Mounted sites redirect to the full path.
+	sub := NewSite("example.com", 80, nil)
+	sub.Always(h)
+	site := NewSite("example.com", 80, nil)
+	site.Mount("/sub", sub)
+	if w := serveSite(site, "GET", "/sub/Post"); w.Header().Get("Location") != "/sub/post" {
+		t.Errorf("got %d to %q, want 301 to /sub/post", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestRedirectMap(t *testing.T) {
+	redirects := map[string]string{
+		"/about.php":   "/about",
+		"/search.php":  "/search?source=old",
+		"/contact.php": "https://example.com/contact",
+	}
+	h := RedirectMap(redirects, http.StatusFound)
+	redirects["/about.php"] = "/changed"
+	redirects["/added.php"] = "/added"
+
+	site := NewSite("example.com", 80, nil)
+	site.Always(h)
+
+	tests := []struct {
+		target string
+		want   int
+		url    string
+	}{
+		{"/about.php", http.StatusFound, "/about"},
+		{"/about.php?a=1&b=2", http.StatusFound, "/about?a=1&b=2"},
+		{"/search.php?q=go", http.StatusFound, "/search?source=old&q=go"},
+		{"/contact.php", http.StatusFound, "https://example.com/contact"},
+		{"/added.php", http.StatusNotFound, ""},
+		{"/about.php/", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := serveSite(site, "GET", test.target)
+		if w.Code != test.want || w.Header().Get("Location") != test.url {
+			t.Errorf("%s: got %d to %q, want %d to %q", test.target, w.Code, w.Header().Get("Location"), test.want, test.url)
+		}
+	}
+}
+
+func TestRedirectMapInvalidCode(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RedirectMap did not panic")
+		}
+	}()
+	RedirectMap(map[string]string{"/a": "/b"}, http.StatusOK)
+}
+
+func TestRedirectWithQuery(t *testing.T) {
+	h := RedirectWithQuery("/api/users?version=1", http.StatusMovedPermanently)
+	tests := []struct {
+		target, want string
+	}{
+		{"/api/v1/users", "/api/users?version=1"},
+		{"/api/v1/users?page=2", "/api/users?page=2&version=1"},
+		{"/api/v1/users?version=2&page=2", "/api/users?page=2&version=1"},
+		{"/api/v1/users?q=a%26b", "/api/users?q=a%26b&version=1"},
+	}
+	for _, test := range tests {
+		w := serveSite(h, "GET", test.target)
+		if got := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || got != test.want {
+			t.Errorf("%s: got %d to %q, want 301 to %q", test.target, w.Code, got, test.want)
+		}
+	}
+
+	w := serveSite(RedirectWithQuery("https://example.com/search", http.StatusFound), "GET", "/find?q=go")
+	if got := w.Header().Get("Location"); w.Code != http.StatusFound || got != "https://example.com/search?q=go" {
+		t.Errorf("got %d to %q, want 302 to https://example.com/search?q=go", w.Code, got)
+	}
+}
+
+func TestRedirectWithQueryInvalid(t *testing.T) {
+	for _, test := range []struct {
+		target string
+		code   int
+	}{
+		{"/a", http.StatusOK},
+		{"http://[::1", http.StatusFound},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RedirectWithQuery(%q, %d) did not panic", test.target, test.code)
+				}
+			}()
+			RedirectWithQuery(test.target, test.code)
+		}()
+	}
+}
+
+func TestUseReplace(t *testing.T) {
+	h := UseReplace("/assets/", "/var/www/static/", writePath)
+	for target, want := range map[string]string{
+		"/assets/css/site.css":  "/var/www/static/css/site.css",
+		"/assets/a/assets/b.js": "/var/www/static/a/assets/b.js",
+		"/other/a.js":           "/other/a.js",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if got := w.Body.String(); got != want {
+			t.Errorf("%s: got path %q, want %q", target, got, want)
+		}
+	}
+}
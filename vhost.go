@@ -0,0 +1,65 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+// VirtualHosts is used to serve multiple sites from one listener,
+// choosing the site using the request's Host header.
+//
+// Each key in Hosts is either an exact host name, such as
+// "www.example.com", or a wildcard, such as "*.example.com",
+// which matches any subdomain. Exact host names are preferred,
+// followed by the longest matching wildcard. Host names are
+// compared case-insensitively, and the port is ignored. If no
+// hosts match, the request is passed to Default, or a 404 Not
+// Found response is sent if Default is nil.
+//
+//	hosts := web.VirtualHosts{
+//		Hosts: map[string]*web.Site{
+//			"example.com":     site,
+//			"www.example.com": site,
+//			"api.example.com": api,
+//			"*.example.com":   users,
+//		},
+//		Default: site,
+//	}
+//	err := http.ListenAndServe(":80", hosts)
+type VirtualHosts struct {
+	Hosts   map[string]*Site
+	Default *Site
+}
+
+// ServeHTTP allows VirtualHosts to fulfil the http.Handler interface.
+func (v VirtualHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if site := v.site(r.Host); site != nil {
+		site.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// site returns the site for the given host.
+func (v VirtualHosts) site(host string) *Site {
+	host = strings.ToLower(strings.TrimSuffix(hostname(host), "."))
+	var best *Site
+	bestLen := 0
+	for pattern, site := range v.Hosts {
+		pattern = strings.ToLower(pattern)
+		if pattern == host {
+			return site
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) && len(pattern) > bestLen {
+			best, bestLen = site, len(pattern)
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return v.Default
+}
@@ -0,0 +1,86 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// namedSite returns a site which writes
+// its name for every request.
+func namedSite(name string) *Site {
+	site := NewSite(name, 80, nil)
+	site.Always(namedHandler(name))
+	return site
+}
+
+func TestVirtualHosts(t *testing.T) {
+	hosts := VirtualHosts{
+		Hosts: map[string]*Site{
+			"example.com":         namedSite("example"),
+			"api.example.com":     namedSite("api"),
+			"*.example.com":       namedSite("wildcard"),
+			"*.users.example.com": namedSite("users"),
+			"Upper.Example.com":   namedSite("upper"),
+		},
+	}
+
+	tests := []struct {
+		host, want string
+	}{
+		// Exact names beat wildcards.
+		{"example.com", "example"},
+		{"api.example.com", "api"},
+
+		// The longest wildcard wins.
+		{"www.example.com", "wildcard"},
+		{"a.b.example.com", "wildcard"},
+		{"jamie.users.example.com", "users"},
+		{"users.example.com", "wildcard"},
+
+		// Case, ports and a trailing dot are ignored.
+		{"API.Example.COM", "api"},
+		{"upper.example.com", "upper"},
+		{"example.com:8080", "example"},
+		{"api.example.com.", "api"},
+		{"www.example.com:443", "wildcard"},
+
+		// A wildcard does not match the bare domain's suffix alone.
+		{"badexample.com", "404 page not found\n"},
+		{"example.org", "404 page not found\n"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		hosts.ServeHTTP(w, r)
+		if got := w.Body.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.host, got, test.want)
+		}
+	}
+}
+
+func TestVirtualHostsDefault(t *testing.T) {
+	hosts := VirtualHosts{
+		Hosts:   map[string]*Site{"example.com": namedSite("example")},
+		Default: namedSite("default"),
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "example.org"
+	w := httptest.NewRecorder()
+	hosts.ServeHTTP(w, r)
+	if got := w.Body.String(); got != "default" {
+		t.Errorf("got %q, want default", got)
+	}
+
+	hosts.Default = nil
+	w = httptest.NewRecorder()
+	hosts.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", w.Code)
+	}
+}
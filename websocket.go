@@ -0,0 +1,523 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Errors returned by UpgradeWebSocket and WSConn.
+var (
+	ErrBadHandshake      = errors.New("web: bad WebSocket handshake")
+	ErrWSProtocol        = errors.New("web: WebSocket protocol error")
+	ErrWSMessageTooLarge = errors.New("web: WebSocket message too large")
+	ErrWSClosed          = errors.New("web: WebSocket connection closed")
+)
+
+// DefaultWSMaxMessageSize is the largest message read by a
+// WSConn, if WSOptions.MaxMessageSize is not set.
+const DefaultWSMaxMessageSize = 1 << 20
+
+// wsGUID is used to compute the Sec-WebSocket-Accept
+// header, as defined in RFC 6455, section 1.3.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WSMessageType is the type of a WebSocket message.
+type WSMessageType int
+
+// The types of WebSocket data messages.
+const (
+	WSText   WSMessageType = 1
+	WSBinary WSMessageType = 2
+)
+
+// WebSocket frame opcodes, as defined in RFC 6455, section 5.2.
+const (
+	wsContinuation = 0
+	wsClose        = 8
+	wsPing         = 9
+	wsPong         = 10
+)
+
+// WebSocket close status codes, as defined in RFC 6455, section 7.4.1.
+const (
+	WSCloseNormal          = 1000
+	WSCloseGoingAway       = 1001
+	WSCloseProtocolError   = 1002
+	WSCloseUnsupportedData = 1003
+	WSCloseNoStatus        = 1005
+	WSCloseInvalidData     = 1007
+	WSClosePolicyViolation = 1008
+	WSCloseMessageTooBig   = 1009
+	WSCloseInternalError   = 1011
+)
+
+// WSCloseError is returned by WSConn.ReadMessage when the client
+// closes the connection. Code is the status code sent by the client,
+// or WSCloseNoStatus if it sent none.
+type WSCloseError struct {
+	Code int
+	Text string
+}
+
+func (e *WSCloseError) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("web: WebSocket closed with status %d: %s", e.Code, e.Text)
+	}
+	return fmt.Sprintf("web: WebSocket closed with status %d", e.Code)
+}
+
+// WSOptions controls how UpgradeWebSocket accepts connections.
+type WSOptions struct {
+	// Subprotocols lists the subprotocols supported by the
+	// server, in order of preference. The first one also
+	// requested by the client is used.
+	Subprotocols []string
+
+	// CheckOrigin reports whether the request's Origin header
+	// is acceptable. If nil, requests are only accepted if the
+	// Origin header is missing, or has the same host as the
+	// request, so other sites cannot connect from a browser.
+	CheckOrigin func(r *http.Request) bool
+
+	// MaxMessageSize is the size of the largest message read.
+	// If zero, DefaultWSMaxMessageSize is used.
+	MaxMessageSize int64
+}
+
+// WSConn is a server's WebSocket connection, as defined in RFC 6455.
+// Pings from the client are answered automatically while reading
+// messages. Extensions, such as permessage-deflate, are not supported.
+//
+// WSConn supports one concurrent reader, and any number of concurrent
+// writers.
+type WSConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	maxSize     int64
+	readErr     error
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+// UpgradeWebSocket performs the WebSocket opening handshake, as described
+// in RFC 6455, section 4.2, taking over the connection from the HTTP
+// server. If the request is not a valid WebSocket handshake, or its
+// origin is not accepted, an error response is sent, such as 400 Bad
+// Request or 403 Forbidden, and an error matching ErrBadHandshake is
+// returned. The handler must not write to w after UpgradeWebSocket
+// returns.
+//
+//	func serveChat(w http.ResponseWriter, r *http.Request) {
+//		conn, err := web.UpgradeWebSocket(w, r, web.WSOptions{Subprotocols: []string{"chat.v1"}})
+//		if err != nil {
+//			return
+//		}
+//		defer conn.Close()
+//		for {
+//			typ, msg, err := conn.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			if err := conn.WriteMessage(typ, msg); err != nil {
+//				return
+//			}
+//		}
+//	}
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request, opts WSOptions) (*WSConn, error) {
+	fail := func(code int, reason string) (*WSConn, error) {
+		http.Error(w, http.StatusText(code), code)
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+	}
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		return fail(http.StatusMethodNotAllowed, "method is not GET")
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a WebSocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+
+	subprotocol := ""
+	requested := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	for _, supported := range opts.Subprotocols {
+		if contains(requested, supported) {
+			subprotocol = supported
+			break
+		}
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+	// Remove any deadlines set by the HTTP server.
+	conn.SetDeadline(time.Time{})
+
+	var buf strings.Builder
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	buf.WriteString("Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n")
+	if subprotocol != "" {
+		buf.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	if _, err := rw.WriteString(buf.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	maxSize := opts.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultWSMaxMessageSize
+	}
+	return &WSConn{conn: conn, br: rw.Reader, subprotocol: subprotocol, maxSize: maxSize}, nil
+}
+
+// wsAccept returns the Sec-WebSocket-Accept
+// header value for the given key.
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// sameOrigin reports whether the request's Origin
+// header is missing or has the request's host.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// headerTokens returns the elements of the
+// comma-separated lists in the named header.
+func headerTokens(header http.Header, name string) []string {
+	var tokens []string
+	for _, value := range header.Values(name) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// headerContainsToken reports whether the named header
+// contains the token, ignoring case.
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, t := range headerTokens(header, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subprotocol returns the subprotocol chosen during the
+// handshake, or the empty string if there is none.
+func (c *WSConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// ReadMessage reads the next text or binary message from the client,
+// joining fragmented messages. Pings received meanwhile are answered.
+//
+// If the client closes the connection, the close is acknowledged and a
+// *WSCloseError is returned. If the client breaks the protocol, or sends
+// a message larger than the maximum size, the connection is closed with
+// the appropriate status code, and an error matching ErrWSProtocol or
+// ErrWSMessageTooLarge is returned. Once ReadMessage has returned an
+// error, it returns the same error on each call.
+func (c *WSConn) ReadMessage() (WSMessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, msg, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		return 0, nil, err
+	}
+	return typ, msg, nil
+}
+
+func (c *WSConn) readMessage() (WSMessageType, []byte, error) {
+	var typ WSMessageType
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame(int64(len(msg)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil && err != ErrWSClosed {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return 0, nil, c.handleClose(payload)
+		case wsContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(WSCloseProtocolError, "unexpected continuation frame")
+			}
+		case int(WSText), int(WSBinary):
+			if typ != 0 {
+				return 0, nil, c.fail(WSCloseProtocolError, "expected continuation frame")
+			}
+			typ = WSMessageType(opcode)
+		default:
+			return 0, nil, c.fail(WSCloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		msg = append(msg, payload...)
+		if fin {
+			if typ == WSText && !utf8.Valid(msg) {
+				return 0, nil, c.fail(WSCloseInvalidData, "invalid UTF-8 in text message")
+			}
+			if msg == nil {
+				msg = []byte{}
+			}
+			return typ, msg, nil
+		}
+	}
+}
+
+// readFrame reads a frame from the client, as described in RFC 6455,
+// section 5.2. The size of data frames is limited so that the message
+// is not too large, given the bytes already read.
+func (c *WSConn) readFrame(read int64) (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(WSCloseProtocolError, "reserved bits set")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(WSCloseProtocolError, "client frame is not masked")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n > 1<<63-1 {
+			return false, 0, nil, c.fail(WSCloseProtocolError, "invalid frame length")
+		}
+		length = int64(n)
+	}
+
+	if opcode >= wsClose {
+		if !fin || length > 125 {
+			return false, 0, nil, c.fail(WSCloseProtocolError, "invalid control frame")
+		}
+	} else if length > c.maxSize-read {
+		return false, 0, nil, c.fail(WSCloseMessageTooBig, "")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// handleClose acknowledges a close frame from the
+// client, returning the resulting error.
+func (c *WSConn) handleClose(payload []byte) error {
+	closeErr := &WSCloseError{Code: WSCloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(WSCloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(WSCloseProtocolError, "invalid close status code")
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return c.fail(WSCloseInvalidData, "invalid UTF-8 in close reason")
+		}
+	}
+
+	var reply []byte
+	if closeErr.Code != WSCloseNoStatus {
+		reply = closePayload(closeErr.Code, "")
+	}
+	c.writeFrame(wsClose, reply)
+	c.conn.Close()
+	return closeErr
+}
+
+// validCloseCode reports whether the close status
+// code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail closes the connection with the given status
+// code, returning the corresponding error.
+func (c *WSConn) fail(code int, reason string) error {
+	c.writeFrame(wsClose, closePayload(code, ""))
+	c.conn.Close()
+	if code == WSCloseMessageTooBig {
+		return ErrWSMessageTooLarge
+	}
+	return fmt.Errorf("%w: %s", ErrWSProtocol, reason)
+}
+
+// closePayload returns the payload of a close frame.
+func closePayload(code int, text string) []byte {
+	payload := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], text)
+	return payload
+}
+
+// WriteMessage sends a text or binary message to the client, in a
+// single frame. Text messages must be valid UTF-8. WriteMessage
+// returns ErrWSClosed if the connection has been closed.
+func (c *WSConn) WriteMessage(typ WSMessageType, data []byte) error {
+	if typ != WSText && typ != WSBinary {
+		return fmt.Errorf("web: invalid WebSocket message type %d", typ)
+	}
+	return c.writeFrame(int(typ), data)
+}
+
+// Ping sends a ping to the client, with the given data,
+// which must be at most 125 bytes. The client's pong is
+// received by ReadMessage.
+func (c *WSConn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("web: WebSocket ping is longer than 125 bytes")
+	}
+	return c.writeFrame(wsPing, data)
+}
+
+// CloseWithStatus sends a close frame with the given status
+// code and reason, which must be at most 123 bytes, then
+// closes the connection.
+func (c *WSConn) CloseWithStatus(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	err := c.writeFrame(wsClose, closePayload(code, reason))
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err == ErrWSClosed {
+		return nil
+	}
+	return err
+}
+
+// Close closes the connection with the status code
+// WSCloseNormal.
+func (c *WSConn) Close() error {
+	return c.CloseWithStatus(WSCloseNormal, "")
+}
+
+// SetReadDeadline sets the time after which reads from the
+// connection fail, such as to close idle connections.
+func (c *WSConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the time after which writes to the
+// connection fail.
+func (c *WSConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// writeFrame sends an unmasked frame, as servers must not
+// mask frames. No frames are sent after a close frame.
+func (c *WSConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrWSClosed
+	}
+	if opcode == wsClose {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	_, err := c.conn.Write(frame)
+	return err
+}
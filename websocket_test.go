@@ -0,0 +1,410 @@
+// Copyright 2013 Jamie Hall. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWSKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// wsClient is a raw WebSocket client, connected
+// over TCP loopback.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+// dialWS sends a handshake to the server, with the given
+// extra header lines, and reads the response.
+func dialWS(t *testing.T, srv *httptest.Server, headers ...string) *wsClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Key: " + testWSKey + "\r\n"
+	hasVersion := false
+	for _, header := range headers {
+		hasVersion = hasVersion || strings.HasPrefix(header, "Sec-WebSocket-Version:")
+		req += header + "\r\n"
+	}
+	if !hasVersion {
+		req += "Sec-WebSocket-Version: 13\r\n"
+	}
+	fmt.Fprint(conn, req+"\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{t: t, conn: conn, br: br, resp: resp}
+}
+
+// writeFrame sends a masked frame.
+func (c *wsClient) writeFrame(fin bool, opcode int, payload []byte) {
+	c.writeRawFrame(fin, opcode, payload, true)
+}
+
+func (c *wsClient) writeRawFrame(fin bool, opcode int, payload []byte, masked bool) {
+	c.t.Helper()
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	data := append([]byte(nil), payload...)
+	if masked {
+		mask := []byte{0x12, 0x34, 0x56, 0x78}
+		frame = append(frame, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(append(frame, data...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// readFrame reads a frame from the server, which
+// must not be masked or fragmented.
+func (c *wsClient) readFrame() (opcode int, payload []byte) {
+	c.t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		c.t.Fatalf("got frame header %x, want unmasked final frame", header)
+	}
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+// expectClose reads a close frame with the given status code.
+func (c *wsClient) expectClose(code int) {
+	c.t.Helper()
+	opcode, payload := c.readFrame()
+	if opcode != wsClose || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		c.t.Errorf("got frame %d %x, want close with status %d", opcode, payload, code)
+	}
+}
+
+// wsServer runs a server which upgrades connections, then
+// calls serve. Errors from serve are sent to the channel.
+func wsServer(t *testing.T, opts WSOptions, serve func(*WSConn) error) (*httptest.Server, chan error) {
+	errs := make(chan error, 1)
+	srv := httptest.NewServer(Handler(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := UpgradeWebSocket(w, r, opts)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		errs <- serve(conn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, errs
+}
+
+// echoWS echoes messages until an error.
+func echoWS(conn *WSConn) error {
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteMessage(typ, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func TestUpgradeWebSocketHandshake(t *testing.T) {
+	opts := WSOptions{Subprotocols: []string{"chat.v2", "chat.v1"}}
+	srv, _ := wsServer(t, opts, echoWS)
+
+	c := dialWS(t, srv, "Sec-WebSocket-Protocol: chat.v1, chat.v2")
+	if c.resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want 101", c.resp.StatusCode)
+	}
+	// The accept value from RFC 6455, section 1.3.
+	if got := c.resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got Sec-WebSocket-Accept %q", got)
+	}
+	if got := c.resp.Header.Get("Sec-WebSocket-Protocol"); got != "chat.v2" {
+		t.Errorf("got subprotocol %q, want chat.v2", got)
+	}
+
+	c.writeFrame(true, int(WSBinary), []byte{0, 1, 2})
+	if opcode, payload := c.readFrame(); opcode != int(WSBinary) || string(payload) != "\x00\x01\x02" {
+		t.Errorf("got frame %d %x, want binary echo", opcode, payload)
+	}
+
+	// No subprotocol is chosen if none match.
+	c = dialWS(t, srv, "Sec-WebSocket-Protocol: other")
+	if _, ok := c.resp.Header["Sec-Websocket-Protocol"]; ok || c.resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("got status %d and subprotocol %q, want none", c.resp.StatusCode, c.resp.Header.Get("Sec-WebSocket-Protocol"))
+	}
+}
+
+func TestUpgradeWebSocketRejected(t *testing.T) {
+	allowOther := WSOptions{CheckOrigin: func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://other.example"
+	}}
+
+	tests := []struct {
+		name    string
+		opts    WSOptions
+		headers []string
+		want    int
+	}{
+		{"same origin", WSOptions{}, []string{"Origin: https://example.com"}, http.StatusSwitchingProtocols},
+		{"cross origin", WSOptions{}, []string{"Origin: https://evil.example"}, http.StatusForbidden},
+		{"checked origin", allowOther, []string{"Origin: https://other.example"}, http.StatusSwitchingProtocols},
+		{"failed origin check", allowOther, []string{"Origin: https://example.com"}, http.StatusForbidden},
+		{"old version", WSOptions{}, []string{"Sec-WebSocket-Version: 8"}, http.StatusUpgradeRequired},
+	}
+	for _, test := range tests {
+		srv, errs := wsServer(t, test.opts, echoWS)
+		c := dialWS(t, srv, test.headers...)
+		if c.resp.StatusCode != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, c.resp.StatusCode, test.want)
+			continue
+		}
+		if test.want == http.StatusSwitchingProtocols {
+			continue
+		}
+		if err := <-errs; !errors.Is(err, ErrBadHandshake) {
+			t.Errorf("%s: got error %v, want ErrBadHandshake", test.name, err)
+		}
+		if test.want == http.StatusUpgradeRequired && c.resp.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("%s: got Sec-WebSocket-Version %q, want 13", test.name, c.resp.Header.Get("Sec-WebSocket-Version"))
+		}
+	}
+}
+
+func TestUpgradeWebSocketBadRequest(t *testing.T) {
+	h := Handler(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := UpgradeWebSocket(w, r, WSOptions{}); !errors.Is(err, ErrBadHandshake) {
+			t.Errorf("got error %v, want ErrBadHandshake", err)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		want   int
+	}{
+		{"post", "POST", nil, http.StatusMethodNotAllowed},
+		{"no upgrade", "GET", map[string]string{"Upgrade": ""}, http.StatusBadRequest},
+		{"no connection", "GET", map[string]string{"Connection": "keep-alive"}, http.StatusBadRequest},
+		{"short key", "GET", map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+		{"invalid key", "GET", map[string]string{"Sec-WebSocket-Key": "not base64!"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/ws", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", testWSKey)
+		for name, value := range test.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
+
+func TestWSConnFragmented(t *testing.T) {
+	srv, _ := wsServer(t, WSOptions{}, echoWS)
+	c := dialWS(t, srv)
+
+	// A ping between fragments is answered first.
+	c.writeFrame(false, int(WSText), []byte("Hel"))
+	c.writeFrame(true, wsPing, []byte("are you there?"))
+	c.writeFrame(false, wsContinuation, []byte("lo, "))
+	c.writeFrame(true, wsContinuation, []byte("world"))
+
+	if opcode, payload := c.readFrame(); opcode != wsPong || string(payload) != "are you there?" {
+		t.Errorf("got frame %d %q, want pong", opcode, payload)
+	}
+	if opcode, payload := c.readFrame(); opcode != int(WSText) || string(payload) != "Hello, world" {
+		t.Errorf("got frame %d %q, want joined text message", opcode, payload)
+	}
+
+	// Pongs are ignored, and messages of 126 bytes
+	// or more use extended lengths.
+	long := strings.Repeat("x", 70000)
+	c.writeFrame(true, wsPong, nil)
+	c.writeFrame(true, int(WSText), []byte(long))
+	if opcode, payload := c.readFrame(); opcode != int(WSText) || string(payload) != long {
+		t.Errorf("got frame %d of %d bytes, want long text message", opcode, len(payload))
+	}
+}
+
+func TestWSConnMessageTooLarge(t *testing.T) {
+	srv, errs := wsServer(t, WSOptions{MaxMessageSize: 10}, echoWS)
+
+	// The limit applies to whole messages.
+	c := dialWS(t, srv)
+	c.writeFrame(true, int(WSBinary), []byte("0123456789"))
+	if _, payload := c.readFrame(); string(payload) != "0123456789" {
+		t.Errorf("got %q, want message at the limit", payload)
+	}
+	c.writeFrame(false, int(WSBinary), []byte("012345"))
+	c.writeFrame(true, wsContinuation, []byte("6789a"))
+	c.expectClose(WSCloseMessageTooBig)
+	if err := <-errs; err != ErrWSMessageTooLarge {
+		t.Errorf("got error %v, want ErrWSMessageTooLarge", err)
+	}
+
+	// Oversized frames are rejected before their payload is read.
+	c = dialWS(t, srv)
+	c.writeFrame(true, int(WSBinary), make([]byte, 1000))
+	c.expectClose(WSCloseMessageTooBig)
+	if err := <-errs; err != ErrWSMessageTooLarge {
+		t.Errorf("got error %v, want ErrWSMessageTooLarge", err)
+	}
+}
+
+func TestWSConnProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *wsClient)
+		code  int
+	}{
+		{"unmasked frame", func(c *wsClient) { c.writeRawFrame(true, int(WSText), []byte("hi"), false) }, WSCloseProtocolError},
+		{"reserved bits", func(c *wsClient) { c.writeFrame(true, 0x40|int(WSText), []byte("hi")) }, WSCloseProtocolError},
+		{"unknown opcode", func(c *wsClient) { c.writeFrame(true, 3, nil) }, WSCloseProtocolError},
+		{"fragmented ping", func(c *wsClient) { c.writeFrame(false, wsPing, nil) }, WSCloseProtocolError},
+		{"long ping", func(c *wsClient) { c.writeFrame(true, wsPing, make([]byte, 126)) }, WSCloseProtocolError},
+		{"unexpected continuation", func(c *wsClient) { c.writeFrame(true, wsContinuation, []byte("hi")) }, WSCloseProtocolError},
+		{"interrupted message", func(c *wsClient) {
+			c.writeFrame(false, int(WSText), []byte("a"))
+			c.writeFrame(true, int(WSText), []byte("b"))
+		}, WSCloseProtocolError},
+		{"invalid UTF-8", func(c *wsClient) { c.writeFrame(true, int(WSText), []byte{0xff}) }, WSCloseInvalidData},
+	}
+	for _, test := range tests {
+		srv, errs := wsServer(t, WSOptions{}, echoWS)
+		c := dialWS(t, srv)
+		test.write(c)
+		c.expectClose(test.code)
+		if err := <-errs; !errors.Is(err, ErrWSProtocol) {
+			t.Errorf("%s: got error %v, want ErrWSProtocol", test.name, err)
+		}
+	}
+}
+
+func TestWSConnClose(t *testing.T) {
+	srv, errs := wsServer(t, WSOptions{}, echoWS)
+
+	// Close frames are echoed, and reported to the reader.
+	c := dialWS(t, srv)
+	c.writeFrame(true, wsClose, closePayload(WSCloseGoingAway, "bye"))
+	c.expectClose(WSCloseGoingAway)
+	var closeErr *WSCloseError
+	if err := <-errs; !errors.As(err, &closeErr) || closeErr.Code != WSCloseGoingAway || closeErr.Text != "bye" {
+		t.Errorf("got error %v, want close with status 1001", err)
+	}
+	// Nothing is sent after the close frame.
+	if _, err := c.br.ReadByte(); err != io.EOF {
+		t.Errorf("got error %v after close, want EOF", err)
+	}
+
+	// An empty close frame has no status.
+	c = dialWS(t, srv)
+	c.writeFrame(true, wsClose, nil)
+	if opcode, payload := c.readFrame(); opcode != wsClose || len(payload) != 0 {
+		t.Errorf("got frame %d %x, want empty close", opcode, payload)
+	}
+	if err := <-errs; !errors.As(err, &closeErr) || closeErr.Code != WSCloseNoStatus {
+		t.Errorf("got error %v, want close with status 1005", err)
+	}
+
+	// Invalid status codes are protocol errors.
+	c = dialWS(t, srv)
+	c.writeFrame(true, wsClose, closePayload(1006, ""))
+	c.expectClose(WSCloseProtocolError)
+	if err := <-errs; !errors.Is(err, ErrWSProtocol) {
+		t.Errorf("got error %v, want ErrWSProtocol", err)
+	}
+}
+
+func TestWSConnServerClose(t *testing.T) {
+	srv, errs := wsServer(t, WSOptions{}, func(conn *WSConn) error {
+		if err := conn.Ping([]byte("ping")); err != nil {
+			return err
+		}
+		if err := conn.CloseWithStatus(WSClosePolicyViolation, "no thanks"); err != nil {
+			return err
+		}
+		if err := conn.WriteMessage(WSText, []byte("late")); err != ErrWSClosed {
+			return fmt.Errorf("got error %v writing after close, want ErrWSClosed", err)
+		}
+		return nil
+	})
+
+	c := dialWS(t, srv)
+	if opcode, payload := c.readFrame(); opcode != wsPing || string(payload) != "ping" {
+		t.Errorf("got frame %d %q, want ping", opcode, payload)
+	}
+	opcode, payload := c.readFrame()
+	if opcode != wsClose || string(payload) != string(closePayload(WSClosePolicyViolation, "no thanks")) {
+		t.Errorf("got frame %d %q, want close with status 1008", opcode, payload)
+	}
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}